@@ -48,6 +48,7 @@ var WeChatAuthEnabled = false
 var TelegramOAuthEnabled = false
 var TurnstileCheckEnabled = false
 var RegisterEnabled = true
+var AdminTwoFAEnforcementEnabled = false // 是否强制管理员启用两步验证
 
 var EmailDomainRestrictionEnabled = false // 是否启用邮箱域名限制
 var EmailAliasRestrictionEnabled = false  // 是否启用邮箱别名限制
@@ -109,6 +110,13 @@ var RetryTimes = 0
 
 var IsMasterNode bool
 
+// TrustedProxies 受信任的反向代理 IP 或网段，逗号分隔，只有来自这些地址的 X-Forwarded-For 才会被用于识别客户端 IP。
+// 为空时保持 gin 的默认行为（信任所有代理）
+var TrustedProxies string
+
+// TrustedPlatform 由 CDN 或负载均衡写入客户端 IP 的请求头，cloudflare、google 或自定义请求头名
+var TrustedPlatform string
+
 var requestInterval int
 var RequestInterval time.Duration
 
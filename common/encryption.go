@@ -0,0 +1,191 @@
+package common
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 渠道密钥等敏感信息使用信封加密保存：每个值随机生成数据密钥并以 AES-256-GCM 加密，
+// 数据密钥再由主密钥加密后与密文一同保存，格式为 enc:v<主密钥版本>:<加密的数据密钥>:<密文>。
+// 主密钥由环境变量 ENCRYPTION_MASTER_KEYS 配置，格式为 版本:密钥，多个以逗号分隔，
+// 第一个为当前加密使用的主密钥，其余仅用于解密轮换前的数据
+const encryptedSecretPrefix = "enc:v"
+
+const maskedSecretMark = "********"
+
+var (
+	masterKeys       = make(map[int][]byte)
+	masterKeyVersion = 0
+)
+
+// InitMasterKeys 解析主密钥配置，为空时不加密
+func InitMasterKeys(config string) error {
+	keys := make(map[int][]byte)
+	current := 0
+	for _, item := range strings.Split(config, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		versionStr, secret, ok := strings.Cut(item, ":")
+		version, err := strconv.Atoi(versionStr)
+		if !ok || err != nil || version <= 0 || secret == "" {
+			return fmt.Errorf("invalid master key %q, expected <version>:<secret>", versionStr)
+		}
+		if _, exists := keys[version]; exists {
+			return fmt.Errorf("duplicate master key version %d", version)
+		}
+		key := sha256.Sum256([]byte(secret))
+		keys[version] = key[:]
+		if current == 0 {
+			current = version
+		}
+	}
+	masterKeys = keys
+	masterKeyVersion = current
+	return nil
+}
+
+// EncryptionEnabled 是否配置了主密钥
+func EncryptionEnabled() bool {
+	return masterKeyVersion > 0
+}
+
+func IsEncryptedSecret(s string) bool {
+	return strings.HasPrefix(s, encryptedSecretPrefix)
+}
+
+// SecretKeyVersion 返回加密值使用的主密钥版本，明文返回 0
+func SecretKeyVersion(s string) int {
+	if !IsEncryptedSecret(s) {
+		return 0
+	}
+	versionStr, _, _ := strings.Cut(strings.TrimPrefix(s, encryptedSecretPrefix), ":")
+	version, _ := strconv.Atoi(versionStr)
+	return version
+}
+
+// NeedsReencrypt 判断保存的值是否需要用当前主密钥重新加密
+func NeedsReencrypt(s string) bool {
+	if s == "" || !EncryptionEnabled() {
+		return false
+	}
+	return SecretKeyVersion(s) != masterKeyVersion
+}
+
+func sealAESGCM(key []byte, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func openAESGCM(key []byte, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+}
+
+// EncryptSecret 使用当前主密钥加密，未配置主密钥、值为空或已加密时原样返回
+func EncryptSecret(plaintext string) (string, error) {
+	if plaintext == "" || !EncryptionEnabled() || IsEncryptedSecret(plaintext) {
+		return plaintext, nil
+	}
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	wrappedKey, err := sealAESGCM(masterKeys[masterKeyVersion], dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := sealAESGCM(dataKey, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%d:%s:%s", encryptedSecretPrefix, masterKeyVersion,
+		base64.RawStdEncoding.EncodeToString(wrappedKey),
+		base64.RawStdEncoding.EncodeToString(ciphertext)), nil
+}
+
+// DecryptSecret 解密 EncryptSecret 的结果，明文原样返回
+func DecryptSecret(s string) (string, error) {
+	if !IsEncryptedSecret(s) {
+		return s, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(s, encryptedSecretPrefix), ":")
+	if len(parts) != 3 {
+		return "", errors.New("malformed encrypted secret")
+	}
+	version, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return "", errors.New("malformed encrypted secret")
+	}
+	masterKey, ok := masterKeys[version]
+	if !ok {
+		return "", fmt.Errorf("master key version %d is not configured", version)
+	}
+	wrappedKey, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", errors.New("malformed encrypted secret")
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", errors.New("malformed encrypted secret")
+	}
+	dataKey, err := openAESGCM(masterKey, wrappedKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to unwrap data key with master key version %d", version)
+	}
+	plaintext, err := openAESGCM(dataKey, ciphertext)
+	if err != nil {
+		return "", errors.New("failed to decrypt secret")
+	}
+	return string(plaintext), nil
+}
+
+// MaskSecret 返回脱敏后的值，仅保留首尾各 4 个字符，多行的值逐行脱敏
+func MaskSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if len(line) <= 12 {
+			lines[i] = maskedSecretMark
+		} else {
+			lines[i] = line[:4] + maskedSecretMark + line[len(line)-4:]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// IsMaskedSecret 判断值是否为 MaskSecret 的结果，用于忽略前端原样提交的脱敏值
+func IsMaskedSecret(s string) bool {
+	return strings.Contains(s, maskedSecretMark)
+}
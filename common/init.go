@@ -15,13 +15,14 @@ var (
 	PrintVersion = flag.Bool("version", false, "print version and exit")
 	PrintHelp    = flag.Bool("help", false, "print help and exit")
 	LogDir       = flag.String("log-dir", "./logs", "specify the log directory")
+	Reencrypt    = flag.Bool("reencrypt", false, "re-encrypt stored secrets with the current master key and exit")
 )
 
 func printHelp() {
 	fmt.Println("New API " + Version + " - All in one API service for OpenAI API.")
 	fmt.Println("Copyright (C) 2023 JustSong. All rights reserved.")
 	fmt.Println("GitHub: https://github.com/songquanpeng/one-api")
-	fmt.Println("Usage: one-api [--port <port>] [--log-dir <log directory>] [--version] [--help]")
+	fmt.Println("Usage: one-api [--port <port>] [--log-dir <log directory>] [--reencrypt] [--version] [--help]")
 }
 
 func LoadEnv() {
@@ -52,6 +53,9 @@ func LoadEnv() {
 	} else {
 		CryptoSecret = SessionSecret
 	}
+	if err := InitMasterKeys(os.Getenv("ENCRYPTION_MASTER_KEYS")); err != nil {
+		log.Fatal("failed to parse ENCRYPTION_MASTER_KEYS: " + err.Error())
+	}
 	if os.Getenv("SQLITE_PATH") != "" {
 		SQLitePath = os.Getenv("SQLITE_PATH")
 	}
@@ -85,6 +89,8 @@ func LoadEnv() {
 
 	// Initialize string variables with GetEnvOrDefaultString
 	GeminiSafetySetting = GetEnvOrDefaultString("GEMINI_SAFETY_SETTING", "BLOCK_NONE")
+	TrustedProxies = GetEnvOrDefaultString("TRUSTED_PROXIES", "")
+	TrustedPlatform = GetEnvOrDefaultString("TRUSTED_PLATFORM", "")
 	CohereSafetySetting = GetEnvOrDefaultString("COHERE_SAFETY_SETTING", "NONE")
 
 	// Initialize rate limit variables
@@ -0,0 +1,167 @@
+package common
+
+import (
+	"container/list"
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+// IPTrie 由 IP 与 CIDR 网段编译而成的二进制前缀树，IPv4 地址按 IPv4-mapped IPv6 地址存储，
+// 查询时沿地址的比特位下行，途经任意网段终点即命中
+type IPTrie struct {
+	root *ipTrieNode
+	size int
+}
+
+type ipTrieNode struct {
+	children [2]*ipTrieNode
+	terminal bool
+}
+
+// ParseIPPrefix 解析单个 IP 或 CIDR 网段，单个 IP 视为 /32 或 /128
+func ParseIPPrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.WithZone("")
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// SplitIPList 按换行、逗号或空白拆分 IP 列表
+func SplitIPList(list string) []string {
+	return strings.FieldsFunc(list, func(r rune) bool {
+		return r == '\n' || r == '\r' || r == ',' || r == ' ' || r == '\t' || r == ';'
+	})
+}
+
+// ValidateIPList 校验 IP 列表中的每一项都是合法的 IP 或 CIDR 网段
+func ValidateIPList(list string) error {
+	var invalid []string
+	for _, item := range SplitIPList(list) {
+		if _, err := ParseIPPrefix(item); err != nil {
+			invalid = append(invalid, item)
+		}
+	}
+	if len(invalid) > 0 {
+		return fmt.Errorf("无效的 IP 或网段：%s", strings.Join(invalid, ", "))
+	}
+	return nil
+}
+
+// NewIPTrie 编译 IP 列表，无法解析的项被忽略
+func NewIPTrie(list string) *IPTrie {
+	trie := &IPTrie{root: &ipTrieNode{}}
+	for _, item := range SplitIPList(list) {
+		prefix, err := ParseIPPrefix(item)
+		if err != nil {
+			continue
+		}
+		trie.Insert(prefix)
+	}
+	return trie
+}
+
+func (t *IPTrie) Insert(prefix netip.Prefix) {
+	bytes := prefix.Addr().As16()
+	bits := prefix.Bits()
+	if prefix.Addr().Is4() {
+		bits += 96
+	}
+	node := t.root
+	for i := 0; i < bits; i++ {
+		if node.terminal {
+			// 已被更短的网段覆盖
+			return
+		}
+		bit := (bytes[i/8] >> (7 - i%8)) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &ipTrieNode{}
+		}
+		node = node.children[bit]
+	}
+	node.terminal = true
+	node.children = [2]*ipTrieNode{}
+	t.size++
+}
+
+// Len 返回编译进前缀树的网段数
+func (t *IPTrie) Len() int {
+	return t.size
+}
+
+func (t *IPTrie) Contains(addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return false
+	}
+	bytes := addr.Unmap().WithZone("").As16()
+	node := t.root
+	for i := 0; i < 128; i++ {
+		if node.terminal {
+			return true
+		}
+		node = node.children[(bytes[i/8]>>(7-i%8))&1]
+		if node == nil {
+			return false
+		}
+	}
+	return node.terminal
+}
+
+// ContainsIP 判断字符串形式的 IP 是否命中，无法解析时视为未命中
+func (t *IPTrie) ContainsIP(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	return t.Contains(addr)
+}
+
+// ipTrieCacheSize 缓存的已编译 IP 列表数量上限，超出时淘汰最久未使用的列表
+const ipTrieCacheSize = 1024
+
+type ipTrieCacheItem struct {
+	list string
+	trie *IPTrie
+}
+
+var (
+	ipTrieCacheLock  sync.Mutex
+	ipTrieCacheItems = make(map[string]*list.Element)
+	ipTrieCacheOrder = list.New()
+)
+
+// GetIPTrie 返回 IP 列表编译后的前缀树，最近使用的列表只编译一次
+func GetIPTrie(ipList string) *IPTrie {
+	ipTrieCacheLock.Lock()
+	if element, ok := ipTrieCacheItems[ipList]; ok {
+		ipTrieCacheOrder.MoveToFront(element)
+		ipTrieCacheLock.Unlock()
+		return element.Value.(*ipTrieCacheItem).trie
+	}
+	ipTrieCacheLock.Unlock()
+
+	trie := NewIPTrie(ipList)
+	ipTrieCacheLock.Lock()
+	defer ipTrieCacheLock.Unlock()
+	if element, ok := ipTrieCacheItems[ipList]; ok {
+		ipTrieCacheOrder.MoveToFront(element)
+		return element.Value.(*ipTrieCacheItem).trie
+	}
+	ipTrieCacheItems[ipList] = ipTrieCacheOrder.PushFront(&ipTrieCacheItem{list: ipList, trie: trie})
+	for ipTrieCacheOrder.Len() > ipTrieCacheSize {
+		oldest := ipTrieCacheOrder.Back()
+		ipTrieCacheOrder.Remove(oldest)
+		delete(ipTrieCacheItems, oldest.Value.(*ipTrieCacheItem).list)
+	}
+	return trie
+}
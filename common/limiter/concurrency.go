@@ -0,0 +1,136 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Slot 并发计数器，Limit 为 0 时只计数不限制
+type Slot struct {
+	Key   string
+	Limit int64
+}
+
+// ConcurrencyLimiter 并发请求限制，请求以 id 在所有计数器中同时占用名额，任意计数器已满时拒绝。
+// Acquire 返回已满计数器的序号与当前并发数
+type ConcurrencyLimiter interface {
+	Acquire(ctx context.Context, slots []Slot, id string, lease time.Duration) (bool, int, int64, error)
+	Refresh(ctx context.Context, slots []Slot, id string, lease time.Duration) error
+	Release(ctx context.Context, slots []Slot, id string) error
+	Count(ctx context.Context, key string) (int64, error)
+}
+
+func (rl *RedisLimiter) evalConcurrency(ctx context.Context, mode string, slots []Slot, id string, lease time.Duration) ([]int64, error) {
+	keys := make([]string, 0, len(slots))
+	args := make([]interface{}, 0, len(slots)+3)
+	args = append(args, mode, id, lease.Milliseconds())
+	for _, slot := range slots {
+		keys = append(keys, slot.Key)
+		args = append(args, slot.Limit)
+	}
+	result, err := rl.client.EvalSha(ctx, rl.concurrencyScriptSHA, keys, args...).Int64Slice()
+	if err != nil && strings.HasPrefix(err.Error(), "NOSCRIPT") {
+		result, err = rl.client.Eval(ctx, concurrencyScript, keys, args...).Int64Slice()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("concurrency limit failed: %w", err)
+	}
+	if len(result) != 3 {
+		return nil, fmt.Errorf("concurrency limit failed: unexpected result %v", result)
+	}
+	return result, nil
+}
+
+func (rl *RedisLimiter) Acquire(ctx context.Context, slots []Slot, id string, lease time.Duration) (bool, int, int64, error) {
+	if len(slots) == 0 {
+		return true, 0, 0, nil
+	}
+	result, err := rl.evalConcurrency(ctx, "acquire", slots, id, lease)
+	if err != nil {
+		return false, 0, 0, err
+	}
+	if result[0] == 1 {
+		return true, 0, 0, nil
+	}
+	return false, int(result[1]) - 1, result[2], nil
+}
+
+func (rl *RedisLimiter) Refresh(ctx context.Context, slots []Slot, id string, lease time.Duration) error {
+	if len(slots) == 0 {
+		return nil
+	}
+	_, err := rl.evalConcurrency(ctx, "refresh", slots, id, lease)
+	return err
+}
+
+func (rl *RedisLimiter) Release(ctx context.Context, slots []Slot, id string) error {
+	pipe := rl.client.Pipeline()
+	for _, slot := range slots {
+		pipe.ZRem(ctx, slot.Key, id)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Count 返回计数器中租期未过期的名额数
+func (rl *RedisLimiter) Count(ctx context.Context, key string) (int64, error) {
+	now, err := rl.client.Time(ctx).Result()
+	if err != nil {
+		return 0, err
+	}
+	return rl.client.ZCount(ctx, key, fmt.Sprintf("(%d", now.UnixMilli()), "+inf").Result()
+}
+
+// MemoryConcurrencyLimiter 未启用 Redis 时使用的单机并发计数，请求结束时一定会释放，不需要租期
+type MemoryConcurrencyLimiter struct {
+	mutex  sync.Mutex
+	counts map[string]int64
+}
+
+func NewMemoryConcurrencyLimiter() *MemoryConcurrencyLimiter {
+	return &MemoryConcurrencyLimiter{
+		counts: make(map[string]int64),
+	}
+}
+
+func (m *MemoryConcurrencyLimiter) Acquire(ctx context.Context, slots []Slot, id string, lease time.Duration) (bool, int, int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for i, slot := range slots {
+		if slot.Limit > 0 && m.counts[slot.Key] >= slot.Limit {
+			return false, i, m.counts[slot.Key], nil
+		}
+	}
+	for _, slot := range slots {
+		m.counts[slot.Key]++
+	}
+	return true, 0, 0, nil
+}
+
+func (m *MemoryConcurrencyLimiter) Refresh(ctx context.Context, slots []Slot, id string, lease time.Duration) error {
+	return nil
+}
+
+func (m *MemoryConcurrencyLimiter) Release(ctx context.Context, slots []Slot, id string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, slot := range slots {
+		m.counts[slot.Key]--
+		if m.counts[slot.Key] <= 0 {
+			delete(m.counts, slot.Key)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryConcurrencyLimiter) Count(ctx context.Context, key string) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.counts[key], nil
+}
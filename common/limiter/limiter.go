@@ -12,9 +12,17 @@ import (
 //go:embed lua/rate_limit.lua
 var rateLimitScript string
 
+//go:embed lua/token_bucket.lua
+var tokenBucketScript string
+
+//go:embed lua/concurrency.lua
+var concurrencyScript string
+
 type RedisLimiter struct {
-	client         *redis.Client
-	limitScriptSHA string
+	client               *redis.Client
+	limitScriptSHA       string
+	tokenBucketScriptSHA string
+	concurrencyScriptSHA string
 }
 
 var (
@@ -29,9 +37,19 @@ func New(ctx context.Context, r *redis.Client) *RedisLimiter {
 		if err != nil {
 			common.SysLog(fmt.Sprintf("Failed to load rate limit script: %v", err))
 		}
+		tokenBucketSHA, err := r.ScriptLoad(ctx, tokenBucketScript).Result()
+		if err != nil {
+			common.SysLog(fmt.Sprintf("Failed to load token bucket script: %v", err))
+		}
+		concurrencySHA, err := r.ScriptLoad(ctx, concurrencyScript).Result()
+		if err != nil {
+			common.SysLog(fmt.Sprintf("Failed to load concurrency script: %v", err))
+		}
 		instance = &RedisLimiter{
-			client:         r,
-			limitScriptSHA: limitSHA,
+			client:               r,
+			limitScriptSHA:       limitSHA,
+			tokenBucketScriptSHA: tokenBucketSHA,
+			concurrencyScriptSHA: concurrencySHA,
 		}
 	})
 
@@ -0,0 +1,40 @@
+-- 并发请求计数，每个请求在有序集合中占用一个带租期的名额，节点异常退出时名额随租期过期
+-- KEYS[i]: 第 i 个计数器的唯一标识
+-- ARGV[1]: 操作类型 acquire 占用名额 / refresh 续期
+-- ARGV[2]: 请求唯一标识
+-- ARGV[3]: 租期 (毫秒)
+-- ARGV[i+3]: 第 i 个计数器的并发上限，0 表示只计数不限制
+-- 返回: {allowed, 超限计数器序号, 超限计数器当前并发数}
+
+local mode = ARGV[1]
+local member = ARGV[2]
+local lease = tonumber(ARGV[3])
+
+local now = redis.call('TIME')
+local nowMs = tonumber(now[1]) * 1000 + math.floor(tonumber(now[2]) / 1000)
+local expireAt = nowMs + lease
+
+if mode == 'refresh' then
+    for i = 1, #KEYS do
+        redis.call('ZADD', KEYS[i], 'XX', expireAt, member)
+        redis.call('PEXPIRE', KEYS[i], lease)
+    end
+    return { 1, 0, 0 }
+end
+
+for i = 1, #KEYS do
+    redis.call('ZREMRANGEBYSCORE', KEYS[i], '-inf', nowMs)
+    local limit = tonumber(ARGV[i + 3])
+    if limit > 0 then
+        local count = redis.call('ZCARD', KEYS[i])
+        if count >= limit then
+            return { 0, i, count }
+        end
+    end
+end
+
+for i = 1, #KEYS do
+    redis.call('ZADD', KEYS[i], expireAt, member)
+    redis.call('PEXPIRE', KEYS[i], lease)
+end
+return { 1, 0, 0 }
@@ -0,0 +1,55 @@
+-- 多桶令牌桶，支持小数速率、强制扣减与退还
+-- KEYS[i]: 第 i 个桶的唯一标识
+-- ARGV[1]: 请求令牌数，负数表示退还
+-- ARGV[2]: 是否强制扣减 (1/0)，强制扣减时余量可以为负
+-- ARGV[2i+1]: 第 i 个桶的令牌生成速率 (每秒，可为小数)
+-- ARGV[2i+2]: 第 i 个桶的容量
+-- 返回: {allowed, 桶1剩余令牌, 桶1回满毫秒数, 桶2剩余令牌, ...}
+
+local requested = tonumber(ARGV[1])
+local force = ARGV[2] == '1'
+
+local now = redis.call('TIME')
+local nowMs = tonumber(now[1]) * 1000 + math.floor(tonumber(now[2]) / 1000)
+
+local tokens = {}
+local allowed = 1
+for i = 1, #KEYS do
+    local rate = tonumber(ARGV[i * 2 + 1])
+    local capacity = tonumber(ARGV[i * 2 + 2])
+    local bucket = redis.call('HMGET', KEYS[i], 'tokens', 'last_time')
+    local t = tonumber(bucket[1])
+    local last = tonumber(bucket[2])
+    if not t or not last then
+        t = capacity
+    else
+        t = math.min(capacity, t + math.max(0, nowMs - last) * rate / 1000)
+    end
+    tokens[i] = t
+    if requested > 0 and t < requested then
+        allowed = 0
+    end
+end
+if force then
+    allowed = 1
+end
+
+local result = { allowed }
+for i = 1, #KEYS do
+    local rate = tonumber(ARGV[i * 2 + 1])
+    local capacity = tonumber(ARGV[i * 2 + 2])
+    local t = tokens[i]
+    if allowed == 1 then
+        t = math.min(capacity, t - requested)
+    end
+    local reset = 0
+    if t < capacity then
+        reset = math.ceil((capacity - t) / rate * 1000)
+    end
+    redis.call('HMSET', KEYS[i], 'tokens', tostring(t), 'last_time', nowMs)
+    -- 桶回满后即可过期，下次请求按满桶初始化
+    redis.call('PEXPIRE', KEYS[i], reset + 60000)
+    table.insert(result, math.floor(t))
+    table.insert(result, reset)
+end
+return result
@@ -0,0 +1,147 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bucket 令牌桶定义，Rate 为每秒补充的令牌数，可以为小数
+type Bucket struct {
+	Key      string
+	Capacity int64
+	Rate     float64
+}
+
+// BucketState 扣减后桶的状态，Reset 为桶重新回满所需的时间
+type BucketState struct {
+	Key       string
+	Capacity  int64
+	Remaining int64
+	Reset     time.Duration
+}
+
+// TokenBucketLimiter 多桶令牌桶，所有桶余量都足够时才同时扣减。
+// requested 为负数时表示退还，force 为 true 时无论余量是否足够都扣减，余量可以为负
+type TokenBucketLimiter interface {
+	Consume(ctx context.Context, buckets []Bucket, requested int64, force bool) (bool, []BucketState, error)
+}
+
+func (rl *RedisLimiter) Consume(ctx context.Context, buckets []Bucket, requested int64, force bool) (bool, []BucketState, error) {
+	if len(buckets) == 0 {
+		return true, nil, nil
+	}
+	keys := make([]string, 0, len(buckets))
+	args := make([]interface{}, 0, len(buckets)*2+2)
+	forceArg := 0
+	if force {
+		forceArg = 1
+	}
+	args = append(args, requested, forceArg)
+	for _, bucket := range buckets {
+		keys = append(keys, bucket.Key)
+		args = append(args, bucket.Rate, bucket.Capacity)
+	}
+	result, err := rl.client.EvalSha(ctx, rl.tokenBucketScriptSHA, keys, args...).Int64Slice()
+	if err != nil && strings.HasPrefix(err.Error(), "NOSCRIPT") {
+		// Redis 重启后脚本缓存丢失，直接执行脚本
+		result, err = rl.client.Eval(ctx, tokenBucketScript, keys, args...).Int64Slice()
+	}
+	if err != nil {
+		return false, nil, fmt.Errorf("token bucket failed: %w", err)
+	}
+	if len(result) != len(buckets)*2+1 {
+		return false, nil, fmt.Errorf("token bucket failed: unexpected result %v", result)
+	}
+	states := make([]BucketState, 0, len(buckets))
+	for i, bucket := range buckets {
+		states = append(states, BucketState{
+			Key:       bucket.Key,
+			Capacity:  bucket.Capacity,
+			Remaining: result[i*2+1],
+			Reset:     time.Duration(result[i*2+2]) * time.Millisecond,
+		})
+	}
+	return result[0] == 1, states, nil
+}
+
+type memoryBucket struct {
+	tokens   float64
+	lastTime time.Time
+}
+
+// MemoryTokenBucket 未启用 Redis 时使用的单机令牌桶
+type MemoryTokenBucket struct {
+	mutex     sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+func NewMemoryTokenBucket() *MemoryTokenBucket {
+	return &MemoryTokenBucket{
+		buckets:   make(map[string]*memoryBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+func (m *MemoryTokenBucket) Consume(ctx context.Context, buckets []Bucket, requested int64, force bool) (bool, []BucketState, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	tokens := make([]float64, len(buckets))
+	allowed := true
+	for i, bucket := range buckets {
+		tokens[i] = m.refill(bucket, now)
+		if requested > 0 && tokens[i] < float64(requested) {
+			allowed = false
+		}
+	}
+	if force {
+		allowed = true
+	}
+	states := make([]BucketState, 0, len(buckets))
+	for i, bucket := range buckets {
+		t := tokens[i]
+		if allowed {
+			t = math.Min(float64(bucket.Capacity), t-float64(requested))
+		}
+		m.buckets[bucket.Key] = &memoryBucket{tokens: t, lastTime: now}
+		var reset time.Duration
+		if t < float64(bucket.Capacity) {
+			reset = time.Duration(math.Ceil((float64(bucket.Capacity)-t)/bucket.Rate*1000)) * time.Millisecond
+		}
+		states = append(states, BucketState{
+			Key:       bucket.Key,
+			Capacity:  bucket.Capacity,
+			Remaining: int64(math.Floor(t)),
+			Reset:     reset,
+		})
+	}
+	if now.Sub(m.lastSweep) > time.Minute {
+		m.sweep(now)
+	}
+	return allowed, states, nil
+}
+
+func (m *MemoryTokenBucket) refill(bucket Bucket, now time.Time) float64 {
+	state, ok := m.buckets[bucket.Key]
+	if !ok {
+		return float64(bucket.Capacity)
+	}
+	elapsed := math.Max(0, now.Sub(state.lastTime).Seconds())
+	return math.Min(float64(bucket.Capacity), state.tokens+elapsed*bucket.Rate)
+}
+
+// sweep 清理长时间未使用的桶，这些桶早已回满，下次使用时按满桶初始化
+func (m *MemoryTokenBucket) sweep(now time.Time) {
+	for key, state := range m.buckets {
+		if now.Sub(state.lastTime) > time.Hour {
+			delete(m.buckets, key)
+		}
+	}
+	m.lastSweep = now
+}
@@ -10,6 +10,7 @@ import (
 	"html/template"
 	"io"
 	"log"
+	"math"
 	"math/big"
 	"math/rand"
 	"net"
@@ -205,6 +206,23 @@ func Max(a int, b int) int {
 	}
 }
 
+// CosineSimilarity 计算两个向量的余弦相似度，长度不一致或为零向量时返回 0
+func CosineSimilarity(a []float64, b []float64) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
 func MessageWithRequestId(message string, id string) string {
 	return fmt.Sprintf("%s (request id: %s)", message, id)
 }
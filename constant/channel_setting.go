@@ -1,7 +1,8 @@
 package constant
 
 var (
-	ForceFormat                     = "force_format"        // ForceFormat 强制格式化为OpenAI格式
-	ChanelSettingProxy              = "proxy"               // Proxy 代理
-	ChannelSettingThinkingToContent = "thinking_to_content" // ThinkingToContent
+	ForceFormat                      = "force_format"         // ForceFormat 强制格式化为OpenAI格式
+	ChanelSettingProxy               = "proxy"                // Proxy 代理
+	ChannelSettingThinkingToContent  = "thinking_to_content"  // ThinkingToContent
+	ChannelSettingEmbeddingBatchSize = "embedding_batch_size" // EmbeddingBatchSize 单次 embedding 请求的最大 input 数量
 )
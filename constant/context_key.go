@@ -7,4 +7,10 @@ const (
 	ContextKeyUserStatus       = "user_status"
 	ContextKeyUserEmail        = "user_email"
 	ContextKeyUserGroup        = "user_group"
+	ContextKeyUserAllowIps     = "user_allow_ips"
+	ContextKeyUserDenyIps      = "user_deny_ips"
+	// ContextKeyRerankEmulationModel 使用 embedding 模型模拟 rerank 时，记录用户请求的 rerank 模型
+	ContextKeyRerankEmulationModel = "rerank_emulation_model"
+	// ContextKeyUpstreamCancel 取消当前上游请求的 context.CancelFunc，输出被过滤停止后用于提前结束上游生成
+	ContextKeyUpstreamCancel = "upstream_cancel"
 )
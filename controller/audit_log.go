@@ -0,0 +1,84 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/csv"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 单次导出的审计日志条数上限
+const auditLogExportLimit = 100000
+
+func parseAuditLogFilter(c *gin.Context) model.AuditLogFilter {
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	return model.AuditLogFilter{
+		UserId:         userId,
+		Action:         c.Query("action"),
+		TargetType:     c.Query("target_type"),
+		TargetId:       c.Query("target_id"),
+		StartTimestamp: startTimestamp,
+		EndTimestamp:   endTimestamp,
+	}
+}
+
+func GetAuditLogs(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	if p < 1 {
+		p = 1
+	}
+	if pageSize < 1 {
+		pageSize = common.ItemsPerPage
+	}
+	auditLogs, total, err := model.GetAuditLogs(parseAuditLogFilter(c), (p-1)*pageSize, pageSize)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"items":     auditLogs,
+			"total":     total,
+			"page":      p,
+			"page_size": pageSize,
+		},
+	})
+}
+
+// ExportAuditLogs 按查询条件导出审计日志为 CSV
+func ExportAuditLogs(c *gin.Context) {
+	auditLogs, _, err := model.GetAuditLogs(parseAuditLogFilter(c), 0, auditLogExportLimit)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	buf := &bytes.Buffer{}
+	writer := csv.NewWriter(buf)
+	_ = writer.Write([]string{"id", "created_at", "user_id", "username", "role", "ip", "action", "target_type", "target_id", "diff"})
+	for _, auditLog := range auditLogs {
+		_ = writer.Write([]string{
+			strconv.Itoa(auditLog.Id), time.Unix(auditLog.CreatedAt, 0).Format("2006-01-02 15:04:05"),
+			strconv.Itoa(auditLog.UserId), auditLog.Username, strconv.Itoa(auditLog.Role), auditLog.Ip,
+			auditLog.Action, auditLog.TargetType, auditLog.TargetId, auditLog.Diff,
+		})
+	}
+	writer.Flush()
+	c.Header("Content-Disposition", "attachment; filename=audit-logs.csv")
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+}
@@ -5,6 +5,7 @@ import (
 	"one-api/common"
 	"one-api/dto"
 	"one-api/model"
+	"strconv"
 )
 
 func GetSubscription(c *gin.Context) {
@@ -53,6 +54,25 @@ func GetSubscription(c *gin.Context) {
 		SystemHardLimitUSD: amount,
 		AccessUntil:        expiredTime,
 	}
+	if userSubscription, _ := model.GetActiveSubscription(c.GetInt("id")); userSubscription != nil {
+		plan := &OpenAISubscriptionPlan{
+			Id:          strconv.Itoa(userSubscription.PlanId),
+			RemainUSD:   quotaToDisplayAmount(userSubscription.RemainQuota),
+			UsedUSD:     quotaToDisplayAmount(userSubscription.UsedQuota),
+			ExpireTime:  userSubscription.ExpireTime,
+			NextResetAt: userSubscription.NextResetTime,
+		}
+		if userSubscription.Plan != nil {
+			plan.Title = userSubscription.Plan.Name
+		}
+		subscription.Plan = plan
+		if token == nil || !token.UnlimitedQuota {
+			// 套餐剩余额度同样可用于请求
+			subscription.SoftLimitUSD += plan.RemainUSD
+			subscription.HardLimitUSD += plan.RemainUSD
+			subscription.SystemHardLimitUSD += plan.RemainUSD
+		}
+	}
 	c.JSON(200, subscription)
 	return
 }
@@ -90,3 +110,11 @@ func GetUsage(c *gin.Context) {
 	c.JSON(200, usage)
 	return
 }
+
+func quotaToDisplayAmount(quota int) float64 {
+	amount := float64(quota)
+	if common.DisplayInCurrencyEnabled {
+		amount /= common.QuotaPerUnit
+	}
+	return amount
+}
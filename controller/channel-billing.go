@@ -24,6 +24,17 @@ type OpenAISubscriptionResponse struct {
 	HardLimitUSD       float64 `json:"hard_limit_usd"`
 	SystemHardLimitUSD float64 `json:"system_hard_limit_usd"`
 	AccessUntil        int64   `json:"access_until"`
+	// Plan 用户当前订阅的套餐
+	Plan *OpenAISubscriptionPlan `json:"plan,omitempty"`
+}
+
+type OpenAISubscriptionPlan struct {
+	Title       string  `json:"title"`
+	Id          string  `json:"id"`
+	RemainUSD   float64 `json:"remain_usd"`
+	UsedUSD     float64 `json:"used_usd"`
+	ExpireTime  int64   `json:"expire_time"`
+	NextResetAt int64   `json:"next_reset_at"`
 }
 
 type OpenAIUsageDailyCost struct {
@@ -305,6 +316,9 @@ func updateChannelOpenRouterBalance(channel *model.Channel) (float64, error) {
 }
 
 func updateChannelBalance(channel *model.Channel) (float64, error) {
+	if err := channel.KeyError(); err != nil {
+		return 0, err
+	}
 	baseURL := common.ChannelBaseURLs[channel.Type]
 	if channel.GetBaseURL() == "" {
 		channel.BaseURL = &baseURL
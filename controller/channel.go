@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"one-api/common"
 	"one-api/model"
+	"one-api/service"
 	"strconv"
 	"strings"
 
@@ -80,6 +81,9 @@ func GetAllChannels(c *gin.Context) {
 		}
 		channelData = channels
 	}
+	for _, channel := range channelData {
+		channel.MaskKey()
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -168,6 +172,7 @@ func FixChannelsAbilities(c *gin.Context) {
 		})
 		return
 	}
+	service.RecordAuditAction(c, "channel.fix_abilities", model.AuditTargetChannel, "", gin.H{"count": count})
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -210,6 +215,9 @@ func SearchChannels(c *gin.Context) {
 		}
 		channelData = channels
 	}
+	for _, channel := range channelData {
+		channel.MaskKey()
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -227,7 +235,7 @@ func GetChannel(c *gin.Context) {
 		})
 		return
 	}
-	channel, err := model.GetChannelById(id, false)
+	channel, err := model.GetChannelById(id, true)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -235,6 +243,7 @@ func GetChannel(c *gin.Context) {
 		})
 		return
 	}
+	channel.MaskKey()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -243,6 +252,43 @@ func GetChannel(c *gin.Context) {
 	return
 }
 
+// GetChannelKey 返回渠道的原始密钥，仅限超级管理员，并记录查看日志
+func GetChannelKey(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	channel, err := model.GetChannelById(id, true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = channel.KeyError(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.RecordLog(c.GetInt("id"), model.LogTypeManage, fmt.Sprintf("查看了渠道 #%d（%s）的密钥，IP：%s", channel.Id, channel.Name, c.ClientIP()))
+	service.RecordAuditAction(c, "channel.reveal_key", model.AuditTargetChannel, channel.Id, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"key": channel.Key,
+		},
+	})
+	return
+}
+
 func AddChannel(c *gin.Context) {
 	channel := model.Channel{}
 	err := c.ShouldBindJSON(&channel)
@@ -305,6 +351,9 @@ func AddChannel(c *gin.Context) {
 		})
 		return
 	}
+	for i := range channels {
+		service.RecordAudit(c, "channel.create", model.AuditTargetChannel, channels[i].Id, nil, &channels[i])
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -314,6 +363,7 @@ func AddChannel(c *gin.Context) {
 
 func DeleteChannel(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
+	origin, _ := model.GetChannelById(id, true)
 	channel := model.Channel{Id: id}
 	err := channel.Delete()
 	if err != nil {
@@ -323,6 +373,7 @@ func DeleteChannel(c *gin.Context) {
 		})
 		return
 	}
+	service.RecordAudit(c, "channel.delete", model.AuditTargetChannel, id, origin, nil)
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -339,6 +390,7 @@ func DeleteDisabledChannel(c *gin.Context) {
 		})
 		return
 	}
+	service.RecordAuditAction(c, "channel.delete_disabled", model.AuditTargetChannel, "", gin.H{"count": rows})
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -375,6 +427,7 @@ func DisableTagChannels(c *gin.Context) {
 		})
 		return
 	}
+	service.RecordAuditAction(c, "channel.disable_tag", model.AuditTargetChannel, "tag:"+channelTag.Tag, nil)
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -400,6 +453,7 @@ func EnableTagChannels(c *gin.Context) {
 		})
 		return
 	}
+	service.RecordAuditAction(c, "channel.enable_tag", model.AuditTargetChannel, "tag:"+channelTag.Tag, nil)
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -432,6 +486,7 @@ func EditTagChannels(c *gin.Context) {
 		})
 		return
 	}
+	service.RecordAuditAction(c, "channel.edit_tag", model.AuditTargetChannel, "tag:"+channelTag.Tag, channelTag)
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -462,6 +517,7 @@ func DeleteChannelBatch(c *gin.Context) {
 		})
 		return
 	}
+	service.RecordAuditAction(c, "channel.batch_delete", model.AuditTargetChannel, "", gin.H{"ids": channelBatch.Ids})
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -501,6 +557,11 @@ func UpdateChannel(c *gin.Context) {
 			}
 		}
 	}
+	// 前端原样提交的脱敏密钥表示不修改密钥
+	if common.IsMaskedSecret(channel.Key) {
+		channel.Key = ""
+	}
+	origin, _ := model.GetChannelById(channel.Id, true)
 	err = channel.Update()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -509,6 +570,10 @@ func UpdateChannel(c *gin.Context) {
 		})
 		return
 	}
+	if updated, err := model.GetChannelById(channel.Id, true); err == nil {
+		service.RecordAudit(c, "channel.update", model.AuditTargetChannel, channel.Id, origin, updated)
+	}
+	channel.MaskKey()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -616,6 +681,7 @@ func BatchSetChannelTag(c *gin.Context) {
 		})
 		return
 	}
+	service.RecordAuditAction(c, "channel.batch_set_tag", model.AuditTargetChannel, "", channelBatch)
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
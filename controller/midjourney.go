@@ -150,7 +150,7 @@ func UpdateMidjourneyTaskBulk() {
 				if (task.Progress != "100%" && responseItem.FailReason != "") || (task.Progress == "100%" && task.Status == "FAILURE") {
 					common.LogInfo(ctx, task.MjId+" 构建失败，"+task.FailReason)
 					task.Progress = "100%"
-					if task.Quota != 0 {
+					if task.RefundQuota() != 0 {
 						shouldReturnQuota = true
 					}
 				}
@@ -159,11 +159,24 @@ func UpdateMidjourneyTaskBulk() {
 					common.LogError(ctx, "UpdateMidjourneyTask task error: "+err.Error())
 				} else {
 					if shouldReturnQuota {
-						err = model.IncreaseUserQuota(task.UserId, task.Quota, false)
+						// 套餐承担的部分不补偿到余额
+						quota := task.RefundQuota()
+						if task.OrgId != 0 {
+							err = model.ChangeOrganizationQuota(task.OrgId, task.UserId, -quota)
+						} else if task.FundingTokenId != 0 {
+							err = model.IncreaseTokenQuotaById(task.FundingTokenId, quota)
+						} else {
+							err = model.ChangeUserQuota(task.UserId, quota, model.LedgerEntry{
+								Type:           model.LedgerTypeRefund,
+								CounterAccount: model.LedgerAccountConsume,
+								RefType:        "midjourney",
+								RefId:          task.MjId,
+							}, false)
+						}
 						if err != nil {
 							common.LogError(ctx, "fail to increase user quota: "+err.Error())
 						}
-						logContent := fmt.Sprintf("构图失败 %s，补偿 %s", task.MjId, common.LogQuota(task.Quota))
+						logContent := fmt.Sprintf("构图失败 %s，补偿 %s", task.MjId, common.LogQuota(quota))
 						model.RecordLog(task.UserId, model.LogTypeSystem, logContent)
 					}
 				}
@@ -7,6 +7,7 @@ import (
 	"one-api/common"
 	"one-api/constant"
 	"one-api/model"
+	"one-api/service/payment"
 	"one-api/setting"
 	"one-api/setting/operation_setting"
 	"one-api/setting/system_setting"
@@ -66,6 +67,7 @@ func GetStatus(c *gin.Context) {
 			"data_export_default_time":    common.DataExportDefaultTime,
 			"default_collapse_sidebar":    common.DefaultCollapseSidebar,
 			"enable_online_topup":         setting.PayAddress != "" && setting.EpayId != "" && setting.EpayKey != "",
+			"payment_providers":           payment.GetEnabledProviderNames(),
 			"mj_notify_enabled":           setting.MjNotifyEnabled,
 			"chats":                       setting.Chats,
 			"demo_site_enabled":           operation_setting.DemoSiteEnabled,
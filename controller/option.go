@@ -5,7 +5,9 @@ import (
 	"net/http"
 	"one-api/common"
 	"one-api/model"
+	"one-api/service"
 	"one-api/setting"
+	"one-api/setting/operation_setting"
 	"one-api/setting/system_setting"
 	"strings"
 
@@ -119,8 +121,38 @@ func UpdateOption(c *gin.Context) {
 			})
 			return
 		}
+	case "ModelTPMLimitGroup", "ModelTPMLimitModel":
+		err = setting.CheckModelTPMLimitMap(option.Value)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "ModelConcurrencyLimitGroup", "ModelConcurrencyLimitGroupTotal":
+		err = setting.CheckModelConcurrencyLimitGroup(option.Value)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "pii_redaction.detectors":
+		err = operation_setting.CheckPIIDetectors(option.Value)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
 
 	}
+	common.OptionMapRWMutex.RLock()
+	oldValue := common.OptionMap[option.Key]
+	common.OptionMapRWMutex.RUnlock()
 	err = model.UpdateOption(option.Key, option.Value)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -129,6 +161,7 @@ func UpdateOption(c *gin.Context) {
 		})
 		return
 	}
+	service.RecordOptionAudit(c, option.Key, oldValue, option.Value)
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -0,0 +1,604 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"one-api/service"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OrganizationMemberRequest struct {
+	Username       string `json:"username"`
+	UserId         int    `json:"user_id"`
+	Role           string `json:"role"`
+	QuotaLimit     int    `json:"quota_limit"`
+	ResetUsedQuota bool   `json:"reset_used_quota"`
+}
+
+type OrganizationQuotaRequest struct {
+	Quota int `json:"quota"`
+}
+
+// getOrganizationMember 返回当前用户在路径参数 id 对应组织中的成员信息，失败时直接写入响应
+func getOrganizationMember(c *gin.Context) (*model.OrganizationMember, bool) {
+	orgId, _ := strconv.Atoi(c.Param("id"))
+	member, err := model.GetOrganizationMember(orgId, c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return nil, false
+	}
+	return member, true
+}
+
+func organizationPermissionDenied(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": false,
+		"message": "无权进行此操作，组织角色权限不足",
+	})
+}
+
+func GetSelfOrganizations(c *gin.Context) {
+	orgs, err := model.GetUserOrganizations(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    orgs,
+	})
+}
+
+func CreateOrganization(c *gin.Context) {
+	org := model.Organization{}
+	err := c.ShouldBindJSON(&org)
+	if err != nil || org.Name == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "参数错误",
+		})
+		return
+	}
+	if len(org.Name) > 64 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "组织名称过长",
+		})
+		return
+	}
+	created, err := model.CreateOrganization(org.Name, c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    created,
+	})
+}
+
+func GetOrganization(c *gin.Context) {
+	member, ok := getOrganizationMember(c)
+	if !ok {
+		return
+	}
+	org, err := model.GetOrganizationById(member.OrgId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	org.Role = member.Role
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"organization": org,
+			"member":       member,
+		},
+	})
+}
+
+func UpdateOrganization(c *gin.Context) {
+	member, ok := getOrganizationMember(c)
+	if !ok {
+		return
+	}
+	if !member.CanManageMembers() {
+		organizationPermissionDenied(c)
+		return
+	}
+	req := model.Organization{}
+	err := c.ShouldBindJSON(&req)
+	if err != nil || req.Name == "" || len(req.Name) > 64 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "参数错误",
+		})
+		return
+	}
+	org, err := model.GetOrganizationById(member.OrgId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	org.Name = req.Name
+	if err = org.Update(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    org,
+	})
+}
+
+func DeleteOrganization(c *gin.Context) {
+	member, ok := getOrganizationMember(c)
+	if !ok {
+		return
+	}
+	if member.Role != model.OrganizationRoleOwner {
+		organizationPermissionDenied(c)
+		return
+	}
+	if err := model.DeleteOrganization(member.OrgId); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// GetOrganizationMembers 管理角色可以查看所有成员，普通成员只能看到自己
+func GetOrganizationMembers(c *gin.Context) {
+	member, ok := getOrganizationMember(c)
+	if !ok {
+		return
+	}
+	if !member.CanViewUsage() {
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "",
+			"data":    []*model.OrganizationMember{member},
+		})
+		return
+	}
+	members, err := model.GetOrganizationMembers(member.OrgId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    members,
+	})
+}
+
+func AddOrganizationMember(c *gin.Context) {
+	member, ok := getOrganizationMember(c)
+	if !ok {
+		return
+	}
+	if !member.CanManageMembers() {
+		organizationPermissionDenied(c)
+		return
+	}
+	var req OrganizationMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.QuotaLimit < 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "参数错误",
+		})
+		return
+	}
+	if req.Role == "" {
+		req.Role = model.OrganizationRoleMember
+	}
+	// 只有所有者可以任命管理员
+	if req.Role == model.OrganizationRoleAdmin && member.Role != model.OrganizationRoleOwner {
+		organizationPermissionDenied(c)
+		return
+	}
+	userId := req.UserId
+	if req.Username != "" {
+		var err error
+		userId, err = model.GetUserIdByUsername(req.Username)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+	added, err := model.AddOrganizationMember(member.OrgId, userId, req.Role, req.QuotaLimit)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    added,
+	})
+}
+
+// UpdateOrganizationMember 修改成员角色与消费上限，可选择清零成员已用额度以重新计算上限
+func UpdateOrganizationMember(c *gin.Context) {
+	member, ok := getOrganizationMember(c)
+	if !ok {
+		return
+	}
+	if !member.CanManageMembers() {
+		organizationPermissionDenied(c)
+		return
+	}
+	var req OrganizationMemberRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.QuotaLimit < 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "参数错误",
+		})
+		return
+	}
+	target, err := model.GetOrganizationMember(member.OrgId, req.UserId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	// 未指定角色时保留成员原有角色
+	if req.Role == "" {
+		req.Role = target.Role
+	}
+	if target.Role == model.OrganizationRoleOwner && req.Role != target.Role {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "不能修改组织所有者的角色",
+		})
+		return
+	}
+	if !model.IsValidOrganizationRole(req.Role) || (req.Role == model.OrganizationRoleOwner && target.Role != model.OrganizationRoleOwner) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的成员角色",
+		})
+		return
+	}
+	// 只有所有者可以修改自己的消费上限与用量，管理员不能给自己提额或清零用量
+	if target.UserId == member.UserId && member.Role != model.OrganizationRoleOwner {
+		organizationPermissionDenied(c)
+		return
+	}
+	if member.Role != model.OrganizationRoleOwner &&
+		(target.Role == model.OrganizationRoleAdmin || req.Role == model.OrganizationRoleAdmin) {
+		organizationPermissionDenied(c)
+		return
+	}
+	target.Role = req.Role
+	target.QuotaLimit = req.QuotaLimit
+	if req.ResetUsedQuota {
+		target.UsedQuota = 0
+	}
+	if err = target.Update(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    target,
+	})
+}
+
+// RemoveOrganizationMember 管理角色可以移除成员，成员也可以自行退出组织
+func RemoveOrganizationMember(c *gin.Context) {
+	member, ok := getOrganizationMember(c)
+	if !ok {
+		return
+	}
+	userId, _ := strconv.Atoi(c.Param("user_id"))
+	if userId != member.UserId {
+		if !member.CanManageMembers() {
+			organizationPermissionDenied(c)
+			return
+		}
+		target, err := model.GetOrganizationMember(member.OrgId, userId)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		if target.Role == model.OrganizationRoleAdmin && member.Role != model.OrganizationRoleOwner {
+			organizationPermissionDenied(c)
+			return
+		}
+	}
+	if err := model.RemoveOrganizationMember(member.OrgId, userId); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// TransferQuotaToOrganization 将个人余额转入组织额度池
+func TransferQuotaToOrganization(c *gin.Context) {
+	member, ok := getOrganizationMember(c)
+	if !ok {
+		return
+	}
+	if !member.CanManageBilling() {
+		organizationPermissionDenied(c)
+		return
+	}
+	var req OrganizationQuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "参数错误",
+		})
+		return
+	}
+	if err := model.TransferQuotaToOrganization(member.OrgId, member.UserId, req.Quota); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// GetOrganizationTokens 管理角色查看组织下的所有令牌
+func GetOrganizationTokens(c *gin.Context) {
+	member, ok := getOrganizationMember(c)
+	if !ok {
+		return
+	}
+	if !member.CanViewUsage() {
+		organizationPermissionDenied(c)
+		return
+	}
+	p, _ := strconv.Atoi(c.Query("p"))
+	size, _ := strconv.Atoi(c.Query("size"))
+	if p < 0 {
+		p = 0
+	}
+	if size <= 0 {
+		size = common.ItemsPerPage
+	} else if size > 100 {
+		size = 100
+	}
+	tokens, err := model.GetOrganizationTokens(member.OrgId, p*size, size)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    tokens,
+	})
+}
+
+func GetOrganizationLogs(c *gin.Context) {
+	member, ok := getOrganizationMember(c)
+	if !ok {
+		return
+	}
+	p, _ := strconv.Atoi(c.Query("p"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	if p < 1 {
+		p = 1
+	}
+	if pageSize <= 0 {
+		pageSize = common.ItemsPerPage
+	}
+	if pageSize > 100 {
+		pageSize = 100
+	}
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	tokenName := c.Query("token_name")
+	modelName := c.Query("model_name")
+	username := c.Query("username")
+	if !member.CanViewUsage() {
+		// 普通成员只能查看自己在组织内的日志
+		username, _ = model.GetUsernameById(member.UserId, false)
+	}
+	logs, total, err := model.GetOrganizationLogs(member.OrgId, startTimestamp, endTimestamp, modelName, username, tokenName, (p-1)*pageSize, pageSize)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": map[string]any{
+			"items":     logs,
+			"total":     total,
+			"page":      p,
+			"page_size": pageSize,
+		},
+	})
+}
+
+func GetOrganizationQuotaDates(c *gin.Context) {
+	member, ok := getOrganizationMember(c)
+	if !ok {
+		return
+	}
+	if !member.CanViewUsage() {
+		organizationPermissionDenied(c)
+		return
+	}
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	// 判断时间跨度是否超过 1 个月
+	if endTimestamp-startTimestamp > 2592000 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "时间跨度不能超过 1 个月",
+		})
+		return
+	}
+	dates, err := model.GetQuotaDataByOrgId(member.OrgId, startTimestamp, endTimestamp)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    dates,
+	})
+}
+
+func GetAllOrganizations(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	if p < 1 {
+		p = 1
+	}
+	if pageSize <= 0 {
+		pageSize = common.ItemsPerPage
+	}
+	orgs, total, err := model.GetAllOrganizations((p-1)*pageSize, pageSize)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": map[string]any{
+			"items":     orgs,
+			"total":     total,
+			"page":      p,
+			"page_size": pageSize,
+		},
+	})
+}
+
+// ManageOrganization 管理员设置组织的额度池与状态
+func ManageOrganization(c *gin.Context) {
+	var req struct {
+		Id     int  `json:"id"`
+		Quota  *int `json:"quota"`
+		Status int  `json:"status"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "参数错误",
+		})
+		return
+	}
+	if req.Quota != nil && !model.HasAnyPermission(c.GetInt("role"), c.GetString("permission_role"), model.PermissionUserQuota) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无权修改组织额度，缺少权限 " + model.PermissionUserQuota,
+		})
+		return
+	}
+	org, err := model.GetOrganizationById(req.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	origin := *org
+	if req.Status == model.OrganizationStatusEnabled || req.Status == model.OrganizationStatusDisabled {
+		org.Status = req.Status
+		if err = org.Update(); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+	if req.Quota != nil {
+		if err = model.SetOrganizationQuota(org.Id, *req.Quota); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		model.RecordLog(c.GetInt("id"), model.LogTypeManage, "设置组织 "+org.Name+" 的额度池为 "+common.LogQuota(*req.Quota))
+	}
+	if updated, err := model.GetOrganizationById(org.Id); err == nil {
+		service.RecordAudit(c, "organization.manage", model.AuditTargetOrganization, org.Id, &origin, updated)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
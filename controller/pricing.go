@@ -2,9 +2,12 @@ package controller
 
 import (
 	"github.com/gin-gonic/gin"
+	"one-api/common"
 	"one-api/model"
+	"one-api/service"
 	"one-api/setting"
 	"one-api/setting/operation_setting"
+	"time"
 )
 
 func GetPricing(c *gin.Context) {
@@ -36,11 +39,16 @@ func GetPricing(c *gin.Context) {
 		"data":         pricing,
 		"group_ratio":  groupRatio,
 		"usable_group": usableGroup,
+		// 当前生效的定价时段，倍率在模型与分组倍率之上相乘
+		"pricing_schedules": operation_setting.GetCurrentPricingSchedules(time.Now()),
 	})
 }
 
 func ResetModelRatio(c *gin.Context) {
 	defaultStr := operation_setting.DefaultModelRatio2JSONString()
+	common.OptionMapRWMutex.RLock()
+	oldValue := common.OptionMap["ModelRatio"]
+	common.OptionMapRWMutex.RUnlock()
 	err := model.UpdateOption("ModelRatio", defaultStr)
 	if err != nil {
 		c.JSON(200, gin.H{
@@ -57,6 +65,7 @@ func ResetModelRatio(c *gin.Context) {
 		})
 		return
 	}
+	service.RecordOptionAudit(c, "ModelRatio", oldValue, defaultStr)
 	c.JSON(200, gin.H{
 		"success": true,
 		"message": "重置模型倍率成功",
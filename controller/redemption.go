@@ -1,10 +1,16 @@
 package controller
 
 import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
 	"net/http"
+	"net/url"
 	"one-api/common"
 	"one-api/model"
+	"one-api/service"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -87,14 +93,77 @@ func GetRedemption(c *gin.Context) {
 		})
 		return
 	}
+	usages, err := model.GetRedemptionUsages(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
 		"data":    redemption,
+		"usages":  usages,
 	})
 	return
 }
 
+// GetRedemptionStats 按兑换码名称返回各活动的统计
+func GetRedemptionStats(c *gin.Context) {
+	stats, err := model.GetRedemptionCampaignStats(c.Query("keyword"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    stats,
+	})
+}
+
+// ExportRedemptions 以 CSV 导出同一名称下的全部兑换码
+func ExportRedemptions(c *gin.Context) {
+	name := c.Query("name")
+	if name == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "请指定兑换码名称",
+		})
+		return
+	}
+	redemptions, err := model.GetRedemptionsByName(name)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	buf := &bytes.Buffer{}
+	writer := csv.NewWriter(buf)
+	_ = writer.Write([]string{"id", "key", "name", "status", "reward_type", "quota", "reward_group", "reward_days", "max_uses", "per_user_limit", "used_count", "expired_time"})
+	for _, redemption := range redemptions {
+		expiredTime := ""
+		if redemption.ExpiredTime != 0 {
+			expiredTime = time.Unix(redemption.ExpiredTime, 0).Format("2006-01-02 15:04:05")
+		}
+		_ = writer.Write([]string{
+			strconv.Itoa(redemption.Id), redemption.Key, redemption.Name, strconv.Itoa(redemption.Status),
+			redemption.RewardType, strconv.Itoa(redemption.Quota), redemption.RewardGroup, strconv.Itoa(redemption.RewardDays),
+			strconv.Itoa(redemption.MaxUses), strconv.Itoa(redemption.PerUserLimit), strconv.Itoa(redemption.UsedCount), expiredTime,
+		})
+	}
+	writer.Flush()
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=redemptions-%s.csv", url.PathEscape(name)))
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+}
+
 func AddRedemption(c *gin.Context) {
 	redemption := model.Redemption{}
 	err := c.ShouldBindJSON(&redemption)
@@ -126,15 +195,28 @@ func AddRedemption(c *gin.Context) {
 		})
 		return
 	}
+	if err = redemption.ValidateRewards(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	var keys []string
 	for i := 0; i < redemption.Count; i++ {
 		key := common.GetUUID()
 		cleanRedemption := model.Redemption{
-			UserId:      c.GetInt("id"),
-			Name:        redemption.Name,
-			Key:         key,
-			CreatedTime: common.GetTimestamp(),
-			Quota:       redemption.Quota,
+			UserId:       c.GetInt("id"),
+			Name:         redemption.Name,
+			Key:          key,
+			CreatedTime:  common.GetTimestamp(),
+			Quota:        redemption.Quota,
+			ExpiredTime:  redemption.ExpiredTime,
+			MaxUses:      redemption.MaxUses,
+			PerUserLimit: redemption.PerUserLimit,
+			RewardType:   redemption.RewardType,
+			RewardGroup:  redemption.RewardGroup,
+			RewardDays:   redemption.RewardDays,
 		}
 		err = cleanRedemption.Insert()
 		if err != nil {
@@ -145,6 +227,7 @@ func AddRedemption(c *gin.Context) {
 			})
 			return
 		}
+		service.RecordAudit(c, "redemption.create", model.AuditTargetRedemption, cleanRedemption.Id, nil, &cleanRedemption)
 		keys = append(keys, key)
 	}
 	c.JSON(http.StatusOK, gin.H{
@@ -157,6 +240,7 @@ func AddRedemption(c *gin.Context) {
 
 func DeleteRedemption(c *gin.Context) {
 	id, _ := strconv.Atoi(c.Param("id"))
+	originRedemption, _ := model.GetRedemptionById(id)
 	err := model.DeleteRedemptionById(id)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -165,6 +249,7 @@ func DeleteRedemption(c *gin.Context) {
 		})
 		return
 	}
+	service.RecordAudit(c, "redemption.delete", model.AuditTargetRedemption, id, originRedemption, nil)
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -191,12 +276,26 @@ func UpdateRedemption(c *gin.Context) {
 		})
 		return
 	}
+	originRedemption := *cleanRedemption
 	if statusOnly != "" {
 		cleanRedemption.Status = redemption.Status
 	} else {
 		// If you add more fields, please also update redemption.Update()
 		cleanRedemption.Name = redemption.Name
 		cleanRedemption.Quota = redemption.Quota
+		cleanRedemption.ExpiredTime = redemption.ExpiredTime
+		cleanRedemption.MaxUses = redemption.MaxUses
+		cleanRedemption.PerUserLimit = redemption.PerUserLimit
+		cleanRedemption.RewardType = redemption.RewardType
+		cleanRedemption.RewardGroup = redemption.RewardGroup
+		cleanRedemption.RewardDays = redemption.RewardDays
+		if err = cleanRedemption.ValidateRewards(); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
 	}
 	err = cleanRedemption.Update()
 	if err != nil {
@@ -206,6 +305,7 @@ func UpdateRedemption(c *gin.Context) {
 		})
 		return
 	}
+	service.RecordAudit(c, "redemption.update", model.AuditTargetRedemption, cleanRedemption.Id, &originRedemption, cleanRedemption)
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -0,0 +1,222 @@
+package controller
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"one-api/service"
+	"one-api/service/payment"
+	"strconv"
+	"time"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+type RefundTopUpRequest struct {
+	TradeNo string `json:"trade_no"`
+	// Money 退款金额，为 0 时退还订单剩余的全部金额
+	Money  float64 `json:"money"`
+	Reason string  `json:"reason"`
+	// Offline 为 true 时只扣回额度并标记订单，不调用支付渠道退款
+	Offline bool `json:"offline"`
+	// Force 为 true 时即使用户余额不足也扣回额度
+	Force bool `json:"force"`
+}
+
+type RefundConsumeRequest struct {
+	LogId int `json:"log_id"`
+	// Quota 退还额度，为 0 时退还该次消耗剩余的全部可退额度，订阅套餐承担的部分不退还
+	Quota  int    `json:"quota"`
+	Reason string `json:"reason"`
+}
+
+func GetAllRefunds(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	if p < 1 {
+		p = 1
+	}
+	if pageSize <= 0 {
+		pageSize = common.ItemsPerPage
+	}
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	refunds, total, err := model.GetRefunds(userId, c.Query("type"), (p-1)*pageSize, pageSize)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": map[string]any{
+			"items":     refunds,
+			"total":     total,
+			"page":      p,
+			"page_size": pageSize,
+		},
+	})
+}
+
+func GetSelfRefunds(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	if p < 1 {
+		p = 1
+	}
+	if pageSize <= 0 {
+		pageSize = common.ItemsPerPage
+	}
+	refunds, total, err := model.GetRefunds(c.GetInt("id"), c.Query("type"), (p-1)*pageSize, pageSize)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": map[string]any{
+			"items":     refunds,
+			"total":     total,
+			"page":      p,
+			"page_size": pageSize,
+		},
+	})
+}
+
+// RefundTopUp 对充值订单全额或部分退款：先扣回额度并标记订单，再调用支付渠道退款，渠道退款失败时撤销
+func RefundTopUp(c *gin.Context) {
+	var req RefundTopUpRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.TradeNo == "" {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": "参数错误"})
+		return
+	}
+	LockOrder(req.TradeNo)
+	defer UnlockOrder(req.TradeNo)
+
+	topUp := model.GetTopUpByTradeNo(req.TradeNo)
+	if topUp == nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": "订单不存在"})
+		return
+	}
+	if topUp.Status != model.TopUpStatusSuccess && topUp.Status != model.TopUpStatusPartialRefunded {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": fmt.Sprintf("订单状态为 %s，不能退款", topUp.Status)})
+		return
+	}
+	remaining := math.Round((topUp.Money-topUp.RefundedMoney)*100) / 100
+	money := math.Round(req.Money*100) / 100
+	if money <= 0 {
+		money = remaining
+	}
+	if money <= 0 || money > remaining {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": fmt.Sprintf("退款金额无效，可退金额为 %.2f", remaining)})
+		return
+	}
+	var provider payment.PaymentProvider
+	if !req.Offline {
+		var err error
+		provider, err = payment.GetProvider(topUp.PaymentProvider)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{"success": false, "message": "订单的支付渠道未配置，无法原路退款"})
+			return
+		}
+	}
+
+	quota := model.GetTopUpRefundQuota(topUp, money)
+	previousStatus := topUp.Status
+	if err := model.ApplyTopUpRefund(topUp, money, quota, req.Force); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+	if provider != nil {
+		err := provider.Refund(&payment.RefundRequest{
+			TradeNo:         topUp.TradeNo,
+			ProviderOrderId: topUp.ProviderOrderId,
+			Money:           money,
+			Reason:          req.Reason,
+		})
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to refund order %s via %s: %s", topUp.TradeNo, topUp.PaymentProvider, err.Error()))
+			if revertErr := model.RevertTopUpRefund(topUp, previousStatus, money, quota); revertErr != nil {
+				common.SysError(fmt.Sprintf("failed to revert refund of order %s: %s", topUp.TradeNo, revertErr.Error()))
+			}
+			c.JSON(http.StatusOK, gin.H{"success": false, "message": "支付渠道退款失败：" + err.Error()})
+			return
+		}
+	}
+	if topUp.PlanId > 0 && topUp.Status == model.TopUpStatusRefunded {
+		if err := model.RevokeSubscription(topUp.UserId, topUp.PlanId); err != nil {
+			common.SysError(fmt.Sprintf("failed to revoke subscription of refunded order %s: %s", topUp.TradeNo, err.Error()))
+		}
+	}
+
+	refund := &model.Refund{
+		UserId:     topUp.UserId,
+		Type:       model.RefundTypeTopUp,
+		TopUpId:    topUp.Id,
+		TradeNo:    topUp.TradeNo,
+		Money:      money,
+		Quota:      quota,
+		Reason:     req.Reason,
+		OperatorId: c.GetInt("id"),
+	}
+	if err := refund.Insert(); err != nil {
+		common.SysError(fmt.Sprintf("failed to record refund of order %s: %s", topUp.TradeNo, err.Error()))
+	}
+	service.RecordAudit(c, "refund.top_up", model.AuditTargetRefund, refund.Id, nil, refund)
+	model.RecordLog(topUp.UserId, model.LogTypeManage, fmt.Sprintf("管理员对充值订单 %s 退款 %.2f，扣回额度 %s，原因：%s",
+		topUp.TradeNo, money, common.LogQuota(quota), req.Reason))
+	gopool.Go(func() {
+		service.NotifyUserRefund(topUp.UserId, "您的充值订单 {{value}} 已退款 {{value}}，扣回额度 {{value}}。原因：{{value}}",
+			[]interface{}{topUp.TradeNo, fmt.Sprintf("%.2f", money), common.LogQuota(quota), req.Reason})
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    refund,
+	})
+}
+
+// RefundConsume 退还一次请求的消耗
+func RefundConsume(c *gin.Context) {
+	var req RefundConsumeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.LogId == 0 {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": "参数错误"})
+		return
+	}
+	if req.Reason == "" {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": "请填写退还原因"})
+		return
+	}
+	log, err := model.GetConsumeLogById(req.LogId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+	refund, err := model.RefundConsumeLog(log, req.Quota, req.Reason, c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+	service.RecordAudit(c, "refund.consume", model.AuditTargetRefund, refund.Id, nil, refund)
+	quota := refund.Quota
+	model.RecordLog(log.UserId, model.LogTypeManage, fmt.Sprintf("管理员退还请求消耗 %s（记录 #%d，模型 %s），原因：%s",
+		common.LogQuota(quota), log.Id, log.ModelName, req.Reason))
+	gopool.Go(func() {
+		service.NotifyUserRefund(log.UserId, "您在 {{value}} 使用模型 {{value}} 的请求已退还 {{value}}。原因：{{value}}",
+			[]interface{}{time.Unix(log.CreatedAt, 0).Format("2006-01-02 15:04:05"), log.ModelName, common.LogQuota(quota), req.Reason})
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    refund,
+	})
+}
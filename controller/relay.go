@@ -102,7 +102,7 @@ func Relay(c *gin.Context) {
 	}
 
 	if openaiErr != nil {
-		if openaiErr.StatusCode == http.StatusTooManyRequests {
+		if openaiErr.StatusCode == http.StatusTooManyRequests && !openaiErr.LocalError {
 			common.LogError(c, fmt.Sprintf("origin 429 error: %s", openaiErr.Error.Message))
 			openaiErr.Error.Message = "当前分组上游负载已饱和，请稍后再试"
 		}
@@ -166,7 +166,7 @@ func WssRelay(c *gin.Context) {
 	}
 
 	if openaiErr != nil {
-		if openaiErr.StatusCode == http.StatusTooManyRequests {
+		if openaiErr.StatusCode == http.StatusTooManyRequests && !openaiErr.LocalError {
 			openaiErr.Error.Message = "当前分组上游负载已饱和，请稍后再试"
 		}
 		openaiErr.Error.Message = common.MessageWithRequestId(openaiErr.Error.Message, requestId)
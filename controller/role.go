@@ -0,0 +1,207 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"one-api/service"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RoleRequest struct {
+	Id          int      `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+type AssignRoleRequest struct {
+	UserId         int    `json:"user_id"`
+	PermissionRole string `json:"permission_role"`
+}
+
+func roleResponse(role *model.Role) gin.H {
+	return gin.H{
+		"id":           role.Id,
+		"name":         role.Name,
+		"description":  role.Description,
+		"permissions":  role.GetPermissions(),
+		"builtin":      role.Builtin,
+		"created_time": role.CreatedTime,
+		"updated_time": role.UpdatedTime,
+	}
+}
+
+// GetRoles 返回全部角色以及可授予的权限列表
+func GetRoles(c *gin.Context) {
+	roles, err := model.GetAllRoles()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	items := make([]gin.H, 0, len(roles))
+	for _, role := range roles {
+		items = append(items, roleResponse(role))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"items":       items,
+			"permissions": model.Permissions,
+		},
+	})
+}
+
+func AddRole(c *gin.Context) {
+	var req RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "参数错误",
+		})
+		return
+	}
+	role := &model.Role{
+		Name:        req.Name,
+		Description: req.Description,
+	}
+	if err := role.SetPermissions(req.Permissions); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err := role.Insert(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	service.RecordAudit(c, "role.create", model.AuditTargetRole, role.Name, nil, roleResponse(role))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    roleResponse(role),
+	})
+}
+
+// UpdateRole 修改自定义角色的描述与权限，已分配该角色的用户立即生效
+func UpdateRole(c *gin.Context) {
+	var req RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Id == 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "参数错误",
+		})
+		return
+	}
+	role, err := model.GetRoleById(req.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	origin := roleResponse(role)
+	role.Description = req.Description
+	if err = role.SetPermissions(req.Permissions); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = role.Update(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	service.RecordAudit(c, "role.update", model.AuditTargetRole, role.Name, origin, roleResponse(role))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    roleResponse(role),
+	})
+}
+
+func DeleteRole(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	role, err := model.GetRoleById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = model.DeleteRoleById(id); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	service.RecordAudit(c, "role.delete", model.AuditTargetRole, role.Name, roleResponse(role), nil)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// AssignRole 为用户分配自定义角色，permission_role 为空表示恢复为按权限等级使用内置角色
+func AssignRole(c *gin.Context) {
+	var req AssignRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.UserId == 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "参数错误",
+		})
+		return
+	}
+	user, err := model.GetUserById(req.UserId, false)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if user.Role == common.RoleRootUser {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "超级管理员拥有全部权限，无需分配角色",
+		})
+		return
+	}
+	if user.Role < common.RoleAdminUser && req.PermissionRole != "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "自定义角色只对管理员生效，请先将该用户提升为管理员",
+		})
+		return
+	}
+	if err = model.SetUserPermissionRole(user.Id, req.PermissionRole); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	service.RecordAudit(c, "user.assign_role", model.AuditTargetUser, user.Id,
+		gin.H{"permission_role": user.PermissionRole}, gin.H{"permission_role": req.PermissionRole})
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
@@ -0,0 +1,42 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetSemanticCacheEntries(c *gin.Context) {
+	scope := c.Query("scope")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    service.ListSemanticCache(scope),
+	})
+}
+
+func DeleteSemanticCacheEntry(c *gin.Context) {
+	id := c.Param("id")
+	if !service.GetSemanticCacheStorage().Delete(id) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "缓存条目不存在",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+func PurgeSemanticCache(c *gin.Context) {
+	scope := c.Query("scope")
+	count := service.GetSemanticCacheStorage().Purge(scope)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    count,
+	})
+}
@@ -110,7 +110,7 @@ func PostSetup(c *gin.Context) {
 			AccessToken: nil,
 			Quota:       100000000,
 		}
-		err = model.DB.Create(&rootUser).Error
+		err = model.CreateUserWithOpeningLedger(&rootUser)
 		if err != nil {
 			c.JSON(500, gin.H{
 				"success": false,
@@ -0,0 +1,208 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type GenerateStatementRequest struct {
+	Period string `json:"period"`
+	UserId int    `json:"user_id"`
+	Force  bool   `json:"force"`
+}
+
+func GetSelfStatements(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	if p < 1 {
+		p = 1
+	}
+	if pageSize <= 0 {
+		pageSize = common.ItemsPerPage
+	}
+	statements, total, err := model.GetUserStatements(c.GetInt("id"), (p-1)*pageSize, pageSize)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": map[string]any{
+			"items":     statements,
+			"total":     total,
+			"page":      p,
+			"page_size": pageSize,
+		},
+	})
+}
+
+func ExportSelfStatement(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	statement, err := model.GetStatementById(id)
+	if err != nil || statement.UserId != c.GetInt("id") {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "账单不存在",
+		})
+		return
+	}
+	exportStatement(c, statement)
+}
+
+func GetAllStatements(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	if p < 1 {
+		p = 1
+	}
+	if pageSize <= 0 {
+		pageSize = common.ItemsPerPage
+	}
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	statements, total, err := model.GetAllStatements(userId, c.Query("period"), (p-1)*pageSize, pageSize)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": map[string]any{
+			"items":     statements,
+			"total":     total,
+			"page":      p,
+			"page_size": pageSize,
+		},
+	})
+}
+
+func ExportStatement(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	statement, err := model.GetStatementById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "账单不存在",
+		})
+		return
+	}
+	exportStatement(c, statement)
+}
+
+// GenerateStatements 手动生成已结束月份的账单，未指定用户时为所有有记录的用户生成
+func GenerateStatements(c *gin.Context) {
+	var req GenerateStatementRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "参数错误",
+		})
+		return
+	}
+	if req.Period == "" {
+		req.Period = model.GetLastClosedStatementPeriod(time.Now())
+	}
+	if req.UserId != 0 {
+		statement, err := model.GenerateStatement(req.UserId, req.Period, req.Force)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "",
+			"data":    statement,
+		})
+		return
+	}
+	count, err := model.GenerateStatementsForPeriod(req.Period)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": fmt.Sprintf("已生成 %d 份账单", count),
+	})
+}
+
+// statementAmount 将额度换算为美元金额
+func statementAmount(quota int) string {
+	return strconv.FormatFloat(float64(quota)/common.QuotaPerUnit, 'f', 6, 64)
+}
+
+// exportStatement 以 JSON 或 CSV 导出账单，指定 token_id 时只导出该令牌的消耗
+func exportStatement(c *gin.Context, statement *model.Statement) {
+	detail, err := statement.GetDetail()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	tokenId, _ := strconv.Atoi(c.Query("token_id"))
+	if tokenId != 0 {
+		detail = detail.FilterToken(tokenId)
+	}
+	if c.Query("format") != "csv" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "",
+			"data": gin.H{
+				"statement": statement,
+				"usage":     detail.Usage,
+				"credits":   detail.Credits,
+				"by_model":  detail.Summarize("model"),
+				"by_token":  detail.Summarize("token"),
+				"by_day":    detail.Summarize("day"),
+			},
+		})
+		return
+	}
+	buf := &bytes.Buffer{}
+	writer := csv.NewWriter(buf)
+	_ = writer.Write([]string{"type", "date", "token_id", "token_name", "model_name", "requests", "prompt_tokens", "completion_tokens", "quota", "amount", "money", "reference"})
+	for _, item := range detail.Usage {
+		_ = writer.Write([]string{
+			"usage", item.Date, strconv.Itoa(item.TokenId), item.TokenName, item.ModelName,
+			strconv.Itoa(item.Requests), strconv.Itoa(item.PromptTokens), strconv.Itoa(item.CompletionTokens),
+			strconv.Itoa(item.Quota), statementAmount(item.Quota), "", "",
+		})
+	}
+	for _, credit := range detail.Credits {
+		_ = writer.Write([]string{
+			credit.Type, time.Unix(credit.Time, 0).Format("2006-01-02 15:04:05"), "", "", "",
+			"", "", "", strconv.Itoa(credit.Quota), statementAmount(credit.Quota),
+			strconv.FormatFloat(credit.Money, 'f', 2, 64), credit.Reference,
+		})
+	}
+	writer.Flush()
+	filename := fmt.Sprintf("statement-%s-%d", statement.Period, statement.UserId)
+	if tokenId != 0 {
+		filename += fmt.Sprintf("-token-%d", tokenId)
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.csv", filename))
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+}
@@ -0,0 +1,310 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"one-api/service"
+	"one-api/service/payment"
+	"one-api/setting"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SubscribeRequest struct {
+	PlanId int `json:"plan_id"`
+	// PaymentMethod 为 balance 时使用余额支付，否则发起在线支付
+	PaymentMethod string `json:"payment_method"`
+	AutoRenew     bool   `json:"auto_renew"`
+	// Provider 在线支付使用的支付渠道，默认为 epay
+	Provider string `json:"provider"`
+}
+
+type GrantSubscriptionRequest struct {
+	UserId    int  `json:"user_id"`
+	PlanId    int  `json:"plan_id"`
+	AutoRenew bool `json:"auto_renew"`
+}
+
+func GetPlans(c *gin.Context) {
+	plans, err := model.GetAllPlans(true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    plans,
+	})
+}
+
+func GetAllPlans(c *gin.Context) {
+	plans, err := model.GetAllPlans(false)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    plans,
+	})
+}
+
+func AddPlan(c *gin.Context) {
+	plan := model.Plan{}
+	err := c.ShouldBindJSON(&plan)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	plan.Id = 0
+	if err = plan.ValidateAndFill(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = plan.Insert(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	service.RecordAudit(c, "plan.create", model.AuditTargetPlan, plan.Id, nil, &plan)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    plan,
+	})
+}
+
+func UpdatePlan(c *gin.Context) {
+	plan := model.Plan{}
+	err := c.ShouldBindJSON(&plan)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	origin, err := model.GetPlanById(plan.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = plan.ValidateAndFill(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = plan.Update(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	service.RecordAudit(c, "plan.update", model.AuditTargetPlan, plan.Id, origin, &plan)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    plan,
+	})
+}
+
+func DeletePlan(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	origin, _ := model.GetPlanById(id)
+	err := model.DeletePlanById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	service.RecordAudit(c, "plan.delete", model.AuditTargetPlan, id, origin, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+func GetSelfSubscription(c *gin.Context) {
+	userId := c.GetInt("id")
+	p, _ := strconv.Atoi(c.Query("p"))
+	pageSize, _ := strconv.Atoi(c.Query("page_size"))
+	if p < 1 {
+		p = 1
+	}
+	if pageSize < 1 {
+		pageSize = common.ItemsPerPage
+	}
+	subscription, err := model.GetActiveSubscription(userId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	history, total, err := model.GetUserSubscriptions(userId, (p-1)*pageSize, pageSize)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"active":    subscription,
+			"items":     history,
+			"total":     total,
+			"page":      p,
+			"page_size": pageSize,
+		},
+	})
+}
+
+func Subscribe(c *gin.Context) {
+	var req SubscribeRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": "参数错误"})
+		return
+	}
+	plan, err := model.GetPlanById(req.PlanId)
+	if err != nil || plan.Status != model.PlanStatusEnabled {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": "套餐不存在或已下架"})
+		return
+	}
+	id := c.GetInt("id")
+	if req.PaymentMethod == "balance" {
+		subscription, err := model.PurchaseSubscriptionWithQuota(id, plan, service.PlanPriceToQuota(plan.Price), req.AutoRenew)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{"success": false, "message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": "", "data": subscription})
+		return
+	}
+
+	if plan.Price < 0.01 {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": "套餐价格过低，请使用余额开通"})
+		return
+	}
+	providerName := req.Provider
+	if providerName == "" {
+		providerName = "epay"
+	}
+	provider, err := payment.GetProvider(providerName)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": "当前管理员未配置支付信息"})
+		return
+	}
+	payType := "wxpay"
+	if req.PaymentMethod == "zfb" || req.PaymentMethod == "alipay" {
+		payType = "alipay"
+	}
+	// 套餐价格以易支付单价计价，其他渠道按各自单价换算
+	money := plan.Price
+	if providerName != "epay" && setting.Price > 0 {
+		money = plan.Price / setting.Price * provider.UnitPrice()
+	}
+	money = provider.RoundMoney(money)
+	if money <= 0 {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": "套餐价格过低，请使用余额开通"})
+		return
+	}
+	tradeNo := fmt.Sprintf("SUB%dNO%s%d", id, common.GetRandomString(6), time.Now().Unix())
+	result, err := provider.CreateOrder(&payment.Order{
+		TradeNo:       tradeNo,
+		Name:          fmt.Sprintf("PLAN%d", plan.Id),
+		Money:         money,
+		PaymentMethod: payType,
+		NotifyUrl:     getPaymentNotifyUrl(providerName),
+		ReturnUrl:     setting.ServerAddress + "/topup",
+	})
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": "拉起支付失败"})
+		return
+	}
+	topUp := &model.TopUp{
+		UserId:          id,
+		Money:           money,
+		TradeNo:         tradeNo,
+		CreateTime:      time.Now().Unix(),
+		Status:          model.TopUpStatusPending,
+		PlanId:          plan.Id,
+		PaymentProvider: providerName,
+		PaymentMethod:   payType,
+		ProviderOrderId: result.ProviderOrderId,
+	}
+	if err = topUp.Insert(); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": "创建订单失败"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "", "data": result.Params, "url": result.Url})
+}
+
+func CancelSelfSubscription(c *gin.Context) {
+	err := model.CancelSubscription(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "已关闭自动续费，订阅将在当前周期结束后失效",
+	})
+}
+
+// GrantSubscription 管理员为用户直接开通套餐，不扣除余额
+func GrantSubscription(c *gin.Context) {
+	var req GrantSubscriptionRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": "参数错误"})
+		return
+	}
+	plan, err := model.GetPlanById(req.PlanId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+	subscription, err := model.ActivateSubscription(req.UserId, plan, req.AutoRenew)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": err.Error()})
+		return
+	}
+	service.RecordAudit(c, "subscription.grant", model.AuditTargetSubscription, subscription.Id, nil, subscription)
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "", "data": subscription})
+}
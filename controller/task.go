@@ -157,9 +157,20 @@ func updateSunoTaskAll(ctx context.Context, channelId int, taskIds []string, tas
 			if err != nil {
 				common.LogError(ctx, "error update user quota cache: "+err.Error())
 			} else {
-				quota := task.Quota
+				quota := task.RefundQuota()
 				if quota != 0 {
-					err = model.IncreaseUserQuota(task.UserId, quota, false)
+					if task.OrgId != 0 {
+						err = model.ChangeOrganizationQuota(task.OrgId, task.UserId, -quota)
+					} else if task.FundingTokenId != 0 {
+						err = model.IncreaseTokenQuotaById(task.FundingTokenId, quota)
+					} else {
+						err = model.ChangeUserQuota(task.UserId, quota, model.LedgerEntry{
+							Type:           model.LedgerTypeRefund,
+							CounterAccount: model.LedgerAccountConsume,
+							RefType:        "task",
+							RefId:          task.TaskID,
+						}, false)
+					}
 					if err != nil {
 						common.LogError(ctx, "fail to increase user quota: "+err.Error())
 					}
@@ -6,6 +6,7 @@ import (
 	"one-api/common"
 	"one-api/model"
 	"strconv"
+	"time"
 )
 
 func GetAllTokens(c *gin.Context) {
@@ -74,11 +75,24 @@ func GetToken(c *gin.Context) {
 		})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"success": true,
 		"message": "",
 		"data":    token,
-	})
+	}
+	if token.IsBudgetEnabled() {
+		start, end := model.GetTokenBudgetWindow(token.BudgetPeriod, time.Now())
+		usages, err := model.GetTokenBudgetUsages(token.Id, start.Unix())
+		if err == nil {
+			response["budget"] = gin.H{
+				"window_start": start.Unix(),
+				"reset_time":   end.Unix(),
+				"used_quota":   usages[""],
+				"model_usages": usages,
+			}
+		}
+	}
+	c.JSON(http.StatusOK, response)
 	return
 }
 
@@ -123,6 +137,36 @@ func AddToken(c *gin.Context) {
 		})
 		return
 	}
+	if err = model.ValidateTokenBudget(token.BudgetPeriod, token.BudgetQuota, token.BudgetModelLimits); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = common.ValidateIPList(token.GetAllowIps() + "\n" + token.GetDenyIps()); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = model.ValidateTokenScopes(token.Scopes); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if token.OrgId != 0 {
+		if err = model.ValidateOrganizationToken(token.OrgId, c.GetInt("id")); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
 	key, err := common.GenerateKey()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -144,7 +188,15 @@ func AddToken(c *gin.Context) {
 		ModelLimitsEnabled: token.ModelLimitsEnabled,
 		ModelLimits:        token.ModelLimits,
 		AllowIps:           token.AllowIps,
+		DenyIps:            token.DenyIps,
 		Group:              token.Group,
+		BudgetPeriod:       token.BudgetPeriod,
+		BudgetQuota:        token.BudgetQuota,
+		BudgetModelLimits:  token.BudgetModelLimits,
+		OrgId:              token.OrgId,
+		TPMLimit:           token.TPMLimit,
+		ConcurrencyLimit:   token.ConcurrencyLimit,
+		Scopes:             token.Scopes,
 	}
 	err = cleanToken.Insert()
 	if err != nil {
@@ -198,6 +250,27 @@ func UpdateToken(c *gin.Context) {
 		})
 		return
 	}
+	if err = model.ValidateTokenBudget(token.BudgetPeriod, token.BudgetQuota, token.BudgetModelLimits); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = common.ValidateIPList(token.GetAllowIps() + "\n" + token.GetDenyIps()); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = model.ValidateTokenScopes(token.Scopes); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	cleanToken, err := model.GetTokenByIds(token.Id, userId)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -228,12 +301,22 @@ func UpdateToken(c *gin.Context) {
 		// If you add more fields, please also update token.Update()
 		cleanToken.Name = token.Name
 		cleanToken.ExpiredTime = token.ExpiredTime
-		cleanToken.RemainQuota = token.RemainQuota
-		cleanToken.UnlimitedQuota = token.UnlimitedQuota
+		if !cleanToken.SelfFunded {
+			// 自付令牌的额度来自兑换码，不允许用户修改
+			cleanToken.RemainQuota = token.RemainQuota
+			cleanToken.UnlimitedQuota = token.UnlimitedQuota
+		}
 		cleanToken.ModelLimitsEnabled = token.ModelLimitsEnabled
 		cleanToken.ModelLimits = token.ModelLimits
 		cleanToken.AllowIps = token.AllowIps
+		cleanToken.DenyIps = token.DenyIps
 		cleanToken.Group = token.Group
+		cleanToken.BudgetPeriod = token.BudgetPeriod
+		cleanToken.BudgetQuota = token.BudgetQuota
+		cleanToken.BudgetModelLimits = token.BudgetModelLimits
+		cleanToken.TPMLimit = token.TPMLimit
+		cleanToken.ConcurrencyLimit = token.ConcurrencyLimit
+		cleanToken.Scopes = token.Scopes
 	}
 	err = cleanToken.Update()
 	if err != nil {
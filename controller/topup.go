@@ -3,18 +3,17 @@ package controller
 import (
 	"fmt"
 	"log"
-	"net/url"
+	"net/http"
 	"one-api/common"
 	"one-api/model"
 	"one-api/service"
+	"one-api/service/payment"
 	"one-api/setting"
 	"strconv"
 	"sync"
 	"time"
 
-	"github.com/Calcium-Ion/go-epay/epay"
 	"github.com/gin-gonic/gin"
-	"github.com/samber/lo"
 	"github.com/shopspring/decimal"
 )
 
@@ -24,26 +23,29 @@ type EpayRequest struct {
 	TopUpCode     string `json:"top_up_code"`
 }
 
+type PaymentRequest struct {
+	Provider      string `json:"provider"`
+	Amount        int64  `json:"amount"`
+	PaymentMethod string `json:"payment_method"`
+	TopUpCode     string `json:"top_up_code"`
+}
+
 type AmountRequest struct {
 	Amount    int64  `json:"amount"`
 	TopUpCode string `json:"top_up_code"`
+	Provider  string `json:"provider"`
 }
 
-func GetEpayClient() *epay.Client {
-	if setting.PayAddress == "" || setting.EpayId == "" || setting.EpayKey == "" {
-		return nil
-	}
-	withUrl, err := epay.NewClient(&epay.Config{
-		PartnerID: setting.EpayId,
-		Key:       setting.EpayKey,
-	}, setting.PayAddress)
-	if err != nil {
-		return nil
+// getPaymentNotifyUrl 返回支付渠道的回调地址，易支付沿用原有地址
+func getPaymentNotifyUrl(providerName string) string {
+	callBackAddress := service.GetCallbackAddress()
+	if providerName == "epay" {
+		return callBackAddress + "/api/user/epay/notify"
 	}
-	return withUrl
+	return callBackAddress + "/api/payment/notify/" + providerName
 }
 
-func getPayMoney(amount int64, group string) float64 {
+func getPayMoney(amount int64, group string, unitPrice float64) float64 {
 	dAmount := decimal.NewFromInt(amount)
 
 	if !common.DisplayInCurrencyEnabled {
@@ -57,7 +59,7 @@ func getPayMoney(amount int64, group string) float64 {
 	}
 
 	dTopupGroupRatio := decimal.NewFromFloat(topupGroupRatio)
-	dPrice := decimal.NewFromFloat(setting.Price)
+	dPrice := decimal.NewFromFloat(unitPrice)
 
 	payMoney := dAmount.Mul(dPrice).Mul(dTopupGroupRatio)
 
@@ -81,7 +83,30 @@ func RequestEpay(c *gin.Context) {
 		c.JSON(200, gin.H{"message": "error", "data": "参数错误"})
 		return
 	}
-	if req.Amount < getMinTopup() {
+	requestTopUpPayment(c, "epay", req.Amount, req.PaymentMethod)
+}
+
+// RequestPayment 通过指定的支付渠道发起充值
+func RequestPayment(c *gin.Context) {
+	var req PaymentRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(200, gin.H{"message": "error", "data": "参数错误"})
+		return
+	}
+	if req.Provider == "" {
+		req.Provider = "epay"
+	}
+	requestTopUpPayment(c, req.Provider, req.Amount, req.PaymentMethod)
+}
+
+func requestTopUpPayment(c *gin.Context, providerName string, amount int64, paymentMethod string) {
+	provider, err := payment.GetProvider(providerName)
+	if err != nil {
+		c.JSON(200, gin.H{"message": "error", "data": "当前管理员未配置支付信息"})
+		return
+	}
+	if amount < getMinTopup() {
 		c.JSON(200, gin.H{"message": "error", "data": fmt.Sprintf("充值数量不能小于 %d", getMinTopup())})
 		return
 	}
@@ -92,62 +117,51 @@ func RequestEpay(c *gin.Context) {
 		c.JSON(200, gin.H{"message": "error", "data": "获取用户分组失败"})
 		return
 	}
-	payMoney := getPayMoney(req.Amount, group)
+	payMoney := provider.RoundMoney(getPayMoney(amount, group, provider.UnitPrice()))
 	if payMoney < 0.01 {
 		c.JSON(200, gin.H{"message": "error", "data": "充值金额过低"})
 		return
 	}
-	payType := "wxpay"
-	if req.PaymentMethod == "zfb" {
-		payType = "alipay"
-	}
-	if req.PaymentMethod == "wx" {
-		req.PaymentMethod = "wxpay"
-		payType = "wxpay"
+	if paymentMethod == "wx" {
+		paymentMethod = "wxpay"
 	}
-	callBackAddress := service.GetCallbackAddress()
-	returnUrl, _ := url.Parse(setting.ServerAddress + "/log")
-	notifyUrl, _ := url.Parse(callBackAddress + "/api/user/epay/notify")
 	tradeNo := fmt.Sprintf("%s%d", common.GetRandomString(6), time.Now().Unix())
 	tradeNo = fmt.Sprintf("USR%dNO%s", id, tradeNo)
-	client := GetEpayClient()
-	if client == nil {
-		c.JSON(200, gin.H{"message": "error", "data": "当前管理员未配置支付信息"})
-		return
-	}
-	uri, params, err := client.Purchase(&epay.PurchaseArgs{
-		Type:           payType,
-		ServiceTradeNo: tradeNo,
-		Name:           fmt.Sprintf("TUC%d", req.Amount),
-		Money:          strconv.FormatFloat(payMoney, 'f', 2, 64),
-		Device:         epay.PC,
-		NotifyUrl:      notifyUrl,
-		ReturnUrl:      returnUrl,
+	result, err := provider.CreateOrder(&payment.Order{
+		TradeNo:       tradeNo,
+		Name:          fmt.Sprintf("TUC%d", amount),
+		Money:         payMoney,
+		PaymentMethod: paymentMethod,
+		NotifyUrl:     getPaymentNotifyUrl(providerName),
+		ReturnUrl:     setting.ServerAddress + "/log",
 	})
 	if err != nil {
+		common.SysError(fmt.Sprintf("failed to create %s payment order: %s", providerName, err.Error()))
 		c.JSON(200, gin.H{"message": "error", "data": "拉起支付失败"})
 		return
 	}
-	amount := req.Amount
 	if !common.DisplayInCurrencyEnabled {
 		dAmount := decimal.NewFromInt(int64(amount))
 		dQuotaPerUnit := decimal.NewFromFloat(common.QuotaPerUnit)
 		amount = dAmount.Div(dQuotaPerUnit).IntPart()
 	}
 	topUp := &model.TopUp{
-		UserId:     id,
-		Amount:     amount,
-		Money:      payMoney,
-		TradeNo:    tradeNo,
-		CreateTime: time.Now().Unix(),
-		Status:     "pending",
+		UserId:          id,
+		Amount:          amount,
+		Money:           payMoney,
+		TradeNo:         tradeNo,
+		CreateTime:      time.Now().Unix(),
+		Status:          model.TopUpStatusPending,
+		PaymentProvider: providerName,
+		PaymentMethod:   paymentMethod,
+		ProviderOrderId: result.ProviderOrderId,
 	}
 	err = topUp.Insert()
 	if err != nil {
 		c.JSON(200, gin.H{"message": "error", "data": "创建订单失败"})
 		return
 	}
-	c.JSON(200, gin.H{"message": "success", "data": params, "url": uri})
+	c.JSON(200, gin.H{"message": "success", "data": result.Params, "url": result.Url})
 }
 
 // tradeNo lock
@@ -178,66 +192,122 @@ func UnlockOrder(tradeNo string) {
 }
 
 func EpayNotify(c *gin.Context) {
-	params := lo.Reduce(lo.Keys(c.Request.URL.Query()), func(r map[string]string, t string, i int) map[string]string {
-		r[t] = c.Request.URL.Query().Get(t)
-		return r
-	}, map[string]string{})
-	client := GetEpayClient()
-	if client == nil {
-		log.Println("易支付回调失败 未找到配置信息")
-		_, err := c.Writer.Write([]byte("fail"))
-		if err != nil {
-			log.Println("易支付回调写入失败")
-			return
-		}
+	handlePaymentCallback(c, "epay")
+}
+
+// PaymentNotify 接收各支付渠道的异步回调
+func PaymentNotify(c *gin.Context) {
+	handlePaymentCallback(c, c.Param("provider"))
+}
+
+func handlePaymentCallback(c *gin.Context, providerName string) {
+	provider, err := payment.GetProvider(providerName)
+	if err != nil {
+		log.Printf("支付回调失败 未找到 %s 的配置信息", providerName)
+		c.String(http.StatusBadRequest, "fail")
+		return
 	}
-	verifyInfo, err := client.Verify(params)
-	if err == nil && verifyInfo.VerifyStatus {
-		_, err := c.Writer.Write([]byte("success"))
-		if err != nil {
-			log.Println("易支付回调写入失败")
+	result, err := provider.VerifyCallback(c.Request)
+	if err != nil {
+		log.Printf("%s 支付回调验证失败: %s", providerName, err.Error())
+		provider.WriteCallbackResponse(c.Writer, false)
+		return
+	}
+	if result.TradeNo == "" {
+		provider.WriteCallbackResponse(c.Writer, true)
+		return
+	}
+	// 订单入账提交后才应答成功，处理失败时由支付渠道重试回调
+	_, err = applyPaymentResult(provider, result.TradeNo, result.ProviderOrderId, result.Status, result.Money)
+	if err != nil {
+		log.Printf("%s 支付回调处理订单 %s 失败: %s", providerName, result.TradeNo, err.Error())
+		provider.WriteCallbackResponse(c.Writer, false)
+		return
+	}
+	provider.WriteCallbackResponse(c.Writer, true)
+}
+
+// applyPaymentResult 根据支付渠道确认的订单状态推进本地订单，订单首次变为成功时入账，实付金额与订单金额不一致时拒绝入账
+func applyPaymentResult(provider payment.PaymentProvider, tradeNo string, providerOrderId string, status string, money float64) (*model.TopUp, error) {
+	LockOrder(tradeNo)
+	defer UnlockOrder(tradeNo)
+	topUp := model.GetTopUpByTradeNo(tradeNo)
+	if topUp == nil {
+		return nil, fmt.Errorf("未找到订单 %s", tradeNo)
+	}
+	if topUp.PaymentProvider != provider.Name() {
+		return nil, fmt.Errorf("订单 %s 不属于支付渠道 %s", tradeNo, provider.Name())
+	}
+	if providerOrderId != "" {
+		topUp.ProviderOrderId = providerOrderId
+	}
+	switch status {
+	case payment.OrderStatusPaid:
+		if !model.CanTransitTopUpStatus(topUp.Status, model.TopUpStatusSuccess) {
+			return topUp, nil
 		}
-	} else {
-		_, err := c.Writer.Write([]byte("fail"))
-		if err != nil {
-			log.Println("易支付回调写入失败")
+		if !payment.MoneyEqual(provider, money, topUp.Money) {
+			return topUp, fmt.Errorf("订单 %s 实付金额 %f 与订单金额 %f 不一致", tradeNo, money, topUp.Money)
+		}
+		return topUp, fulfillTopUp(topUp)
+	case payment.OrderStatusExpired:
+		if topUp.Status == model.TopUpStatusPending {
+			_, err := topUp.TransitStatus(model.TopUpStatusExpired)
+			return topUp, err
 		}
-		log.Println("易支付回调签名验证失败")
-		return
 	}
+	return topUp, nil
+}
 
-	if verifyInfo.TradeStatus == epay.StatusTradeSuccess {
-		log.Println(verifyInfo)
-		LockOrder(verifyInfo.ServiceTradeNo)
-		defer UnlockOrder(verifyInfo.ServiceTradeNo)
-		topUp := model.GetTopUpByTradeNo(verifyInfo.ServiceTradeNo)
-		if topUp == nil {
-			log.Printf("易支付回调未找到订单: %v", verifyInfo)
-			return
+// fulfillTopUp 在同一事务内将支付成功的订单置为成功并增加用户额度或开通订阅套餐
+func fulfillTopUp(topUp *model.TopUp) error {
+	if topUp.PlanId > 0 {
+		plan, completed, err := topUp.CompleteSubscriptionTopUp()
+		if err != nil || !completed {
+			return err
 		}
-		if topUp.Status == "pending" {
-			topUp.Status = "success"
-			err := topUp.Update()
-			if err != nil {
-				log.Printf("易支付回调更新订单失败: %v", topUp)
-				return
-			}
-			//user, _ := model.GetUserById(topUp.UserId, false)
-			//user.Quota += topUp.Amount * 500000
-			dAmount := decimal.NewFromInt(int64(topUp.Amount))
-			dQuotaPerUnit := decimal.NewFromFloat(common.QuotaPerUnit)
-			quotaToAdd := int(dAmount.Mul(dQuotaPerUnit).IntPart())
-			err = model.IncreaseUserQuota(topUp.UserId, quotaToAdd, true)
+		log.Printf("支付回调开通订阅成功 %v", topUp)
+		model.RecordLog(topUp.UserId, model.LogTypeTopup, fmt.Sprintf("在线支付购买订阅套餐 %s，支付金额：%f", plan.Name, topUp.Money))
+		return nil
+	}
+	dAmount := decimal.NewFromInt(int64(topUp.Amount))
+	dQuotaPerUnit := decimal.NewFromFloat(common.QuotaPerUnit)
+	quotaToAdd := int(dAmount.Mul(dQuotaPerUnit).IntPart())
+	completed, err := topUp.CompleteQuotaTopUp(quotaToAdd)
+	if err != nil || !completed {
+		return err
+	}
+	log.Printf("支付回调更新用户成功 %v", topUp)
+	model.RecordLog(topUp.UserId, model.LogTypeTopup, fmt.Sprintf("使用在线充值成功，充值金额: %v，支付金额：%f", common.LogQuota(quotaToAdd), topUp.Money))
+	return nil
+}
+
+// GetPaymentOrder 查询当前用户的充值订单，未完成的订单会向支付渠道主动查询状态
+func GetPaymentOrder(c *gin.Context) {
+	topUp := model.GetTopUpByTradeNo(c.Param("trade_no"))
+	if topUp == nil || topUp.UserId != c.GetInt("id") {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": "订单不存在"})
+		return
+	}
+	if topUp.Status == model.TopUpStatusPending || topUp.Status == model.TopUpStatusExpired {
+		provider, err := payment.GetProvider(topUp.PaymentProvider)
+		if err == nil {
+			result, err := provider.QueryOrder(topUp.TradeNo, topUp.ProviderOrderId)
 			if err != nil {
-				log.Printf("易支付回调更新用户失败: %v", topUp)
-				return
+				common.SysError(fmt.Sprintf("failed to query payment order %s: %s", topUp.TradeNo, err.Error()))
+			} else if updated, err := applyPaymentResult(provider, topUp.TradeNo, result.ProviderOrderId, result.Status, result.Money); err != nil {
+				common.SysError(fmt.Sprintf("failed to apply payment order %s: %s", topUp.TradeNo, err.Error()))
+			} else {
+				topUp = updated
 			}
-			log.Printf("易支付回调更新用户成功 %v", topUp)
-			model.RecordLog(topUp.UserId, model.LogTypeTopup, fmt.Sprintf("使用在线充值成功，充值金额: %v，支付金额：%f", common.LogQuota(quotaToAdd), topUp.Money))
 		}
-	} else {
-		log.Printf("易支付异常回调: %v", verifyInfo)
 	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "", "data": topUp})
+}
+
+// GetPaymentProviders 返回已配置的支付渠道
+func GetPaymentProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"success": true, "message": "", "data": payment.GetEnabledProviderNames()})
 }
 
 func RequestAmount(c *gin.Context) {
@@ -258,7 +328,16 @@ func RequestAmount(c *gin.Context) {
 		c.JSON(200, gin.H{"message": "error", "data": "获取用户分组失败"})
 		return
 	}
-	payMoney := getPayMoney(req.Amount, group)
+	unitPrice := setting.Price
+	if req.Provider != "" {
+		provider, err := payment.GetProvider(req.Provider)
+		if err != nil {
+			c.JSON(200, gin.H{"message": "error", "data": "当前管理员未配置支付信息"})
+			return
+		}
+		unitPrice = provider.UnitPrice()
+	}
+	payMoney := getPayMoney(req.Amount, group, unitPrice)
 	if payMoney <= 0.01 {
 		c.JSON(200, gin.H{"message": "error", "data": "充值金额过低"})
 		return
@@ -0,0 +1,341 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"one-api/service"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 密码或第三方登录通过后，等待输入两步验证码的有效期
+const twoFAPendingLoginTTL = 5 * time.Minute
+
+type TwoFACodeRequest struct {
+	Code string `json:"code"`
+}
+
+// setupTwoFAPendingLogin 记录待验证的登录，通过 LoginTwoFA 校验验证码后才写入登录会话
+func setupTwoFAPendingLogin(user *model.User, c *gin.Context) {
+	session := sessions.Default(c)
+	for _, key := range []string{"id", "username", "role", "status", "group"} {
+		session.Delete(key)
+	}
+	session.Set("two_fa_pending_user_id", user.Id)
+	session.Set("two_fa_pending_time", time.Now().Unix())
+	err := session.Save()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "无法保存会话信息，请重试",
+			"success": false,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "请输入两步验证码",
+		"success": true,
+		"data": gin.H{
+			"require_two_fa": true,
+		},
+	})
+}
+
+func bindTwoFACode(c *gin.Context) (string, bool) {
+	var req TwoFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Code == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "请输入验证码",
+		})
+		return "", false
+	}
+	return req.Code, true
+}
+
+// LoginTwoFA 登录的第二步，校验验证码或恢复码后完成登录
+func LoginTwoFA(c *gin.Context) {
+	session := sessions.Default(c)
+	userId, ok := session.Get("two_fa_pending_user_id").(int)
+	pendingTime, _ := session.Get("two_fa_pending_time").(int64)
+	if !ok || time.Since(time.Unix(pendingTime, 0)) > twoFAPendingLoginTTL {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "登录状态已过期，请重新登录",
+		})
+		return
+	}
+	code, ok := bindTwoFACode(c)
+	if !ok {
+		return
+	}
+	user, err := model.GetUserById(userId, false)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if user.Status != common.UserStatusEnabled {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "用户已被封禁",
+		})
+		return
+	}
+	twoFA, err := model.GetTwoFAByUserId(userId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	passed, usedRecoveryCode, err := twoFA.Verify(code)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if !passed {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "验证码错误或已被使用",
+		})
+		return
+	}
+	if usedRecoveryCode {
+		model.RecordLog(userId, model.LogTypeSystem, fmt.Sprintf("使用恢复码完成两步验证登录，剩余恢复码 %d 个，IP：%s", twoFA.RecoveryCodesRemaining(), c.ClientIP()))
+	}
+	completeLogin(user, c)
+}
+
+// GetTwoFAStatus 返回当前用户的两步验证状态
+func GetTwoFAStatus(c *gin.Context) {
+	enabled := false
+	recoveryCodesRemaining := 0
+	twoFA, err := model.GetTwoFAByUserId(c.GetInt("id"))
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err == nil && twoFA.Enabled {
+		enabled = true
+		recoveryCodesRemaining = twoFA.RecoveryCodesRemaining()
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"enabled":                  enabled,
+			"required":                 model.TwoFARequired(c.GetInt("role")),
+			"recovery_codes_remaining": recoveryCodesRemaining,
+		},
+	})
+}
+
+// SetupTwoFA 生成新的两步验证密钥，返回密钥与用于生成二维码的 otpauth 链接，验证通过后才会启用
+func SetupTwoFA(c *gin.Context) {
+	secret, err := model.SetupTwoFA(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"secret":      secret,
+			"otpauth_url": common.TOTPURI(common.SystemName, c.GetString("username"), secret),
+		},
+	})
+}
+
+// EnableTwoFA 校验验证器生成的验证码后启用两步验证，返回只显示一次的恢复码
+func EnableTwoFA(c *gin.Context) {
+	code, ok := bindTwoFACode(c)
+	if !ok {
+		return
+	}
+	userId := c.GetInt("id")
+	twoFA, err := model.GetTwoFAByUserId(userId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "请先生成两步验证密钥",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	recoveryCodes, err := twoFA.Enable(code)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.RecordLog(userId, model.LogTypeSystem, fmt.Sprintf("启用了两步验证，IP：%s", c.ClientIP()))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "两步验证已启用，请妥善保存恢复码",
+		"data": gin.H{
+			"recovery_codes": recoveryCodes,
+		},
+	})
+}
+
+// getEnabledTwoFA 返回已启用的两步验证配置并校验验证码，失败时已写入响应
+func getEnabledTwoFA(c *gin.Context, code string) (*model.TwoFA, bool) {
+	twoFA, err := model.GetTwoFAByUserId(c.GetInt("id"))
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !twoFA.Enabled) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "未启用两步验证",
+		})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return nil, false
+	}
+	passed, _, err := twoFA.Verify(code)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return nil, false
+	}
+	if !passed {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "验证码错误或已被使用",
+		})
+		return nil, false
+	}
+	return twoFA, true
+}
+
+// DisableTwoFA 校验验证码或恢复码后关闭两步验证
+func DisableTwoFA(c *gin.Context) {
+	code, ok := bindTwoFACode(c)
+	if !ok {
+		return
+	}
+	if model.TwoFARequired(c.GetInt("role")) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "管理员账户必须启用两步验证，无法关闭",
+		})
+		return
+	}
+	if _, ok := getEnabledTwoFA(c, code); !ok {
+		return
+	}
+	userId := c.GetInt("id")
+	if err := model.DeleteTwoFAByUserId(userId); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.RecordLog(userId, model.LogTypeSystem, fmt.Sprintf("关闭了两步验证，IP：%s", c.ClientIP()))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "两步验证已关闭",
+	})
+}
+
+// RegenerateTwoFARecoveryCodes 校验验证码后重新生成恢复码，旧的恢复码全部作废
+func RegenerateTwoFARecoveryCodes(c *gin.Context) {
+	code, ok := bindTwoFACode(c)
+	if !ok {
+		return
+	}
+	twoFA, ok := getEnabledTwoFA(c, code)
+	if !ok {
+		return
+	}
+	recoveryCodes, err := twoFA.RegenerateRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.RecordLog(c.GetInt("id"), model.LogTypeSystem, fmt.Sprintf("重新生成了两步验证恢复码，IP：%s", c.ClientIP()))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"recovery_codes": recoveryCodes,
+		},
+	})
+}
+
+// ResetUserTwoFA 管理员为丢失验证器与恢复码的用户关闭两步验证
+func ResetUserTwoFA(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	user, err := model.GetUserById(id, false)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if !model.CanManageUser(c.GetInt("role"), user.Role) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无权重置管理员的两步验证",
+		})
+		return
+	}
+	if err := model.DeleteTwoFAByUserId(id); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.RecordLog(id, model.LogTypeManage, fmt.Sprintf("管理员 %s 重置了用户的两步验证", c.GetString("username")))
+	service.RecordAuditAction(c, "user.reset_2fa", model.AuditTargetUser, id, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"one-api/common"
 	"one-api/model"
+	"one-api/service"
 	"one-api/setting"
 	"strconv"
 	"strings"
@@ -66,7 +67,27 @@ func Login(c *gin.Context) {
 
 // setup session & cookies and then return user info
 func setupLogin(user *model.User, c *gin.Context) {
+	twoFAEnabled, err := model.IsTwoFAEnabled(user.Id)
+	if err != nil {
+		common.SysError("failed to get two-factor status: " + err.Error())
+	}
+	if twoFAEnabled {
+		setupTwoFAPendingLogin(user, c)
+		return
+	}
+	completeLogin(user, c)
+}
+
+// completeLogin 写入登录会话并返回用户信息，强制启用两步验证的管理员尚未启用时只能访问两步验证相关接口
+func completeLogin(user *model.User, c *gin.Context) {
+	twoFASetupRequired := model.TwoFARequired(user.Role)
+	if twoFASetupRequired {
+		twoFAEnabled, _ := model.IsTwoFAEnabled(user.Id)
+		twoFASetupRequired = !twoFAEnabled
+	}
 	session := sessions.Default(c)
+	session.Delete("two_fa_pending_user_id")
+	session.Delete("two_fa_pending_time")
 	session.Set("id", user.Id)
 	session.Set("username", user.Username)
 	session.Set("role", user.Role)
@@ -89,9 +110,10 @@ func setupLogin(user *model.User, c *gin.Context) {
 		Group:       user.Group,
 	}
 	c.JSON(http.StatusOK, gin.H{
-		"message": "",
-		"success": true,
-		"data":    cleanUser,
+		"message":              "",
+		"success":              true,
+		"data":                 cleanUser,
+		"require_two_fa_setup": twoFASetupRequired,
 	})
 }
 
@@ -259,6 +281,9 @@ func GetAllUsers(c *gin.Context) {
 		})
 		return
 	}
+	for _, user := range users {
+		user.MaskSecrets()
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -292,6 +317,9 @@ func SearchUsers(c *gin.Context) {
 		})
 		return
 	}
+	for _, user := range users {
+		user.MaskSecrets()
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -322,14 +350,14 @@ func GetUser(c *gin.Context) {
 		})
 		return
 	}
-	myRole := c.GetInt("role")
-	if myRole <= user.Role && myRole != common.RoleRootUser {
+	if !model.CanManageUser(c.GetInt("role"), user.Role) {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
-			"message": "无权获取同级或更高等级用户的信息",
+			"message": "无权获取管理员的信息",
 		})
 		return
 	}
+	user.MaskSecrets()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -459,6 +487,13 @@ func GetSelf(c *gin.Context) {
 		})
 		return
 	}
+	user.Subscription, err = model.GetActiveSubscription(id)
+	if err != nil {
+		common.SysError("failed to get user subscription: " + err.Error())
+	}
+	user.Concurrency = service.GetUserConcurrency(id, user.Group)
+	user.Permissions = model.GetUserPermissions(user.Role, user.PermissionRole)
+	user.MaskSecrets()
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -497,6 +532,62 @@ func GetUserModels(c *gin.Context) {
 	return
 }
 
+type UpdateUserIpLimitsRequest struct {
+	Id       int    `json:"id"`
+	AllowIps string `json:"allow_ips"`
+	DenyIps  string `json:"deny_ips"`
+}
+
+// UpdateUserIpLimits 设置用户所有令牌共用的 IP 黑白名单
+func UpdateUserIpLimits(c *gin.Context) {
+	var req UpdateUserIpLimitsRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Id == 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	if err := common.ValidateIPList(req.AllowIps + "\n" + req.DenyIps); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	originUser, err := model.GetUserById(req.Id, false)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if !model.CanManageUser(c.GetInt("role"), originUser.Role) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无权更新管理员的信息",
+		})
+		return
+	}
+	if err = model.UpdateUserIpLimits(req.Id, req.AllowIps, req.DenyIps); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	updatedUser := *originUser
+	updatedUser.AllowIps = req.AllowIps
+	updatedUser.DenyIps = req.DenyIps
+	service.RecordAudit(c, "user.update_ip_limits", model.AuditTargetUser, req.Id, auditUserSnapshot(originUser), auditUserSnapshot(&updatedUser))
+	model.RecordLog(req.Id, model.LogTypeManage, "管理员更新了用户的 IP 黑白名单")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
 func UpdateUser(c *gin.Context) {
 	var updatedUser model.User
 	err := json.NewDecoder(c.Request.Body).Decode(&updatedUser)
@@ -526,17 +617,24 @@ func UpdateUser(c *gin.Context) {
 		return
 	}
 	myRole := c.GetInt("role")
-	if myRole <= originUser.Role && myRole != common.RoleRootUser {
+	if !model.CanManageUser(myRole, originUser.Role) {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
-			"message": "无权更新同权限等级或更高权限等级的用户信息",
+			"message": "无权更新管理员的信息",
 		})
 		return
 	}
-	if myRole <= updatedUser.Role && myRole != common.RoleRootUser {
+	if !model.CanManageUser(myRole, updatedUser.Role) {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
-			"message": "无权将其他用户权限等级提升到大于等于自己的权限等级",
+			"message": "无权将其他用户提升为管理员",
+		})
+		return
+	}
+	if originUser.Quota != updatedUser.Quota && !model.HasAnyPermission(myRole, c.GetString("permission_role"), model.PermissionUserQuota) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无权修改用户额度，缺少权限 " + model.PermissionUserQuota,
 		})
 		return
 	}
@@ -544,13 +642,18 @@ func UpdateUser(c *gin.Context) {
 		updatedUser.Password = "" // rollback to what it should be
 	}
 	updatePassword := updatedUser.Password != ""
-	if err := updatedUser.Edit(updatePassword); err != nil {
+	if err := updatedUser.Edit(updatePassword, c.GetInt("id")); err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
 			"message": err.Error(),
 		})
 		return
 	}
+	updatedSnapshot := auditUserSnapshot(&updatedUser)
+	if updatePassword {
+		updatedSnapshot["password"] = "changed"
+	}
+	service.RecordAudit(c, "user.update", model.AuditTargetUser, updatedUser.Id, auditUserSnapshot(originUser), updatedSnapshot)
 	if originUser.Quota != updatedUser.Quota {
 		model.RecordLog(originUser.Id, model.LogTypeManage, fmt.Sprintf("管理员将用户额度从 %s修改为 %s", common.LogQuota(originUser.Quota), common.LogQuota(updatedUser.Quota)))
 	}
@@ -649,11 +752,17 @@ func DeleteUser(c *gin.Context) {
 		})
 		return
 	}
-	myRole := c.GetInt("role")
-	if myRole <= originUser.Role {
+	if !model.CanManageUser(c.GetInt("role"), originUser.Role) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无权删除管理员",
+		})
+		return
+	}
+	if originUser.Role == common.RoleRootUser {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
-			"message": "无权删除同权限等级或更高权限等级的用户",
+			"message": "无法删除超级管理员用户",
 		})
 		return
 	}
@@ -665,6 +774,7 @@ func DeleteUser(c *gin.Context) {
 		})
 		return
 	}
+	service.RecordAudit(c, "user.hard_delete", model.AuditTargetUser, id, auditUserSnapshot(originUser), nil)
 }
 
 func DeleteSelf(c *gin.Context) {
@@ -715,11 +825,10 @@ func CreateUser(c *gin.Context) {
 	if user.DisplayName == "" {
 		user.DisplayName = user.Username
 	}
-	myRole := c.GetInt("role")
-	if user.Role >= myRole {
+	if !model.CanManageUser(c.GetInt("role"), user.Role) || user.Role >= common.RoleRootUser {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
-			"message": "无法创建权限大于等于自己的用户",
+			"message": "无权创建管理员或超级管理员用户",
 		})
 		return
 	}
@@ -736,6 +845,7 @@ func CreateUser(c *gin.Context) {
 		})
 		return
 	}
+	service.RecordAudit(c, "user.create", model.AuditTargetUser, cleanUser.Id, nil, auditUserSnapshot(&cleanUser))
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -774,13 +884,14 @@ func ManageUser(c *gin.Context) {
 		return
 	}
 	myRole := c.GetInt("role")
-	if myRole <= user.Role && myRole != common.RoleRootUser {
+	if !model.CanManageUser(myRole, user.Role) {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
-			"message": "无权更新同权限等级或更高权限等级的用户信息",
+			"message": "无权更新管理员的信息",
 		})
 		return
 	}
+	originSnapshot := auditUserSnapshot(&user)
 	switch req.Action {
 	case "disable":
 		user.Status = common.UserStatusDisabled
@@ -849,6 +960,22 @@ func ManageUser(c *gin.Context) {
 		})
 		return
 	}
+	// 自定义角色只对管理员生效，降级为普通用户时一并移除
+	if req.Action == "demote" && user.PermissionRole != "" {
+		if err := model.SetUserPermissionRole(user.Id, ""); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		user.PermissionRole = ""
+	}
+	if req.Action == "delete" {
+		service.RecordAudit(c, "user.delete", model.AuditTargetUser, user.Id, originSnapshot, nil)
+	} else {
+		service.RecordAudit(c, "user."+req.Action, model.AuditTargetUser, user.Id, originSnapshot, auditUserSnapshot(&user))
+	}
 	clearUser := model.User{
 		Role:   user.Role,
 		Status: user.Status,
@@ -1024,7 +1151,12 @@ func UpdateUserSetting(c *gin.Context) {
 	// 如果是webhook类型,添加webhook相关设置
 	if req.QuotaWarningType == constant.NotifyTypeWebhook {
 		settings[constant.UserSettingWebhookUrl] = req.WebhookUrl
-		if req.WebhookSecret != "" {
+		if common.IsMaskedSecret(req.WebhookSecret) {
+			// 前端原样提交的脱敏密钥表示不修改密钥
+			if secret, ok := user.GetSetting()[constant.UserSettingWebhookSecret].(string); ok && secret != "" {
+				settings[constant.UserSettingWebhookSecret] = secret
+			}
+		} else if req.WebhookSecret != "" {
 			settings[constant.UserSettingWebhookSecret] = req.WebhookSecret
 		}
 	}
@@ -1034,6 +1166,14 @@ func UpdateUserSetting(c *gin.Context) {
 		settings[constant.UserSettingNotificationEmail] = req.NotificationEmail
 	}
 
+	if err := model.EncryptUserSettingSecrets(settings); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "更新设置失败: " + err.Error(),
+		})
+		return
+	}
+
 	// 更新用户设置
 	user.SetSetting(settings)
 	if err := user.Update(false); err != nil {
@@ -1049,3 +1189,19 @@ func UpdateUserSetting(c *gin.Context) {
 		"message": "设置已更新",
 	})
 }
+
+// auditUserSnapshot 审计日志中比较的用户字段，密码、访问令牌与个人设置不参与比较
+func auditUserSnapshot(user *model.User) gin.H {
+	return gin.H{
+		"username":        user.Username,
+		"display_name":    user.DisplayName,
+		"role":            user.Role,
+		"status":          user.Status,
+		"email":           user.Email,
+		"group":           user.Group,
+		"quota":           user.Quota,
+		"allow_ips":       user.AllowIps,
+		"deny_ips":        user.DenyIps,
+		"permission_role": user.PermissionRole,
+	}
+}
@@ -53,6 +53,15 @@ func main() {
 		common.FatalLog("failed to initialize database: " + err.Error())
 	}
 
+	if *common.Reencrypt {
+		channelCount, userCount, err := model.ReencryptSecrets()
+		if err != nil {
+			common.FatalLog("failed to re-encrypt secrets: " + err.Error())
+		}
+		common.SysLog(fmt.Sprintf("re-encrypted %d channel keys and %d user secrets", channelCount, userCount))
+		os.Exit(0)
+	}
+
 	model.CheckSetup()
 
 	// Initialize SQL Database
@@ -112,6 +121,39 @@ func main() {
 	// 数据看板
 	go model.UpdateQuotaData()
 
+	// 语义缓存过期清理
+	go service.StartSemanticCacheCleanup(600)
+
+	// 订阅套餐额度重置与续费
+	if common.IsMasterNode {
+		go service.StartSubscriptionTask(60)
+	}
+
+	// 令牌预算用量记录清理
+	if common.IsMasterNode {
+		go service.StartTokenBudgetCleanupTask(3600)
+	}
+
+	// 月度账单生成
+	if common.IsMasterNode {
+		go service.StartStatementTask(3600)
+	}
+
+	// 兑换码限时分组升级到期恢复
+	if common.IsMasterNode {
+		go service.StartRedemptionTask(300)
+	}
+
+	// 额度流水核对
+	if common.IsMasterNode {
+		go service.StartQuotaLedgerReconcileTask(3600)
+	}
+
+	// 审计日志过期清理
+	if common.IsMasterNode {
+		go service.StartAuditLogCleanupTask(3600)
+	}
+
 	if os.Getenv("CHANNEL_UPDATE_FREQUENCY") != "" {
 		frequency, err := strconv.Atoi(os.Getenv("CHANNEL_UPDATE_FREQUENCY"))
 		if err != nil {
@@ -150,6 +192,21 @@ func main() {
 
 	// Initialize HTTP server
 	server := gin.New()
+	if common.TrustedProxies != "" {
+		err = server.SetTrustedProxies(common.SplitIPList(common.TrustedProxies))
+		if err != nil {
+			common.FatalLog("failed to set trusted proxies: " + err.Error())
+		}
+	}
+	switch common.TrustedPlatform {
+	case "":
+	case "cloudflare":
+		server.TrustedPlatform = gin.PlatformCloudflare
+	case "google":
+		server.TrustedPlatform = gin.PlatformGoogleAppEngine
+	default:
+		server.TrustedPlatform = common.TrustedPlatform
+	}
 	server.Use(gin.CustomRecovery(func(c *gin.Context, err any) {
 		common.SysError(fmt.Sprintf("panic detected: %v", err))
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"fmt"
 	"net/http"
 	"one-api/common"
 	"one-api/model"
@@ -22,7 +23,8 @@ func validUserInfo(username string, role int) bool {
 	return true
 }
 
-func authHelper(c *gin.Context, minRole int) {
+// authHelper 校验登录状态与权限等级，指定 permissions 时还需拥有其中任意一个权限
+func authHelper(c *gin.Context, minRole int, permissions ...string) {
 	session := sessions.Default(c)
 	username := session.Get("username")
 	role := session.Get("role")
@@ -117,14 +119,60 @@ func authHelper(c *gin.Context, minRole int) {
 		c.Abort()
 		return
 	}
+	// 自定义角色只对管理员生效，普通用户无需读取
+	permissionRole := ""
+	if role.(int) >= common.RoleAdminUser {
+		userCache, err := model.GetUserCache(id.(int))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无权进行此操作，用户信息无效",
+			})
+			c.Abort()
+			return
+		}
+		permissionRole = userCache.PermissionRole
+	}
+	// 强制启用两步验证的管理员尚未启用时，登录会话只能访问两步验证相关接口，access token 不受影响
+	if !useAccessToken && model.TwoFARequired(role.(int)) && !isTwoFASetupRequest(c) {
+		twoFAEnabled, err := model.IsTwoFAEnabledCached(id.(int))
+		if err != nil {
+			common.SysError("failed to get two-factor status: " + err.Error())
+		}
+		if !twoFAEnabled {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "管理员账户必须先启用两步验证",
+			})
+			c.Abort()
+			return
+		}
+	}
+	if len(permissions) > 0 && !model.HasAnyPermission(role.(int), permissionRole, permissions...) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无权进行此操作，缺少权限 " + strings.Join(permissions, " 或 "),
+		})
+		c.Abort()
+		return
+	}
 	c.Set("username", username)
 	c.Set("role", role)
 	c.Set("id", id)
+	c.Set("permission_role", permissionRole)
 	c.Set("group", session.Get("group"))
 	c.Set("use_access_token", useAccessToken)
 	c.Next()
 }
 
+func isTwoFASetupRequest(c *gin.Context) bool {
+	path := c.Request.URL.Path
+	if path == "/api/user/self" {
+		return c.Request.Method == http.MethodGet
+	}
+	return path == "/api/user/2fa" || strings.HasPrefix(path, "/api/user/2fa/")
+}
+
 func TryUserAuth() func(c *gin.Context) {
 	return func(c *gin.Context) {
 		session := sessions.Default(c)
@@ -154,6 +202,13 @@ func RootAuth() func(c *gin.Context) {
 	}
 }
 
+// PermissionAuth 要求登录用户拥有任意一个指定的权限，权限来自用户的自定义角色或内置角色
+func PermissionAuth(permissions ...string) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		authHelper(c, common.RoleCommonUser, permissions...)
+	}
+}
+
 func WssAuth(c *gin.Context) {
 
 }
@@ -242,8 +297,45 @@ func TokenAuth() func(c *gin.Context) {
 		} else {
 			c.Set("token_model_limit_enabled", false)
 		}
-		c.Set("allow_ips", token.GetIpLimitsMap())
+		if allowIps := token.GetAllowIps(); allowIps != "" {
+			c.Set("token_allow_ips", allowIps)
+		}
+		if denyIps := token.GetDenyIps(); denyIps != "" {
+			c.Set("token_deny_ips", denyIps)
+		}
 		c.Set("token_group", token.Group)
+		if token.TPMLimit > 0 {
+			c.Set("token_tpm_limit", token.TPMLimit)
+		}
+		if token.ConcurrencyLimit > 0 {
+			c.Set("token_concurrency_limit", token.ConcurrencyLimit)
+		}
+		if scopes := token.GetScopes(); scopes != nil {
+			endpoint, known := getTokenEndpoint(c.Request.URL.Path)
+			if !known && scopes.Endpoints != nil {
+				abortWithOpenAiMessage(c, http.StatusForbidden, "该令牌无权访问此接口")
+				return
+			}
+			if endpoint != "" && !scopes.AllowEndpoint(endpoint) {
+				abortWithOpenAiMessage(c, http.StatusForbidden, fmt.Sprintf("该令牌无权访问 %s 接口", endpoint))
+				return
+			}
+			c.Set("token_scopes", scopes)
+		}
+		if token.IsBudgetEnabled() {
+			c.Set("token_budget_period", token.BudgetPeriod)
+		}
+		if token.OrgId != 0 {
+			err = model.ValidateOrganizationToken(token.OrgId, token.UserId)
+			if err != nil {
+				abortWithOpenAiMessage(c, http.StatusForbidden, err.Error())
+				return
+			}
+			c.Set("token_org_id", token.OrgId)
+		}
+		if token.SelfFunded {
+			c.Set("token_self_funded", true)
+		}
 		if len(parts) > 1 {
 			if model.IsAdmin(token.UserId) {
 				c.Set("specific_channel_id", parts[1])
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"net/http"
+	"one-api/constant"
+	"one-api/service"
+	"one-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ModelConcurrencyLimit 限制用户与令牌同时处理中的请求数，名额在请求（包括流式响应与实时会话）结束后释放
+func ModelConcurrencyLimit() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if !setting.ModelConcurrencyLimitEnabled && c.GetInt("token_concurrency_limit") == 0 {
+			c.Next()
+			return
+		}
+
+		group := c.GetString("token_group")
+		if group == "" {
+			group = c.GetString(constant.ContextKeyUserGroup)
+		}
+
+		release, err := service.AcquireConcurrency(c, group)
+		if err != nil {
+			abortWithOpenAiMessage(c, http.StatusTooManyRequests, err.Error())
+			return
+		}
+		defer release()
+		c.Next()
+	}
+}
@@ -11,6 +11,7 @@ import (
 	relayconstant "one-api/relay/constant"
 	"one-api/service"
 	"one-api/setting"
+	"one-api/setting/model_setting"
 	"strconv"
 	"strings"
 	"time"
@@ -24,13 +25,9 @@ type ModelRequest struct {
 
 func Distribute() func(c *gin.Context) {
 	return func(c *gin.Context) {
-		allowIpsMap := c.GetStringMap("allow_ips")
-		if len(allowIpsMap) != 0 {
-			clientIp := c.ClientIP()
-			if _, ok := allowIpsMap[clientIp]; !ok {
-				abortWithOpenAiMessage(c, http.StatusForbidden, "您的 IP 不在令牌允许访问的列表中")
-				return
-			}
+		if message := checkClientIp(c); message != "" {
+			abortWithOpenAiMessage(c, http.StatusForbidden, message)
+			return
 		}
 		var channel *model.Channel
 		channelId, ok := c.Get("specific_channel_id")
@@ -39,6 +36,10 @@ func Distribute() func(c *gin.Context) {
 			abortWithOpenAiMessage(c, http.StatusBadRequest, "Invalid request, "+err.Error())
 			return
 		}
+		if message := checkTokenScopes(c); message != "" {
+			abortWithOpenAiMessage(c, http.StatusForbidden, message)
+			return
+		}
 		userGroup := c.GetString(constant.ContextKeyUserGroup)
 		tokenGroup := c.GetString("token_group")
 		if tokenGroup != "" {
@@ -70,6 +71,10 @@ func Distribute() func(c *gin.Context) {
 				abortWithOpenAiMessage(c, http.StatusForbidden, "该渠道已被禁用")
 				return
 			}
+			if err = channel.KeyError(); err != nil {
+				abortWithOpenAiMessage(c, http.StatusInternalServerError, err.Error())
+				return
+			}
 		} else {
 			// Select a channel for the user
 			// check token model mapping
@@ -96,6 +101,17 @@ func Distribute() func(c *gin.Context) {
 
 			if shouldSelectChannel {
 				channel, err = model.CacheGetRandomSatisfiedChannel(userGroup, modelRequest.Model, 0)
+				if err != nil && relayconstant.Path2RelayMode(c.Request.URL.Path) == relayconstant.RelayModeRerank {
+					// 无可用 rerank 渠道时，尝试使用映射的 embedding 模型模拟
+					if embeddingModel, ok := model_setting.GetRerankEmulationModel(modelRequest.Model); ok {
+						emulationChannel, emulationErr := model.CacheGetRandomSatisfiedChannel(userGroup, embeddingModel, 0)
+						if emulationErr == nil && emulationChannel != nil {
+							c.Set(constant.ContextKeyRerankEmulationModel, modelRequest.Model)
+							modelRequest.Model = embeddingModel
+							channel, err = emulationChannel, nil
+						}
+					}
+				}
 				if err != nil {
 					message := fmt.Sprintf("当前分组 %s 下对于模型 %s 无可用渠道", userGroup, modelRequest.Model)
 					// 如果错误，但是渠道不为空，说明是数据库一致性问题
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/netip"
+	"one-api/common"
+	"one-api/constant"
+
+	"github.com/gin-gonic/gin"
+)
+
+// checkClientIp 按用户与令牌的 IP 黑白名单检查客户端 IP，黑名单优先，白名单为空时不限制。
+// 返回拒绝原因，允许访问时返回空字符串
+func checkClientIp(c *gin.Context) string {
+	userAllowIps := c.GetString(constant.ContextKeyUserAllowIps)
+	userDenyIps := c.GetString(constant.ContextKeyUserDenyIps)
+	tokenAllowIps := c.GetString("token_allow_ips")
+	tokenDenyIps := c.GetString("token_deny_ips")
+	if userAllowIps == "" && userDenyIps == "" && tokenAllowIps == "" && tokenDenyIps == "" {
+		return ""
+	}
+	clientIp, err := netip.ParseAddr(c.ClientIP())
+	if err != nil {
+		return "无法识别您的 IP"
+	}
+	if userDenyIps != "" && common.GetIPTrie(userDenyIps).Contains(clientIp) {
+		return "您的 IP 已被禁止访问"
+	}
+	if tokenDenyIps != "" && common.GetIPTrie(tokenDenyIps).Contains(clientIp) {
+		return "您的 IP 已被令牌禁止访问"
+	}
+	if userAllowIps != "" {
+		trie := common.GetIPTrie(userAllowIps)
+		if trie.Len() > 0 && !trie.Contains(clientIp) {
+			return "您的 IP 不在用户允许访问的列表中"
+		}
+	}
+	if tokenAllowIps != "" {
+		trie := common.GetIPTrie(tokenAllowIps)
+		if trie.Len() > 0 && !trie.Contains(clientIp) {
+			return "您的 IP 不在令牌允许访问的列表中"
+		}
+	}
+	return ""
+}
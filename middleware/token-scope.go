@@ -0,0 +1,208 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getTokenEndpoint 返回请求路径对应的接口类别；模型列表、用量查询等不受限制的接口返回空字符串，
+// 无法识别的路径 known 为 false，令牌限制了接口类别时拒绝访问
+func getTokenEndpoint(path string) (endpoint string, known bool) {
+	switch {
+	case strings.HasPrefix(path, "/v1/models"), strings.HasPrefix(path, "/dashboard/"),
+		strings.HasPrefix(path, "/v1/dashboard/"):
+		return "", true
+	case strings.HasPrefix(path, "/v1/chat/completions"):
+		return model.TokenEndpointChat, true
+	case strings.HasPrefix(path, "/v1/completions"), strings.HasPrefix(path, "/v1/edits"):
+		return model.TokenEndpointCompletions, true
+	case strings.HasPrefix(path, "/v1/responses"):
+		return model.TokenEndpointResponses, true
+	case strings.HasPrefix(path, "/v1/messages"):
+		return model.TokenEndpointClaude, true
+	case strings.HasPrefix(path, "/v1beta/models"):
+		return model.TokenEndpointGemini, true
+	case strings.HasPrefix(path, "/v1/embeddings"), strings.HasPrefix(path, "/v1/engines/"):
+		return model.TokenEndpointEmbeddings, true
+	case strings.HasPrefix(path, "/v1/images"):
+		return model.TokenEndpointImages, true
+	case strings.HasPrefix(path, "/v1/audio"):
+		return model.TokenEndpointAudio, true
+	case strings.HasPrefix(path, "/v1/moderations"):
+		return model.TokenEndpointModerations, true
+	case strings.HasPrefix(path, "/v1/rerank"):
+		return model.TokenEndpointRerank, true
+	case strings.HasPrefix(path, "/v1/realtime"):
+		return model.TokenEndpointRealtime, true
+	case strings.Contains(path, "/mj/"):
+		return model.TokenEndpointMidjourney, true
+	case strings.HasPrefix(path, "/suno/"):
+		return model.TokenEndpointSuno, true
+	}
+	return "", false
+}
+
+// 生成文本的接口才检查最大输出 token 数
+var maxTokensEndpoints = []string{
+	model.TokenEndpointChat, model.TokenEndpointCompletions, model.TokenEndpointResponses,
+	model.TokenEndpointClaude, model.TokenEndpointGemini,
+}
+
+// scopeRequest 检查令牌权限范围时关心的请求字段，兼容 OpenAI、Claude 与 Gemini 格式
+type scopeRequest struct {
+	Stream              bool             `json:"stream"`
+	MaxTokens           float64          `json:"max_tokens"`
+	MaxCompletionTokens float64          `json:"max_completion_tokens"`
+	MaxOutputTokens     float64          `json:"max_output_tokens"`
+	Functions           json.RawMessage  `json:"functions"`
+	Tools               []map[string]any `json:"tools"`
+	WebSearchOptions    json.RawMessage  `json:"web_search_options"`
+	GenerationConfig    struct {
+		MaxOutputTokens float64 `json:"maxOutputTokens"`
+	} `json:"generationConfig"`
+	Messages any `json:"messages"`
+	Input    any `json:"input"`
+	Contents any `json:"contents"`
+}
+
+func (r *scopeRequest) maxTokens() int {
+	return int(max(r.MaxTokens, r.MaxCompletionTokens, r.MaxOutputTokens, r.GenerationConfig.MaxOutputTokens))
+}
+
+// features 返回请求使用的能力
+func (r *scopeRequest) features(path string) []string {
+	features := make([]string, 0)
+	if r.Stream || strings.Contains(path, ":streamGenerateContent") {
+		features = append(features, model.TokenFeatureStream)
+	}
+	useTools, useWebSearch := false, false
+	if len(r.Functions) > 0 && string(r.Functions) != "null" {
+		useTools = true
+	}
+	if len(r.WebSearchOptions) > 0 && string(r.WebSearchOptions) != "null" {
+		useWebSearch = true
+	}
+	for _, tool := range r.Tools {
+		toolType, _ := tool["type"].(string)
+		_, googleSearch := tool["googleSearch"]
+		_, googleSearchSnake := tool["google_search"]
+		_, googleSearchRetrieval := tool["googleSearchRetrieval"]
+		if strings.HasPrefix(toolType, "web_search") || googleSearch || googleSearchSnake || googleSearchRetrieval {
+			useWebSearch = true
+		} else {
+			useTools = true
+		}
+	}
+	if useTools {
+		features = append(features, model.TokenFeatureTools)
+	}
+	if useWebSearch {
+		features = append(features, model.TokenFeatureWebSearch)
+	}
+	if containsImageInput(r.Messages) || containsImageInput(r.Input) || containsImageInput(r.Contents) {
+		features = append(features, model.TokenFeatureVision)
+	}
+	return features
+}
+
+// containsImageInput 递归查找消息中的图片内容：OpenAI image_url / input_image、Claude image、Gemini 图片类型的 inlineData / fileData
+func containsImageInput(v any) bool {
+	switch value := v.(type) {
+	case []any:
+		for _, item := range value {
+			if containsImageInput(item) {
+				return true
+			}
+		}
+	case map[string]any:
+		if partType, ok := value["type"].(string); ok {
+			if partType == "image_url" || partType == "input_image" || partType == "image" {
+				return true
+			}
+		}
+		for _, key := range []string{"inlineData", "inline_data", "fileData", "file_data"} {
+			if data, ok := value[key].(map[string]any); ok {
+				mimeType, _ := data["mimeType"].(string)
+				if mimeType == "" {
+					mimeType, _ = data["mime_type"].(string)
+				}
+				if strings.HasPrefix(mimeType, "image/") {
+					return true
+				}
+			}
+		}
+		for _, item := range value {
+			if containsImageInput(item) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkTokenScopes 检查请求使用的能力与最大输出 token 数是否在令牌权限范围内，返回拒绝原因
+func checkTokenScopes(c *gin.Context) string {
+	value, ok := c.Get("token_scopes")
+	if !ok {
+		return ""
+	}
+	scopes := value.(*model.TokenScopes)
+	if scopes.Features == nil && scopes.MaxTokens == 0 {
+		return ""
+	}
+	var request scopeRequest
+	if err := parseScopeRequest(c, &request); err != nil {
+		return "无效的请求, " + err.Error()
+	}
+	for _, feature := range request.features(c.Request.URL.Path) {
+		if !scopes.AllowFeature(feature) {
+			return fmt.Sprintf("该令牌不允许使用 %s 能力", feature)
+		}
+	}
+	endpoint, _ := getTokenEndpoint(c.Request.URL.Path)
+	if scopes.MaxTokens > 0 && common.StringsContains(maxTokensEndpoints, endpoint) {
+		maxTokens := request.maxTokens()
+		if maxTokens == 0 {
+			return fmt.Sprintf("该令牌限制单次请求最多输出 %d 个 token，请在请求中设置 max_tokens", scopes.MaxTokens)
+		}
+		if maxTokens > scopes.MaxTokens {
+			return fmt.Sprintf("该令牌限制单次请求最多输出 %d 个 token，当前请求为 %d", scopes.MaxTokens, maxTokens)
+		}
+	}
+	return ""
+}
+
+// parseScopeRequest 解析请求中与权限范围相关的字段：表单请求（音频转写、图片编辑）读取表单字段，
+// 其余请求体按 JSON 解析，无法解析时返回错误而不是放行
+func parseScopeRequest(c *gin.Context, request *scopeRequest) error {
+	contentType := c.Request.Header.Get("Content-Type")
+	if strings.HasPrefix(contentType, "multipart/form-data") || strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		if c.Request.MultipartForm == nil && c.Request.PostForm == nil {
+			requestBody, err := common.GetRequestBody(c)
+			if err != nil {
+				return err
+			}
+			c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+		}
+		request.Stream, _ = strconv.ParseBool(c.PostForm("stream"))
+		request.MaxTokens, _ = strconv.ParseFloat(c.PostForm("max_tokens"), 64)
+		return nil
+	}
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+	if len(bytes.TrimSpace(requestBody)) == 0 {
+		return nil
+	}
+	return json.Unmarshal(requestBody, request)
+}
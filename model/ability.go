@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"one-api/common"
+	"sort"
 	"strings"
 
 	"github.com/samber/lo"
@@ -127,9 +128,51 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 		return nil, errors.New("channel not found")
 	}
 	err = DB.First(&channel, "id = ?", channel.Id).Error
+	if err == nil {
+		err = channel.KeyError()
+	}
 	return &channel, err
 }
 
+// GetSatisfiedChannels 获取分组下支持该模型的所有启用渠道，按优先级降序排列
+func GetSatisfiedChannels(group string, model string) ([]*Channel, error) {
+	trueVal := "1"
+	if common.UsingPostgreSQL {
+		trueVal = "true"
+	}
+	var abilities []Ability
+	err := DB.Where(groupCol+" = ? and model = ? and enabled = "+trueVal, group, model).
+		Order("priority DESC").Order("weight DESC").Find(&abilities).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(abilities) == 0 {
+		return nil, errors.New("channel not found")
+	}
+	ids := make([]int, 0, len(abilities))
+	for _, ability_ := range abilities {
+		ids = append(ids, ability_.ChannelId)
+	}
+	channels, err := GetChannelsByIds(ids)
+	if err != nil {
+		return nil, err
+	}
+	usableChannels := make([]*Channel, 0, len(channels))
+	for _, channel := range channels {
+		if channel.KeyError() == nil {
+			usableChannels = append(usableChannels, channel)
+		}
+	}
+	if len(usableChannels) == 0 {
+		return nil, errors.New("channel not found")
+	}
+	channels = usableChannels
+	sort.SliceStable(channels, func(i, j int) bool {
+		return channels[i].GetPriority() > channels[j].GetPriority()
+	})
+	return channels, nil
+}
+
 func (channel *Channel) AddAbilities() error {
 	models_ := strings.Split(channel.Models, ",")
 	groups_ := strings.Split(channel.Group, ",")
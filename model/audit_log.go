@@ -0,0 +1,84 @@
+package model
+
+import "gorm.io/gorm"
+
+// 审计日志的操作对象类型
+const (
+	AuditTargetOption       = "option"
+	AuditTargetChannel      = "channel"
+	AuditTargetUser         = "user"
+	AuditTargetRedemption   = "redemption"
+	AuditTargetRole         = "role"
+	AuditTargetRefund       = "refund"
+	AuditTargetPlan         = "plan"
+	AuditTargetSubscription = "subscription"
+	AuditTargetOrganization = "organization"
+)
+
+// AuditLog 管理操作审计日志，只追加不修改。Diff 为变更字段的 JSON，
+// 格式为 {"字段": {"before": 旧值, "after": 新值}}，敏感字段的值已脱敏
+type AuditLog struct {
+	Id         int    `json:"id"`
+	CreatedAt  int64  `json:"created_at" gorm:"bigint;index"`
+	UserId     int    `json:"user_id" gorm:"index"`
+	Username   string `json:"username" gorm:"type:varchar(64)"`
+	Role       int    `json:"role"`
+	Ip         string `json:"ip" gorm:"type:varchar(64)"`
+	Action     string `json:"action" gorm:"type:varchar(64);index"`
+	TargetType string `json:"target_type" gorm:"type:varchar(32);index:idx_audit_target"`
+	TargetId   string `json:"target_id" gorm:"type:varchar(128);index:idx_audit_target"`
+	Diff       string `json:"diff" gorm:"type:text"`
+}
+
+// AuditLogFilter 审计日志查询条件，零值表示不限制
+type AuditLogFilter struct {
+	UserId         int
+	Action         string
+	TargetType     string
+	TargetId       string
+	StartTimestamp int64
+	EndTimestamp   int64
+}
+
+func (auditLog *AuditLog) Insert() error {
+	return DB.Create(auditLog).Error
+}
+
+func (filter *AuditLogFilter) query() *gorm.DB {
+	tx := DB.Model(&AuditLog{})
+	if filter.UserId != 0 {
+		tx = tx.Where("user_id = ?", filter.UserId)
+	}
+	if filter.Action != "" {
+		tx = tx.Where("action = ?", filter.Action)
+	}
+	if filter.TargetType != "" {
+		tx = tx.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetId != "" {
+		tx = tx.Where("target_id = ?", filter.TargetId)
+	}
+	if filter.StartTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", filter.StartTimestamp)
+	}
+	if filter.EndTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", filter.EndTimestamp)
+	}
+	return tx
+}
+
+func GetAuditLogs(filter AuditLogFilter, startIdx int, num int) (auditLogs []*AuditLog, total int64, err error) {
+	tx := filter.query()
+	err = tx.Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&auditLogs).Error
+	return auditLogs, total, err
+}
+
+// DeleteAuditLogsBefore 删除早于指定时间的审计日志
+func DeleteAuditLogsBefore(timestamp int64) (int64, error) {
+	result := DB.Where("created_at < ?", timestamp).Delete(&AuditLog{})
+	return result.RowsAffected, result.Error
+}
@@ -22,9 +22,16 @@ func InitChannelCache() {
 	newChannelId2channel := make(map[int]*Channel)
 	var channels []*Channel
 	DB.Where("status = ?", common.ChannelStatusEnabled).Find(&channels)
+	// 密钥解密失败的渠道不参与选择
+	usableChannels := make([]*Channel, 0, len(channels))
 	for _, channel := range channels {
+		if channel.KeyError() != nil {
+			continue
+		}
+		usableChannels = append(usableChannels, channel)
 		newChannelId2channel[channel.Id] = channel
 	}
+	channels = usableChannels
 	var abilities []*Ability
 	DB.Find(&abilities)
 	groups := make(map[string]bool)
@@ -140,6 +147,24 @@ func CacheGetRandomSatisfiedChannel(group string, model string, retry int) (*Cha
 	return nil, errors.New("channel not found")
 }
 
+// CacheGetSatisfiedChannels 获取分组下支持该模型的所有启用渠道，按优先级降序排列
+func CacheGetSatisfiedChannels(group string, model string) ([]*Channel, error) {
+	if !common.MemoryCacheEnabled {
+		return GetSatisfiedChannels(group, model)
+	}
+
+	channelSyncLock.RLock()
+	channels := group2model2channels[group][model]
+	channelSyncLock.RUnlock()
+
+	if len(channels) == 0 {
+		return nil, errors.New("channel not found")
+	}
+	result := make([]*Channel, len(channels))
+	copy(result, channels)
+	return result, nil
+}
+
 func CacheGetChannel(id int) (*Channel, error) {
 	if !common.MemoryCacheEnabled {
 		return GetChannelById(id, true)
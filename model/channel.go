@@ -2,6 +2,7 @@ package model
 
 import (
 	"encoding/json"
+	"fmt"
 	"one-api/common"
 	"strings"
 	"sync"
@@ -37,6 +38,10 @@ type Channel struct {
 	Tag               *string `json:"tag" gorm:"index"`
 	Setting           *string `json:"setting" gorm:"type:text"`
 	ParamOverride     *string `json:"param_override" gorm:"type:text"`
+
+	// keyErr 密钥解密失败的原因，此时 Key 为空，encryptedKey 保存数据库中的密文
+	keyErr       error
+	encryptedKey string
 }
 
 func (channel *Channel) GetModels() []string {
@@ -95,6 +100,71 @@ func (channel *Channel) GetAutoBan() bool {
 	return *channel.AutoBan == 1
 }
 
+// 渠道密钥在数据库中加密保存，写入前加密、读取后解密，内存中始终为明文。
+// 更新时只有写入的就是渠道本身才需要加密，避免部分字段更新时改动共享的渠道对象
+func (channel *Channel) BeforeCreate(tx *gorm.DB) error {
+	return channel.encryptKey()
+}
+
+func (channel *Channel) BeforeUpdate(tx *gorm.DB) error {
+	if dest, ok := tx.Statement.Dest.(*Channel); !ok || dest != channel {
+		return nil
+	}
+	return channel.encryptKey()
+}
+
+func (channel *Channel) AfterSave(tx *gorm.DB) error {
+	channel.decryptKey()
+	return nil
+}
+
+func (channel *Channel) AfterFind(tx *gorm.DB) error {
+	channel.decryptKey()
+	return nil
+}
+
+func (channel *Channel) encryptKey() error {
+	// 解密失败的渠道保存其他字段时写回原密文，不覆盖数据库中的密钥
+	if channel.keyErr != nil && channel.Key == "" {
+		channel.Key = channel.encryptedKey
+		return nil
+	}
+	key, err := common.EncryptSecret(channel.Key)
+	if err != nil {
+		return err
+	}
+	channel.Key = key
+	return nil
+}
+
+// decryptKey 解密失败时清空 Key 并记录原因，不把密文当作密钥发往上游
+func (channel *Channel) decryptKey() {
+	channel.keyErr = nil
+	channel.encryptedKey = ""
+	if !common.IsEncryptedSecret(channel.Key) {
+		return
+	}
+	key, err := common.DecryptSecret(channel.Key)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to decrypt key of channel %d: %s", channel.Id, err.Error()))
+		channel.keyErr = fmt.Errorf("渠道 #%d 密钥解密失败，请检查加密主密钥配置", channel.Id)
+		channel.encryptedKey = channel.Key
+		channel.Key = ""
+		return
+	}
+	channel.Key = key
+}
+
+// KeyError 返回密钥解密失败的原因，不为 nil 时渠道不可用于请求上游
+func (channel *Channel) KeyError() error {
+	return channel.keyErr
+}
+
+// MaskKey 将渠道密钥替换为脱敏后的值，用于管理接口返回
+func (channel *Channel) MaskKey() {
+	channel.Key = common.MaskSecret(channel.Key)
+}
+
 func (channel *Channel) Save() error {
 	return DB.Save(channel).Error
 }
@@ -147,7 +217,7 @@ func SearchChannels(keyword string, group string, model string, idSort bool) ([]
 	// 构造基础查询
 	baseQuery := DB.Model(&Channel{}).Omit(keyCol)
 
-	// 构造WHERE子句
+	// 构造WHERE子句，渠道密钥加密存储，不支持按密钥搜索
 	var whereClause string
 	var args []interface{}
 	if group != "" && group != "null" {
@@ -158,11 +228,11 @@ func SearchChannels(keyword string, group string, model string, idSort bool) ([]
 			// sqlite, PostgreSQL
 			groupCondition = `(',' || ` + groupCol + ` || ',') LIKE ?`
 		}
-		whereClause = "(id = ? OR name LIKE ? OR " + keyCol + " = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + ` LIKE ? AND ` + groupCondition
-		args = append(args, common.String2Int(keyword), "%"+keyword+"%", keyword, "%"+keyword+"%", "%"+model+"%", "%,"+group+",%")
+		whereClause = "(id = ? OR name LIKE ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + ` LIKE ? AND ` + groupCondition
+		args = append(args, common.String2Int(keyword), "%"+keyword+"%", "%"+keyword+"%", "%"+model+"%", "%,"+group+",%")
 	} else {
-		whereClause = "(id = ? OR name LIKE ? OR " + keyCol + " = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + " LIKE ?"
-		args = append(args, common.String2Int(keyword), "%"+keyword+"%", keyword, "%"+keyword+"%", "%"+model+"%")
+		whereClause = "(id = ? OR name LIKE ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + " LIKE ?"
+		args = append(args, common.String2Int(keyword), "%"+keyword+"%", "%"+keyword+"%", "%"+model+"%")
 	}
 
 	// 执行查询
@@ -480,7 +550,7 @@ func SearchTags(keyword string, group string, model string, idSort bool) ([]*str
 	// 构造基础查询
 	baseQuery := DB.Model(&Channel{}).Omit(keyCol)
 
-	// 构造WHERE子句
+	// 构造WHERE子句，渠道密钥加密存储，不支持按密钥搜索
 	var whereClause string
 	var args []interface{}
 	if group != "" && group != "null" {
@@ -491,11 +561,11 @@ func SearchTags(keyword string, group string, model string, idSort bool) ([]*str
 			// sqlite, PostgreSQL
 			groupCondition = `(',' || ` + groupCol + ` || ',') LIKE ?`
 		}
-		whereClause = "(id = ? OR name LIKE ? OR " + keyCol + " = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + ` LIKE ? AND ` + groupCondition
-		args = append(args, common.String2Int(keyword), "%"+keyword+"%", keyword, "%"+keyword+"%", "%"+model+"%", "%,"+group+",%")
+		whereClause = "(id = ? OR name LIKE ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + ` LIKE ? AND ` + groupCondition
+		args = append(args, common.String2Int(keyword), "%"+keyword+"%", "%"+keyword+"%", "%"+model+"%", "%,"+group+",%")
 	} else {
-		whereClause = "(id = ? OR name LIKE ? OR " + keyCol + " = ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + " LIKE ?"
-		args = append(args, common.String2Int(keyword), "%"+keyword+"%", keyword, "%"+keyword+"%", "%"+model+"%")
+		whereClause = "(id = ? OR name LIKE ? OR " + baseURLCol + " LIKE ?) AND " + modelsCol + " LIKE ?"
+		args = append(args, common.String2Int(keyword), "%"+keyword+"%", "%"+keyword+"%", "%"+model+"%")
 	}
 
 	subQuery := baseQuery.Where(whereClause, args...).
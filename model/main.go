@@ -51,7 +51,7 @@ func createRootAccountIfNeed() error {
 			AccessToken: nil,
 			Quota:       100000000,
 		}
-		DB.Create(&rootUser)
+		return CreateUserWithOpeningLedger(&rootUser)
 	}
 	return nil
 }
@@ -242,6 +242,58 @@ func migrateDB() error {
 	if err != nil {
 		return err
 	}
+	err = DB.AutoMigrate(&Plan{})
+	if err != nil {
+		return err
+	}
+	err = DB.AutoMigrate(&UserSubscription{})
+	if err != nil {
+		return err
+	}
+	err = DB.AutoMigrate(&TokenBudgetUsage{})
+	if err != nil {
+		return err
+	}
+	err = DB.AutoMigrate(&Organization{})
+	if err != nil {
+		return err
+	}
+	err = DB.AutoMigrate(&OrganizationMember{})
+	if err != nil {
+		return err
+	}
+	err = DB.AutoMigrate(&Statement{})
+	if err != nil {
+		return err
+	}
+	err = DB.AutoMigrate(&RedemptionUsage{})
+	if err != nil {
+		return err
+	}
+	err = DB.AutoMigrate(&Refund{})
+	if err != nil {
+		return err
+	}
+	err = DB.AutoMigrate(&QuotaLedger{})
+	if err != nil {
+		return err
+	}
+	err = DB.AutoMigrate(&TwoFA{})
+	if err != nil {
+		return err
+	}
+	err = DB.AutoMigrate(&AuditLog{})
+	if err != nil {
+		return err
+	}
+	err = DB.AutoMigrate(&Role{})
+	if err != nil {
+		return err
+	}
+	err = initQuotaLedgerOpenings()
+	if err != nil {
+		return err
+	}
 	err = DB.AutoMigrate(&Setup{})
 	common.SysLog("database migrated")
 	//err = createRootAccountIfNeed()
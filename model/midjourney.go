@@ -1,26 +1,31 @@
 package model
 
 type Midjourney struct {
-	Id          int    `json:"id"`
-	Code        int    `json:"code"`
-	UserId      int    `json:"user_id" gorm:"index"`
-	Action      string `json:"action" gorm:"type:varchar(40);index"`
-	MjId        string `json:"mj_id" gorm:"index"`
-	Prompt      string `json:"prompt"`
-	PromptEn    string `json:"prompt_en"`
-	Description string `json:"description"`
-	State       string `json:"state"`
-	SubmitTime  int64  `json:"submit_time" gorm:"index"`
-	StartTime   int64  `json:"start_time" gorm:"index"`
-	FinishTime  int64  `json:"finish_time" gorm:"index"`
-	ImageUrl    string `json:"image_url"`
-	Status      string `json:"status" gorm:"type:varchar(20);index"`
-	Progress    string `json:"progress" gorm:"type:varchar(30);index"`
-	FailReason  string `json:"fail_reason"`
-	ChannelId   int    `json:"channel_id"`
-	Quota       int    `json:"quota"`
-	Buttons     string `json:"buttons"`
-	Properties  string `json:"properties"`
+	Id     int `json:"id"`
+	Code   int `json:"code"`
+	UserId int `json:"user_id" gorm:"index"`
+	OrgId  int `json:"org_id" gorm:"default:0"`
+	// FundingTokenId 由自付令牌付费时为该令牌，任务失败的补偿退回令牌额度
+	FundingTokenId int    `json:"funding_token_id" gorm:"default:0"`
+	Action         string `json:"action" gorm:"type:varchar(40);index"`
+	MjId           string `json:"mj_id" gorm:"index"`
+	Prompt         string `json:"prompt"`
+	PromptEn       string `json:"prompt_en"`
+	Description    string `json:"description"`
+	State          string `json:"state"`
+	SubmitTime     int64  `json:"submit_time" gorm:"index"`
+	StartTime      int64  `json:"start_time" gorm:"index"`
+	FinishTime     int64  `json:"finish_time" gorm:"index"`
+	ImageUrl       string `json:"image_url"`
+	Status         string `json:"status" gorm:"type:varchar(20);index"`
+	Progress       string `json:"progress" gorm:"type:varchar(30);index"`
+	FailReason     string `json:"fail_reason"`
+	ChannelId      int    `json:"channel_id"`
+	Quota          int    `json:"quota"`
+	// SubscriptionQuota 订阅套餐承担以及未向用户收取的额度，任务失败时只补偿付费方承担的部分
+	SubscriptionQuota int    `json:"subscription_quota" gorm:"default:0"`
+	Buttons           string `json:"buttons"`
+	Properties        string `json:"properties"`
 }
 
 // TaskQueryParams 用于包含所有搜索条件的结构体，可以根据需求添加更多字段
@@ -155,6 +160,17 @@ func (midjourney *Midjourney) Update() error {
 	return err
 }
 
+// UpdateSubscriptionQuota 结算后记录套餐承担的额度
+func (midjourney *Midjourney) UpdateSubscriptionQuota(quota int) error {
+	midjourney.SubscriptionQuota = quota
+	return DB.Model(midjourney).Update("subscription_quota", quota).Error
+}
+
+// RefundQuota 任务失败时应补偿给付费方的额度，不含套餐承担的部分
+func (midjourney *Midjourney) RefundQuota() int {
+	return midjourney.Quota - midjourney.SubscriptionQuota
+}
+
 func MjBulkUpdate(mjIds []string, params map[string]any) error {
 	return DB.Model(&Midjourney{}).
 		Where("mj_id in (?)", mjIds).
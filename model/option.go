@@ -50,6 +50,7 @@ func InitOptionMap() {
 	common.OptionMap["DataExportEnabled"] = strconv.FormatBool(common.DataExportEnabled)
 	common.OptionMap["ChannelDisableThreshold"] = strconv.FormatFloat(common.ChannelDisableThreshold, 'f', -1, 64)
 	common.OptionMap["EmailDomainRestrictionEnabled"] = strconv.FormatBool(common.EmailDomainRestrictionEnabled)
+	common.OptionMap["AdminTwoFAEnforcementEnabled"] = strconv.FormatBool(common.AdminTwoFAEnforcementEnabled)
 	common.OptionMap["EmailAliasRestrictionEnabled"] = strconv.FormatBool(common.EmailAliasRestrictionEnabled)
 	common.OptionMap["EmailDomainWhitelist"] = strings.Join(common.EmailDomainWhitelist, ",")
 	common.OptionMap["SMTPServer"] = ""
@@ -72,6 +73,11 @@ func InitOptionMap() {
 	common.OptionMap["CustomCallbackAddress"] = ""
 	common.OptionMap["EpayId"] = ""
 	common.OptionMap["EpayKey"] = ""
+	common.OptionMap["StripeApiBase"] = setting.StripeApiBase
+	common.OptionMap["StripeApiSecret"] = ""
+	common.OptionMap["StripeWebhookSecret"] = ""
+	common.OptionMap["StripeCurrency"] = setting.StripeCurrency
+	common.OptionMap["StripeUnitPrice"] = strconv.FormatFloat(setting.StripeUnitPrice, 'f', -1, 64)
 	common.OptionMap["Price"] = strconv.FormatFloat(setting.Price, 'f', -1, 64)
 	common.OptionMap["MinTopUp"] = strconv.Itoa(setting.MinTopUp)
 	common.OptionMap["TopupGroupRatio"] = common.TopupGroupRatio2JSONString()
@@ -94,9 +100,16 @@ func InitOptionMap() {
 	common.OptionMap["ModelRequestRateLimitDurationMinutes"] = strconv.Itoa(setting.ModelRequestRateLimitDurationMinutes)
 	common.OptionMap["ModelRequestRateLimitSuccessCount"] = strconv.Itoa(setting.ModelRequestRateLimitSuccessCount)
 	common.OptionMap["ModelRequestRateLimitGroup"] = setting.ModelRequestRateLimitGroup2JSONString()
+	common.OptionMap["ModelTPMLimitUser"] = strconv.Itoa(setting.ModelTPMLimitUser)
+	common.OptionMap["ModelTPMLimitGroup"] = setting.ModelTPMLimitGroup2JSONString()
+	common.OptionMap["ModelTPMLimitModel"] = setting.ModelTPMLimitModel2JSONString()
+	common.OptionMap["ModelConcurrencyLimitUser"] = strconv.Itoa(setting.ModelConcurrencyLimitUser)
+	common.OptionMap["ModelConcurrencyLimitGroup"] = setting.ModelConcurrencyLimitGroup2JSONString()
+	common.OptionMap["ModelConcurrencyLimitGroupTotal"] = setting.ModelConcurrencyLimitGroupTotal2JSONString()
 	common.OptionMap["ModelRatio"] = operation_setting.ModelRatio2JSONString()
 	common.OptionMap["ModelPrice"] = operation_setting.ModelPrice2JSONString()
 	common.OptionMap["CacheRatio"] = operation_setting.CacheRatio2JSONString()
+	common.OptionMap["ModelTieredRatio"] = operation_setting.ModelTieredRatio2JSONString()
 	common.OptionMap["GroupRatio"] = setting.GroupRatio2JSONString()
 	common.OptionMap["UserUsableGroups"] = setting.UserUsableGroups2JSONString()
 	common.OptionMap["CompletionRatio"] = operation_setting.CompletionRatio2JSONString()
@@ -117,7 +130,10 @@ func InitOptionMap() {
 	common.OptionMap["DemoSiteEnabled"] = strconv.FormatBool(operation_setting.DemoSiteEnabled)
 	common.OptionMap["SelfUseModeEnabled"] = strconv.FormatBool(operation_setting.SelfUseModeEnabled)
 	common.OptionMap["ModelRequestRateLimitEnabled"] = strconv.FormatBool(setting.ModelRequestRateLimitEnabled)
+	common.OptionMap["ModelTPMLimitEnabled"] = strconv.FormatBool(setting.ModelTPMLimitEnabled)
+	common.OptionMap["ModelConcurrencyLimitEnabled"] = strconv.FormatBool(setting.ModelConcurrencyLimitEnabled)
 	common.OptionMap["CheckSensitiveOnPromptEnabled"] = strconv.FormatBool(setting.CheckSensitiveOnPromptEnabled)
+	common.OptionMap["CheckSensitiveOnCompletionEnabled"] = strconv.FormatBool(setting.CheckSensitiveOnCompletionEnabled)
 	common.OptionMap["StopOnSensitiveEnabled"] = strconv.FormatBool(setting.StopOnSensitiveEnabled)
 	common.OptionMap["SensitiveWords"] = setting.SensitiveWordsToString()
 	common.OptionMap["StreamCacheQueueLength"] = strconv.Itoa(setting.StreamCacheQueueLength)
@@ -214,6 +230,8 @@ func updateOptionMap(key string, value string) (err error) {
 			common.RegisterEnabled = boolValue
 		case "EmailDomainRestrictionEnabled":
 			common.EmailDomainRestrictionEnabled = boolValue
+		case "AdminTwoFAEnforcementEnabled":
+			common.AdminTwoFAEnforcementEnabled = boolValue
 		case "EmailAliasRestrictionEnabled":
 			common.EmailAliasRestrictionEnabled = boolValue
 		case "AutomaticDisableChannelEnabled":
@@ -252,8 +270,14 @@ func updateOptionMap(key string, value string) (err error) {
 			operation_setting.SelfUseModeEnabled = boolValue
 		case "CheckSensitiveOnPromptEnabled":
 			setting.CheckSensitiveOnPromptEnabled = boolValue
+		case "CheckSensitiveOnCompletionEnabled":
+			setting.CheckSensitiveOnCompletionEnabled = boolValue
 		case "ModelRequestRateLimitEnabled":
 			setting.ModelRequestRateLimitEnabled = boolValue
+		case "ModelTPMLimitEnabled":
+			setting.ModelTPMLimitEnabled = boolValue
+		case "ModelConcurrencyLimitEnabled":
+			setting.ModelConcurrencyLimitEnabled = boolValue
 		case "StopOnSensitiveEnabled":
 			setting.StopOnSensitiveEnabled = boolValue
 		case "SMTPSSLEnabled":
@@ -292,6 +316,16 @@ func updateOptionMap(key string, value string) (err error) {
 		setting.EpayId = value
 	case "EpayKey":
 		setting.EpayKey = value
+	case "StripeApiBase":
+		setting.StripeApiBase = value
+	case "StripeApiSecret":
+		setting.StripeApiSecret = value
+	case "StripeWebhookSecret":
+		setting.StripeWebhookSecret = value
+	case "StripeCurrency":
+		setting.StripeCurrency = value
+	case "StripeUnitPrice":
+		setting.StripeUnitPrice, _ = strconv.ParseFloat(value, 64)
 	case "Price":
 		setting.Price, _ = strconv.ParseFloat(value, 64)
 	case "MinTopUp":
@@ -344,6 +378,18 @@ func updateOptionMap(key string, value string) (err error) {
 		setting.ModelRequestRateLimitSuccessCount, _ = strconv.Atoi(value)
 	case "ModelRequestRateLimitGroup":
 		err = setting.UpdateModelRequestRateLimitGroupByJSONString(value)
+	case "ModelTPMLimitUser":
+		setting.ModelTPMLimitUser, _ = strconv.Atoi(value)
+	case "ModelTPMLimitGroup":
+		err = setting.UpdateModelTPMLimitGroupByJSONString(value)
+	case "ModelTPMLimitModel":
+		err = setting.UpdateModelTPMLimitModelByJSONString(value)
+	case "ModelConcurrencyLimitUser":
+		setting.ModelConcurrencyLimitUser, _ = strconv.Atoi(value)
+	case "ModelConcurrencyLimitGroup":
+		err = setting.UpdateModelConcurrencyLimitGroupByJSONString(value)
+	case "ModelConcurrencyLimitGroupTotal":
+		err = setting.UpdateModelConcurrencyLimitGroupTotalByJSONString(value)
 	case "RetryTimes":
 		common.RetryTimes, _ = strconv.Atoi(value)
 	case "DataExportInterval":
@@ -362,6 +408,8 @@ func updateOptionMap(key string, value string) (err error) {
 		err = operation_setting.UpdateModelPriceByJSONString(value)
 	case "CacheRatio":
 		err = operation_setting.UpdateCacheRatioByJSONString(value)
+	case "ModelTieredRatio":
+		err = operation_setting.UpdateModelTieredRatioByJSONString(value)
 	case "TopUpLink":
 		common.TopUpLink = value
 	//case "ChatLink":
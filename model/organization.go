@@ -0,0 +1,403 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"one-api/common"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+const (
+	OrganizationStatusEnabled  = 1
+	OrganizationStatusDisabled = 2
+)
+
+const (
+	OrganizationRoleOwner   = "owner"
+	OrganizationRoleAdmin   = "admin"
+	OrganizationRoleBilling = "billing"
+	OrganizationRoleMember  = "member"
+)
+
+// Organization 组织拥有共享的额度池，组织令牌的消耗从额度池中扣除
+type Organization struct {
+	Id          int            `json:"id"`
+	Name        string         `json:"name" gorm:"type:varchar(64);index"`
+	OwnerId     int            `json:"owner_id" gorm:"index"`
+	Quota       int            `json:"quota" gorm:"default:0"`
+	UsedQuota   int            `json:"used_quota" gorm:"default:0"`
+	Status      int            `json:"status" gorm:"default:1"`
+	CreatedTime int64          `json:"created_time" gorm:"bigint"`
+	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"`
+	// Role 当前用户在组织中的角色，仅用于展示
+	Role string `json:"role,omitempty" gorm:"-:all"`
+}
+
+// OrganizationMember 组织成员，QuotaLimit 为成员可从额度池消耗的上限，0 表示不限制
+type OrganizationMember struct {
+	Id          int    `json:"id"`
+	OrgId       int    `json:"org_id" gorm:"uniqueIndex:idx_org_member"`
+	UserId      int    `json:"user_id" gorm:"uniqueIndex:idx_org_member;index"`
+	Role        string `json:"role" gorm:"type:varchar(16);default:'member'"`
+	QuotaLimit  int    `json:"quota_limit" gorm:"default:0"`
+	UsedQuota   int    `json:"used_quota" gorm:"default:0"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+	Username    string `json:"username" gorm:"-:all"`
+}
+
+func IsValidOrganizationRole(role string) bool {
+	switch role {
+	case OrganizationRoleOwner, OrganizationRoleAdmin, OrganizationRoleBilling, OrganizationRoleMember:
+		return true
+	}
+	return false
+}
+
+// CanManageMembers 所有者与管理员可以管理成员及其消费上限
+func (member *OrganizationMember) CanManageMembers() bool {
+	return member.Role == OrganizationRoleOwner || member.Role == OrganizationRoleAdmin
+}
+
+// CanViewUsage 所有者、管理员与财务可以查看组织的全部日志与数据看板
+func (member *OrganizationMember) CanViewUsage() bool {
+	return member.Role != OrganizationRoleMember
+}
+
+// CanManageBilling 所有者、管理员与财务可以向额度池充值
+func (member *OrganizationMember) CanManageBilling() bool {
+	return member.Role != OrganizationRoleMember
+}
+
+func CreateOrganization(name string, ownerId int) (*Organization, error) {
+	org := &Organization{
+		Name:        name,
+		OwnerId:     ownerId,
+		Status:      OrganizationStatusEnabled,
+		CreatedTime: common.GetTimestamp(),
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(org).Error; err != nil {
+			return err
+		}
+		return tx.Create(&OrganizationMember{
+			OrgId:       org.Id,
+			UserId:      ownerId,
+			Role:        OrganizationRoleOwner,
+			CreatedTime: org.CreatedTime,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	org.Role = OrganizationRoleOwner
+	return org, nil
+}
+
+func GetOrganizationById(id int) (*Organization, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	org := Organization{}
+	err := DB.First(&org, "id = ?", id).Error
+	return &org, err
+}
+
+func GetAllOrganizations(startIdx int, num int) (orgs []*Organization, total int64, err error) {
+	err = DB.Model(&Organization{}).Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	err = DB.Order("id desc").Limit(num).Offset(startIdx).Find(&orgs).Error
+	return orgs, total, err
+}
+
+// GetUserOrganizations 返回用户加入的组织，并附带用户在组织中的角色
+func GetUserOrganizations(userId int) ([]*Organization, error) {
+	var members []*OrganizationMember
+	err := DB.Where("user_id = ?", userId).Find(&members).Error
+	if err != nil {
+		return nil, err
+	}
+	roles := make(map[int]string, len(members))
+	orgIds := make([]int, 0, len(members))
+	for _, member := range members {
+		roles[member.OrgId] = member.Role
+		orgIds = append(orgIds, member.OrgId)
+	}
+	orgs := make([]*Organization, 0)
+	if len(orgIds) == 0 {
+		return orgs, nil
+	}
+	err = DB.Where("id in ?", orgIds).Order("id desc").Find(&orgs).Error
+	for _, org := range orgs {
+		org.Role = roles[org.Id]
+	}
+	return orgs, err
+}
+
+func (org *Organization) Update() error {
+	err := DB.Model(org).Select("name", "status").Updates(org).Error
+	invalidateOrganizationCache(org.Id)
+	return err
+}
+
+// DeleteOrganization 删除组织，组织下的令牌将因校验失败而无法使用
+func DeleteOrganization(id int) error {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("org_id = ?", id).Delete(&OrganizationMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Organization{}, "id = ?", id).Error
+	})
+	// 成员缓存随组织失效：令牌校验与额度查询都会先检查组织
+	invalidateOrganizationCache(id)
+	return err
+}
+
+func GetOrganizationMember(orgId int, userId int) (*OrganizationMember, error) {
+	member := OrganizationMember{}
+	err := DB.Where("org_id = ? and user_id = ?", orgId, userId).First(&member).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("不是该组织的成员")
+		}
+		return nil, err
+	}
+	return &member, nil
+}
+
+func GetOrganizationMembers(orgId int) ([]*OrganizationMember, error) {
+	var members []*OrganizationMember
+	err := DB.Where("org_id = ?", orgId).Order("id asc").Find(&members).Error
+	if err != nil {
+		return nil, err
+	}
+	fillOrganizationMemberUsernames(members)
+	return members, nil
+}
+
+func fillOrganizationMemberUsernames(members []*OrganizationMember) {
+	userIds := make([]int, 0, len(members))
+	for _, member := range members {
+		userIds = append(userIds, member.UserId)
+	}
+	if len(userIds) == 0 {
+		return
+	}
+	var users []struct {
+		Id       int
+		Username string
+	}
+	if err := DB.Model(&User{}).Select("id, username").Where("id in ?", userIds).Find(&users).Error; err != nil {
+		common.SysError("failed to get organization member usernames: " + err.Error())
+		return
+	}
+	usernames := make(map[int]string, len(users))
+	for _, user := range users {
+		usernames[user.Id] = user.Username
+	}
+	for _, member := range members {
+		member.Username = usernames[member.UserId]
+	}
+}
+
+func AddOrganizationMember(orgId int, userId int, role string, quotaLimit int) (*OrganizationMember, error) {
+	if !IsValidOrganizationRole(role) || role == OrganizationRoleOwner {
+		return nil, errors.New("无效的成员角色")
+	}
+	if userId == 0 {
+		return nil, errors.New("用户不存在")
+	}
+	if _, err := GetOrganizationMember(orgId, userId); err == nil {
+		return nil, errors.New("该用户已是组织成员")
+	}
+	member := &OrganizationMember{
+		OrgId:       orgId,
+		UserId:      userId,
+		Role:        role,
+		QuotaLimit:  quotaLimit,
+		CreatedTime: common.GetTimestamp(),
+	}
+	err := DB.Create(member).Error
+	invalidateOrganizationMemberCache(orgId, userId)
+	return member, err
+}
+
+// Update 更新成员角色与消费上限
+func (member *OrganizationMember) Update() error {
+	err := DB.Model(member).Select("role", "quota_limit", "used_quota").Updates(member).Error
+	invalidateOrganizationMemberCache(member.OrgId, member.UserId)
+	return err
+}
+
+func RemoveOrganizationMember(orgId int, userId int) error {
+	member, err := GetOrganizationMember(orgId, userId)
+	if err != nil {
+		return err
+	}
+	if member.Role == OrganizationRoleOwner {
+		return errors.New("不能移除组织所有者")
+	}
+	err = DB.Delete(member).Error
+	invalidateOrganizationMemberCache(orgId, userId)
+	return err
+}
+
+// ValidateOrganizationToken 校验组织令牌的组织是否可用且令牌所有者仍是组织成员
+func ValidateOrganizationToken(orgId int, userId int) error {
+	org, err := CacheGetOrganization(orgId)
+	if err != nil {
+		return errors.New("令牌所属组织不存在")
+	}
+	if org.Status != OrganizationStatusEnabled {
+		return errors.New("令牌所属组织已被禁用")
+	}
+	_, err = CacheGetOrganizationMember(orgId, userId)
+	return err
+}
+
+// GetOrganizationAvailableQuota 返回成员可从组织额度池使用的额度，受成员消费上限约束
+func GetOrganizationAvailableQuota(orgId int, userId int) (int, error) {
+	org, err := CacheGetOrganization(orgId)
+	if err != nil {
+		return 0, err
+	}
+	member, err := CacheGetOrganizationMember(orgId, userId)
+	if err != nil {
+		return 0, err
+	}
+	available := org.Quota
+	if member.QuotaLimit > 0 && member.QuotaLimit-member.UsedQuota < available {
+		available = member.QuotaLimit - member.UsedQuota
+	}
+	return available, nil
+}
+
+// ChangeOrganizationQuota 从组织额度池扣除成员的消耗并累计到成员用量，quota 为负数时表示退还；
+// 用于预扣费，扣除时额度池不足返回错误，不会扣成负数
+func ChangeOrganizationQuota(orgId int, userId int, quota int) error {
+	return updateOrganizationQuota(orgId, userId, quota, true)
+}
+
+// SettleOrganizationQuota 结算时按实际消耗补扣或退还组织额度，服务已经完成，额度池不足时允许扣成负数，
+// 之后的请求在预扣费时被拒绝
+func SettleOrganizationQuota(orgId int, userId int, quota int) error {
+	return updateOrganizationQuota(orgId, userId, quota, false)
+}
+
+func updateOrganizationQuota(orgId int, userId int, quota int, guard bool) error {
+	if quota == 0 {
+		return nil
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		return changeOrganizationQuota(tx, orgId, userId, quota, guard)
+	})
+	if err != nil {
+		return err
+	}
+	cacheChangeOrganizationQuota(orgId, userId)
+	return nil
+}
+
+// changeOrganizationQuota 在事务内变动组织额度池与成员用量，guard 为 true 时额度池不足不扣除，
+// 提交后需调用 cacheChangeOrganizationQuota 清除缓存
+func changeOrganizationQuota(tx *gorm.DB, orgId int, userId int, quota int, guard bool) error {
+	query := tx.Model(&Organization{}).Where("id = ?", orgId)
+	if guard && quota > 0 {
+		query = query.Where("quota >= ?", quota)
+	}
+	result := query.Updates(map[string]interface{}{
+		"quota":      gorm.Expr("quota - ?", quota),
+		"used_quota": gorm.Expr("used_quota + ?", quota),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		if guard && quota > 0 {
+			return errors.New("组织额度不足")
+		}
+		return errors.New("组织不存在")
+	}
+	result = tx.Model(&OrganizationMember{}).Where("org_id = ? and user_id = ?", orgId, userId).
+		Update("used_quota", gorm.Expr("used_quota + ?", quota))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("不是该组织的成员")
+	}
+	return nil
+}
+
+// cacheChangeOrganizationQuota 额度变更后清除组织与成员缓存，下次读取时从数据库加载，
+// 不在缓存上读改写，避免并发请求互相覆盖
+func cacheChangeOrganizationQuota(orgId int, userId int) {
+	invalidateOrganizationCache(orgId)
+	invalidateOrganizationMemberCache(orgId, userId)
+}
+
+// TransferQuotaToOrganization 将用户的个人余额转入组织额度池
+func TransferQuotaToOrganization(orgId int, userId int, quota int) error {
+	if quota <= 0 {
+		return errors.New("转入额度必须大于 0")
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&User{}).Where("id = ? and quota >= ?", userId, quota).
+			Update("quota", gorm.Expr("quota - ?", quota))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("余额不足")
+		}
+		err := recordQuotaLedger(tx, userId, -quota, LedgerEntry{
+			Type:           LedgerTypeOrgTransfer,
+			CounterAccount: fmt.Sprintf("org:%d", orgId),
+			RefType:        "organization",
+			RefId:          strconv.Itoa(orgId),
+			ActorId:        userId,
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Model(&Organization{}).Where("id = ?", orgId).
+			Update("quota", gorm.Expr("quota + ?", quota)).Error
+	})
+	if err != nil {
+		return err
+	}
+	_ = invalidateUserCache(userId)
+	invalidateOrganizationCache(orgId)
+	RecordLog(userId, LogTypeManage, fmt.Sprintf("向组织 %d 的额度池转入 %s", orgId, common.LogQuota(quota)))
+	return nil
+}
+
+// GetOrganizationTokens 返回组织下所有成员创建的令牌，令牌密钥已清除
+func GetOrganizationTokens(orgId int, startIdx int, num int) ([]*Token, error) {
+	var tokens []*Token
+	err := DB.Where("org_id = ?", orgId).Order("id desc").Limit(num).Offset(startIdx).Find(&tokens).Error
+	for _, token := range tokens {
+		token.Clean()
+	}
+	return tokens, err
+}
+
+// GetUserIdByUsername 根据用户名查找用户 id，用于添加组织成员
+func GetUserIdByUsername(username string) (int, error) {
+	user := User{}
+	err := DB.Select("id").Where("username = ?", username).First(&user).Error
+	if err != nil {
+		return 0, errors.New("用户不存在")
+	}
+	return user.Id, nil
+}
+
+// SetOrganizationQuota 管理员直接设置组织额度池
+func SetOrganizationQuota(orgId int, quota int) error {
+	err := DB.Model(&Organization{}).Where("id = ?", orgId).Update("quota", quota).Error
+	invalidateOrganizationCache(orgId)
+	return err
+}
@@ -0,0 +1,69 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+// 组织令牌每次请求都要校验组织与成员并查询可用额度，缓存组织与成员记录，nil 表示记录不存在
+var (
+	organizationCache       = newRowCache[Organization]("organization:")
+	organizationMemberCache = newRowCache[OrganizationMember]("organization_member:")
+)
+
+func getOrganizationMemberCacheKey(orgId int, userId int) string {
+	return fmt.Sprintf("%d:%d", orgId, userId)
+}
+
+// CacheGetOrganization 获取组织，优先读取缓存
+func CacheGetOrganization(id int) (*Organization, error) {
+	key := strconv.Itoa(id)
+	org, ok := organizationCache.Get(key)
+	if !ok {
+		var err error
+		org, err = GetOrganizationById(id)
+		if err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, err
+			}
+			org = nil
+		}
+		organizationCache.Set(key, org)
+	}
+	if org == nil {
+		return nil, errors.New("组织不存在")
+	}
+	return org, nil
+}
+
+// CacheGetOrganizationMember 获取组织成员，优先读取缓存
+func CacheGetOrganizationMember(orgId int, userId int) (*OrganizationMember, error) {
+	key := getOrganizationMemberCacheKey(orgId, userId)
+	member, ok := organizationMemberCache.Get(key)
+	if !ok {
+		member = &OrganizationMember{}
+		err := DB.Where("org_id = ? and user_id = ?", orgId, userId).First(member).Error
+		if err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, err
+			}
+			member = nil
+		}
+		organizationMemberCache.Set(key, member)
+	}
+	if member == nil {
+		return nil, errors.New("不是该组织的成员")
+	}
+	return member, nil
+}
+
+func invalidateOrganizationCache(orgId int) {
+	organizationCache.Delete(strconv.Itoa(orgId))
+}
+
+func invalidateOrganizationMemberCache(orgId int, userId int) {
+	organizationMemberCache.Delete(getOrganizationMemberCacheKey(orgId, userId))
+}
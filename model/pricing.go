@@ -15,6 +15,8 @@ type Pricing struct {
 	OwnerBy         string   `json:"owner_by"`
 	CompletionRatio float64  `json:"completion_ratio"`
 	EnableGroup     []string `json:"enable_groups,omitempty"`
+	// TieredRatios 按提示 token 数划分的阶梯倍率
+	TieredRatios []operation_setting.ModelRatioTier `json:"tiered_ratios,omitempty"`
 }
 
 var (
@@ -73,6 +75,7 @@ func updatePricing() {
 			modelRatio, _ := operation_setting.GetModelRatio(model)
 			pricing.ModelRatio = modelRatio
 			pricing.CompletionRatio = operation_setting.GetCompletionRatio(model)
+			pricing.TieredRatios, _ = operation_setting.GetModelTieredRatios(model)
 			pricing.QuotaType = 0
 		}
 		pricingMap = append(pricingMap, pricing)
@@ -0,0 +1,358 @@
+package model
+
+import (
+	"fmt"
+	"one-api/common"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	LedgerTypeOpening      = "opening"      // 启用流水时的期初余额
+	LedgerTypeRegister     = "register"     // 新用户注册赠送
+	LedgerTypeInvite       = "invite"       // 使用邀请码赠送
+	LedgerTypeAffTransfer  = "aff_transfer" // 邀请额度划转
+	LedgerTypeTopUp        = "topup"        // 在线充值
+	LedgerTypeRedemption   = "redemption"   // 兑换码充值
+	LedgerTypeConsume      = "consume"      // 请求消耗，负数为预扣费退还
+	LedgerTypeRefund       = "refund"       // 失败任务补偿、退款等
+	LedgerTypeSubscription = "subscription" // 余额购买或续费订阅套餐
+	LedgerTypeOrgTransfer  = "org_transfer" // 转入组织额度池
+	LedgerTypeAdjust       = "adjust"       // 管理员调整
+)
+
+// 流水对方科目，与用户账户构成一借一贷
+const (
+	LedgerAccountOpening      = "equity:opening"
+	LedgerAccountPromotion    = "expense:promotion"
+	LedgerAccountAffiliate    = "expense:affiliate"
+	LedgerAccountRedemption   = "liability:redemption"
+	LedgerAccountConsume      = "revenue:consume"
+	LedgerAccountSubscription = "revenue:subscription"
+	LedgerAccountAdjust       = "equity:adjust"
+)
+
+// QuotaLedger 用户额度流水，只追加不修改。Amount 为用户账户的变动（正数为入账），
+// 对方科目 CounterAccount 记相反的变动，所有流水借贷相抵
+type QuotaLedger struct {
+	Id             int    `json:"id"`
+	UserId         int    `json:"user_id" gorm:"index"`
+	Type           string `json:"type" gorm:"type:varchar(32);index"`
+	Amount         int    `json:"amount"`
+	BalanceAfter   int    `json:"balance_after"`
+	CounterAccount string `json:"counter_account" gorm:"type:varchar(64);index"`
+	RefType        string `json:"ref_type" gorm:"type:varchar(32)"`
+	RefId          string `json:"ref_id" gorm:"type:varchar(128);index"`
+	ActorId        int    `json:"actor_id"`
+	Remark         string `json:"remark"`
+	CreatedAt      int64  `json:"created_at" gorm:"bigint;index"`
+}
+
+// LedgerEntry 记录流水时由调用方提供的业务信息
+type LedgerEntry struct {
+	Type           string
+	CounterAccount string
+	RefType        string
+	RefId          string
+	ActorId        int
+	Remark         string
+}
+
+// LedgerAccountBalance 对方科目的累计发生额
+type LedgerAccountBalance struct {
+	Account string `json:"account"`
+	Balance int    `json:"balance"`
+	Count   int    `json:"count"`
+}
+
+// LedgerReconcileItem 流水与用户余额不一致的记录
+type LedgerReconcileItem struct {
+	UserId           int `json:"user_id"`
+	Quota            int `json:"quota"`
+	LedgerBalance    int `json:"ledger_balance"`
+	LastBalanceAfter int `json:"last_balance_after"`
+	Diff             int `json:"diff"`
+}
+
+// recordQuotaLedger 在余额变动的同一事务内追加一条流水，amount 为正数表示入账；
+// BalanceAfter 取本事务更新后的用户余额，更新语句持有用户行锁，因此多节点并发写入时余额仍然连续
+func recordQuotaLedger(tx *gorm.DB, userId int, amount int, entry LedgerEntry) error {
+	if userId == 0 || amount == 0 {
+		return nil
+	}
+	var balance int
+	if err := tx.Unscoped().Model(&User{}).Where("id = ?", userId).Select("quota").Scan(&balance).Error; err != nil {
+		return err
+	}
+	return tx.Create(&QuotaLedger{
+		UserId:         userId,
+		Type:           entry.Type,
+		Amount:         amount,
+		BalanceAfter:   balance,
+		CounterAccount: entry.CounterAccount,
+		RefType:        entry.RefType,
+		RefId:          entry.RefId,
+		ActorId:        entry.ActorId,
+		Remark:         entry.Remark,
+		CreatedAt:      common.GetTimestamp(),
+	}).Error
+}
+
+// changeUserQuotaWithLedger 在同一事务内变动用户余额并追加流水
+func changeUserQuotaWithLedger(userId int, amount int, entry LedgerEntry) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&User{}).Where("id = ?", userId).Update("quota", gorm.Expr("quota + ?", amount))
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		return recordQuotaLedger(tx, userId, amount, entry)
+	})
+}
+
+// ChangeUserQuota 变动用户余额并记录流水，amount 为正数表示入账；
+// db 为 false 且开启批量更新时，余额变动与流水一起暂存，在批量更新时按用户与流水类型合并后同一事务写入
+func ChangeUserQuota(userId int, amount int, entry LedgerEntry, db bool) error {
+	if amount == 0 {
+		return nil
+	}
+	gopool.Go(func() {
+		err := cacheIncrUserQuota(userId, int64(amount))
+		if err != nil {
+			common.SysError("failed to change user quota cache: " + err.Error())
+		}
+	})
+	if !db && common.BatchUpdateEnabled {
+		addNewUserQuotaRecord(userId, amount, entry)
+		return nil
+	}
+	return changeUserQuotaWithLedger(userId, amount, entry)
+}
+
+// pendingLedgerKey 批量更新中合并流水的维度
+type pendingLedgerKey struct {
+	Type           string
+	CounterAccount string
+}
+
+type pendingLedger struct {
+	Amount int
+	Count  int
+}
+
+// batchUpdateLedgers 批量更新中暂存的流水，与 BatchUpdateTypeUserQuota 共用锁，保证余额与流水一起落库
+var batchUpdateLedgers = make(map[int]map[pendingLedgerKey]*pendingLedger)
+
+func addNewUserQuotaRecord(userId int, amount int, entry LedgerEntry) {
+	batchUpdateLocks[BatchUpdateTypeUserQuota].Lock()
+	defer batchUpdateLocks[BatchUpdateTypeUserQuota].Unlock()
+	batchUpdateStores[BatchUpdateTypeUserQuota][userId] += amount
+	ledgers, ok := batchUpdateLedgers[userId]
+	if !ok {
+		ledgers = make(map[pendingLedgerKey]*pendingLedger)
+		batchUpdateLedgers[userId] = ledgers
+	}
+	key := pendingLedgerKey{Type: entry.Type, CounterAccount: entry.CounterAccount}
+	ledger, ok := ledgers[key]
+	if !ok {
+		ledger = &pendingLedger{}
+		ledgers[key] = ledger
+	}
+	ledger.Amount += amount
+	ledger.Count++
+}
+
+// batchUpdateUserQuota 将暂存的余额变动与合并后的流水在同一事务内写入，每条流水单独变动余额以保证 BalanceAfter 连续
+func batchUpdateUserQuota(userId int, amount int, ledgers map[pendingLedgerKey]*pendingLedger) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		for key, ledger := range ledgers {
+			amount -= ledger.Amount
+			if ledger.Amount == 0 {
+				continue
+			}
+			result := tx.Model(&User{}).Where("id = ?", userId).Update("quota", gorm.Expr("quota + ?", ledger.Amount))
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			err := recordQuotaLedger(tx, userId, ledger.Amount, LedgerEntry{
+				Type:           key.Type,
+				CounterAccount: key.CounterAccount,
+				RefType:        "batch",
+				Remark:         fmt.Sprintf("批量更新合并 %d 条", ledger.Count),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if amount == 0 {
+			return nil
+		}
+		return tx.Model(&User{}).Where("id = ?", userId).Update("quota", gorm.Expr("quota + ?", amount)).Error
+	})
+}
+
+// CreateUserWithOpeningLedger 创建用户并在同一事务内以初始额度写入期初流水
+func CreateUserWithOpeningLedger(user *User) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		return recordQuotaLedger(tx, user.Id, user.Quota, LedgerEntry{
+			Type:           LedgerTypeOpening,
+			CounterAccount: LedgerAccountOpening,
+		})
+	})
+}
+
+// initQuotaLedgerOpenings 为还没有流水的用户写入期初余额，仅在主节点迁移时执行
+func initQuotaLedgerOpenings() error {
+	var users []User
+	return DB.Unscoped().Select("id", "quota").
+		Where("id not in (?)", DB.Model(&QuotaLedger{}).Distinct("user_id")).
+		FindInBatches(&users, 500, func(tx *gorm.DB, batch int) error {
+			now := common.GetTimestamp()
+			ledgers := make([]QuotaLedger, 0, len(users))
+			for _, user := range users {
+				ledgers = append(ledgers, QuotaLedger{
+					UserId:         user.Id,
+					Type:           LedgerTypeOpening,
+					Amount:         user.Quota,
+					BalanceAfter:   user.Quota,
+					CounterAccount: LedgerAccountOpening,
+					CreatedAt:      now,
+				})
+			}
+			return DB.Create(&ledgers).Error
+		}).Error
+}
+
+func GetUserQuotaLedgers(userId int, ledgerType string, startIdx int, num int) (ledgers []*QuotaLedger, total int64, err error) {
+	tx := DB.Model(&QuotaLedger{}).Where("user_id = ?", userId)
+	if ledgerType != "" {
+		tx = tx.Where("type = ?", ledgerType)
+	}
+	err = tx.Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&ledgers).Error
+	return ledgers, total, err
+}
+
+func GetAllQuotaLedgers(userId int, ledgerType string, refId string, startIdx int, num int) (ledgers []*QuotaLedger, total int64, err error) {
+	tx := DB.Model(&QuotaLedger{})
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if ledgerType != "" {
+		tx = tx.Where("type = ?", ledgerType)
+	}
+	if refId != "" {
+		tx = tx.Where("ref_id = ?", refId)
+	}
+	err = tx.Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&ledgers).Error
+	return ledgers, total, err
+}
+
+// GetQuotaLedgerAccountBalances 汇总各对方科目的发生额，对方科目余额为用户入账的相反数
+func GetQuotaLedgerAccountBalances() ([]*LedgerAccountBalance, error) {
+	var balances []*LedgerAccountBalance
+	err := DB.Model(&QuotaLedger{}).
+		Select("counter_account as account, -sum(amount) as balance, count(*) as count").
+		Group("counter_account").Order("counter_account").Scan(&balances).Error
+	return balances, err
+}
+
+// ReconcileQuotaLedger 核对每个用户的流水合计与 User.Quota 是否一致，同时检查最后一条流水的 BalanceAfter 是否等于流水合计，
+// 返回不一致的用户。批量更新中的余额变动与流水一起落库，因此无需计入各节点未落库的部分；
+// 核对期间有新的变动提交时可能出现暂时的不一致，对不一致的用户会单独复核一次
+func ReconcileQuotaLedger() ([]*LedgerReconcileItem, error) {
+	items := make([]*LedgerReconcileItem, 0)
+	var users []User
+	err := DB.Select("id", "quota").FindInBatches(&users, 500, func(tx *gorm.DB, batch int) error {
+		userIds := make([]int, 0, len(users))
+		for _, user := range users {
+			userIds = append(userIds, user.Id)
+		}
+		var sums []struct {
+			UserId  int
+			Balance int
+			LastId  int
+		}
+		err := DB.Model(&QuotaLedger{}).Select("user_id, sum(amount) as balance, max(id) as last_id").
+			Where("user_id in ?", userIds).Group("user_id").Scan(&sums).Error
+		if err != nil {
+			return err
+		}
+		balances := make(map[int]int, len(sums))
+		lastIds := make([]int, 0, len(sums))
+		for _, sum := range sums {
+			balances[sum.UserId] = sum.Balance
+			lastIds = append(lastIds, sum.LastId)
+		}
+		var lasts []QuotaLedger
+		if len(lastIds) > 0 {
+			if err = DB.Select("user_id", "balance_after").Where("id in ?", lastIds).Find(&lasts).Error; err != nil {
+				return err
+			}
+		}
+		lastBalances := make(map[int]int, len(lasts))
+		for _, last := range lasts {
+			lastBalances[last.UserId] = last.BalanceAfter
+		}
+		for _, user := range users {
+			if user.Quota == balances[user.Id] && lastBalances[user.Id] == balances[user.Id] {
+				continue
+			}
+			item, err := reconcileUserQuotaLedger(user.Id)
+			if err != nil {
+				return err
+			}
+			if item != nil {
+				items = append(items, item)
+			}
+		}
+		return nil
+	}).Error
+	return items, err
+}
+
+// reconcileUserQuotaLedger 在一个事务内复核单个用户，先锁定用户行以阻止并发的余额变动，一致时返回 nil
+func reconcileUserQuotaLedger(userId int) (*LedgerReconcileItem, error) {
+	var item *LedgerReconcileItem
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var user User
+		err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "quota").
+			Where("id = ?", userId).First(&user).Error
+		if err != nil {
+			return err
+		}
+		var balance int
+		err = tx.Model(&QuotaLedger{}).Where("user_id = ?", userId).Select("coalesce(sum(amount), 0)").Scan(&balance).Error
+		if err != nil {
+			return err
+		}
+		var last QuotaLedger
+		err = tx.Select("balance_after").Where("user_id = ?", userId).Order("id desc").Limit(1).Find(&last).Error
+		if err != nil {
+			return err
+		}
+		if user.Quota == balance && last.BalanceAfter == balance {
+			return nil
+		}
+		item = &LedgerReconcileItem{
+			UserId:           userId,
+			Quota:            user.Quota,
+			LedgerBalance:    balance,
+			LastBalanceAfter: last.BalanceAfter,
+			Diff:             user.Quota - balance,
+		}
+		return nil
+	})
+	return item, err
+}
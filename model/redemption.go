@@ -4,9 +4,12 @@ import (
 	"errors"
 	"fmt"
 	"one-api/common"
+	"one-api/setting"
 	"strconv"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Redemption struct {
@@ -21,6 +24,83 @@ type Redemption struct {
 	Count        int            `json:"count" gorm:"-:all"` // only for api request
 	UsedUserId   int            `json:"used_user_id"`
 	DeletedAt    gorm.DeletedAt `gorm:"index"`
+	// ExpiredTime 兑换码过期时间，0 表示永不过期
+	ExpiredTime int64 `json:"expired_time" gorm:"bigint;default:0"`
+	// MaxUses 兑换码可被兑换的总次数，PerUserLimit 为每个用户可兑换的次数，-1 表示不限制
+	MaxUses      int `json:"max_uses" gorm:"default:1"`
+	PerUserLimit int `json:"per_user_limit" gorm:"default:1"`
+	UsedCount    int `json:"used_count" gorm:"default:0"`
+	// RewardType 兑换内容：quota 增加额度，group 升级用户分组，token 发放一个限时令牌
+	RewardType string `json:"reward_type" gorm:"type:varchar(16);default:'quota'"`
+	// RewardGroup 分组升级的目标分组
+	RewardGroup string `json:"reward_group" gorm:"type:varchar(64);default:''"`
+	// RewardDays 分组升级或令牌的有效天数，分组升级为 0 时永久生效
+	RewardDays int `json:"reward_days" gorm:"default:0"`
+}
+
+const (
+	RedemptionRewardQuota = "quota"
+	RedemptionRewardGroup = "group"
+	RedemptionRewardToken = "token"
+)
+
+// RedemptionUsage 兑换记录，多次使用的兑换码每次兑换记录一条，限时分组升级到期后据此恢复原分组
+type RedemptionUsage struct {
+	Id            int    `json:"id"`
+	RedemptionId  int    `json:"redemption_id" gorm:"index"`
+	UserId        int    `json:"user_id" gorm:"index"`
+	Name          string `json:"name" gorm:"index"`
+	RewardType    string `json:"reward_type" gorm:"type:varchar(16)"`
+	Quota         int    `json:"quota" gorm:"default:0"`
+	RewardGroup   string `json:"reward_group" gorm:"type:varchar(64);default:''"`
+	PreviousGroup string `json:"previous_group" gorm:"type:varchar(64);default:''"`
+	TokenId       int    `json:"token_id" gorm:"default:0"`
+	ExpireTime    int64  `json:"expire_time" gorm:"bigint;default:0;index"`
+	Restored      bool   `json:"restored" gorm:"default:false"`
+	CreatedTime   int64  `json:"created_time" gorm:"bigint"`
+}
+
+// RedemptionCampaignStat 按兑换码名称汇总的活动统计
+type RedemptionCampaignStat struct {
+	Name         string `json:"name"`
+	CodeCount    int    `json:"code_count"`
+	EnabledCount int    `json:"enabled_count"`
+	UsedUpCount  int    `json:"used_up_count"`
+	ExpiredCount int    `json:"expired_count"`
+	Redemptions  int    `json:"redemptions"`
+	UserCount    int    `json:"user_count"`
+	Quota        int    `json:"quota"`
+}
+
+// ValidateRewards 校验兑换内容与使用规则
+func (redemption *Redemption) ValidateRewards() error {
+	if redemption.RewardType == "" {
+		redemption.RewardType = RedemptionRewardQuota
+	}
+	// 未填写使用次数时与原有兑换码一致，只能兑换一次
+	if redemption.MaxUses == 0 {
+		redemption.MaxUses = 1
+	}
+	if redemption.PerUserLimit == 0 {
+		redemption.PerUserLimit = 1
+	}
+	if redemption.MaxUses < -1 || redemption.PerUserLimit < -1 || redemption.RewardDays < 0 {
+		return errors.New("使用次数只能为 -1（不限制）或正数，有效天数不能为负数")
+	}
+	switch redemption.RewardType {
+	case RedemptionRewardQuota:
+	case RedemptionRewardGroup:
+		if !setting.ContainsGroupRatio(redemption.RewardGroup) {
+			return errors.New("分组升级的目标分组不存在")
+		}
+	case RedemptionRewardToken:
+		if redemption.RewardDays <= 0 {
+			return errors.New("限时令牌的有效天数必须大于 0")
+		}
+	default:
+		return errors.New("无效的兑换内容类型")
+	}
+	return nil
 }
 
 func GetAllRedemptions(startIdx int, num int) (redemptions []*Redemption, total int64, err error) {
@@ -109,6 +189,7 @@ func GetRedemptionById(id int) (*Redemption, error) {
 	return &redemption, err
 }
 
+// Redeem 兑换兑换码，使用次数以条件更新占用，保证并发兑换不会超过 MaxUses
 func Redeem(key string, userId int) (quota int, err error) {
 	if key == "" {
 		return 0, errors.New("未提供兑换码")
@@ -117,6 +198,7 @@ func Redeem(key string, userId int) (quota int, err error) {
 		return 0, errors.New("无效的 user id")
 	}
 	redemption := &Redemption{}
+	usage := &RedemptionUsage{}
 
 	keyCol := "`key`"
 	if common.UsingPostgreSQL {
@@ -124,28 +206,239 @@ func Redeem(key string, userId int) (quota int, err error) {
 	}
 	common.RandomSleep()
 	err = DB.Transaction(func(tx *gorm.DB) error {
-		err := tx.Set("gorm:query_option", "FOR UPDATE").Where(keyCol+" = ?", key).First(redemption).Error
+		// 锁定兑换码行，同一兑换码的兑换串行执行，保证每用户兑换次数的检查不会被并发绕过
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where(keyCol+" = ?", key).First(redemption).Error
 		if err != nil {
 			return errors.New("无效的兑换码")
 		}
 		if redemption.Status != common.RedemptionCodeStatusEnabled {
 			return errors.New("该兑换码已被使用")
 		}
-		err = tx.Model(&User{}).Where("id = ?", userId).Update("quota", gorm.Expr("quota + ?", redemption.Quota)).Error
+		now := common.GetTimestamp()
+		if redemption.ExpiredTime != 0 && redemption.ExpiredTime < now {
+			return errors.New("该兑换码已过期")
+		}
+		if redemption.PerUserLimit > 0 {
+			var used int64
+			err = tx.Model(&RedemptionUsage{}).Where("redemption_id = ? and user_id = ?", redemption.Id, userId).Count(&used).Error
+			if err != nil {
+				return err
+			}
+			if used >= int64(redemption.PerUserLimit) {
+				return errors.New("已达到该兑换码的兑换次数上限")
+			}
+		}
+		result := tx.Model(&Redemption{}).
+			Where("id = ? and status = ? and (max_uses < 0 or used_count < max_uses)", redemption.Id, common.RedemptionCodeStatusEnabled).
+			Updates(map[string]interface{}{
+				"used_count":    gorm.Expr("used_count + 1"),
+				"redeemed_time": now,
+				"used_user_id":  userId,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("该兑换码已被使用")
+		}
+		redemption.UsedCount++
+		redemption.RedeemedTime = now
+		redemption.UsedUserId = userId
+		if redemption.MaxUses > 0 && redemption.UsedCount >= redemption.MaxUses {
+			redemption.Status = common.RedemptionCodeStatusUsed
+			err = tx.Model(&Redemption{}).Where("id = ?", redemption.Id).Update("status", redemption.Status).Error
+			if err != nil {
+				return err
+			}
+		}
+		usage = &RedemptionUsage{
+			RedemptionId: redemption.Id,
+			UserId:       userId,
+			Name:         redemption.Name,
+			RewardType:   redemption.RewardType,
+			CreatedTime:  now,
+		}
+		switch redemption.RewardType {
+		case RedemptionRewardGroup:
+			var user User
+			if err = tx.Select("id", "group").First(&user, "id = ?", userId).Error; err != nil {
+				return err
+			}
+			// 分组之间没有高低之分，只允许从默认分组升级或延长当前分组，避免把其他分组的用户降级
+			if user.Group != redemption.RewardGroup && user.Group != "default" {
+				return fmt.Errorf("当前分组 %s 不能通过该兑换码变更为 %s", user.Group, redemption.RewardGroup)
+			}
+			usage.RewardGroup = redemption.RewardGroup
+			usage.PreviousGroup = user.Group
+			if redemption.RewardDays > 0 {
+				usage.ExpireTime = now + int64(redemption.RewardDays)*86400
+			}
+			// 已有未恢复的限时升级时延长该记录的有效期，到期后恢复为最初的分组；不限时的兑换使其不再到期
+			var open RedemptionUsage
+			err = tx.Where("user_id = ? and reward_type = ? and reward_group = ? and restored = ? and expire_time > 0",
+				userId, RedemptionRewardGroup, redemption.RewardGroup, false).Order("expire_time desc").First(&open).Error
+			if err == nil {
+				usage.PreviousGroup = open.PreviousGroup
+				if usage.ExpireTime > 0 {
+					usage.ExpireTime = max(open.ExpireTime, now) + int64(redemption.RewardDays)*86400
+				}
+				if err = tx.Model(&open).Update("expire_time", usage.ExpireTime).Error; err != nil {
+					return err
+				}
+				// 本次兑换的有效期已合并到之前的记录，不再单独恢复
+				usage.Restored = true
+			} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+			err = tx.Model(&User{}).Where("id = ?", userId).Update("group", redemption.RewardGroup).Error
+		case RedemptionRewardToken:
+			var tokenKey string
+			tokenKey, err = common.GenerateKey()
+			if err != nil {
+				return err
+			}
+			// 赠送的额度只属于该令牌，令牌消耗不扣除用户余额，令牌过期后额度随之失效
+			token := &Token{
+				UserId:       userId,
+				Name:         "兑换码-" + redemption.Name,
+				Key:          tokenKey,
+				CreatedTime:  now,
+				AccessedTime: now,
+				ExpiredTime:  now + int64(redemption.RewardDays)*86400,
+				RemainQuota:  redemption.Quota,
+				SelfFunded:   true,
+			}
+			if err = tx.Create(token).Error; err != nil {
+				return err
+			}
+			usage.TokenId = token.Id
+			usage.ExpireTime = token.ExpiredTime
+			usage.Quota = redemption.Quota
+		default:
+			usage.Quota = redemption.Quota
+			err = tx.Model(&User{}).Where("id = ?", userId).Update("quota", gorm.Expr("quota + ?", redemption.Quota)).Error
+			if err != nil {
+				return err
+			}
+			err = recordQuotaLedger(tx, userId, usage.Quota, LedgerEntry{
+				Type:           LedgerTypeRedemption,
+				CounterAccount: LedgerAccountRedemption,
+				RefType:        "redemption",
+				RefId:          strconv.Itoa(redemption.Id),
+				ActorId:        userId,
+			})
+		}
 		if err != nil {
 			return err
 		}
-		redemption.RedeemedTime = common.GetTimestamp()
-		redemption.Status = common.RedemptionCodeStatusUsed
-		redemption.UsedUserId = userId
-		err = tx.Save(redemption).Error
-		return err
+		return tx.Create(usage).Error
 	})
 	if err != nil {
 		return 0, errors.New("兑换失败，" + err.Error())
 	}
-	RecordLog(userId, LogTypeTopup, fmt.Sprintf("通过兑换码充值 %s，兑换码ID %d", common.LogQuota(redemption.Quota), redemption.Id))
-	return redemption.Quota, nil
+	_ = invalidateUserCache(userId)
+	switch redemption.RewardType {
+	case RedemptionRewardGroup:
+		content := fmt.Sprintf("通过兑换码将分组从 %s 升级为 %s，兑换码ID %d", usage.PreviousGroup, usage.RewardGroup, redemption.Id)
+		if usage.Restored {
+			content = fmt.Sprintf("通过兑换码延长分组 %s 的有效期，兑换码ID %d", usage.RewardGroup, redemption.Id)
+		}
+		if usage.ExpireTime > 0 {
+			content += fmt.Sprintf("，有效期至 %s", time.Unix(usage.ExpireTime, 0).Format("2006-01-02 15:04:05"))
+		}
+		RecordLog(userId, LogTypeTopup, content)
+	case RedemptionRewardToken:
+		RecordLog(userId, LogTypeTopup, fmt.Sprintf("通过兑换码获得令牌 #%d，额度 %s，有效期至 %s，兑换码ID %d", usage.TokenId,
+			common.LogQuota(usage.Quota), time.Unix(usage.ExpireTime, 0).Format("2006-01-02 15:04:05"), redemption.Id))
+	default:
+		RecordLog(userId, LogTypeTopup, fmt.Sprintf("通过兑换码充值 %s，兑换码ID %d", common.LogQuota(redemption.Quota), redemption.Id))
+	}
+	return usage.Quota, nil
+}
+
+// RestoreExpiredRedemptionGroups 恢复到期的限时分组升级，用户分组已被其他方式修改时不再恢复
+func RestoreExpiredRedemptionGroups() (int, error) {
+	var usages []*RedemptionUsage
+	err := DB.Where("reward_type = ? and restored = ? and expire_time > 0 and expire_time < ?",
+		RedemptionRewardGroup, false, common.GetTimestamp()).Find(&usages).Error
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, usage := range usages {
+		result := DB.Model(&User{}).Where("id = ? and "+groupCol+" = ?", usage.UserId, usage.RewardGroup).
+			Update("group", usage.PreviousGroup)
+		if result.Error != nil {
+			common.SysError(fmt.Sprintf("failed to restore group of redemption usage %d: %s", usage.Id, result.Error.Error()))
+			continue
+		}
+		if err = DB.Model(usage).Update("restored", true).Error; err != nil {
+			common.SysError(fmt.Sprintf("failed to mark redemption usage %d restored: %s", usage.Id, err.Error()))
+			continue
+		}
+		if result.RowsAffected > 0 {
+			_ = updateUserGroupCache(usage.UserId, usage.PreviousGroup)
+			RecordLog(usage.UserId, LogTypeSystem, fmt.Sprintf("兑换码分组升级已到期，分组恢复为 %s", usage.PreviousGroup))
+			count++
+		}
+	}
+	return count, nil
+}
+
+// GetRedemptionCampaignStats 按兑换码名称统计各活动的兑换情况
+func GetRedemptionCampaignStats(keyword string) ([]*RedemptionCampaignStat, error) {
+	var stats []*RedemptionCampaignStat
+	now := common.GetTimestamp()
+	query := DB.Model(&Redemption{}).Select(
+		"name, count(*) as code_count, "+
+			"sum(case when status = ? and (expired_time = 0 or expired_time >= ?) then 1 else 0 end) as enabled_count, "+
+			"sum(case when status = ? then 1 else 0 end) as used_up_count, "+
+			"sum(case when status = ? and expired_time != 0 and expired_time < ? then 1 else 0 end) as expired_count, "+
+			"sum(case when status = ? and used_count = 0 then 1 else used_count end) as redemptions",
+		common.RedemptionCodeStatusEnabled, now, common.RedemptionCodeStatusUsed,
+		common.RedemptionCodeStatusEnabled, now, common.RedemptionCodeStatusUsed)
+	if keyword != "" {
+		query = query.Where("name LIKE ?", keyword+"%")
+	}
+	err := query.Group("name").Order("name").Scan(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+	var usageStats []struct {
+		Name      string
+		UserCount int
+		Quota     int
+	}
+	usageQuery := DB.Model(&RedemptionUsage{}).Select("name, count(distinct user_id) as user_count, sum(quota) as quota")
+	if keyword != "" {
+		usageQuery = usageQuery.Where("name LIKE ?", keyword+"%")
+	}
+	err = usageQuery.Group("name").Scan(&usageStats).Error
+	if err != nil {
+		return nil, err
+	}
+	statMap := make(map[string]*RedemptionCampaignStat, len(stats))
+	for _, stat := range stats {
+		statMap[stat.Name] = stat
+	}
+	for _, usageStat := range usageStats {
+		if stat, ok := statMap[usageStat.Name]; ok {
+			stat.UserCount = usageStat.UserCount
+			stat.Quota = usageStat.Quota
+		}
+	}
+	return stats, nil
+}
+
+// GetRedemptionsByName 返回同一名称下的全部兑换码，用于批量导出
+func GetRedemptionsByName(name string) (redemptions []*Redemption, err error) {
+	err = DB.Where("name = ?", name).Order("id asc").Find(&redemptions).Error
+	return redemptions, err
+}
+
+func GetRedemptionUsages(redemptionId int) (usages []*RedemptionUsage, err error) {
+	err = DB.Where("redemption_id = ?", redemptionId).Order("id desc").Find(&usages).Error
+	return usages, err
 }
 
 func (redemption *Redemption) Insert() error {
@@ -162,7 +455,8 @@ func (redemption *Redemption) SelectUpdate() error {
 // Update Make sure your token's fields is completed, because this will update non-zero values
 func (redemption *Redemption) Update() error {
 	var err error
-	err = DB.Model(redemption).Select("name", "status", "quota", "redeemed_time").Updates(redemption).Error
+	err = DB.Model(redemption).Select("name", "status", "quota", "redeemed_time", "expired_time", "max_uses", "per_user_limit",
+		"reward_type", "reward_group", "reward_days").Updates(redemption).Error
 	return err
 }
 
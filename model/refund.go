@@ -0,0 +1,276 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"one-api/common"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+const (
+	RefundTypeTopUp   = "topup"   // 充值订单退款，扣回对应额度
+	RefundTypeConsume = "consume" // 单次请求消耗退还
+)
+
+// Refund 退款记录，充值退款记录退回的金额与扣回的额度，消耗退还记录返还的额度
+type Refund struct {
+	Id          int     `json:"id"`
+	UserId      int     `json:"user_id" gorm:"index"`
+	Type        string  `json:"type" gorm:"type:varchar(16);index"`
+	TopUpId     int     `json:"top_up_id" gorm:"index"`
+	TradeNo     string  `json:"trade_no" gorm:"type:varchar(128)"`
+	LogId       int     `json:"log_id" gorm:"index;uniqueIndex:idx_refund_log_seq"`
+	LogSeq      *int    `json:"-" gorm:"uniqueIndex:idx_refund_log_seq"` // 同一消费记录的第几次退还，与 LogId 构成唯一约束；充值退款为空
+	Money       float64 `json:"money"`
+	Quota       int     `json:"quota"`
+	Reason      string  `json:"reason"`
+	OperatorId  int     `json:"operator_id"`
+	CreatedTime int64   `json:"created_time" gorm:"bigint;index"`
+}
+
+func (refund *Refund) Insert() error {
+	refund.CreatedTime = common.GetTimestamp()
+	return DB.Create(refund).Error
+}
+
+func GetRefunds(userId int, refundType string, startIdx int, num int) (refunds []*Refund, total int64, err error) {
+	tx := DB.Model(&Refund{})
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if refundType != "" {
+		tx = tx.Where("type = ?", refundType)
+	}
+	err = tx.Count(&total).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&refunds).Error
+	return refunds, total, err
+}
+
+// GetTopUpRefundQuota 按退款金额占订单金额的比例计算需要扣回的额度，订阅套餐订单不扣回额度
+func GetTopUpRefundQuota(topUp *TopUp, money float64) int {
+	if topUp.PlanId > 0 || topUp.Money <= 0 {
+		return 0
+	}
+	credited := float64(topUp.Amount) * common.QuotaPerUnit
+	return int(math.Round(credited * money / topUp.Money))
+}
+
+// ApplyTopUpRefund 扣回用户额度并更新订单的退款状态，force 为 true 时允许余额扣为负数
+func ApplyTopUpRefund(topUp *TopUp, money float64, quota int, force bool) error {
+	refundedMoney := math.Round((topUp.RefundedMoney+money)*100) / 100
+	status := TopUpStatusPartialRefunded
+	if refundedMoney >= topUp.Money {
+		status = TopUpStatusRefunded
+	}
+	if !CanTransitTopUpStatus(topUp.Status, status) {
+		return fmt.Errorf("订单状态为 %s，不能退款", topUp.Status)
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if quota > 0 {
+			query := tx.Model(&User{}).Where("id = ?", topUp.UserId)
+			if !force {
+				query = query.Where("quota >= ?", quota)
+			}
+			result := query.Update("quota", gorm.Expr("quota - ?", quota))
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return errors.New("用户余额不足以扣回充值额度")
+			}
+			err := recordQuotaLedger(tx, topUp.UserId, -quota, LedgerEntry{
+				Type:           LedgerTypeRefund,
+				CounterAccount: "payment:" + topUp.PaymentProvider,
+				RefType:        "topup",
+				RefId:          topUp.TradeNo,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		result := tx.Model(&TopUp{}).Where("id = ? and status = ?", topUp.Id, topUp.Status).Updates(map[string]interface{}{
+			"status":         status,
+			"refunded_money": refundedMoney,
+			"refunded_quota": topUp.RefundedQuota + quota,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("订单状态已变更，请刷新后重试")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	topUp.Status = status
+	topUp.RefundedMoney = refundedMoney
+	topUp.RefundedQuota += quota
+	if quota > 0 {
+		_ = invalidateUserCache(topUp.UserId)
+	}
+	return nil
+}
+
+// RevertTopUpRefund 支付渠道退款失败时撤销 ApplyTopUpRefund 的修改
+func RevertTopUpRefund(topUp *TopUp, previousStatus string, money float64, quota int) error {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if quota > 0 {
+			err := tx.Model(&User{}).Where("id = ?", topUp.UserId).Update("quota", gorm.Expr("quota + ?", quota)).Error
+			if err != nil {
+				return err
+			}
+			err = recordQuotaLedger(tx, topUp.UserId, quota, LedgerEntry{
+				Type:           LedgerTypeRefund,
+				CounterAccount: "payment:" + topUp.PaymentProvider,
+				RefType:        "topup",
+				RefId:          topUp.TradeNo,
+				Remark:         "退款失败撤销",
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Model(&TopUp{}).Where("id = ?", topUp.Id).Updates(map[string]interface{}{
+			"status":         previousStatus,
+			"refunded_money": math.Round((topUp.RefundedMoney-money)*100) / 100,
+			"refunded_quota": topUp.RefundedQuota - quota,
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+	if quota > 0 {
+		_ = invalidateUserCache(topUp.UserId)
+	}
+	return nil
+}
+
+func GetConsumeLogById(id int) (*Log, error) {
+	var log Log
+	err := LOG_DB.Where("id = ? and type = ?", id, LogTypeConsume).First(&log).Error
+	if err != nil {
+		return nil, errors.New("消费记录不存在")
+	}
+	return &log, nil
+}
+
+// GetConsumeLogRefundableQuota 返回消费记录最多可退还的额度，订阅套餐承担以及未向用户收取的部分不退还到余额
+func GetConsumeLogRefundableQuota(log *Log) int {
+	quota := log.Quota
+	if other := common.StrToMap(log.Other); other != nil {
+		if subscriptionQuota, ok := other["subscription_quota"].(float64); ok {
+			quota -= int(subscriptionQuota)
+		}
+		if waivedQuota, ok := other["subscription_waived_quota"].(float64); ok {
+			quota -= int(waivedQuota)
+		}
+	}
+	return max(quota, 0)
+}
+
+// getConsumeLogToken 返回该次消耗使用的令牌，令牌不存在时返回 nil
+func getConsumeLogToken(log *Log) *Token {
+	if log.TokenId == 0 {
+		return nil
+	}
+	var token Token
+	err := DB.Unscoped().Select("id", "key", "self_funded").First(&token, "id = ?", log.TokenId).Error
+	if err != nil {
+		return nil
+	}
+	return &token
+}
+
+// RefundConsumeLog 将一次请求的消耗退还给付费方，组织令牌的消耗退回组织额度池，自付令牌的消耗退回令牌额度，
+// 其他消耗退回用户余额；使用令牌的消耗同时恢复令牌的剩余与已用额度。quota 不大于 0 时退还剩余的全部可退额度；
+// 可退额度校验、退还与退款记录在同一事务内完成，并发退还同一记录时由 (log_id, log_seq) 唯一约束保证只有一个成功
+func RefundConsumeLog(log *Log, quota int, reason string, operatorId int) (*Refund, error) {
+	refundable := GetConsumeLogRefundableQuota(log)
+	token := getConsumeLogToken(log)
+	selfFunded := log.OrgId == 0 && token != nil && token.SelfFunded
+	var refund *Refund
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var refunded struct {
+			Quota int
+			Count int
+		}
+		err := tx.Model(&Refund{}).Select("coalesce(sum(quota), 0) as quota, count(*) as count").
+			Where("type = ? and log_id = ?", RefundTypeConsume, log.Id).Scan(&refunded).Error
+		if err != nil {
+			return err
+		}
+		remaining := refundable - refunded.Quota
+		if quota <= 0 {
+			quota = remaining
+		}
+		if remaining <= 0 {
+			return errors.New("该次消耗已全部退还")
+		}
+		if quota > remaining {
+			return fmt.Errorf("退还额度超过可退额度 %s", common.LogQuota(remaining))
+		}
+		seq := refunded.Count + 1
+		refund = &Refund{
+			UserId:      log.UserId,
+			Type:        RefundTypeConsume,
+			LogId:       log.Id,
+			LogSeq:      &seq,
+			Quota:       quota,
+			Reason:      reason,
+			OperatorId:  operatorId,
+			CreatedTime: common.GetTimestamp(),
+		}
+		if err = tx.Create(refund).Error; err != nil {
+			return errors.New("该消费记录正在退还，请稍后重试")
+		}
+		if token != nil {
+			err = tx.Model(&Token{}).Where("id = ?", token.Id).Updates(map[string]interface{}{
+				"remain_quota": gorm.Expr("remain_quota + ?", quota),
+				"used_quota":   gorm.Expr("used_quota - ?", quota),
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		if log.OrgId != 0 {
+			return changeOrganizationQuota(tx, log.OrgId, log.UserId, -quota, false)
+		}
+		if selfFunded {
+			return nil
+		}
+		err = tx.Model(&User{}).Where("id = ?", log.UserId).Update("quota", gorm.Expr("quota + ?", quota)).Error
+		if err != nil {
+			return err
+		}
+		return recordQuotaLedger(tx, log.UserId, quota, LedgerEntry{
+			Type:           LedgerTypeRefund,
+			CounterAccount: LedgerAccountConsume,
+			RefType:        "log",
+			RefId:          strconv.Itoa(log.Id),
+			ActorId:        operatorId,
+			Remark:         reason,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if token != nil && common.RedisEnabled {
+		if err := cacheIncrTokenQuota(token.Key, int64(quota)); err != nil {
+			common.SysError("failed to increase token quota cache: " + err.Error())
+		}
+	}
+	if log.OrgId != 0 {
+		cacheChangeOrganizationQuota(log.OrgId, log.UserId)
+	} else if !selfFunded {
+		_ = invalidateUserCache(log.UserId)
+	}
+	return refund, nil
+}
@@ -0,0 +1,297 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"one-api/common"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 管理接口的权限
+const (
+	PermissionChannelRead     = "channel:read"
+	PermissionChannelWrite    = "channel:write"
+	PermissionChannelKey      = "channel:key"
+	PermissionUserRead        = "user:read"
+	PermissionUserWrite       = "user:write"
+	PermissionUserQuota       = "user:quota"
+	PermissionLogRead         = "log:read"
+	PermissionLogWrite        = "log:write"
+	PermissionOptionRead      = "option:read"
+	PermissionOptionWrite     = "option:write"
+	PermissionRedemptionRead  = "redemption:read"
+	PermissionRedemptionWrite = "redemption:write"
+	PermissionBillingRead     = "billing:read"
+	PermissionBillingWrite    = "billing:write"
+	PermissionAuditRead       = "audit:read"
+	PermissionSystemRead      = "system:read"
+	PermissionSystemWrite     = "system:write"
+	// PermissionRoleManage 管理角色与分配角色，只有超级管理员拥有，不能授予自定义角色
+	PermissionRoleManage = "role:manage"
+)
+
+// Permissions 可授予自定义角色的全部权限
+var Permissions = []string{
+	PermissionChannelRead, PermissionChannelWrite, PermissionChannelKey,
+	PermissionUserRead, PermissionUserWrite, PermissionUserQuota,
+	PermissionLogRead, PermissionLogWrite,
+	PermissionOptionRead, PermissionOptionWrite,
+	PermissionRedemptionRead, PermissionRedemptionWrite,
+	PermissionBillingRead, PermissionBillingWrite,
+	PermissionAuditRead,
+	PermissionSystemRead, PermissionSystemWrite,
+}
+
+// 内置角色，未分配自定义角色的用户按权限等级使用内置角色
+const (
+	RoleNameAdmin = "admin"
+	RoleNameRoot  = "root"
+)
+
+// 内置管理员角色的权限，与原先管理员可访问的接口一致
+var adminPermissions = []string{
+	PermissionChannelRead, PermissionChannelWrite,
+	PermissionUserRead, PermissionUserWrite, PermissionUserQuota,
+	PermissionLogRead, PermissionLogWrite,
+	PermissionRedemptionRead, PermissionRedemptionWrite,
+	PermissionBillingRead, PermissionBillingWrite,
+	PermissionAuditRead,
+	PermissionSystemRead, PermissionSystemWrite,
+}
+
+// Role 自定义角色，Permissions 为权限列表的 JSON
+type Role struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"type:varchar(64);uniqueIndex"`
+	Description string `json:"description" gorm:"type:varchar(255)"`
+	Permissions string `json:"permissions" gorm:"type:text"`
+	Builtin     bool   `json:"builtin" gorm:"-:all"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+	UpdatedTime int64  `json:"updated_time" gorm:"bigint"`
+}
+
+var (
+	roleCache         map[string][]string
+	roleCacheTime     time.Time
+	roleCacheLock     sync.RWMutex
+	errRoleNameExists = errors.New("角色名称已存在")
+)
+
+func IsBuiltinRole(name string) bool {
+	return name == RoleNameAdmin || name == RoleNameRoot
+}
+
+func isValidPermission(permission string) bool {
+	for _, p := range Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func (role *Role) GetPermissions() []string {
+	var permissions []string
+	if role.Permissions == "" {
+		return permissions
+	}
+	if err := json.Unmarshal([]byte(role.Permissions), &permissions); err != nil {
+		common.SysError("failed to unmarshal role permissions: " + err.Error())
+	}
+	return permissions
+}
+
+// SetPermissions 校验并去重后保存权限列表
+func (role *Role) SetPermissions(permissions []string) error {
+	seen := make(map[string]bool)
+	cleaned := make([]string, 0, len(permissions))
+	for _, permission := range permissions {
+		permission = strings.TrimSpace(permission)
+		if seen[permission] {
+			continue
+		}
+		if !isValidPermission(permission) {
+			return errors.New("无效的权限：" + permission)
+		}
+		seen[permission] = true
+		cleaned = append(cleaned, permission)
+	}
+	sort.Strings(cleaned)
+	data, err := json.Marshal(cleaned)
+	if err != nil {
+		return err
+	}
+	role.Permissions = string(data)
+	return nil
+}
+
+func (role *Role) validate() error {
+	role.Name = strings.TrimSpace(role.Name)
+	if role.Name == "" || len(role.Name) > 64 {
+		return errors.New("角色名称长度必须在1-64之间")
+	}
+	if IsBuiltinRole(role.Name) {
+		return errors.New("不能使用内置角色名称")
+	}
+	return nil
+}
+
+func builtinRoles() []*Role {
+	adminRole := &Role{Name: RoleNameAdmin, Description: "管理员", Builtin: true}
+	_ = adminRole.SetPermissions(adminPermissions)
+	rootRole := &Role{Name: RoleNameRoot, Description: "超级管理员，拥有全部权限", Builtin: true}
+	_ = rootRole.SetPermissions(Permissions)
+	return []*Role{adminRole, rootRole}
+}
+
+// GetAllRoles 返回内置角色与自定义角色
+func GetAllRoles() ([]*Role, error) {
+	var roles []*Role
+	if err := DB.Order("id asc").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	return append(builtinRoles(), roles...), nil
+}
+
+func GetRoleById(id int) (*Role, error) {
+	var role Role
+	err := DB.First(&role, id).Error
+	return &role, err
+}
+
+func (role *Role) Insert() error {
+	if err := role.validate(); err != nil {
+		return err
+	}
+	var count int64
+	DB.Model(&Role{}).Where("name = ?", role.Name).Count(&count)
+	if count > 0 {
+		return errRoleNameExists
+	}
+	role.CreatedTime = common.GetTimestamp()
+	role.UpdatedTime = role.CreatedTime
+	if err := DB.Create(role).Error; err != nil {
+		return err
+	}
+	reloadRoleCache()
+	return nil
+}
+
+// Update 更新角色的描述与权限，角色名称已分配给用户，不允许修改
+func (role *Role) Update() error {
+	role.UpdatedTime = common.GetTimestamp()
+	err := DB.Model(role).Select("description", "permissions", "updated_time").Updates(role).Error
+	if err != nil {
+		return err
+	}
+	reloadRoleCache()
+	return nil
+}
+
+// DeleteRoleById 删除角色，仍有用户使用该角色时不允许删除
+func DeleteRoleById(id int) error {
+	role, err := GetRoleById(id)
+	if err != nil {
+		return err
+	}
+	var count int64
+	DB.Model(&User{}).Where("permission_role = ?", role.Name).Count(&count)
+	if count > 0 {
+		return errors.New("仍有用户使用该角色，无法删除")
+	}
+	if err := DB.Delete(role).Error; err != nil {
+		return err
+	}
+	reloadRoleCache()
+	return nil
+}
+
+// RoleExists 角色是否存在，空字符串表示不使用自定义角色
+func RoleExists(name string) bool {
+	if name == "" {
+		return true
+	}
+	if IsBuiltinRole(name) {
+		return false
+	}
+	_, ok := getRolePermissions(name)
+	return ok
+}
+
+func reloadRoleCache() {
+	var roles []*Role
+	if err := DB.Find(&roles).Error; err != nil {
+		common.SysError("failed to load roles: " + err.Error())
+		return
+	}
+	cache := make(map[string][]string, len(roles))
+	for _, role := range roles {
+		cache[role.Name] = role.GetPermissions()
+	}
+	roleCacheLock.Lock()
+	roleCache = cache
+	roleCacheTime = time.Now()
+	roleCacheLock.Unlock()
+}
+
+// getRolePermissions 从缓存读取自定义角色的权限，缓存超过同步间隔后从数据库重新加载
+func getRolePermissions(name string) ([]string, bool) {
+	roleCacheLock.RLock()
+	expired := roleCache == nil || time.Since(roleCacheTime) > time.Duration(common.SyncFrequency)*time.Second
+	roleCacheLock.RUnlock()
+	if expired {
+		reloadRoleCache()
+	}
+	roleCacheLock.RLock()
+	defer roleCacheLock.RUnlock()
+	permissions, ok := roleCache[name]
+	return permissions, ok
+}
+
+// GetUserPermissions 返回用户拥有的权限。超级管理员拥有全部权限，分配了自定义角色的管理员使用该角色的权限，
+// 其余管理员使用内置管理员角色的权限，普通用户没有管理权限，即使分配过自定义角色
+func GetUserPermissions(role int, permissionRole string) []string {
+	if role >= common.RoleRootUser {
+		return append([]string{PermissionRoleManage}, Permissions...)
+	}
+	if role < common.RoleAdminUser {
+		return nil
+	}
+	if permissionRole != "" {
+		permissions, _ := getRolePermissions(permissionRole)
+		return permissions
+	}
+	return adminPermissions
+}
+
+// CanManageUser 拥有用户管理权限的操作者能否管理目标用户，超级管理员可以管理所有用户，其余操作者不能管理管理员与超级管理员
+func CanManageUser(operatorRole int, targetRole int) bool {
+	return operatorRole >= common.RoleRootUser || targetRole < common.RoleAdminUser
+}
+
+// HasAnyPermission 用户是否拥有任意一个指定的权限
+func HasAnyPermission(role int, permissionRole string, permissions ...string) bool {
+	for _, owned := range GetUserPermissions(role, permissionRole) {
+		for _, permission := range permissions {
+			if owned == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SetUserPermissionRole 为用户分配自定义角色，空字符串表示恢复为按权限等级使用内置角色
+func SetUserPermissionRole(userId int, permissionRole string) error {
+	if !RoleExists(permissionRole) {
+		return errors.New("角色不存在")
+	}
+	err := DB.Model(&User{}).Where("id = ?", userId).Update("permission_role", permissionRole).Error
+	if err != nil {
+		return err
+	}
+	return invalidateUserCache(userId)
+}
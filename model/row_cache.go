@@ -0,0 +1,59 @@
+package model
+
+import (
+	"encoding/json"
+	"one-api/common"
+	"one-api/constant"
+	"time"
+)
+
+// rowCache 在 Redis 中以 JSON 缓存数据库行，value 为 nil 表示缓存记录不存在的结果。
+// 未启用 Redis 时不缓存，直接读取数据库，避免多节点部署时各节点缓存不一致
+type rowCache[T any] struct {
+	prefix string
+}
+
+func newRowCache[T any](prefix string) *rowCache[T] {
+	return &rowCache[T]{prefix: prefix}
+}
+
+func (cache *rowCache[T]) ttl() time.Duration {
+	return time.Duration(constant.UserId2QuotaCacheSeconds) * time.Second
+}
+
+// Get 返回缓存的记录，ok 为 false 表示未命中
+func (cache *rowCache[T]) Get(key string) (value *T, ok bool) {
+	if !common.RedisEnabled {
+		return nil, false
+	}
+	data, err := common.RedisGet(cache.prefix + key)
+	if err != nil {
+		return nil, false
+	}
+	if err = json.Unmarshal([]byte(data), &value); err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+func (cache *rowCache[T]) Set(key string, value *T) {
+	if !common.RedisEnabled {
+		return
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	if err = common.RedisSet(cache.prefix+key, string(data), cache.ttl()); err != nil {
+		common.SysError("failed to set cache " + cache.prefix + key + ": " + err.Error())
+	}
+}
+
+func (cache *rowCache[T]) Delete(key string) {
+	if !common.RedisEnabled {
+		return
+	}
+	if err := common.RedisDel(cache.prefix + key); err != nil {
+		common.SysError("failed to delete cache " + cache.prefix + key + ": " + err.Error())
+	}
+}
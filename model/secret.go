@@ -0,0 +1,121 @@
+package model
+
+import (
+	"fmt"
+	"one-api/common"
+	"one-api/constant"
+)
+
+// EncryptUserSettingSecrets 加密用户设置中的 Webhook 密钥
+func EncryptUserSettingSecrets(setting map[string]interface{}) error {
+	secret, ok := setting[constant.UserSettingWebhookSecret].(string)
+	if !ok || secret == "" {
+		return nil
+	}
+	encrypted, err := common.EncryptSecret(secret)
+	if err != nil {
+		return err
+	}
+	setting[constant.UserSettingWebhookSecret] = encrypted
+	return nil
+}
+
+// GetUserWebhookSecret 返回用户设置中解密后的 Webhook 密钥
+func GetUserWebhookSecret(setting map[string]interface{}) (string, error) {
+	secret, _ := setting[constant.UserSettingWebhookSecret].(string)
+	return common.DecryptSecret(secret)
+}
+
+// MaskSecrets 将用户设置中的 Webhook 密钥替换为脱敏后的值，用于管理接口返回
+func (user *User) MaskSecrets() {
+	setting := user.GetSetting()
+	secret, ok := setting[constant.UserSettingWebhookSecret].(string)
+	if !ok || secret == "" {
+		return
+	}
+	if plaintext, err := common.DecryptSecret(secret); err == nil {
+		secret = plaintext
+	}
+	setting[constant.UserSettingWebhookSecret] = common.MaskSecret(secret)
+	user.SetSetting(setting)
+}
+
+// ReencryptSecrets 使用当前主密钥重新加密渠道密钥、用户 Webhook 密钥与两步验证密钥，
+// 返回重新加密的渠道密钥数与用户密钥数。
+// 轮换主密钥时需保留旧主密钥直到重新加密完成
+func ReencryptSecrets() (channelCount int, userCount int, err error) {
+	if !common.EncryptionEnabled() {
+		return 0, 0, fmt.Errorf("ENCRYPTION_MASTER_KEYS is not configured")
+	}
+	// 查询到普通结构体中以读取数据库中的原始值，不经过渠道的解密钩子
+	var channels []struct {
+		Id  int
+		Key string
+	}
+	if err = DB.Model(&Channel{}).Select("id", "key").Find(&channels).Error; err != nil {
+		return
+	}
+	for _, channel := range channels {
+		if !common.NeedsReencrypt(channel.Key) {
+			continue
+		}
+		var key string
+		if key, err = common.DecryptSecret(channel.Key); err != nil {
+			return channelCount, userCount, fmt.Errorf("channel %d: %w", channel.Id, err)
+		}
+		if key, err = common.EncryptSecret(key); err != nil {
+			return channelCount, userCount, fmt.Errorf("channel %d: %w", channel.Id, err)
+		}
+		if err = DB.Model(&Channel{}).Where("id = ?", channel.Id).Update("key", key).Error; err != nil {
+			return
+		}
+		channelCount++
+	}
+
+	var users []*User
+	err = DB.Select("id", "setting").Where("setting LIKE ?", "%"+constant.UserSettingWebhookSecret+"%").Find(&users).Error
+	if err != nil {
+		return
+	}
+	for _, user := range users {
+		setting := user.GetSetting()
+		secret, ok := setting[constant.UserSettingWebhookSecret].(string)
+		if !ok || !common.NeedsReencrypt(secret) {
+			continue
+		}
+		if secret, err = common.DecryptSecret(secret); err != nil {
+			return channelCount, userCount, fmt.Errorf("user %d: %w", user.Id, err)
+		}
+		setting[constant.UserSettingWebhookSecret] = secret
+		if err = EncryptUserSettingSecrets(setting); err != nil {
+			return channelCount, userCount, fmt.Errorf("user %d: %w", user.Id, err)
+		}
+		user.SetSetting(setting)
+		if err = DB.Model(&User{}).Where("id = ?", user.Id).Update("setting", user.Setting).Error; err != nil {
+			return
+		}
+		userCount++
+	}
+
+	var twoFAs []*TwoFA
+	if err = DB.Select("id", "user_id", "secret").Find(&twoFAs).Error; err != nil {
+		return
+	}
+	for _, twoFA := range twoFAs {
+		if !common.NeedsReencrypt(twoFA.Secret) {
+			continue
+		}
+		var secret string
+		if secret, err = common.DecryptSecret(twoFA.Secret); err != nil {
+			return channelCount, userCount, fmt.Errorf("two-factor secret of user %d: %w", twoFA.UserId, err)
+		}
+		if secret, err = common.EncryptSecret(secret); err != nil {
+			return channelCount, userCount, fmt.Errorf("two-factor secret of user %d: %w", twoFA.UserId, err)
+		}
+		if err = DB.Model(&TwoFA{}).Where("id = ?", twoFA.Id).Update("secret", secret).Error; err != nil {
+			return
+		}
+		userCount++
+	}
+	return
+}
@@ -0,0 +1,465 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"one-api/common"
+	"one-api/setting/operation_setting"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	StatementCreditTopup        = "topup"
+	StatementCreditRedemption   = "redemption"
+	StatementCreditRefund       = "refund"
+	StatementCreditSubscription = "subscription"
+	StatementCreditTopupRefund  = "topup_refund"
+)
+
+// Statement 用户的月度账单快照，生成后不再随日志删除而变化
+type Statement struct {
+	Id               int     `json:"id"`
+	UserId           int     `json:"user_id" gorm:"uniqueIndex:idx_statement_user_period"`
+	Username         string  `json:"username" gorm:"default:''"`
+	Period           string  `json:"period" gorm:"type:varchar(7);uniqueIndex:idx_statement_user_period;index"`
+	StartTime        int64   `json:"start_time" gorm:"bigint"`
+	EndTime          int64   `json:"end_time" gorm:"bigint"`
+	RequestCount     int     `json:"request_count" gorm:"default:0"`
+	PromptTokens     int     `json:"prompt_tokens" gorm:"default:0"`
+	CompletionTokens int     `json:"completion_tokens" gorm:"default:0"`
+	UsedQuota        int     `json:"used_quota" gorm:"default:0"`
+	TopupQuota       int     `json:"topup_quota" gorm:"default:0"`
+	TopupMoney       float64 `json:"topup_money" gorm:"default:0"`
+	RedemptionQuota  int     `json:"redemption_quota" gorm:"default:0"`
+	RefundQuota      int     `json:"refund_quota" gorm:"default:0"`
+	Detail           string  `json:"-" gorm:"type:text"`
+	CreatedTime      int64   `json:"created_time" gorm:"bigint"`
+}
+
+// StatementUsageItem 按日期、令牌与模型聚合的消耗
+type StatementUsageItem struct {
+	Date             string `json:"date,omitempty"`
+	TokenId          int    `json:"token_id,omitempty"`
+	TokenName        string `json:"token_name,omitempty"`
+	ModelName        string `json:"model_name,omitempty"`
+	Requests         int    `json:"requests"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	Quota            int    `json:"quota"`
+}
+
+// StatementCreditItem 账单周期内的充值、兑换、退款与套餐购买记录
+type StatementCreditItem struct {
+	Type      string  `json:"type"`
+	Time      int64   `json:"time"`
+	Quota     int     `json:"quota"`
+	Money     float64 `json:"money"`
+	Reference string  `json:"reference"`
+}
+
+type StatementDetail struct {
+	Usage   []StatementUsageItem  `json:"usage"`
+	Credits []StatementCreditItem `json:"credits"`
+}
+
+func getStatementLocation() *time.Location {
+	timezone := operation_setting.GetBillingStatementSetting().Timezone
+	if timezone == "" {
+		return time.Local
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		common.SysError("invalid billing statement timezone: " + timezone)
+		return time.Local
+	}
+	return location
+}
+
+// GetStatementPeriodRange 解析 2006-01 格式的账单月份，返回其开始与结束时间
+func GetStatementPeriodRange(period string) (time.Time, time.Time, error) {
+	start, err := time.ParseInLocation("2006-01", period, getStatementLocation())
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.New("账单月份格式错误，应为 2006-01")
+	}
+	return start, start.AddDate(0, 1, 0), nil
+}
+
+// GetLastClosedStatementPeriod 返回给定时间之前最近一个已结束的账单月份
+func GetLastClosedStatementPeriod(now time.Time) string {
+	now = now.In(getStatementLocation())
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).AddDate(0, -1, 0).Format("2006-01")
+}
+
+func (statement *Statement) GetDetail() (*StatementDetail, error) {
+	detail := &StatementDetail{}
+	if statement.Detail == "" {
+		return detail, nil
+	}
+	err := json.Unmarshal([]byte(statement.Detail), detail)
+	return detail, err
+}
+
+// FilterToken 返回只包含指定令牌消耗的明细，令牌账单不包含充值等用户级记录
+func (detail *StatementDetail) FilterToken(tokenId int) *StatementDetail {
+	filtered := &StatementDetail{Usage: make([]StatementUsageItem, 0), Credits: make([]StatementCreditItem, 0)}
+	for _, item := range detail.Usage {
+		if item.TokenId == tokenId {
+			filtered.Usage = append(filtered.Usage, item)
+		}
+	}
+	return filtered
+}
+
+// Summarize 按给定维度汇总消耗明细，dimension 为 model、token 或 day
+func (detail *StatementDetail) Summarize(dimension string) []StatementUsageItem {
+	summary := make(map[string]*StatementUsageItem)
+	keys := make([]string, 0)
+	for _, item := range detail.Usage {
+		var key string
+		row := StatementUsageItem{}
+		switch dimension {
+		case "model":
+			key = item.ModelName
+			row.ModelName = item.ModelName
+		case "token":
+			key = fmt.Sprintf("%d", item.TokenId)
+			row.TokenId = item.TokenId
+			row.TokenName = item.TokenName
+		default:
+			key = item.Date
+			row.Date = item.Date
+		}
+		existing, ok := summary[key]
+		if !ok {
+			existing = &row
+			summary[key] = existing
+			keys = append(keys, key)
+		}
+		existing.Requests += item.Requests
+		existing.PromptTokens += item.PromptTokens
+		existing.CompletionTokens += item.CompletionTokens
+		existing.Quota += item.Quota
+	}
+	sort.Strings(keys)
+	result := make([]StatementUsageItem, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, *summary[key])
+	}
+	return result
+}
+
+type statementUsageKey struct {
+	date      string
+	tokenId   int
+	modelName string
+}
+
+func collectStatementUsage(userId int, start time.Time, end time.Time) ([]StatementUsageItem, error) {
+	rows, err := LOG_DB.Model(&Log{}).
+		Select("created_at, token_id, token_name, model_name, quota, prompt_tokens, completion_tokens").
+		Where("user_id = ? and type = ? and created_at >= ? and created_at < ?", userId, LogTypeConsume, start.Unix(), end.Unix()).
+		Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := make(map[statementUsageKey]*StatementUsageItem)
+	location := start.Location()
+	for rows.Next() {
+		var log Log
+		if err = LOG_DB.ScanRows(rows, &log); err != nil {
+			return nil, err
+		}
+		key := statementUsageKey{
+			date:      time.Unix(log.CreatedAt, 0).In(location).Format("2006-01-02"),
+			tokenId:   log.TokenId,
+			modelName: log.ModelName,
+		}
+		item, ok := items[key]
+		if !ok {
+			item = &StatementUsageItem{
+				Date:      key.date,
+				TokenId:   log.TokenId,
+				TokenName: log.TokenName,
+				ModelName: log.ModelName,
+			}
+			items[key] = item
+		}
+		item.Requests++
+		item.PromptTokens += log.PromptTokens
+		item.CompletionTokens += log.CompletionTokens
+		item.Quota += log.Quota
+	}
+	usage := make([]StatementUsageItem, 0, len(items))
+	for _, item := range items {
+		usage = append(usage, *item)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Date != usage[j].Date {
+			return usage[i].Date < usage[j].Date
+		}
+		if usage[i].TokenId != usage[j].TokenId {
+			return usage[i].TokenId < usage[j].TokenId
+		}
+		return usage[i].ModelName < usage[j].ModelName
+	})
+	return usage, nil
+}
+
+func collectStatementCredits(userId int, start time.Time, end time.Time) ([]StatementCreditItem, error) {
+	credits := make([]StatementCreditItem, 0)
+	var topUps []*TopUp
+	err := DB.Where("user_id = ? and status in ? and create_time >= ? and create_time < ?", userId,
+		[]string{TopUpStatusSuccess, TopUpStatusPartialRefunded, TopUpStatusRefunded}, start.Unix(), end.Unix()).
+		Order("create_time asc").Find(&topUps).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, topUp := range topUps {
+		credit := StatementCreditItem{
+			Type:      StatementCreditTopup,
+			Time:      topUp.CreateTime,
+			Quota:     int(float64(topUp.Amount) * common.QuotaPerUnit),
+			Money:     topUp.Money,
+			Reference: topUp.TradeNo,
+		}
+		if topUp.PlanId > 0 {
+			credit.Type = StatementCreditSubscription
+			credit.Quota = 0
+		}
+		credits = append(credits, credit)
+	}
+	// 可多次使用的兑换码每次兑换一条使用记录，只有额度奖励计入余额
+	var usages []*RedemptionUsage
+	err = DB.Where("user_id = ? and reward_type = ? and created_time >= ? and created_time < ?",
+		userId, RedemptionRewardQuota, start.Unix(), end.Unix()).
+		Order("created_time asc").Find(&usages).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, usage := range usages {
+		credits = append(credits, StatementCreditItem{
+			Type:      StatementCreditRedemption,
+			Time:      usage.CreatedTime,
+			Quota:     usage.Quota,
+			Reference: usage.Name,
+		})
+	}
+	// 失败的异步任务会退还预扣的额度
+	var tasks []*Task
+	err = DB.Where("user_id = ? and status = ? and quota > 0 and updated_at >= ? and updated_at < ?", userId, TaskStatusFailure, start.Unix(), end.Unix()).
+		Find(&tasks).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, task := range tasks {
+		credits = append(credits, StatementCreditItem{
+			Type:      StatementCreditRefund,
+			Time:      task.UpdatedAt,
+			Quota:     task.Quota,
+			Reference: task.TaskID,
+		})
+	}
+	var midjourneys []*Midjourney
+	err = DB.Where("user_id = ? and status = ? and quota > 0 and finish_time >= ? and finish_time < ?", userId, "FAILURE", start.UnixMilli(), end.UnixMilli()).
+		Find(&midjourneys).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, midjourney := range midjourneys {
+		credits = append(credits, StatementCreditItem{
+			Type:      StatementCreditRefund,
+			Time:      midjourney.FinishTime / 1000,
+			Quota:     midjourney.Quota,
+			Reference: midjourney.MjId,
+		})
+	}
+	// 充值退款记为负数，消耗退还记为退款
+	var refunds []*Refund
+	err = DB.Where("user_id = ? and created_time >= ? and created_time < ?", userId, start.Unix(), end.Unix()).
+		Find(&refunds).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, refund := range refunds {
+		if refund.Type == RefundTypeTopUp {
+			credits = append(credits, StatementCreditItem{
+				Type:      StatementCreditTopupRefund,
+				Time:      refund.CreatedTime,
+				Quota:     -refund.Quota,
+				Money:     -refund.Money,
+				Reference: refund.TradeNo,
+			})
+			continue
+		}
+		credits = append(credits, StatementCreditItem{
+			Type:      StatementCreditRefund,
+			Time:      refund.CreatedTime,
+			Quota:     refund.Quota,
+			Reference: fmt.Sprintf("log:%d", refund.LogId),
+		})
+	}
+	sort.SliceStable(credits, func(i, j int) bool {
+		return credits[i].Time < credits[j].Time
+	})
+	return credits, nil
+}
+
+// GenerateStatement 生成用户在指定月份的账单快照，已存在时直接返回，force 为 true 时重新生成
+func GenerateStatement(userId int, period string, force bool) (*Statement, error) {
+	start, end, err := GetStatementPeriodRange(period)
+	if err != nil {
+		return nil, err
+	}
+	if end.After(time.Now()) {
+		return nil, errors.New("只能为已结束的月份生成账单")
+	}
+	existing := &Statement{}
+	err = DB.Where("user_id = ? and period = ?", userId, period).First(existing).Error
+	if err == nil && !force {
+		return existing, nil
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	usage, err := collectStatementUsage(userId, start, end)
+	if err != nil {
+		return nil, err
+	}
+	credits, err := collectStatementCredits(userId, start, end)
+	if err != nil {
+		return nil, err
+	}
+	detail, err := json.Marshal(StatementDetail{Usage: usage, Credits: credits})
+	if err != nil {
+		return nil, err
+	}
+	username, _ := GetUsernameById(userId, false)
+	statement := &Statement{
+		Id:          existing.Id,
+		UserId:      userId,
+		Username:    username,
+		Period:      period,
+		StartTime:   start.Unix(),
+		EndTime:     end.Unix(),
+		Detail:      string(detail),
+		CreatedTime: common.GetTimestamp(),
+	}
+	for _, item := range usage {
+		statement.RequestCount += item.Requests
+		statement.PromptTokens += item.PromptTokens
+		statement.CompletionTokens += item.CompletionTokens
+		statement.UsedQuota += item.Quota
+	}
+	for _, credit := range credits {
+		switch credit.Type {
+		case StatementCreditTopup, StatementCreditTopupRefund:
+			statement.TopupQuota += credit.Quota
+			statement.TopupMoney += credit.Money
+		case StatementCreditSubscription:
+			statement.TopupMoney += credit.Money
+		case StatementCreditRedemption:
+			statement.RedemptionQuota += credit.Quota
+		case StatementCreditRefund:
+			statement.RefundQuota += credit.Quota
+		}
+	}
+	err = DB.Save(statement).Error
+	return statement, err
+}
+
+// GetStatementUserIds 返回在时间范围内有消耗、充值或兑换记录的用户
+func GetStatementUserIds(start time.Time, end time.Time) ([]int, error) {
+	seen := make(map[int]bool)
+	var logUserIds []int
+	err := LOG_DB.Model(&Log{}).Where("type = ? and created_at >= ? and created_at < ?", LogTypeConsume, start.Unix(), end.Unix()).
+		Distinct("user_id").Pluck("user_id", &logUserIds).Error
+	if err != nil {
+		return nil, err
+	}
+	var topUpUserIds []int
+	err = DB.Model(&TopUp{}).Where("status in ? and create_time >= ? and create_time < ?",
+		[]string{TopUpStatusSuccess, TopUpStatusPartialRefunded, TopUpStatusRefunded}, start.Unix(), end.Unix()).
+		Distinct("user_id").Pluck("user_id", &topUpUserIds).Error
+	if err != nil {
+		return nil, err
+	}
+	var refundUserIds []int
+	err = DB.Model(&Refund{}).Where("created_time >= ? and created_time < ?", start.Unix(), end.Unix()).
+		Distinct("user_id").Pluck("user_id", &refundUserIds).Error
+	if err != nil {
+		return nil, err
+	}
+	var redemptionUserIds []int
+	err = DB.Model(&RedemptionUsage{}).Where("reward_type = ? and created_time >= ? and created_time < ?",
+		RedemptionRewardQuota, start.Unix(), end.Unix()).
+		Distinct("user_id").Pluck("user_id", &redemptionUserIds).Error
+	if err != nil {
+		return nil, err
+	}
+	userIds := make([]int, 0)
+	for _, ids := range [][]int{logUserIds, topUpUserIds, redemptionUserIds, refundUserIds} {
+		for _, id := range ids {
+			if id != 0 && !seen[id] {
+				seen[id] = true
+				userIds = append(userIds, id)
+			}
+		}
+	}
+	return userIds, nil
+}
+
+// GenerateStatementsForPeriod 为月份内有记录的所有用户生成账单，已生成的账单保持不变
+func GenerateStatementsForPeriod(period string) (int, error) {
+	start, end, err := GetStatementPeriodRange(period)
+	if err != nil {
+		return 0, err
+	}
+	userIds, err := GetStatementUserIds(start, end)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, userId := range userIds {
+		if _, err = GenerateStatement(userId, period, false); err != nil {
+			common.SysError(fmt.Sprintf("failed to generate statement for user %d period %s: %s", userId, period, err.Error()))
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+func GetStatementById(id int) (*Statement, error) {
+	statement := &Statement{}
+	err := DB.First(statement, "id = ?", id).Error
+	return statement, err
+}
+
+func GetUserStatements(userId int, startIdx int, num int) (statements []*Statement, total int64, err error) {
+	tx := DB.Model(&Statement{}).Where("user_id = ?", userId)
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("period desc").Limit(num).Offset(startIdx).Find(&statements).Error
+	return statements, total, err
+}
+
+func GetAllStatements(userId int, period string, startIdx int, num int) (statements []*Statement, total int64, err error) {
+	tx := DB.Model(&Statement{})
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if period != "" {
+		tx = tx.Where("period = ?", period)
+	}
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("period desc, id desc").Limit(num).Offset(startIdx).Find(&statements).Error
+	return statements, total, err
+}
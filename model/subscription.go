@@ -0,0 +1,584 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"one-api/common"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	PlanStatusEnabled  = 1
+	PlanStatusDisabled = 2
+)
+
+const (
+	SubscriptionStatusActive    = 1
+	SubscriptionStatusExpired   = 2
+	SubscriptionStatusCancelled = 3
+)
+
+const (
+	// PlanOverageFallback 套餐额度用尽后继续从用户余额扣费
+	PlanOverageFallback = "fallback"
+	// PlanOverageBlock 套餐额度用尽后拒绝请求
+	PlanOverageBlock = "block"
+)
+
+// Plan 订阅套餐
+type Plan struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"index"`
+	Description string `json:"description"`
+	// Price 每个订阅周期的价格，与在线充值使用相同的货币
+	Price float64 `json:"price"`
+	// DurationDays 每次购买或续费的订阅天数
+	DurationDays int `json:"duration_days" gorm:"default:30"`
+	// ResetDays 套餐额度的重置周期天数，0 表示与订阅天数相同
+	ResetDays int `json:"reset_days" gorm:"default:0"`
+	// Quota 每个重置周期包含的通用额度
+	Quota int `json:"quota" gorm:"default:0"`
+	// ModelQuotas 按模型系列划分的额度，JSON 格式，如 {"gpt-4o*": 500000}，匹配的模型只使用对应系列的额度
+	ModelQuotas     string         `json:"model_quotas" gorm:"type:text"`
+	OverageBehavior string         `json:"overage_behavior" gorm:"type:varchar(16);default:'fallback'"`
+	Group           string         `json:"group" gorm:"type:varchar(64);column:target_group"`
+	Status          int            `json:"status" gorm:"default:1"`
+	CreatedTime     int64          `json:"created_time" gorm:"bigint"`
+	DeletedAt       gorm.DeletedAt `json:"-" gorm:"index"`
+}
+
+// UserSubscription 用户订阅，记录当前周期剩余的套餐额度
+type UserSubscription struct {
+	Id        int  `json:"id"`
+	UserId    int  `json:"user_id" gorm:"index"`
+	PlanId    int  `json:"plan_id" gorm:"index"`
+	Status    int  `json:"status" gorm:"default:1;index"`
+	AutoRenew bool `json:"auto_renew"`
+	// StartTime 订阅开始时间，ExpireTime 当前已支付周期的结束时间
+	StartTime  int64 `json:"start_time" gorm:"bigint"`
+	ExpireTime int64 `json:"expire_time" gorm:"bigint;index"`
+	// NextResetTime 下一次重置套餐额度的时间
+	NextResetTime int64 `json:"next_reset_time" gorm:"bigint;index"`
+	RemainQuota   int   `json:"remain_quota" gorm:"default:0"`
+	UsedQuota     int   `json:"used_quota" gorm:"default:0"`
+	// ModelRemainQuotas 按模型系列的剩余额度，JSON 格式
+	ModelRemainQuotas string `json:"model_remain_quotas" gorm:"type:text"`
+	// PreviousGroup 订阅前的用户分组，订阅结束后恢复
+	PreviousGroup string `json:"previous_group" gorm:"type:varchar(64)"`
+	CreatedTime   int64  `json:"created_time" gorm:"bigint"`
+	Plan          *Plan  `json:"plan,omitempty" gorm:"-:all"`
+}
+
+func (plan *Plan) GetModelQuotas() map[string]int {
+	quotas := make(map[string]int)
+	if plan.ModelQuotas == "" {
+		return quotas
+	}
+	if err := json.Unmarshal([]byte(plan.ModelQuotas), &quotas); err != nil {
+		common.SysError("failed to unmarshal plan model quotas: " + err.Error())
+	}
+	return quotas
+}
+
+func (plan *Plan) resetSeconds() int64 {
+	days := plan.ResetDays
+	if days <= 0 {
+		days = plan.DurationDays
+	}
+	if days <= 0 {
+		days = 30
+	}
+	return int64(days) * 24 * 3600
+}
+
+func (plan *Plan) durationSeconds() int64 {
+	days := plan.DurationDays
+	if days <= 0 {
+		days = 30
+	}
+	return int64(days) * 24 * 3600
+}
+
+func (plan *Plan) ValidateAndFill() error {
+	if plan.Name == "" {
+		return errors.New("套餐名称不能为空")
+	}
+	if plan.Price < 0 || plan.Quota < 0 || plan.DurationDays < 0 || plan.ResetDays < 0 {
+		return errors.New("套餐价格、额度及周期不能为负数")
+	}
+	if plan.DurationDays == 0 {
+		plan.DurationDays = 30
+	}
+	switch plan.OverageBehavior {
+	case "":
+		plan.OverageBehavior = PlanOverageFallback
+	case PlanOverageFallback, PlanOverageBlock:
+	default:
+		return fmt.Errorf("无效的超额行为：%s", plan.OverageBehavior)
+	}
+	if plan.ModelQuotas != "" {
+		quotas := make(map[string]int)
+		if err := json.Unmarshal([]byte(plan.ModelQuotas), &quotas); err != nil {
+			return errors.New("模型额度格式错误：" + err.Error())
+		}
+	}
+	return nil
+}
+
+func GetAllPlans(enabledOnly bool) (plans []*Plan, err error) {
+	query := DB.Order("id asc")
+	if enabledOnly {
+		query = query.Where("status = ?", PlanStatusEnabled)
+	}
+	err = query.Find(&plans).Error
+	return plans, err
+}
+
+func GetPlanById(id int) (*Plan, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	plan := Plan{Id: id}
+	err := DB.First(&plan, "id = ?", id).Error
+	return &plan, err
+}
+
+// getPlanUnscoped 获取套餐，包括已删除的套餐，供已有订阅使用
+func getPlanUnscoped(id int) (*Plan, error) {
+	var plan Plan
+	err := DB.Unscoped().First(&plan, "id = ?", id).Error
+	return &plan, err
+}
+
+func (plan *Plan) Insert() error {
+	plan.CreatedTime = common.GetTimestamp()
+	return DB.Create(plan).Error
+}
+
+func (plan *Plan) Update() error {
+	return DB.Model(plan).Select("name", "description", "price", "duration_days", "reset_days", "quota",
+		"model_quotas", "overage_behavior", "target_group", "status").Updates(plan).Error
+}
+
+func DeletePlanById(id int) error {
+	if id == 0 {
+		return errors.New("id 为空！")
+	}
+	return DB.Delete(&Plan{}, "id = ?", id).Error
+}
+
+// matchPlanModelFamily 返回模型匹配的模型系列，支持以 * 结尾的前缀匹配
+func matchPlanModelFamily(families map[string]int, modelName string) (string, bool) {
+	if _, ok := families[modelName]; ok {
+		return modelName, true
+	}
+	matched := ""
+	for family := range families {
+		prefix, ok := strings.CutSuffix(family, "*")
+		if ok && strings.HasPrefix(modelName, prefix) && len(family) > len(matched) {
+			matched = family
+		}
+	}
+	return matched, matched != ""
+}
+
+func (subscription *UserSubscription) getModelRemainQuotas() map[string]int {
+	quotas := make(map[string]int)
+	if subscription.ModelRemainQuotas != "" {
+		_ = json.Unmarshal([]byte(subscription.ModelRemainQuotas), &quotas)
+	}
+	return quotas
+}
+
+func (subscription *UserSubscription) setModelRemainQuotas(quotas map[string]int) {
+	data, _ := json.Marshal(quotas)
+	subscription.ModelRemainQuotas = string(data)
+}
+
+// resetAllowance 将套餐额度恢复为一个完整周期
+func (subscription *UserSubscription) resetAllowance(plan *Plan, now int64) {
+	subscription.RemainQuota = plan.Quota
+	subscription.setModelRemainQuotas(plan.GetModelQuotas())
+	subscription.NextResetTime = now + plan.resetSeconds()
+	if subscription.NextResetTime > subscription.ExpireTime {
+		subscription.NextResetTime = subscription.ExpireTime
+	}
+}
+
+// RemainQuotaForModel 返回模型可用的套餐额度
+func (subscription *UserSubscription) RemainQuotaForModel(modelName string) int {
+	quotas := subscription.getModelRemainQuotas()
+	if family, ok := matchPlanModelFamily(quotas, modelName); ok {
+		return quotas[family]
+	}
+	return subscription.RemainQuota
+}
+
+// GetActiveSubscription 获取用户当前生效的订阅，没有时返回 nil
+func GetActiveSubscription(userId int) (*UserSubscription, error) {
+	var subscription UserSubscription
+	err := DB.Where("user_id = ? and status = ? and expire_time > ?", userId, SubscriptionStatusActive, common.GetTimestamp()).
+		Order("id desc").First(&subscription).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	plan, err := getPlanUnscoped(subscription.PlanId)
+	if err == nil {
+		subscription.Plan = plan
+	}
+	return &subscription, nil
+}
+
+// CacheGetActiveSubscription 获取用户当前生效的订阅，优先读取缓存，供转发请求使用
+func CacheGetActiveSubscription(userId int) (*UserSubscription, error) {
+	if subscription, ok := cacheGetActiveSubscription(userId); ok {
+		return subscription, nil
+	}
+	subscription, err := GetActiveSubscription(userId)
+	if err != nil {
+		return nil, err
+	}
+	cacheSetActiveSubscription(userId, subscription)
+	return subscription, nil
+}
+
+func GetUserSubscriptions(userId int, startIdx int, num int) (subscriptions []*UserSubscription, total int64, err error) {
+	query := DB.Model(&UserSubscription{}).Where("user_id = ?", userId)
+	if err = query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = query.Order("id desc").Limit(num).Offset(startIdx).Find(&subscriptions).Error
+	return subscriptions, total, err
+}
+
+// ActivateSubscription 为用户开通或续费套餐：同一套餐延长有效期，不同套餐则替换当前订阅
+func ActivateSubscription(userId int, plan *Plan, autoRenew bool) (*UserSubscription, error) {
+	var subscription *UserSubscription
+	var targetGroup string
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		subscription, targetGroup, err = activateSubscription(tx, userId, plan, autoRenew)
+		return err
+	})
+	if err != nil {
+		invalidateSubscriptionCache(userId)
+		return nil, err
+	}
+	afterActivateSubscription(userId, plan, subscription, targetGroup)
+	return subscription, nil
+}
+
+// activateSubscription 在事务内开通或续期套餐，targetGroup 非空表示用户分组发生了变更
+func activateSubscription(tx *gorm.DB, userId int, plan *Plan, autoRenew bool) (subscription *UserSubscription, targetGroup string, err error) {
+	now := common.GetTimestamp()
+	var current UserSubscription
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? and status = ? and expire_time > ?", userId, SubscriptionStatusActive, now).
+		Order("id desc").First(&current).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, "", err
+	}
+	hasCurrent := err == nil
+	if hasCurrent && current.PlanId == plan.Id {
+		current.ExpireTime += plan.durationSeconds()
+		current.AutoRenew = autoRenew
+		return &current, "", tx.Save(&current).Error
+	}
+	var user User
+	if err = tx.First(&user, "id = ?", userId).Error; err != nil {
+		return nil, "", err
+	}
+	previousGroup := user.Group
+	if hasCurrent {
+		previousGroup = current.PreviousGroup
+		current.Status = SubscriptionStatusCancelled
+		if err = tx.Save(&current).Error; err != nil {
+			return nil, "", err
+		}
+	}
+	subscription = &UserSubscription{
+		UserId:        userId,
+		PlanId:        plan.Id,
+		Status:        SubscriptionStatusActive,
+		AutoRenew:     autoRenew,
+		StartTime:     now,
+		ExpireTime:    now + plan.durationSeconds(),
+		PreviousGroup: previousGroup,
+		CreatedTime:   now,
+	}
+	subscription.resetAllowance(plan, now)
+	if err = tx.Create(subscription).Error; err != nil {
+		return nil, "", err
+	}
+	targetGroup = previousGroup
+	if plan.Group != "" {
+		targetGroup = plan.Group
+	}
+	if targetGroup == user.Group {
+		return subscription, "", nil
+	}
+	return subscription, targetGroup, tx.Model(&User{}).Where("id = ?", userId).Update("group", targetGroup).Error
+}
+
+// afterActivateSubscription 开通套餐的事务提交后刷新缓存并记录日志
+func afterActivateSubscription(userId int, plan *Plan, subscription *UserSubscription, targetGroup string) {
+	invalidateSubscriptionCache(userId)
+	if targetGroup != "" {
+		_ = updateUserGroupCache(userId, targetGroup)
+	}
+	subscription.Plan = plan
+	RecordLog(userId, LogTypeTopup, fmt.Sprintf("开通订阅套餐 %s，有效期至 %s", plan.Name,
+		time.Unix(subscription.ExpireTime, 0).Format("2006-01-02 15:04:05")))
+}
+
+// PurchaseSubscriptionWithQuota 使用用户余额购买套餐，扣除余额、记录流水与开通套餐在同一事务内完成
+func PurchaseSubscriptionWithQuota(userId int, plan *Plan, priceQuota int, autoRenew bool) (*UserSubscription, error) {
+	var subscription *UserSubscription
+	var targetGroup string
+	err := DB.Transaction(func(tx *gorm.DB) (err error) {
+		if priceQuota > 0 {
+			result := tx.Model(&User{}).Where("id = ? and quota >= ?", userId, priceQuota).
+				Update("quota", gorm.Expr("quota - ?", priceQuota))
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return errors.New("余额不足")
+			}
+		}
+		subscription, targetGroup, err = activateSubscription(tx, userId, plan, autoRenew)
+		if err != nil {
+			return err
+		}
+		return recordQuotaLedger(tx, userId, -priceQuota, LedgerEntry{
+			Type:           LedgerTypeSubscription,
+			CounterAccount: LedgerAccountSubscription,
+			RefType:        "subscription",
+			RefId:          strconv.Itoa(subscription.Id),
+			ActorId:        userId,
+		})
+	})
+	if err != nil {
+		invalidateSubscriptionCache(userId)
+		return nil, err
+	}
+	if priceQuota > 0 {
+		_ = invalidateUserCache(userId)
+	}
+	afterActivateSubscription(userId, plan, subscription, targetGroup)
+	if priceQuota > 0 {
+		RecordLog(userId, LogTypeConsume, fmt.Sprintf("使用余额购买订阅套餐 %s，扣除 %s", plan.Name, common.LogQuota(priceQuota)))
+	}
+	return subscription, nil
+}
+
+// CancelSubscription 关闭自动续费，订阅在当前周期结束后失效
+func CancelSubscription(userId int) error {
+	result := DB.Model(&UserSubscription{}).
+		Where("user_id = ? and status = ?", userId, SubscriptionStatusActive).
+		Update("auto_renew", false)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("没有生效中的订阅")
+	}
+	invalidateSubscriptionCache(userId)
+	return nil
+}
+
+var errSubscriptionQuotaConflict = errors.New("subscription quota changed concurrently")
+
+// ConsumeSubscriptionQuota 从用户订阅的套餐额度中扣除，返回套餐实际承担的额度；
+// 扣除使用带剩余额度条件的相对更新，并发请求不会重复使用同一部分额度
+func ConsumeSubscriptionQuota(userId int, modelName string, quota int) (int, error) {
+	if quota <= 0 {
+		return 0, nil
+	}
+	var covered int
+	var err error
+	for i := 0; i < 3; i++ {
+		covered, err = consumeSubscriptionQuota(userId, modelName, quota)
+		if !errors.Is(err, errSubscriptionQuotaConflict) {
+			break
+		}
+	}
+	return covered, err
+}
+
+func consumeSubscriptionQuota(userId int, modelName string, quota int) (int, error) {
+	covered := 0
+	var subscription UserSubscription
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? and status = ? and expire_time > ?", userId, SubscriptionStatusActive, common.GetTimestamp()).
+			Order("id desc").First(&subscription).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+		quotas := subscription.getModelRemainQuotas()
+		var result *gorm.DB
+		if family, ok := matchPlanModelFamily(quotas, modelName); ok {
+			covered = min(quota, quotas[family])
+			if covered <= 0 {
+				covered = 0
+				return nil
+			}
+			// 模型系列额度以 JSON 保存，以读取时的内容作为条件，内容被并发修改时重试
+			previous := subscription.ModelRemainQuotas
+			quotas[family] -= covered
+			subscription.setModelRemainQuotas(quotas)
+			result = tx.Model(&UserSubscription{}).
+				Where("id = ? and model_remain_quotas = ?", subscription.Id, previous).
+				Updates(map[string]interface{}{
+					"model_remain_quotas": subscription.ModelRemainQuotas,
+					"used_quota":          gorm.Expr("used_quota + ?", covered),
+				})
+		} else {
+			covered = min(quota, subscription.RemainQuota)
+			if covered <= 0 {
+				covered = 0
+				return nil
+			}
+			subscription.RemainQuota -= covered
+			result = tx.Model(&UserSubscription{}).
+				Where("id = ? and remain_quota >= ?", subscription.Id, covered).
+				Updates(map[string]interface{}{
+					"remain_quota": gorm.Expr("remain_quota - ?", covered),
+					"used_quota":   gorm.Expr("used_quota + ?", covered),
+				})
+		}
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errSubscriptionQuotaConflict
+		}
+		subscription.UsedQuota += covered
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if covered > 0 {
+		invalidateSubscriptionCache(subscription.UserId)
+	}
+	return covered, nil
+}
+
+// expireSubscription 订阅到期，恢复用户原分组
+func expireSubscription(subscription *UserSubscription) error {
+	subscription.Status = SubscriptionStatusExpired
+	if err := DB.Model(subscription).Update("status", SubscriptionStatusExpired).Error; err != nil {
+		return err
+	}
+	if subscription.PreviousGroup != "" {
+		if err := DB.Model(&User{}).Where("id = ?", subscription.UserId).Update("group", subscription.PreviousGroup).Error; err != nil {
+			return err
+		}
+		_ = updateUserGroupCache(subscription.UserId, subscription.PreviousGroup)
+	}
+	invalidateSubscriptionCache(subscription.UserId)
+	RecordLog(subscription.UserId, LogTypeSystem, fmt.Sprintf("订阅 #%d 已到期", subscription.Id))
+	return nil
+}
+
+// RevokeSubscription 套餐订单全额退款后立即终止用户生效中的该套餐订阅
+func RevokeSubscription(userId int, planId int) error {
+	var subscription UserSubscription
+	err := DB.Where("user_id = ? and plan_id = ? and status = ?", userId, planId, SubscriptionStatusActive).
+		Order("id desc").First(&subscription).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	return expireSubscription(&subscription)
+}
+
+// renewSubscription 使用用户余额续费一个周期，余额不足时返回错误
+func renewSubscription(subscription *UserSubscription, plan *Plan, priceQuota int) error {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&User{}).Where("id = ? and quota >= ?", subscription.UserId, priceQuota).
+			Update("quota", gorm.Expr("quota - ?", priceQuota))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("余额不足")
+		}
+		err := recordQuotaLedger(tx, subscription.UserId, -priceQuota, LedgerEntry{
+			Type:           LedgerTypeSubscription,
+			CounterAccount: LedgerAccountSubscription,
+			RefType:        "subscription",
+			RefId:          strconv.Itoa(subscription.Id),
+		})
+		if err != nil {
+			return err
+		}
+		now := common.GetTimestamp()
+		subscription.ExpireTime = max(subscription.ExpireTime, now) + plan.durationSeconds()
+		subscription.resetAllowance(plan, now)
+		return tx.Model(subscription).Select("expire_time", "remain_quota", "model_remain_quotas", "next_reset_time").
+			Updates(subscription).Error
+	})
+	if err != nil {
+		return err
+	}
+	invalidateSubscriptionCache(subscription.UserId)
+	_ = invalidateUserCache(subscription.UserId)
+	RecordLog(subscription.UserId, LogTypeConsume, fmt.Sprintf("订阅套餐 %s 自动续费，扣除 %s", plan.Name, common.LogQuota(priceQuota)))
+	return nil
+}
+
+// UpdateSubscriptions 重置到期周期的套餐额度，处理订阅的自动续费与过期；priceToQuota 将套餐价格换算为余额额度
+func UpdateSubscriptions(priceToQuota func(price float64) int) {
+	now := common.GetTimestamp()
+	var subscriptions []*UserSubscription
+	err := DB.Where("status = ? and (next_reset_time <= ? or expire_time <= ?)", SubscriptionStatusActive, now, now).
+		Find(&subscriptions).Error
+	if err != nil {
+		common.SysError("failed to query subscriptions: " + err.Error())
+		return
+	}
+	for _, subscription := range subscriptions {
+		plan, err := getPlanUnscoped(subscription.PlanId)
+		if err != nil {
+			common.SysError(fmt.Sprintf("subscription %d plan %d not found: %s", subscription.Id, subscription.PlanId, err.Error()))
+			continue
+		}
+		if subscription.ExpireTime <= now {
+			if subscription.AutoRenew && plan.Status == PlanStatusEnabled && !plan.DeletedAt.Valid {
+				err = renewSubscription(subscription, plan, priceToQuota(plan.Price))
+				if err == nil {
+					continue
+				}
+				common.SysLog(fmt.Sprintf("subscription %d auto renew failed: %s", subscription.Id, err.Error()))
+			}
+			if err = expireSubscription(subscription); err != nil {
+				common.SysError(fmt.Sprintf("failed to expire subscription %d: %s", subscription.Id, err.Error()))
+			}
+			continue
+		}
+		subscription.resetAllowance(plan, now)
+		err = DB.Model(subscription).Select("remain_quota", "model_remain_quotas", "next_reset_time").Updates(subscription).Error
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to reset subscription %d: %s", subscription.Id, err.Error()))
+			continue
+		}
+		invalidateSubscriptionCache(subscription.UserId)
+	}
+}
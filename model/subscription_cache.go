@@ -0,0 +1,27 @@
+package model
+
+import (
+	"one-api/common"
+	"strconv"
+)
+
+// subscriptionCache 缓存用户生效中的订阅，nil 表示用户没有生效中的订阅
+var subscriptionCache = newRowCache[UserSubscription]("subscription:")
+
+// cacheGetActiveSubscription 从缓存获取用户生效中的订阅，ok 为 false 表示未命中
+func cacheGetActiveSubscription(userId int) (*UserSubscription, bool) {
+	subscription, ok := subscriptionCache.Get(strconv.Itoa(userId))
+	if !ok || (subscription != nil && subscription.ExpireTime <= common.GetTimestamp()) {
+		return nil, false
+	}
+	return subscription, true
+}
+
+func cacheSetActiveSubscription(userId int, subscription *UserSubscription) {
+	subscriptionCache.Set(strconv.Itoa(userId), subscription)
+}
+
+// invalidateSubscriptionCache 订阅变更或扣除套餐额度后清除缓存，不在缓存上读改写，避免并发请求互相覆盖
+func invalidateSubscriptionCache(userId int) {
+	subscriptionCache.Delete(strconv.Itoa(userId))
+}
@@ -21,22 +21,27 @@ const (
 )
 
 type Task struct {
-	ID         int64                 `json:"id" gorm:"primary_key;AUTO_INCREMENT"`
-	CreatedAt  int64                 `json:"created_at" gorm:"index"`
-	UpdatedAt  int64                 `json:"updated_at"`
-	TaskID     string                `json:"task_id" gorm:"type:varchar(50);index"`  // 第三方id，不一定有/ song id\ Task id
-	Platform   constant.TaskPlatform `json:"platform" gorm:"type:varchar(30);index"` // 平台
-	UserId     int                   `json:"user_id" gorm:"index"`
-	ChannelId  int                   `json:"channel_id" gorm:"index"`
-	Quota      int                   `json:"quota"`
-	Action     string                `json:"action" gorm:"type:varchar(40);index"` // 任务类型, song, lyrics, description-mode
-	Status     TaskStatus            `json:"status" gorm:"type:varchar(20);index"` // 任务状态
-	FailReason string                `json:"fail_reason"`
-	SubmitTime int64                 `json:"submit_time" gorm:"index"`
-	StartTime  int64                 `json:"start_time" gorm:"index"`
-	FinishTime int64                 `json:"finish_time" gorm:"index"`
-	Progress   string                `json:"progress" gorm:"type:varchar(20);index"`
-	Properties Properties            `json:"properties" gorm:"type:json"`
+	ID        int64                 `json:"id" gorm:"primary_key;AUTO_INCREMENT"`
+	CreatedAt int64                 `json:"created_at" gorm:"index"`
+	UpdatedAt int64                 `json:"updated_at"`
+	TaskID    string                `json:"task_id" gorm:"type:varchar(50);index"`  // 第三方id，不一定有/ song id\ Task id
+	Platform  constant.TaskPlatform `json:"platform" gorm:"type:varchar(30);index"` // 平台
+	UserId    int                   `json:"user_id" gorm:"index"`
+	OrgId     int                   `json:"org_id" gorm:"default:0"`
+	// FundingTokenId 由自付令牌付费时为该令牌，任务失败的补偿退回令牌额度
+	FundingTokenId int `json:"funding_token_id" gorm:"default:0"`
+	ChannelId      int `json:"channel_id" gorm:"index"`
+	Quota          int `json:"quota"`
+	// SubscriptionQuota 订阅套餐承担以及未向用户收取的额度，任务失败时只补偿付费方承担的部分
+	SubscriptionQuota int        `json:"subscription_quota" gorm:"default:0"`
+	Action            string     `json:"action" gorm:"type:varchar(40);index"` // 任务类型, song, lyrics, description-mode
+	Status            TaskStatus `json:"status" gorm:"type:varchar(20);index"` // 任务状态
+	FailReason        string     `json:"fail_reason"`
+	SubmitTime        int64      `json:"submit_time" gorm:"index"`
+	StartTime         int64      `json:"start_time" gorm:"index"`
+	FinishTime        int64      `json:"finish_time" gorm:"index"`
+	Progress          string     `json:"progress" gorm:"type:varchar(20);index"`
+	Properties        Properties `json:"properties" gorm:"type:json"`
 
 	Data json.RawMessage `json:"data" gorm:"type:json"`
 }
@@ -79,12 +84,14 @@ type SyncTaskQueryParams struct {
 
 func InitTask(platform constant.TaskPlatform, relayInfo *commonRelay.TaskRelayInfo) *Task {
 	t := &Task{
-		UserId:     relayInfo.UserId,
-		SubmitTime: time.Now().Unix(),
-		Status:     TaskStatusNotStart,
-		Progress:   "0%",
-		ChannelId:  relayInfo.ChannelId,
-		Platform:   platform,
+		UserId:         relayInfo.UserId,
+		OrgId:          relayInfo.OrgId,
+		FundingTokenId: relayInfo.GetFundingTokenId(),
+		SubmitTime:     time.Now().Unix(),
+		Status:         TaskStatusNotStart,
+		Progress:       "0%",
+		ChannelId:      relayInfo.ChannelId,
+		Platform:       platform,
 	}
 	return t
 }
@@ -240,6 +247,17 @@ func (Task *Task) Update() error {
 	return err
 }
 
+// UpdateSubscriptionQuota 结算后记录套餐承担的额度
+func (Task *Task) UpdateSubscriptionQuota(quota int) error {
+	Task.SubscriptionQuota = quota
+	return DB.Model(Task).Update("subscription_quota", quota).Error
+}
+
+// RefundQuota 任务失败时应补偿给付费方的额度，不含套餐承担的部分
+func (Task *Task) RefundQuota() int {
+	return Task.Quota - Task.SubscriptionQuota
+}
+
 func TaskBulkUpdate(TaskIds []string, params map[string]any) error {
 	if len(TaskIds) == 0 {
 		return nil
@@ -24,8 +24,17 @@ type Token struct {
 	ModelLimitsEnabled bool           `json:"model_limits_enabled" gorm:"default:false"`
 	ModelLimits        string         `json:"model_limits" gorm:"type:varchar(1024);default:''"`
 	AllowIps           *string        `json:"allow_ips" gorm:"default:''"`
+	DenyIps            *string        `json:"deny_ips" gorm:"default:''"`
 	UsedQuota          int            `json:"used_quota" gorm:"default:0"` // used quota
 	Group              string         `json:"group" gorm:"default:''"`
+	BudgetPeriod       string         `json:"budget_period" gorm:"type:varchar(16);default:''"` // daily, weekly, monthly or empty
+	BudgetQuota        int            `json:"budget_quota" gorm:"default:0"`                    // quota per budget window, 0 means only model limits apply
+	BudgetModelLimits  string         `json:"budget_model_limits" gorm:"type:text"`             // JSON map of model family to quota per window
+	OrgId              int            `json:"org_id" gorm:"default:0;index"`                    // organization whose quota pool pays for this token, 0 means the user
+	TPMLimit           int            `json:"tpm_limit" gorm:"default:0"`                       // tokens per minute for this token, 0 means no limit
+	ConcurrencyLimit   int            `json:"concurrency_limit" gorm:"default:0"`               // max in-flight requests for this token, 0 means no limit
+	Scopes             string         `json:"scopes" gorm:"type:text"`                          // JSON TokenScopes, empty means no restriction
+	SelfFunded         bool           `json:"self_funded" gorm:"default:false"`                 // quota belongs to the token itself (redemption reward), consumption does not charge the user
 	DeletedAt          gorm.DeletedAt `gorm:"index"`
 }
 
@@ -33,26 +42,20 @@ func (token *Token) Clean() {
 	token.Key = ""
 }
 
-func (token *Token) GetIpLimitsMap() map[string]any {
-	// delete empty spaces
-	//split with \n
-	ipLimitsMap := make(map[string]any)
+// GetAllowIps 返回令牌的 IP 白名单，每行一个 IP 或 CIDR 网段，为空时不限制
+func (token *Token) GetAllowIps() string {
 	if token.AllowIps == nil {
-		return ipLimitsMap
+		return ""
 	}
-	cleanIps := strings.ReplaceAll(*token.AllowIps, " ", "")
-	if cleanIps == "" {
-		return ipLimitsMap
-	}
-	ips := strings.Split(cleanIps, "\n")
-	for _, ip := range ips {
-		ip = strings.TrimSpace(ip)
-		ip = strings.ReplaceAll(ip, ",", "")
-		if common.IsIP(ip) {
-			ipLimitsMap[ip] = true
-		}
+	return *token.AllowIps
+}
+
+// GetDenyIps 返回令牌的 IP 黑名单，黑名单优先于白名单
+func (token *Token) GetDenyIps() string {
+	if token.DenyIps == nil {
+		return ""
 	}
-	return ipLimitsMap
+	return *token.DenyIps
 }
 
 func GetAllUserTokens(userId int, startIdx int, num int) ([]*Token, error) {
@@ -184,7 +187,8 @@ func (token *Token) Update() (err error) {
 		}
 	}()
 	err = DB.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota",
-		"model_limits_enabled", "model_limits", "allow_ips", "group").Updates(token).Error
+		"model_limits_enabled", "model_limits", "allow_ips", "deny_ips", "group", "budget_period", "budget_quota",
+		"budget_model_limits", "tpm_limit", "concurrency_limit", "scopes").Updates(token).Error
 	return err
 }
 
@@ -280,6 +284,15 @@ func IncreaseTokenQuota(id int, key string, quota int) (err error) {
 	return increaseTokenQuota(id, quota)
 }
 
+// IncreaseTokenQuotaById 按 id 退还令牌额度，用于自付令牌的失败补偿与退款，已删除的令牌同样退还
+func IncreaseTokenQuotaById(id int, quota int) error {
+	var token Token
+	if err := DB.Unscoped().Select("id", "key").First(&token, "id = ?", id).Error; err != nil {
+		return err
+	}
+	return IncreaseTokenQuota(token.Id, token.Key, quota)
+}
+
 func increaseTokenQuota(id int, quota int) (err error) {
 	err = DB.Model(&Token{}).Where("id = ?", id).Updates(
 		map[string]interface{}{
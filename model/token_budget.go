@@ -0,0 +1,151 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"one-api/common"
+	"one-api/setting/operation_setting"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	TokenBudgetPeriodDaily   = "daily"
+	TokenBudgetPeriodWeekly  = "weekly"
+	TokenBudgetPeriodMonthly = "monthly"
+)
+
+// TokenBudgetUsage 令牌在一个预算周期内的用量，ModelKey 为空表示总用量，否则为模型子预算的模型系列
+type TokenBudgetUsage struct {
+	Id          int    `json:"id"`
+	TokenId     int    `json:"token_id" gorm:"uniqueIndex:idx_token_budget_window"`
+	WindowStart int64  `json:"window_start" gorm:"bigint;uniqueIndex:idx_token_budget_window;index"`
+	ModelKey    string `json:"model_key" gorm:"type:varchar(128);default:'';uniqueIndex:idx_token_budget_window"`
+	UsedQuota   int    `json:"used_quota" gorm:"default:0"`
+	UpdatedTime int64  `json:"updated_time" gorm:"bigint"`
+}
+
+func (token *Token) IsBudgetEnabled() bool {
+	return token.BudgetPeriod != "" && (token.BudgetQuota > 0 || token.BudgetModelLimits != "")
+}
+
+// GetBudgetModelLimits 返回按模型系列的周期子预算，键支持以 * 结尾的前缀匹配
+func (token *Token) GetBudgetModelLimits() map[string]int {
+	limits := make(map[string]int)
+	if token.BudgetModelLimits == "" {
+		return limits
+	}
+	if err := json.Unmarshal([]byte(token.BudgetModelLimits), &limits); err != nil {
+		common.SysError("failed to unmarshal token budget model limits: " + err.Error())
+	}
+	return limits
+}
+
+// GetBudgetModelKey 返回模型匹配的子预算模型系列，没有匹配时返回空
+func (token *Token) GetBudgetModelKey(modelName string) string {
+	key, _ := matchPlanModelFamily(token.GetBudgetModelLimits(), modelName)
+	return key
+}
+
+func ValidateTokenBudget(period string, budgetQuota int, modelLimits string) error {
+	switch period {
+	case "", TokenBudgetPeriodDaily, TokenBudgetPeriodWeekly, TokenBudgetPeriodMonthly:
+	default:
+		return fmt.Errorf("无效的预算周期：%s", period)
+	}
+	if budgetQuota < 0 {
+		return errors.New("预算额度不能为负数")
+	}
+	if modelLimits != "" {
+		limits := make(map[string]int)
+		if err := json.Unmarshal([]byte(modelLimits), &limits); err != nil {
+			return errors.New("模型预算格式错误：" + err.Error())
+		}
+	}
+	return nil
+}
+
+func getTokenBudgetLocation() *time.Location {
+	timezone := operation_setting.GetTokenBudgetSetting().Timezone
+	if timezone == "" {
+		return time.Local
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		common.SysError("invalid token budget timezone: " + timezone)
+		return time.Local
+	}
+	return location
+}
+
+// GetTokenBudgetWindow 返回给定时间所在预算周期的开始与结束（下次重置）时间，周从星期一开始
+func GetTokenBudgetWindow(period string, now time.Time) (time.Time, time.Time) {
+	now = now.In(getTokenBudgetLocation())
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch period {
+	case TokenBudgetPeriodWeekly:
+		offset := (int(start.Weekday()) + 6) % 7
+		start = start.AddDate(0, 0, -offset)
+		return start, start.AddDate(0, 0, 7)
+	case TokenBudgetPeriodMonthly:
+		start = start.AddDate(0, 0, 1-start.Day())
+		return start, start.AddDate(0, 1, 0)
+	default:
+		return start, start.AddDate(0, 0, 1)
+	}
+}
+
+// GetTokenBudgetUsages 返回令牌在预算周期内的用量，键为模型系列，总用量的键为空
+func GetTokenBudgetUsages(tokenId int, windowStart int64) (map[string]int, error) {
+	var usages []TokenBudgetUsage
+	err := DB.Where("token_id = ? and window_start = ?", tokenId, windowStart).Find(&usages).Error
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]int, len(usages))
+	for _, usage := range usages {
+		result[usage.ModelKey] = usage.UsedQuota
+	}
+	return result, nil
+}
+
+// AddTokenBudgetUsage 累加令牌在预算周期内的总用量及模型系列用量，quota 为负数时表示退还
+func AddTokenBudgetUsage(tokenId int, windowStart int64, modelKey string, quota int) error {
+	if quota == 0 {
+		return nil
+	}
+	keys := []string{""}
+	if modelKey != "" {
+		keys = append(keys, modelKey)
+	}
+	now := common.GetTimestamp()
+	for _, key := range keys {
+		usage := TokenBudgetUsage{
+			TokenId:     tokenId,
+			WindowStart: windowStart,
+			ModelKey:    key,
+			UsedQuota:   quota,
+			UpdatedTime: now,
+		}
+		err := DB.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "token_id"}, {Name: "window_start"}, {Name: "model_key"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"used_quota":   gorm.Expr("used_quota + ?", quota),
+				"updated_time": now,
+			}),
+		}).Create(&usage).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteExpiredTokenBudgetUsages 删除早于给定时间开始的预算周期用量
+func DeleteExpiredTokenBudgetUsages(before int64) (int64, error) {
+	result := DB.Where("window_start < ?", before).Delete(&TokenBudgetUsage{})
+	return result.RowsAffected, result.Error
+}
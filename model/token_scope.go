@@ -0,0 +1,96 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"one-api/common"
+)
+
+// 令牌可访问的接口类别
+const (
+	TokenEndpointChat        = "chat"        // /v1/chat/completions
+	TokenEndpointCompletions = "completions" // /v1/completions、/v1/edits
+	TokenEndpointResponses   = "responses"   // /v1/responses
+	TokenEndpointClaude      = "claude"      // /v1/messages
+	TokenEndpointGemini      = "gemini"      // /v1beta/models
+	TokenEndpointEmbeddings  = "embeddings"  // /v1/embeddings
+	TokenEndpointImages      = "images"      // /v1/images
+	TokenEndpointAudio       = "audio"       // /v1/audio
+	TokenEndpointModerations = "moderations" // /v1/moderations
+	TokenEndpointRerank      = "rerank"      // /v1/rerank
+	TokenEndpointRealtime    = "realtime"    // /v1/realtime
+	TokenEndpointMidjourney  = "midjourney"  // /mj
+	TokenEndpointSuno        = "suno"        // /suno
+)
+
+// 令牌可使用的请求能力
+const (
+	TokenFeatureTools     = "tools"      // 函数调用等工具
+	TokenFeatureVision    = "vision"     // 图片输入
+	TokenFeatureWebSearch = "web_search" // 联网搜索
+	TokenFeatureStream    = "stream"     // 流式输出
+)
+
+var tokenEndpoints = []string{
+	TokenEndpointChat, TokenEndpointCompletions, TokenEndpointResponses, TokenEndpointClaude, TokenEndpointGemini,
+	TokenEndpointEmbeddings, TokenEndpointImages, TokenEndpointAudio, TokenEndpointModerations, TokenEndpointRerank,
+	TokenEndpointRealtime, TokenEndpointMidjourney, TokenEndpointSuno,
+}
+
+var tokenFeatures = []string{TokenFeatureTools, TokenFeatureVision, TokenFeatureWebSearch, TokenFeatureStream}
+
+// TokenScopes 令牌的权限范围。Endpoints、Features 为 null 时不限制，为空数组时全部禁止；
+// MaxTokens 限制单次请求的最大输出 token 数，MaxPrice 限制单次请求的预估费用（美元），0 表示不限制
+type TokenScopes struct {
+	Endpoints []string `json:"endpoints"`
+	Features  []string `json:"features"`
+	MaxTokens int      `json:"max_tokens"`
+	MaxPrice  float64  `json:"max_price"`
+}
+
+// GetScopes 解析令牌的权限范围，未设置时返回 nil
+func (token *Token) GetScopes() *TokenScopes {
+	if token.Scopes == "" {
+		return nil
+	}
+	var scopes TokenScopes
+	if err := json.Unmarshal([]byte(token.Scopes), &scopes); err != nil {
+		common.SysError(fmt.Sprintf("failed to unmarshal scopes of token %d: %s", token.Id, err.Error()))
+		return nil
+	}
+	return &scopes
+}
+
+func (scopes *TokenScopes) AllowEndpoint(endpoint string) bool {
+	return scopes.Endpoints == nil || common.StringsContains(scopes.Endpoints, endpoint)
+}
+
+func (scopes *TokenScopes) AllowFeature(feature string) bool {
+	return scopes.Features == nil || common.StringsContains(scopes.Features, feature)
+}
+
+// ValidateTokenScopes 校验令牌权限范围配置，空字符串表示不限制
+func ValidateTokenScopes(scopesStr string) error {
+	if scopesStr == "" {
+		return nil
+	}
+	var scopes TokenScopes
+	if err := json.Unmarshal([]byte(scopesStr), &scopes); err != nil {
+		return errors.New("令牌权限范围格式错误")
+	}
+	for _, endpoint := range scopes.Endpoints {
+		if !common.StringsContains(tokenEndpoints, endpoint) {
+			return fmt.Errorf("未知的接口类别 %s", endpoint)
+		}
+	}
+	for _, feature := range scopes.Features {
+		if !common.StringsContains(tokenFeatures, feature) {
+			return fmt.Errorf("未知的请求能力 %s", feature)
+		}
+	}
+	if scopes.MaxTokens < 0 || scopes.MaxPrice < 0 {
+		return errors.New("最大输出 token 数与单次请求最高费用不能为负数")
+	}
+	return nil
+}
@@ -1,5 +1,31 @@
 package model
 
+import (
+	"fmt"
+	"one-api/common"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"gorm.io/gorm"
+)
+
+const (
+	TopUpStatusPending  = "pending"
+	TopUpStatusSuccess  = "success"
+	TopUpStatusFailed   = "failed"
+	TopUpStatusExpired  = "expired"
+	TopUpStatusRefunded = "refunded"
+	// TopUpStatusPartialRefunded 订单已部分退款，仍可继续退款
+	TopUpStatusPartialRefunded = "partial_refunded"
+)
+
+// topUpTransitions 订单允许的状态流转，过期订单在支付渠道确认到账后仍可入账
+var topUpTransitions = map[string][]string{
+	TopUpStatusPending:         {TopUpStatusSuccess, TopUpStatusFailed, TopUpStatusExpired},
+	TopUpStatusExpired:         {TopUpStatusSuccess},
+	TopUpStatusSuccess:         {TopUpStatusPartialRefunded, TopUpStatusRefunded},
+	TopUpStatusPartialRefunded: {TopUpStatusPartialRefunded, TopUpStatusRefunded},
+}
+
 type TopUp struct {
 	Id         int     `json:"id"`
 	UserId     int     `json:"user_id" gorm:"index"`
@@ -8,6 +34,124 @@ type TopUp struct {
 	TradeNo    string  `json:"trade_no"`
 	CreateTime int64   `json:"create_time"`
 	Status     string  `json:"status"`
+	// PlanId 购买订阅套餐的订单对应的套餐，0 表示普通充值
+	PlanId int `json:"plan_id" gorm:"default:0"`
+	// PaymentProvider 支付渠道，PaymentMethod 为渠道内的支付方式，ProviderOrderId 为渠道侧的订单号
+	PaymentProvider string `json:"payment_provider" gorm:"type:varchar(32);default:'epay'"`
+	PaymentMethod   string `json:"payment_method" gorm:"type:varchar(32);default:''"`
+	ProviderOrderId string `json:"provider_order_id" gorm:"type:varchar(128);default:''"`
+	CompleteTime    int64  `json:"complete_time" gorm:"bigint;default:0"`
+	// RefundedMoney 已退款金额，RefundedQuota 为退款时扣回的额度
+	RefundedMoney float64 `json:"refunded_money" gorm:"default:0"`
+	RefundedQuota int     `json:"refunded_quota" gorm:"default:0"`
+}
+
+func CanTransitTopUpStatus(from string, to string) bool {
+	for _, status := range topUpTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// TransitStatus 以条件更新的方式切换订单状态，返回是否由本次调用完成切换，保证重复到达的回调只入账一次
+func (topUp *TopUp) TransitStatus(to string) (bool, error) {
+	return topUp.transitStatus(DB, to)
+}
+
+func (topUp *TopUp) transitStatus(tx *gorm.DB, to string) (bool, error) {
+	if !CanTransitTopUpStatus(topUp.Status, to) {
+		return false, fmt.Errorf("订单状态不能从 %s 变更为 %s", topUp.Status, to)
+	}
+	updates := map[string]interface{}{
+		"status": to,
+	}
+	if to == TopUpStatusSuccess {
+		updates["complete_time"] = common.GetTimestamp()
+	}
+	if topUp.ProviderOrderId != "" {
+		updates["provider_order_id"] = topUp.ProviderOrderId
+	}
+	result := tx.Model(&TopUp{}).Where("id = ? and status = ?", topUp.Id, topUp.Status).Updates(updates)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	topUp.Status = to
+	if completeTime, ok := updates["complete_time"]; ok {
+		topUp.CompleteTime = completeTime.(int64)
+	}
+	return true, nil
+}
+
+// complete 在同一事务内将订单置为成功并执行入账，返回是否由本次调用完成入账；事务失败时订单保持原状态
+func (topUp *TopUp) complete(fulfill func(tx *gorm.DB) error) (bool, error) {
+	previousStatus, previousCompleteTime := topUp.Status, topUp.CompleteTime
+	completed := false
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		transited, err := topUp.transitStatus(tx, TopUpStatusSuccess)
+		if err != nil || !transited {
+			return err
+		}
+		if err = fulfill(tx); err != nil {
+			return err
+		}
+		completed = true
+		return nil
+	})
+	if err != nil {
+		topUp.Status, topUp.CompleteTime = previousStatus, previousCompleteTime
+		return false, err
+	}
+	return completed, nil
+}
+
+// CompleteQuotaTopUp 充值订单支付成功，在同一事务内完成订单、为用户增加额度并记录流水
+func (topUp *TopUp) CompleteQuotaTopUp(quota int) (bool, error) {
+	completed, err := topUp.complete(func(tx *gorm.DB) error {
+		err := tx.Model(&User{}).Where("id = ?", topUp.UserId).Update("quota", gorm.Expr("quota + ?", quota)).Error
+		if err != nil {
+			return err
+		}
+		return recordQuotaLedger(tx, topUp.UserId, quota, LedgerEntry{
+			Type:           LedgerTypeTopUp,
+			CounterAccount: "payment:" + topUp.PaymentProvider,
+			RefType:        "topup",
+			RefId:          topUp.TradeNo,
+			ActorId:        topUp.UserId,
+		})
+	})
+	if err != nil || !completed {
+		return completed, err
+	}
+	gopool.Go(func() {
+		if err := cacheIncrUserQuota(topUp.UserId, int64(quota)); err != nil {
+			common.SysError("failed to increase user quota: " + err.Error())
+		}
+	})
+	return true, nil
+}
+
+// CompleteSubscriptionTopUp 套餐订单支付成功，在同一事务内完成订单并开通套餐，已下架的套餐同样开通
+func (topUp *TopUp) CompleteSubscriptionTopUp() (*Plan, bool, error) {
+	plan, err := getPlanUnscoped(topUp.PlanId)
+	if err != nil {
+		return nil, false, err
+	}
+	var subscription *UserSubscription
+	var targetGroup string
+	completed, err := topUp.complete(func(tx *gorm.DB) (err error) {
+		subscription, targetGroup, err = activateSubscription(tx, topUp.UserId, plan, false)
+		return err
+	})
+	if err != nil || !completed {
+		return plan, completed, err
+	}
+	afterActivateSubscription(topUp.UserId, plan, subscription, targetGroup)
+	return plan, true, nil
 }
 
 func (topUp *TopUp) Insert() error {
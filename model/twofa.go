@@ -0,0 +1,252 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"one-api/common"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const twoFARecoveryCodeCount = 10
+
+// TwoFA 用户的两步验证配置，密钥按渠道密钥的方式加密保存，恢复码只保存 SHA-256 摘要
+type TwoFA struct {
+	Id            int    `json:"id"`
+	UserId        int    `json:"user_id" gorm:"uniqueIndex"`
+	Secret        string `json:"-" gorm:"type:text"`
+	Enabled       bool   `json:"enabled" gorm:"default:false"`
+	RecoveryCodes string `json:"-" gorm:"type:text"`
+	LastUsedStep  int64  `json:"-" gorm:"bigint;default:0"`
+	CreatedTime   int64  `json:"created_time" gorm:"bigint"`
+	UpdatedTime   int64  `json:"updated_time" gorm:"bigint"`
+}
+
+// GetTwoFAByUserId 返回用户的两步验证配置，未配置时返回 gorm.ErrRecordNotFound
+func GetTwoFAByUserId(userId int) (*TwoFA, error) {
+	var twoFA TwoFA
+	err := DB.Where("user_id = ?", userId).First(&twoFA).Error
+	return &twoFA, err
+}
+
+// IsTwoFAEnabled 用户是否已启用两步验证，查询失败时视为已启用，避免绕过验证
+func IsTwoFAEnabled(userId int) (bool, error) {
+	twoFA, err := GetTwoFAByUserId(userId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return true, err
+	}
+	return twoFA.Enabled, nil
+}
+
+// twoFAStatusCache 缓存用户是否已启用两步验证，用于每次请求检查强制启用
+var twoFAStatusCache = newRowCache[bool]("two_fa:")
+
+// IsTwoFAEnabledCached 与 IsTwoFAEnabled 相同，优先读取缓存
+func IsTwoFAEnabledCached(userId int) (bool, error) {
+	key := strconv.Itoa(userId)
+	if enabled, ok := twoFAStatusCache.Get(key); ok && enabled != nil {
+		return *enabled, nil
+	}
+	enabled, err := IsTwoFAEnabled(userId)
+	if err != nil {
+		return enabled, err
+	}
+	twoFAStatusCache.Set(key, &enabled)
+	return enabled, nil
+}
+
+func invalidateTwoFAStatusCache(userId int) {
+	twoFAStatusCache.Delete(strconv.Itoa(userId))
+}
+
+// TwoFARequired 当前设置下用户是否必须启用两步验证，管理员与超级管理员需要启用
+func TwoFARequired(role int) bool {
+	return common.AdminTwoFAEnforcementEnabled && role >= common.RoleAdminUser
+}
+
+// SetupTwoFA 为用户生成新的待启用密钥，已启用时返回错误
+func SetupTwoFA(userId int) (string, error) {
+	twoFA, err := GetTwoFAByUserId(userId)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", err
+	}
+	if err == nil && twoFA.Enabled {
+		return "", errors.New("已启用两步验证，请先关闭后再重新绑定")
+	}
+	secret, err := common.GenerateTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+	encrypted, err := common.EncryptSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	now := common.GetTimestamp()
+	if twoFA.Id == 0 {
+		twoFA = &TwoFA{UserId: userId, CreatedTime: now}
+	}
+	twoFA.Secret = encrypted
+	twoFA.Enabled = false
+	twoFA.RecoveryCodes = ""
+	twoFA.LastUsedStep = 0
+	twoFA.UpdatedTime = now
+	err = DB.Save(twoFA).Error
+	invalidateTwoFAStatusCache(userId)
+	return secret, err
+}
+
+func (twoFA *TwoFA) getSecret() (string, error) {
+	return common.DecryptSecret(twoFA.Secret)
+}
+
+// verifyTOTP 校验验证码并原子地记录已使用的步数，同一验证码只能使用一次
+func (twoFA *TwoFA) verifyTOTP(code string) (bool, error) {
+	secret, err := twoFA.getSecret()
+	if err != nil {
+		return false, err
+	}
+	step, ok := common.ValidateTOTP(secret, code, twoFA.LastUsedStep)
+	if !ok {
+		return false, nil
+	}
+	result := DB.Model(&TwoFA{}).Where("id = ? AND last_used_step < ?", twoFA.Id, step).
+		Updates(map[string]interface{}{"last_used_step": step, "updated_time": common.GetTimestamp()})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	twoFA.LastUsedStep = step
+	return result.RowsAffected == 1, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+func (twoFA *TwoFA) getRecoveryCodes() []string {
+	var hashes []string
+	if twoFA.RecoveryCodes == "" {
+		return hashes
+	}
+	if err := json.Unmarshal([]byte(twoFA.RecoveryCodes), &hashes); err != nil {
+		common.SysError("failed to unmarshal recovery codes: " + err.Error())
+	}
+	return hashes
+}
+
+// RecoveryCodesRemaining 返回剩余可用的恢复码数量
+func (twoFA *TwoFA) RecoveryCodesRemaining() int {
+	return len(twoFA.getRecoveryCodes())
+}
+
+// useRecoveryCode 校验恢复码，命中后将其作废
+func (twoFA *TwoFA) useRecoveryCode(code string) (bool, error) {
+	hash := hashRecoveryCode(code)
+	hashes := twoFA.getRecoveryCodes()
+	remaining := make([]string, 0, len(hashes))
+	found := false
+	for _, h := range hashes {
+		if h == hash && !found {
+			found = true
+			continue
+		}
+		remaining = append(remaining, h)
+	}
+	if !found {
+		return false, nil
+	}
+	remainingBytes, err := json.Marshal(remaining)
+	if err != nil {
+		return false, err
+	}
+	// 以原值为条件更新，并发使用同一恢复码时只有一次成功
+	result := DB.Model(&TwoFA{}).Where("id = ? AND recovery_codes = ?", twoFA.Id, twoFA.RecoveryCodes).
+		Updates(map[string]interface{}{"recovery_codes": string(remainingBytes), "updated_time": common.GetTimestamp()})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	twoFA.RecoveryCodes = string(remainingBytes)
+	return result.RowsAffected == 1, nil
+}
+
+// Verify 校验验证码或恢复码，返回是否通过以及是否使用了恢复码
+func (twoFA *TwoFA) Verify(code string) (ok bool, usedRecoveryCode bool, err error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return false, false, nil
+	}
+	if ok, err = twoFA.verifyTOTP(code); ok || err != nil {
+		return ok, false, err
+	}
+	if !twoFA.Enabled {
+		return false, false, nil
+	}
+	ok, err = twoFA.useRecoveryCode(code)
+	return ok, ok, err
+}
+
+// RegenerateRecoveryCodes 生成一组新的恢复码替换旧的恢复码，明文只在此时返回
+func (twoFA *TwoFA) RegenerateRecoveryCodes() ([]string, error) {
+	codes := make([]string, 0, twoFARecoveryCodeCount)
+	hashes := make([]string, 0, twoFARecoveryCodeCount)
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for i := 0; i < twoFARecoveryCodeCount; i++ {
+		random := make([]byte, 7)
+		if _, err := rand.Read(random); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(encoding.EncodeToString(random))[:10]
+		code = code[:5] + "-" + code[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	hashesBytes, err := json.Marshal(hashes)
+	if err != nil {
+		return nil, err
+	}
+	twoFA.RecoveryCodes = string(hashesBytes)
+	twoFA.UpdatedTime = common.GetTimestamp()
+	err = DB.Model(twoFA).Select("recovery_codes", "updated_time").Updates(twoFA).Error
+	return codes, err
+}
+
+// Enable 校验验证码后启用两步验证并生成恢复码
+func (twoFA *TwoFA) Enable(code string) ([]string, error) {
+	if twoFA.Enabled {
+		return nil, errors.New("已启用两步验证")
+	}
+	ok, _, err := twoFA.Verify(code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("验证码错误或已过期")
+	}
+	twoFA.Enabled = true
+	twoFA.UpdatedTime = common.GetTimestamp()
+	if err := DB.Model(twoFA).Select("enabled", "updated_time").Updates(twoFA).Error; err != nil {
+		return nil, err
+	}
+	invalidateTwoFAStatusCache(twoFA.UserId)
+	return twoFA.RegenerateRecoveryCodes()
+}
+
+// DeleteTwoFAByUserId 关闭用户的两步验证
+func DeleteTwoFAByUserId(userId int) error {
+	err := DB.Where("user_id = ?", userId).Delete(&TwoFA{}).Error
+	invalidateTwoFAStatusCache(userId)
+	return err
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/bytedance/gopkg/util/gopool"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // User if you add sensitive fields, don't forget to clean them in setupLogin function.
@@ -41,17 +42,35 @@ type User struct {
 	DeletedAt        gorm.DeletedAt `gorm:"index"`
 	LinuxDOId        string         `json:"linux_do_id" gorm:"column:linux_do_id;index"`
 	Setting          string         `json:"setting" gorm:"type:text;column:setting"`
+	AllowIps         string         `json:"allow_ips" gorm:"type:text"`                         // 用户所有令牌共用的 IP 白名单，每行一个 IP 或 CIDR 网段
+	DenyIps          string         `json:"deny_ips" gorm:"type:text"`                          // 用户所有令牌共用的 IP 黑名单
+	PermissionRole   string         `json:"permission_role" gorm:"type:varchar(64);default:''"` // 自定义管理角色，为空时按权限等级使用内置角色
+	// Subscription 当前生效的订阅，仅用于接口返回
+	Subscription *UserSubscription `json:"subscription,omitempty" gorm:"-:all"`
+	// Concurrency 当前处理中的请求数，仅用于接口返回
+	Concurrency *UserConcurrency `json:"concurrency,omitempty" gorm:"-:all"`
+	// Permissions 用户拥有的管理权限，仅用于接口返回
+	Permissions []string `json:"permissions,omitempty" gorm:"-:all"`
+}
+
+// UserConcurrency 用户当前处理中的请求数与并发上限，Limit 为 0 表示不限制
+type UserConcurrency struct {
+	Current int64 `json:"current"`
+	Limit   int   `json:"limit"`
 }
 
 func (user *User) ToBaseUser() *UserBase {
 	cache := &UserBase{
-		Id:       user.Id,
-		Group:    user.Group,
-		Quota:    user.Quota,
-		Status:   user.Status,
-		Username: user.Username,
-		Setting:  user.Setting,
-		Email:    user.Email,
+		Id:             user.Id,
+		Group:          user.Group,
+		Quota:          user.Quota,
+		Status:         user.Status,
+		Username:       user.Username,
+		Setting:        user.Setting,
+		Email:          user.Email,
+		AllowIps:       user.AllowIps,
+		DenyIps:        user.DenyIps,
+		PermissionRole: user.PermissionRole,
 	}
 	return cache
 }
@@ -296,6 +315,14 @@ func (user *User) TransferAffQuotaToQuota(quota int) error {
 	if err := tx.Save(user).Error; err != nil {
 		return err
 	}
+	err = recordQuotaLedger(tx, user.Id, quota, LedgerEntry{
+		Type:           LedgerTypeAffTransfer,
+		CounterAccount: LedgerAccountAffiliate,
+		ActorId:        user.Id,
+	})
+	if err != nil {
+		return err
+	}
 
 	// 提交事务
 	return tx.Commit().Error
@@ -312,16 +339,29 @@ func (user *User) Insert(inviterId int) error {
 	user.Quota = common.QuotaForNewUser
 	//user.SetAccessToken(common.GetUUID())
 	user.AffCode = common.GetRandomString(4)
-	result := DB.Create(user)
-	if result.Error != nil {
-		return result.Error
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		return recordQuotaLedger(tx, user.Id, common.QuotaForNewUser, LedgerEntry{
+			Type:           LedgerTypeRegister,
+			CounterAccount: LedgerAccountPromotion,
+		})
+	})
+	if err != nil {
+		return err
 	}
 	if common.QuotaForNewUser > 0 {
 		RecordLog(user.Id, LogTypeSystem, fmt.Sprintf("新用户注册赠送 %s", common.LogQuota(common.QuotaForNewUser)))
 	}
 	if inviterId != 0 {
 		if common.QuotaForInvitee > 0 {
-			_ = IncreaseUserQuota(user.Id, common.QuotaForInvitee, true)
+			_ = ChangeUserQuota(user.Id, common.QuotaForInvitee, LedgerEntry{
+				Type:           LedgerTypeInvite,
+				CounterAccount: LedgerAccountAffiliate,
+				RefType:        "user",
+				RefId:          strconv.Itoa(inviterId),
+			}, true)
 			RecordLog(user.Id, LogTypeSystem, fmt.Sprintf("使用邀请码赠送 %s", common.LogQuota(common.QuotaForInvitee)))
 		}
 		if common.QuotaForInviter > 0 {
@@ -351,7 +391,8 @@ func (user *User) Update(updatePassword bool) error {
 	return updateUserCache(*user)
 }
 
-func (user *User) Edit(updatePassword bool) error {
+// Edit 管理员编辑用户，额度变化时在同一事务内按变动量记录调整流水，operatorId 为操作人
+func (user *User) Edit(updatePassword bool, operatorId int) error {
 	var err error
 	if updatePassword {
 		user.Password, err = common.Password2Hash(user.Password)
@@ -371,8 +412,21 @@ func (user *User) Edit(updatePassword bool) error {
 		updates["password"] = newUser.Password
 	}
 
-	DB.First(&user, user.Id)
-	if err = DB.Model(user).Updates(updates).Error; err != nil {
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, user.Id).Error; err != nil {
+			return err
+		}
+		delta := newUser.Quota - user.Quota
+		if err := tx.Model(user).Updates(updates).Error; err != nil {
+			return err
+		}
+		return recordQuotaLedger(tx, user.Id, delta, LedgerEntry{
+			Type:           LedgerTypeAdjust,
+			CounterAccount: LedgerAccountAdjust,
+			ActorId:        operatorId,
+		})
+	})
+	if err != nil {
 		return err
 	}
 
@@ -380,6 +434,18 @@ func (user *User) Edit(updatePassword bool) error {
 	return updateUserCache(*user)
 }
 
+// UpdateUserIpLimits 更新用户的 IP 黑白名单
+func UpdateUserIpLimits(id int, allowIps string, denyIps string) error {
+	err := DB.Model(&User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"allow_ips": allowIps,
+		"deny_ips":  denyIps,
+	}).Error
+	if err != nil {
+		return err
+	}
+	return invalidateUserCache(id)
+}
+
 func (user *User) Delete() error {
 	if user.Id == 0 {
 		return errors.New("id 为空！")
@@ -652,67 +718,6 @@ func GetUserSetting(id int, fromDB bool) (settingMap map[string]interface{}, err
 	return common.StrToMap(setting), nil
 }
 
-func IncreaseUserQuota(id int, quota int, db bool) (err error) {
-	if quota < 0 {
-		return errors.New("quota 不能为负数！")
-	}
-	gopool.Go(func() {
-		err := cacheIncrUserQuota(id, int64(quota))
-		if err != nil {
-			common.SysError("failed to increase user quota: " + err.Error())
-		}
-	})
-	if !db && common.BatchUpdateEnabled {
-		addNewRecord(BatchUpdateTypeUserQuota, id, quota)
-		return nil
-	}
-	return increaseUserQuota(id, quota)
-}
-
-func increaseUserQuota(id int, quota int) (err error) {
-	err = DB.Model(&User{}).Where("id = ?", id).Update("quota", gorm.Expr("quota + ?", quota)).Error
-	if err != nil {
-		return err
-	}
-	return err
-}
-
-func DecreaseUserQuota(id int, quota int) (err error) {
-	if quota < 0 {
-		return errors.New("quota 不能为负数！")
-	}
-	gopool.Go(func() {
-		err := cacheDecrUserQuota(id, int64(quota))
-		if err != nil {
-			common.SysError("failed to decrease user quota: " + err.Error())
-		}
-	})
-	if common.BatchUpdateEnabled {
-		addNewRecord(BatchUpdateTypeUserQuota, id, -quota)
-		return nil
-	}
-	return decreaseUserQuota(id, quota)
-}
-
-func decreaseUserQuota(id int, quota int) (err error) {
-	err = DB.Model(&User{}).Where("id = ?", id).Update("quota", gorm.Expr("quota - ?", quota)).Error
-	if err != nil {
-		return err
-	}
-	return err
-}
-
-func DeltaUpdateUserQuota(id int, delta int) (err error) {
-	if delta == 0 {
-		return nil
-	}
-	if delta > 0 {
-		return IncreaseUserQuota(id, delta, false)
-	} else {
-		return DecreaseUserQuota(id, -delta)
-	}
-}
-
 //func GetRootUserEmail() (email string) {
 //	DB.Model(&User{}).Where("role = ?", common.RoleRootUser).Select("email").Find(&email)
 //	return email
@@ -13,13 +13,16 @@ import (
 
 // UserBase struct remains the same as it represents the cached data structure
 type UserBase struct {
-	Id       int    `json:"id"`
-	Group    string `json:"group"`
-	Email    string `json:"email"`
-	Quota    int    `json:"quota"`
-	Status   int    `json:"status"`
-	Username string `json:"username"`
-	Setting  string `json:"setting"`
+	Id             int    `json:"id"`
+	Group          string `json:"group"`
+	Email          string `json:"email"`
+	Quota          int    `json:"quota"`
+	Status         int    `json:"status"`
+	Username       string `json:"username"`
+	Setting        string `json:"setting"`
+	AllowIps       string `json:"allow_ips"`
+	DenyIps        string `json:"deny_ips"`
+	PermissionRole string `json:"permission_role"`
 }
 
 func (user *UserBase) WriteContext(c *gin.Context) {
@@ -29,6 +32,8 @@ func (user *UserBase) WriteContext(c *gin.Context) {
 	c.Set(constant.ContextKeyUserEmail, user.Email)
 	c.Set("username", user.Username)
 	c.Set(constant.ContextKeyUserSetting, user.GetSetting())
+	c.Set(constant.ContextKeyUserAllowIps, user.AllowIps)
+	c.Set(constant.ContextKeyUserDenyIps, user.DenyIps)
 }
 
 func (user *UserBase) GetSetting() map[string]interface{} {
@@ -103,13 +108,16 @@ func GetUserCache(userId int) (userCache *UserBase, err error) {
 
 	// Create cache object from user data
 	userCache = &UserBase{
-		Id:       user.Id,
-		Group:    user.Group,
-		Quota:    user.Quota,
-		Status:   user.Status,
-		Username: user.Username,
-		Setting:  user.Setting,
-		Email:    user.Email,
+		Id:             user.Id,
+		Group:          user.Group,
+		Quota:          user.Quota,
+		Status:         user.Status,
+		Username:       user.Username,
+		Setting:        user.Setting,
+		Email:          user.Email,
+		AllowIps:       user.AllowIps,
+		DenyIps:        user.DenyIps,
+		PermissionRole: user.PermissionRole,
 	}
 
 	return userCache, nil
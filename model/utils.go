@@ -53,12 +53,17 @@ func batchUpdate() {
 		batchUpdateLocks[i].Lock()
 		store := batchUpdateStores[i]
 		batchUpdateStores[i] = make(map[int]int)
+		var ledgers map[int]map[pendingLedgerKey]*pendingLedger
+		if i == BatchUpdateTypeUserQuota {
+			ledgers = batchUpdateLedgers
+			batchUpdateLedgers = make(map[int]map[pendingLedgerKey]*pendingLedger)
+		}
 		batchUpdateLocks[i].Unlock()
 		// TODO: maybe we can combine updates with same key?
 		for key, value := range store {
 			switch i {
 			case BatchUpdateTypeUserQuota:
-				err := increaseUserQuota(key, value)
+				err := batchUpdateUserQuota(key, value, ledgers[key])
 				if err != nil {
 					common.SysError("failed to batch update user quota: " + err.Error())
 				}
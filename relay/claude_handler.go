@@ -109,6 +109,12 @@ func ClaudeHelper(c *gin.Context) (claudeError *dto.ClaudeErrorWithStatusCode) {
 		relayInfo.UpstreamModelName = textRequest.Model
 	}
 
+	// 个人信息脱敏策略，在转换为上游格式前替换
+	piiRedactor := service.NewPIIRedactor(relayInfo.ChannelId, relayInfo.Group)
+	if piiRedactor != nil {
+		redactClaudeRequest(piiRedactor, textRequest)
+	}
+
 	convertedRequest, err := adaptor.ConvertClaudeRequest(c, relayInfo, textRequest)
 	if err != nil {
 		return service.ClaudeErrorWrapperLocal(err, "convert_request_failed", http.StatusInternalServerError)
@@ -140,6 +146,23 @@ func ClaudeHelper(c *gin.Context) (claudeError *dto.ClaudeErrorWithStatusCode) {
 		}
 	}
 
+	if sensitiveWriter := startSensitiveFilter(c, relaycommon.RelayFormatClaude); sensitiveWriter != nil {
+		defer func() {
+			sensitiveWriter.Finish()
+			c.Writer = sensitiveWriter.ResponseWriter
+		}()
+	}
+
+	if piiRedactor != nil && piiRedactor.HasRedactions() {
+		relayInfo.PIIRedactions = piiRedactor.Counts()
+		logPIIRedactions(c, relayInfo.PIIRedactions)
+		piiWriter := startPIIRestore(c, piiRedactor, relaycommon.RelayFormatClaude)
+		defer func() {
+			piiWriter.Finish()
+			c.Writer = piiWriter.ResponseWriter
+		}()
+	}
+
 	usage, openaiErr := adaptor.DoResponse(c, httpResp, relayInfo)
 	//log.Printf("usage: %v", usage)
 	if openaiErr != nil {
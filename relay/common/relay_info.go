@@ -2,6 +2,7 @@ package common
 
 import (
 	"one-api/common"
+	"one-api/common/limiter"
 	"one-api/constant"
 	"one-api/dto"
 	relayconstant "one-api/relay/constant"
@@ -96,9 +97,34 @@ type RelayInfo struct {
 	UserSetting          map[string]interface{}
 	UserEmail            string
 	UserQuota            int
-	RelayFormat          string
-	SendResponseCount    int
-	ChannelCreateTime    int64
+	// SubscriptionId 用户生效中的订阅，0 表示没有订阅
+	SubscriptionId int
+	// SubscriptionOverageBlock 订阅套餐不允许超额，结算时超出套餐的部分不从余额扣除
+	SubscriptionOverageBlock bool
+	// SubscriptionWaivedQuota 不允许超额的套餐结算时超出套餐、未向用户收取的额度
+	SubscriptionWaivedQuota int
+	// OrgId 组织令牌所属的组织，消耗从组织额度池扣除
+	OrgId int
+	// TokenSelfFunded 令牌额度由令牌自身承担（兑换码赠送的令牌），消耗只扣除令牌额度
+	TokenSelfFunded bool
+	// RequestId 请求 id，作为额度流水的关联单号
+	RequestId string
+	// TokenBudgetPeriod 令牌的周期预算，TokenBudgetWindow 为预扣费时所在预算周期的开始时间
+	TokenBudgetPeriod string
+	TokenBudgetWindow int64
+	// TokenTPMLimit 令牌自身的 TPM 限制，TPMBuckets 为本次请求已扣减的 TPM 令牌桶，TPMCharged 为已扣减的 token 数
+	TokenTPMLimit     int
+	TPMBuckets        []limiter.Bucket
+	TPMCharged        int64
+	RelayFormat       string
+	SendResponseCount int
+	ChannelCreateTime int64
+	ResponseCacheHit  bool
+	SemanticCacheHit  bool
+	// SemanticCacheEmbeddingQuota 语义缓存计算提问向量产生的费用，计入本次请求
+	SemanticCacheEmbeddingQuota int
+	// PIIRedactions 请求中各类个人信息的替换次数
+	PIIRedactions map[string]int
 	ThinkingContentInfo
 	*ClaudeConvertInfo
 	*RerankerInfo
@@ -205,6 +231,11 @@ func GenRelayInfo(c *gin.Context) *RelayInfo {
 		UserId:            userId,
 		Group:             group,
 		TokenUnlimited:    tokenUnlimited,
+		TokenBudgetPeriod: c.GetString("token_budget_period"),
+		TokenTPMLimit:     c.GetInt("token_tpm_limit"),
+		OrgId:             c.GetInt("token_org_id"),
+		TokenSelfFunded:   c.GetBool("token_self_funded"),
+		RequestId:         c.GetString(common.RequestIdKey),
 		StartTime:         startTime,
 		FirstResponseTime: startTime.Add(-time.Second),
 		OriginModelName:   c.GetString("original_model"),
@@ -267,6 +298,14 @@ func (info *RelayInfo) HasSendResponse() bool {
 	return info.FirstResponseTime.After(info.StartTime)
 }
 
+// GetFundingTokenId 自付令牌返回令牌 id，用于异步任务失败时把补偿退回令牌，否则返回 0
+func (info *RelayInfo) GetFundingTokenId() int {
+	if info.TokenSelfFunded {
+		return info.TokenId
+	}
+	return 0
+}
+
 type TaskRelayInfo struct {
 	*RelayInfo
 	Action       string
@@ -11,7 +11,11 @@ import (
 
 func ModelMappedHelper(c *gin.Context, info *common.RelayInfo) error {
 	// map model name
-	modelMapping := c.GetString("model_mapping")
+	return ApplyModelMapping(info, c.GetString("model_mapping"))
+}
+
+// ApplyModelMapping 按渠道的模型映射设置上游模型名称
+func ApplyModelMapping(info *common.RelayInfo, modelMapping string) error {
 	if modelMapping != "" && modelMapping != "{}" {
 		modelMap := make(map[string]string)
 		err := json.Unmarshal([]byte(modelMapping), &modelMap)
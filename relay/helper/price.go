@@ -8,6 +8,7 @@ import (
 	relaycommon "one-api/relay/common"
 	"one-api/setting"
 	"one-api/setting/operation_setting"
+	"strings"
 )
 
 type PriceData struct {
@@ -20,14 +21,21 @@ type PriceData struct {
 	GroupRatio             float64
 	UsePrice               bool
 	ShouldPreConsumedQuota int
+	// TierIndex 应用的阶梯倍率序号，-1 表示未使用阶梯倍率
+	TierIndex int
+	Tier      operation_setting.ModelRatioTier
+	// ScheduleMultiplier 请求开始时生效的定价时段倍率，与分组倍率分开计算
+	ScheduleMultiplier float64
+	ScheduleNames      []string
 }
 
 func (p PriceData) ToSetting() string {
-	return fmt.Sprintf("ModelPrice: %f, ModelRatio: %f, CompletionRatio: %f, CacheRatio: %f, GroupRatio: %f, UsePrice: %t, CacheCreationRatio: %f, ShouldPreConsumedQuota: %d, ImageRatio: %f", p.ModelPrice, p.ModelRatio, p.CompletionRatio, p.CacheRatio, p.GroupRatio, p.UsePrice, p.CacheCreationRatio, p.ShouldPreConsumedQuota, p.ImageRatio)
+	return fmt.Sprintf("ModelPrice: %f, ModelRatio: %f, CompletionRatio: %f, CacheRatio: %f, GroupRatio: %f, UsePrice: %t, CacheCreationRatio: %f, ShouldPreConsumedQuota: %d, ImageRatio: %f, ScheduleMultiplier: %f", p.ModelPrice, p.ModelRatio, p.CompletionRatio, p.CacheRatio, p.GroupRatio, p.UsePrice, p.CacheCreationRatio, p.ShouldPreConsumedQuota, p.ImageRatio, p.ScheduleMultiplier)
 }
 
 func ModelPriceHelper(c *gin.Context, info *relaycommon.RelayInfo, promptTokens int, maxTokens int) (PriceData, error) {
 	modelPrice, usePrice := operation_setting.GetModelPrice(info.OriginModelName, false)
+	schedules, scheduleMultiplier := operation_setting.GetActivePricingSchedules(info.OriginModelName, info.Group, info.StartTime)
 	groupRatio := setting.GetGroupRatio(info.Group)
 	var preConsumedQuota int
 	var modelRatio float64
@@ -42,6 +50,12 @@ func ModelPriceHelper(c *gin.Context, info *relaycommon.RelayInfo, promptTokens
 		}
 		var success bool
 		modelRatio, success = operation_setting.GetModelRatio(info.OriginModelName)
+		tier, _, hasTier := operation_setting.GetModelRatioTier(info.OriginModelName, promptTokens)
+		if hasTier {
+			// 预扣费按预估的提示 token 数选择阶梯，结算时再按实际用量重新选择
+			modelRatio = tier.ModelRatio
+			success = true
+		}
 		if !success {
 			acceptUnsetRatio := false
 			if accept, ok := info.UserSetting[constant2.UserAcceptUnsetRatioModel]; ok {
@@ -58,10 +72,10 @@ func ModelPriceHelper(c *gin.Context, info *relaycommon.RelayInfo, promptTokens
 		cacheRatio, _ = operation_setting.GetCacheRatio(info.OriginModelName)
 		cacheCreationRatio, _ = operation_setting.GetCreateCacheRatio(info.OriginModelName)
 		imageRatio, _ = operation_setting.GetImageRatio(info.OriginModelName)
-		ratio := modelRatio * groupRatio
+		ratio := modelRatio * groupRatio * scheduleMultiplier
 		preConsumedQuota = int(float64(preConsumedTokens) * ratio)
 	} else {
-		preConsumedQuota = int(modelPrice * common.QuotaPerUnit * groupRatio)
+		preConsumedQuota = int(modelPrice * common.QuotaPerUnit * groupRatio * scheduleMultiplier)
 	}
 
 	priceData := PriceData{
@@ -74,6 +88,11 @@ func ModelPriceHelper(c *gin.Context, info *relaycommon.RelayInfo, promptTokens
 		ImageRatio:             imageRatio,
 		CacheCreationRatio:     cacheCreationRatio,
 		ShouldPreConsumedQuota: preConsumedQuota,
+		TierIndex:              -1,
+		ScheduleMultiplier:     scheduleMultiplier,
+	}
+	for _, schedule := range schedules {
+		priceData.ScheduleNames = append(priceData.ScheduleNames, schedule.Name)
 	}
 
 	if common.DebugEnabled {
@@ -83,6 +102,48 @@ func ModelPriceHelper(c *gin.Context, info *relaycommon.RelayInfo, promptTokens
 	return priceData, nil
 }
 
+// ApplyModelRatioTier 按实际提示 token 数选择阶梯倍率，覆盖模型、补全及缓存倍率
+func (p *PriceData) ApplyModelRatioTier(modelName string, promptTokens int) {
+	if p.UsePrice {
+		return
+	}
+	tier, index, ok := operation_setting.GetModelRatioTier(modelName, promptTokens)
+	if !ok {
+		return
+	}
+	p.TierIndex = index
+	p.Tier = tier
+	p.ModelRatio = tier.ModelRatio
+	if tier.CompletionRatio != nil {
+		p.CompletionRatio = *tier.CompletionRatio
+	}
+	if tier.CacheRatio != nil {
+		p.CacheRatio = *tier.CacheRatio
+	}
+	if tier.CacheCreationRatio != nil {
+		p.CacheCreationRatio = *tier.CacheCreationRatio
+	}
+}
+
+// TierLogContent 返回消费日志中的阶梯倍率说明，未使用阶梯倍率时为空
+func (p PriceData) TierLogContent() string {
+	if p.TierIndex < 0 {
+		return ""
+	}
+	if p.Tier.MaxPromptTokens == 0 {
+		return fmt.Sprintf("，阶梯倍率第 %d 档（提示不设上限）", p.TierIndex+1)
+	}
+	return fmt.Sprintf("，阶梯倍率第 %d 档（提示 ≤ %d tokens）", p.TierIndex+1, p.Tier.MaxPromptTokens)
+}
+
+// ScheduleLogContent 返回消费日志中的定价时段说明，没有生效的时段时为空
+func (p PriceData) ScheduleLogContent() string {
+	if len(p.ScheduleNames) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("，定价时段 %s 倍率 %.2f", strings.Join(p.ScheduleNames, "、"), p.ScheduleMultiplier)
+}
+
 func ContainPriceOrRatio(modelName string) bool {
 	_, ok := operation_setting.GetModelPrice(modelName, false)
 	if ok {
@@ -92,5 +153,9 @@ func ContainPriceOrRatio(modelName string) bool {
 	if ok {
 		return true
 	}
+	_, ok = operation_setting.GetModelTieredRatios(modelName)
+	if ok {
+		return true
+	}
 	return false
 }
@@ -3,6 +3,7 @@ package helper
 import (
 	"bufio"
 	"context"
+	"errors"
 	"io"
 	"net/http"
 	"one-api/common"
@@ -129,7 +130,8 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 		}
 
 		if err := scanner.Err(); err != nil {
-			if err != io.EOF {
+			// 输出被过滤停止时会主动取消上游请求
+			if err != io.EOF && !errors.Is(err, context.Canceled) {
 				common.LogError(c, "scanner error: "+err.Error())
 			}
 		}
@@ -56,6 +56,10 @@ func getAndValidAudioRequest(c *gin.Context, info *relaycommon.RelayInfo) (*dto.
 
 func AudioHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 	relayInfo := relaycommon.GenRelayInfo(c)
+
+	if err := service.CheckPIIRedactionUnsupported(relayInfo.ChannelId, relayInfo.Group); err != nil {
+		return service.OpenAIErrorWrapperLocal(err, "pii_redaction_unsupported", http.StatusBadRequest)
+	}
 	audioRequest, err := getAndValidAudioRequest(c, relayInfo)
 
 	if err != nil {
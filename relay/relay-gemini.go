@@ -136,6 +136,12 @@ func GeminiHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 
 	adaptor.Init(relayInfo)
 
+	// 个人信息脱敏策略，在发送到上游前替换
+	piiRedactor := service.NewPIIRedactor(relayInfo.ChannelId, relayInfo.Group)
+	if piiRedactor != nil {
+		redactGeminiRequest(piiRedactor, req)
+	}
+
 	requestBody, err := json.Marshal(req)
 	if err != nil {
 		return service.OpenAIErrorWrapperLocal(err, "marshal_text_request_failed", http.StatusInternalServerError)
@@ -147,6 +153,23 @@ func GeminiHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 		return service.OpenAIErrorWrapperLocal(err, "do_request_failed", http.StatusInternalServerError)
 	}
 
+	if sensitiveWriter := startSensitiveFilter(c, relaycommon.RelayFormatGemini); sensitiveWriter != nil {
+		defer func() {
+			sensitiveWriter.Finish()
+			c.Writer = sensitiveWriter.ResponseWriter
+		}()
+	}
+
+	if piiRedactor != nil && piiRedactor.HasRedactions() {
+		relayInfo.PIIRedactions = piiRedactor.Counts()
+		logPIIRedactions(c, relayInfo.PIIRedactions)
+		piiWriter := startPIIRestore(c, piiRedactor, relaycommon.RelayFormatGemini)
+		defer func() {
+			piiWriter.Finish()
+			c.Writer = piiWriter.ResponseWriter
+		}()
+	}
+
 	usage, openaiErr := adaptor.DoResponse(c, resp.(*http.Response), relayInfo)
 	if openaiErr != nil {
 		return openaiErr
@@ -9,7 +9,6 @@ import (
 	"net/http"
 	"one-api/common"
 	"one-api/dto"
-	"one-api/model"
 	relaycommon "one-api/relay/common"
 	relayconstant "one-api/relay/constant"
 	"one-api/relay/helper"
@@ -104,6 +103,10 @@ func getAndValidImageRequest(c *gin.Context, info *relaycommon.RelayInfo) (*dto.
 func ImageHelper(c *gin.Context) *dto.OpenAIErrorWithStatusCode {
 	relayInfo := relaycommon.GenRelayInfo(c)
 
+	if err := service.CheckPIIRedactionUnsupported(relayInfo.ChannelId, relayInfo.Group); err != nil {
+		return service.OpenAIErrorWrapperLocal(err, "pii_redaction_unsupported", http.StatusBadRequest)
+	}
+
 	imageRequest, err := getAndValidImageRequest(c, relayInfo)
 	if err != nil {
 		common.LogError(c, fmt.Sprintf("getAndValidImageRequest failed: %s", err.Error()))
@@ -162,12 +165,19 @@ func ImageHelper(c *gin.Context) *dto.OpenAIErrorWithStatusCode {
 
 		// reset model price
 		priceData.ModelPrice *= sizeRatio * qualityRatio * float64(imageRequest.N)
-		quota = int(priceData.ModelPrice * priceData.GroupRatio * common.QuotaPerUnit)
-		userQuota, err = model.GetUserQuota(relayInfo.UserId, false)
+		quota = int(priceData.ModelPrice * priceData.GroupRatio * priceData.ScheduleMultiplier * common.QuotaPerUnit)
+		if err = service.CheckTokenMaxPrice(c, quota); err != nil {
+			return service.OpenAIErrorWrapperLocal(err, "token_max_price_exceeded", http.StatusForbidden)
+		}
+		userQuota, err = service.GetPayerQuota(relayInfo)
 		if err != nil {
 			return service.OpenAIErrorWrapperLocal(err, "get_user_quota_failed", http.StatusInternalServerError)
 		}
-		if userQuota-quota < 0 {
+		subscriptionQuota, err := service.GetSubscriptionRemainQuota(relayInfo, quota)
+		if err != nil {
+			return service.OpenAIErrorWrapperLocal(err, "insufficient_subscription_quota", http.StatusForbidden)
+		}
+		if userQuota+subscriptionQuota-quota < 0 {
 			return service.OpenAIErrorWrapperLocal(fmt.Errorf("image pre-consumed quota failed, user quota: %s, need quota: %s", common.FormatQuota(userQuota), common.FormatQuota(quota)), "insufficient_user_quota", http.StatusForbidden)
 		}
 	}
@@ -158,6 +158,16 @@ func coverMidjourneyTaskDto(c *gin.Context, originTask *model.Midjourney) (midjo
 	return
 }
 
+// recordMidjourneySubscriptionQuota 记录套餐承担以及未向用户收取的额度，任务未保存时跳过
+func recordMidjourneySubscriptionQuota(midjourneyTask *model.Midjourney, coveredQuota int) {
+	if coveredQuota <= 0 || midjourneyTask == nil || midjourneyTask.Id == 0 {
+		return
+	}
+	if err := midjourneyTask.UpdateSubscriptionQuota(coveredQuota); err != nil {
+		common.SysError("error update midjourney subscription quota: " + err.Error())
+	}
+}
+
 func RelaySwapFace(c *gin.Context) *dto.MidjourneyResponse {
 	startTime := time.Now().UnixNano() / int64(time.Millisecond)
 	tokenId := c.GetInt("token_id")
@@ -165,6 +175,9 @@ func RelaySwapFace(c *gin.Context) *dto.MidjourneyResponse {
 	group := c.GetString("group")
 	channelId := c.GetInt("channel_id")
 	relayInfo := relaycommon.GenRelayInfo(c)
+	if err := service.CheckPIIRedactionUnsupported(channelId, group); err != nil {
+		return service.MidjourneyErrorWrapper(constant.MjRequestError, "pii_redaction_unsupported")
+	}
 	var swapFaceRequest dto.SwapFaceRequest
 	err := common.UnmarshalBodyReusable(c, &swapFaceRequest)
 	if err != nil {
@@ -184,9 +197,10 @@ func RelaySwapFace(c *gin.Context) *dto.MidjourneyResponse {
 			modelPrice = defaultPrice
 		}
 	}
+	_, scheduleMultiplier := operation_setting.GetActivePricingSchedules(modelName, group, time.Now())
 	groupRatio := setting.GetGroupRatio(group)
-	ratio := modelPrice * groupRatio
-	userQuota, err := model.GetUserQuota(userId, false)
+	ratio := modelPrice * groupRatio * scheduleMultiplier
+	userQuota, err := service.GetPayerQuota(relayInfo)
 	if err != nil {
 		return &dto.MidjourneyResponse{
 			Code:        4,
@@ -194,8 +208,28 @@ func RelaySwapFace(c *gin.Context) *dto.MidjourneyResponse {
 		}
 	}
 	quota := int(ratio * common.QuotaPerUnit)
+	if err = service.CheckTokenMaxPrice(c, quota); err != nil {
+		return &dto.MidjourneyResponse{
+			Code:        4,
+			Description: err.Error(),
+		}
+	}
+	relayInfo.OriginModelName = modelName
+	if err = service.CheckTokenBudget(relayInfo, quota); err != nil {
+		return &dto.MidjourneyResponse{
+			Code:        4,
+			Description: err.Error(),
+		}
+	}
+	subscriptionQuota, err := service.GetSubscriptionRemainQuota(relayInfo, quota)
+	if err != nil {
+		return &dto.MidjourneyResponse{
+			Code:        4,
+			Description: err.Error(),
+		}
+	}
 
-	if userQuota-quota < 0 {
+	if userQuota+subscriptionQuota-quota < 0 {
 		return &dto.MidjourneyResponse{
 			Code:        4,
 			Description: "quota_not_enough",
@@ -208,12 +242,14 @@ func RelaySwapFace(c *gin.Context) *dto.MidjourneyResponse {
 	if err != nil {
 		return &mjResp.Response
 	}
+	var midjourneyTask *model.Midjourney
 	defer func() {
 		if mjResp.StatusCode == 200 && mjResp.Response.Code == 1 {
-			err := service.PostConsumeQuota(relayInfo, quota, 0, true)
+			coveredQuota, err := service.PostConsumeQuotaWithSubscription(relayInfo, quota, 0, true)
 			if err != nil {
 				common.SysError("error consuming token remain quota: " + err.Error())
 			}
+			recordMidjourneySubscriptionQuota(midjourneyTask, coveredQuota+relayInfo.SubscriptionWaivedQuota)
 			//err = model.CacheUpdateUserQuota(userId)
 			if err != nil {
 				common.SysError("error update user quota cache: " + err.Error())
@@ -224,6 +260,14 @@ func RelaySwapFace(c *gin.Context) *dto.MidjourneyResponse {
 				other := make(map[string]interface{})
 				other["model_price"] = modelPrice
 				other["group_ratio"] = groupRatio
+				if scheduleMultiplier != 1 {
+					logContent += fmt.Sprintf("，定价时段倍率 %.2f", scheduleMultiplier)
+					other["pricing_schedule_multiplier"] = scheduleMultiplier
+				}
+				if coveredQuota > 0 {
+					logContent += fmt.Sprintf("，套餐额度抵扣 %s", common.LogQuota(coveredQuota))
+				}
+				service.AppendSubscriptionInfo(other, relayInfo, coveredQuota)
 				model.RecordConsumeLog(c, userId, channelId, 0, 0, modelName, tokenName,
 					quota, logContent, tokenId, userQuota, 0, false, group, other)
 				model.UpdateUserUsedQuotaAndRequestCount(userId, quota)
@@ -233,24 +277,26 @@ func RelaySwapFace(c *gin.Context) *dto.MidjourneyResponse {
 		}
 	}()
 	midjResponse := &mjResp.Response
-	midjourneyTask := &model.Midjourney{
-		UserId:      userId,
-		Code:        midjResponse.Code,
-		Action:      constant.MjActionSwapFace,
-		MjId:        midjResponse.Result,
-		Prompt:      "InsightFace",
-		PromptEn:    "",
-		Description: midjResponse.Description,
-		State:       "",
-		SubmitTime:  startTime,
-		StartTime:   time.Now().UnixNano() / int64(time.Millisecond),
-		FinishTime:  0,
-		ImageUrl:    "",
-		Status:      "",
-		Progress:    "0%",
-		FailReason:  "",
-		ChannelId:   c.GetInt("channel_id"),
-		Quota:       quota,
+	midjourneyTask = &model.Midjourney{
+		UserId:         userId,
+		OrgId:          relayInfo.OrgId,
+		FundingTokenId: relayInfo.GetFundingTokenId(),
+		Code:           midjResponse.Code,
+		Action:         constant.MjActionSwapFace,
+		MjId:           midjResponse.Result,
+		Prompt:         "InsightFace",
+		PromptEn:       "",
+		Description:    midjResponse.Description,
+		State:          "",
+		SubmitTime:     startTime,
+		StartTime:      time.Now().UnixNano() / int64(time.Millisecond),
+		FinishTime:     0,
+		ImageUrl:       "",
+		Status:         "",
+		Progress:       "0%",
+		FailReason:     "",
+		ChannelId:      c.GetInt("channel_id"),
+		Quota:          quota,
 	}
 	err = midjourneyTask.Insert()
 	if err != nil {
@@ -282,6 +328,9 @@ func RelayMidjourneyTaskImageSeed(c *gin.Context) *dto.MidjourneyResponse {
 	if channel.Status != common.ChannelStatusEnabled {
 		return service.MidjourneyErrorWrapper(constant.MjRequestError, "该任务所属渠道已被禁用")
 	}
+	if err = channel.KeyError(); err != nil {
+		return service.MidjourneyErrorWrapper(constant.MjRequestError, err.Error())
+	}
 	c.Set("channel_id", originTask.ChannelId)
 	c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", channel.Key))
 
@@ -377,6 +426,9 @@ func RelayMidjourneySubmit(c *gin.Context, relayMode int) *dto.MidjourneyRespons
 	group := c.GetString("group")
 	channelId := c.GetInt("channel_id")
 	relayInfo := relaycommon.GenRelayInfo(c)
+	if err := service.CheckPIIRedactionUnsupported(channelId, group); err != nil {
+		return service.MidjourneyErrorWrapper(constant.MjRequestError, "pii_redaction_unsupported")
+	}
 	consumeQuota := true
 	var midjRequest dto.MidjourneyRequest
 	err := common.UnmarshalBodyReusable(c, &midjRequest)
@@ -451,6 +503,9 @@ func RelayMidjourneySubmit(c *gin.Context, relayMode int) *dto.MidjourneyRespons
 			if channel.Status != common.ChannelStatusEnabled {
 				return service.MidjourneyErrorWrapper(constant.MjRequestError, "该任务所属渠道已被禁用")
 			}
+			if err = channel.KeyError(); err != nil {
+				return service.MidjourneyErrorWrapper(constant.MjRequestError, err.Error())
+			}
 			c.Set("base_url", channel.GetBaseURL())
 			c.Set("channel_id", originTask.ChannelId)
 			c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", channel.Key))
@@ -490,9 +545,10 @@ func RelayMidjourneySubmit(c *gin.Context, relayMode int) *dto.MidjourneyRespons
 			modelPrice = defaultPrice
 		}
 	}
+	_, scheduleMultiplier := operation_setting.GetActivePricingSchedules(modelName, group, time.Now())
 	groupRatio := setting.GetGroupRatio(group)
-	ratio := modelPrice * groupRatio
-	userQuota, err := model.GetUserQuota(userId, false)
+	ratio := modelPrice * groupRatio * scheduleMultiplier
+	userQuota, err := service.GetPayerQuota(relayInfo)
 	if err != nil {
 		return &dto.MidjourneyResponse{
 			Code:        4,
@@ -500,8 +556,31 @@ func RelayMidjourneySubmit(c *gin.Context, relayMode int) *dto.MidjourneyRespons
 		}
 	}
 	quota := int(ratio * common.QuotaPerUnit)
+	subscriptionQuota := 0
+	if consumeQuota {
+		if err = service.CheckTokenMaxPrice(c, quota); err != nil {
+			return &dto.MidjourneyResponse{
+				Code:        4,
+				Description: err.Error(),
+			}
+		}
+		relayInfo.OriginModelName = modelName
+		if err = service.CheckTokenBudget(relayInfo, quota); err != nil {
+			return &dto.MidjourneyResponse{
+				Code:        4,
+				Description: err.Error(),
+			}
+		}
+		subscriptionQuota, err = service.GetSubscriptionRemainQuota(relayInfo, quota)
+		if err != nil {
+			return &dto.MidjourneyResponse{
+				Code:        4,
+				Description: err.Error(),
+			}
+		}
+	}
 
-	if consumeQuota && userQuota-quota < 0 {
+	if consumeQuota && userQuota+subscriptionQuota-quota < 0 {
 		return &dto.MidjourneyResponse{
 			Code:        4,
 			Description: "quota_not_enough",
@@ -514,18 +593,28 @@ func RelayMidjourneySubmit(c *gin.Context, relayMode int) *dto.MidjourneyRespons
 	}
 	midjResponse := &midjResponseWithStatus.Response
 
+	var midjourneyTask *model.Midjourney
 	defer func() {
 		if consumeQuota && midjResponseWithStatus.StatusCode == 200 {
-			err := service.PostConsumeQuota(relayInfo, quota, 0, true)
+			coveredQuota, err := service.PostConsumeQuotaWithSubscription(relayInfo, quota, 0, true)
 			if err != nil {
 				common.SysError("error consuming token remain quota: " + err.Error())
 			}
+			recordMidjourneySubscriptionQuota(midjourneyTask, coveredQuota+relayInfo.SubscriptionWaivedQuota)
 			if quota != 0 {
 				tokenName := c.GetString("token_name")
 				logContent := fmt.Sprintf("模型固定价格 %.2f，分组倍率 %.2f，操作 %s，ID %s", modelPrice, groupRatio, midjRequest.Action, midjResponse.Result)
 				other := make(map[string]interface{})
 				other["model_price"] = modelPrice
 				other["group_ratio"] = groupRatio
+				if scheduleMultiplier != 1 {
+					logContent += fmt.Sprintf("，定价时段倍率 %.2f", scheduleMultiplier)
+					other["pricing_schedule_multiplier"] = scheduleMultiplier
+				}
+				if coveredQuota > 0 {
+					logContent += fmt.Sprintf("，套餐额度抵扣 %s", common.LogQuota(coveredQuota))
+				}
+				service.AppendSubscriptionInfo(other, relayInfo, coveredQuota)
 				model.RecordConsumeLog(c, userId, channelId, 0, 0, modelName, tokenName,
 					quota, logContent, tokenId, userQuota, 0, false, group, other)
 				model.UpdateUserUsedQuotaAndRequestCount(userId, quota)
@@ -542,24 +631,26 @@ func RelayMidjourneySubmit(c *gin.Context, relayMode int) *dto.MidjourneyRespons
 	// 23-队列已满，请稍后再试 {"code":23,"description":"队列已满，请稍后尝试","result":"14001929738841620","properties":{"discordInstanceId":"1118138338562560102"}}
 	// 24-prompt包含敏感词 {"code":24,"description":"可能包含敏感词","properties":{"promptEn":"nude body","bannedWord":"nude"}}
 	// other: 提交错误，description为错误描述
-	midjourneyTask := &model.Midjourney{
-		UserId:      userId,
-		Code:        midjResponse.Code,
-		Action:      midjRequest.Action,
-		MjId:        midjResponse.Result,
-		Prompt:      midjRequest.Prompt,
-		PromptEn:    "",
-		Description: midjResponse.Description,
-		State:       "",
-		SubmitTime:  time.Now().UnixNano() / int64(time.Millisecond),
-		StartTime:   0,
-		FinishTime:  0,
-		ImageUrl:    "",
-		Status:      "",
-		Progress:    "0%",
-		FailReason:  "",
-		ChannelId:   c.GetInt("channel_id"),
-		Quota:       quota,
+	midjourneyTask = &model.Midjourney{
+		UserId:         userId,
+		OrgId:          relayInfo.OrgId,
+		FundingTokenId: relayInfo.GetFundingTokenId(),
+		Code:           midjResponse.Code,
+		Action:         midjRequest.Action,
+		MjId:           midjResponse.Result,
+		Prompt:         midjRequest.Prompt,
+		PromptEn:       "",
+		Description:    midjResponse.Description,
+		State:          "",
+		SubmitTime:     time.Now().UnixNano() / int64(time.Millisecond),
+		StartTime:      0,
+		FinishTime:     0,
+		ImageUrl:       "",
+		Status:         "",
+		Progress:       "0%",
+		FailReason:     "",
+		ChannelId:      c.GetInt("channel_id"),
+		Quota:          quota,
 	}
 	if midjResponse.Code == 3 {
 		//无实例账号自动禁用渠道（No available account instance）
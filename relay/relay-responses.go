@@ -98,14 +98,27 @@ func ResponsesHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode)
 		return service.OpenAIErrorWrapperLocal(fmt.Errorf("invalid api type: %d", relayInfo.ApiType), "invalid_api_type", http.StatusBadRequest)
 	}
 	adaptor.Init(relayInfo)
+	// 个人信息脱敏策略，在转换为上游格式前替换
+	piiRedactor := service.NewPIIRedactor(relayInfo.ChannelId, relayInfo.Group)
 	var requestBody io.Reader
 	if model_setting.GetGlobalSettings().PassThroughRequestEnabled {
 		body, err := common.GetRequestBody(c)
 		if err != nil {
 			return service.OpenAIErrorWrapperLocal(err, "get_request_body_error", http.StatusInternalServerError)
 		}
+		if piiRedactor != nil {
+			body, err = redactPassThroughBody(piiRedactor, body)
+			if err != nil {
+				return service.OpenAIErrorWrapperLocal(err, "pii_redaction_failed", http.StatusBadRequest)
+			}
+		}
 		requestBody = bytes.NewBuffer(body)
 	} else {
+		if piiRedactor != nil {
+			if err := redactResponsesRequest(piiRedactor, req); err != nil {
+				return service.OpenAIErrorWrapperLocal(err, "pii_redaction_failed", http.StatusBadRequest)
+			}
+		}
 		convertedRequest, err := adaptor.ConvertOpenAIResponsesRequest(c, relayInfo, *req)
 		if err != nil {
 			return service.OpenAIErrorWrapperLocal(err, "convert_request_error", http.StatusBadRequest)
@@ -155,6 +168,23 @@ func ResponsesHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode)
 		}
 	}
 
+	if sensitiveWriter := startSensitiveFilter(c, sensitiveFormatResponses); sensitiveWriter != nil {
+		defer func() {
+			sensitiveWriter.Finish()
+			c.Writer = sensitiveWriter.ResponseWriter
+		}()
+	}
+
+	if piiRedactor != nil && piiRedactor.HasRedactions() {
+		relayInfo.PIIRedactions = piiRedactor.Counts()
+		logPIIRedactions(c, relayInfo.PIIRedactions)
+		piiWriter := startPIIRestore(c, piiRedactor, sensitiveFormatResponses)
+		defer func() {
+			piiWriter.Finish()
+			c.Writer = piiWriter.ResponseWriter
+		}()
+	}
+
 	usage, openaiErr := adaptor.DoResponse(c, httpResp, relayInfo)
 	if openaiErr != nil {
 		// reset status code 重置状态码
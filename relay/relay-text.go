@@ -141,6 +141,7 @@ func TextHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 	defer func() {
 		if openaiErr != nil {
 			returnPreConsumedQuota(c, relayInfo, userQuota, preConsumedQuota)
+			settleSemanticCacheEmbedding(c, relayInfo)
 		}
 	}()
 	includeUsage := false
@@ -165,6 +166,38 @@ func TextHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 		relayInfo.ShouldIncludeUsage = true
 	}
 
+	// 精确匹配响应缓存，仅用于 temperature 为 0 的确定性请求
+	var cacheKey string
+	if textRequest.Temperature != nil && *textRequest.Temperature == 0 && textRequest.N <= 1 {
+		cacheKey = getResponseCacheKey(c, relayInfo)
+	}
+	if cacheKey != "" {
+		if usage, ok := replayResponseCache(c, relayInfo, cacheKey); ok {
+			postConsumeQuota(c, relayInfo, usage, preConsumedQuota, userQuota, priceData, "")
+			return nil
+		}
+	}
+	// 个人信息脱敏策略，语义缓存需要将提问发送给向量模型，启用脱敏的请求不使用语义缓存
+	piiRedactor := service.NewPIIRedactor(relayInfo.ChannelId, relayInfo.Group)
+	// 语义缓存，按最后一轮用户提问的相似度匹配
+	var semanticQuery *semanticCacheQuery
+	if piiRedactor == nil {
+		semanticQuery = getSemanticCacheQuery(c, relayInfo, textRequest)
+	}
+	if semanticQuery != nil {
+		if usage, ok := replaySemanticCache(c, relayInfo, semanticQuery); ok {
+			postConsumeQuota(c, relayInfo, usage, preConsumedQuota, userQuota, priceData, "")
+			return nil
+		}
+	}
+	var cacheWriter *responseCaptureWriter
+	if cacheKey != "" || semanticQuery != nil {
+		cacheWriter = startResponseCapture(c)
+		defer func() {
+			c.Writer = cacheWriter.ResponseWriter
+		}()
+	}
+
 	adaptor := GetAdaptor(relayInfo.ApiType)
 	if adaptor == nil {
 		return service.OpenAIErrorWrapperLocal(fmt.Errorf("invalid api type: %d", relayInfo.ApiType), "invalid_api_type", http.StatusBadRequest)
@@ -177,8 +210,19 @@ func TextHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 		if err != nil {
 			return service.OpenAIErrorWrapperLocal(err, "get_request_body_failed", http.StatusInternalServerError)
 		}
+		if piiRedactor != nil {
+			body, err = redactPassThroughBody(piiRedactor, body)
+			if err != nil {
+				return service.OpenAIErrorWrapperLocal(err, "pii_redaction_failed", http.StatusBadRequest)
+			}
+		}
 		requestBody = bytes.NewBuffer(body)
 	} else {
+		if piiRedactor != nil {
+			if err := redactTextRequest(piiRedactor, textRequest); err != nil {
+				return service.OpenAIErrorWrapperLocal(err, "pii_redaction_failed", http.StatusBadRequest)
+			}
+		}
 		convertedRequest, err := adaptor.ConvertOpenAIRequest(c, relayInfo, textRequest)
 		if err != nil {
 			return service.OpenAIErrorWrapperLocal(err, "convert_request_failed", http.StatusInternalServerError)
@@ -210,6 +254,26 @@ func TextHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 		requestBody = bytes.NewBuffer(jsonData)
 	}
 
+	// 输出敏感词过滤在个人信息还原之后进行，缓存保存的是过滤后的响应
+	sensitiveWriter := startSensitiveFilter(c, relayInfo.RelayFormat)
+	if sensitiveWriter != nil {
+		defer func() {
+			sensitiveWriter.Finish()
+			c.Writer = sensitiveWriter.ResponseWriter
+		}()
+	}
+
+	var piiWriter *sseRewriteWriter
+	if piiRedactor != nil && piiRedactor.HasRedactions() {
+		relayInfo.PIIRedactions = piiRedactor.Counts()
+		logPIIRedactions(c, relayInfo.PIIRedactions)
+		piiWriter = startPIIRestore(c, piiRedactor, relayInfo.RelayFormat)
+		defer func() {
+			piiWriter.Finish()
+			c.Writer = piiWriter.ResponseWriter
+		}()
+	}
+
 	var httpResp *http.Response
 	resp, err := adaptor.DoRequest(c, relayInfo, requestBody)
 
@@ -236,6 +300,20 @@ func TextHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 		service.ResetStatusCode(openaiErr, statusCodeMappingStr)
 		return openaiErr
 	}
+	if piiWriter != nil {
+		// 先输出还原后的完整响应，再保存响应缓存
+		piiWriter.Finish()
+	}
+	if sensitiveWriter != nil {
+		sensitiveWriter.Finish()
+	}
+
+	if cacheKey != "" {
+		saveResponseCache(c, relayInfo, cacheKey, cacheWriter, usage.(*dto.Usage))
+	}
+	if semanticQuery != nil {
+		saveSemanticCache(c, relayInfo, semanticQuery, cacheWriter, usage.(*dto.Usage))
+	}
 
 	if strings.HasPrefix(relayInfo.OriginModelName, "gpt-4o-audio") {
 		service.PostAudioConsumeQuota(c, relayInfo, usage.(*dto.Usage), preConsumedQuota, userQuota, priceData, "")
@@ -281,20 +359,44 @@ func checkRequestSensitive(textRequest *dto.GeneralOpenAIRequest, info *relaycom
 	return words, err
 }
 
-// 预扣费并返回用户剩余配额
+// 预扣费并返回用户剩余配额，同时按提示 token 数扣减 TPM 限制，预扣费失败时退还
 func preConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommon.RelayInfo) (int, int, *dto.OpenAIErrorWithStatusCode) {
-	userQuota, err := model.GetUserQuota(relayInfo.UserId, false)
+	if err := service.CheckTokenMaxPrice(c, preConsumedQuota); err != nil {
+		return 0, 0, service.OpenAIErrorWrapperLocal(err, "token_max_price_exceeded", http.StatusForbidden)
+	}
+	if err := service.PreConsumeTPM(c, relayInfo); err != nil {
+		return 0, 0, service.OpenAIErrorWrapperLocal(err, "rate_limit_exceeded", http.StatusTooManyRequests)
+	}
+	preConsumedQuota, userQuota, openaiErr := preConsumeUserQuota(c, preConsumedQuota, relayInfo)
+	if openaiErr != nil {
+		service.ReturnTPM(relayInfo)
+	}
+	return preConsumedQuota, userQuota, openaiErr
+}
+
+func preConsumeUserQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommon.RelayInfo) (int, int, *dto.OpenAIErrorWithStatusCode) {
+	estimatedQuota := preConsumedQuota
+	userQuota, err := service.GetPayerQuota(relayInfo)
 	if err != nil {
 		return 0, 0, service.OpenAIErrorWrapperLocal(err, "get_user_quota_failed", http.StatusInternalServerError)
 	}
-	if userQuota <= 0 {
+	// 订阅套餐额度优先于用户余额使用
+	subscriptionQuota, err := service.GetSubscriptionRemainQuota(relayInfo, preConsumedQuota)
+	if err != nil {
+		return 0, 0, service.OpenAIErrorWrapperLocal(err, "insufficient_subscription_quota", http.StatusForbidden)
+	}
+	if userQuota+subscriptionQuota <= 0 {
 		return 0, 0, service.OpenAIErrorWrapperLocal(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
 	}
-	if userQuota-preConsumedQuota < 0 {
+	if userQuota+subscriptionQuota-preConsumedQuota < 0 {
 		return 0, 0, service.OpenAIErrorWrapperLocal(fmt.Errorf("chat pre-consumed quota failed, user quota: %s, need quota: %s", common.FormatQuota(userQuota), common.FormatQuota(preConsumedQuota)), "insufficient_user_quota", http.StatusForbidden)
 	}
 	relayInfo.UserQuota = userQuota
-	if userQuota > 100*preConsumedQuota {
+	if subscriptionQuota > 0 && preConsumedQuota > 0 && (subscriptionQuota >= preConsumedQuota || userQuota < preConsumedQuota) {
+		// 套餐额度足以覆盖本次请求，或需要套餐额度补足用户余额时不预扣，结算时先从套餐扣除，用户余额只承担超出部分
+		preConsumedQuota = 0
+		common.LogInfo(c, fmt.Sprintf("user %d subscription quota %s is enough, no need to pre-consume", relayInfo.UserId, common.FormatQuota(subscriptionQuota)))
+	} else if userQuota > 100*preConsumedQuota {
 		// 用户额度充足，判断令牌额度是否充足
 		if !relayInfo.TokenUnlimited {
 			// 非无限令牌，判断令牌额度是否充足
@@ -312,12 +414,18 @@ func preConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommo
 		}
 	}
 
-	if preConsumedQuota > 0 {
-		err := service.PreConsumeTokenQuota(relayInfo, preConsumedQuota)
+	if preConsumedQuota > 0 || relayInfo.TokenBudgetPeriod != "" {
+		// 设置了周期预算的令牌即使无需预扣费也要检查预算
+		err := service.PreConsumeTokenQuota(relayInfo, preConsumedQuota, estimatedQuota)
 		if err != nil {
+			if errors.Is(err, service.ErrTokenBudgetExceeded) {
+				return 0, 0, service.OpenAIErrorWrapperLocal(err, "token_budget_exceeded", http.StatusForbidden)
+			}
 			return 0, 0, service.OpenAIErrorWrapperLocal(err, "pre_consume_token_quota_failed", http.StatusForbidden)
 		}
-		err = model.DecreaseUserQuota(relayInfo.UserId, preConsumedQuota)
+	}
+	if preConsumedQuota > 0 {
+		err = service.ChangePayerQuota(relayInfo, preConsumedQuota)
 		if err != nil {
 			return 0, 0, service.OpenAIErrorWrapperLocal(err, "decrease_user_quota_failed", http.StatusInternalServerError)
 		}
@@ -326,6 +434,7 @@ func preConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommo
 }
 
 func returnPreConsumedQuota(c *gin.Context, relayInfo *relaycommon.RelayInfo, userQuota int, preConsumedQuota int) {
+	service.ReturnTPM(relayInfo)
 	if preConsumedQuota != 0 {
 		gopool.Go(func() {
 			relayInfoCopy := *relayInfo
@@ -348,6 +457,8 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 		}
 		extraContent += "（可能是请求出错）"
 	}
+	service.PostConsumeTPM(relayInfo, usage.PromptTokens, usage.CompletionTokens)
+	priceData.ApplyModelRatioTier(relayInfo.OriginModelName, usage.PromptTokens)
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	promptTokens := usage.PromptTokens
 	cacheTokens := usage.PromptTokensDetails.CachedTokens
@@ -373,10 +484,11 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	dImageRatio := decimal.NewFromFloat(imageRatio)
 	dModelRatio := decimal.NewFromFloat(modelRatio)
 	dGroupRatio := decimal.NewFromFloat(groupRatio)
+	dScheduleMultiplier := decimal.NewFromFloat(priceData.ScheduleMultiplier)
 	dModelPrice := decimal.NewFromFloat(modelPrice)
 	dQuotaPerUnit := decimal.NewFromFloat(common.QuotaPerUnit)
 
-	ratio := dModelRatio.Mul(dGroupRatio)
+	ratio := dModelRatio.Mul(dGroupRatio).Mul(dScheduleMultiplier)
 
 	// openai web search 工具计费
 	var dWebSearchQuota decimal.Decimal
@@ -437,41 +549,67 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 			quotaCalculateDecimal = decimal.NewFromInt(1)
 		}
 	} else {
-		quotaCalculateDecimal = dModelPrice.Mul(dQuotaPerUnit).Mul(dGroupRatio)
+		quotaCalculateDecimal = dModelPrice.Mul(dQuotaPerUnit).Mul(dGroupRatio).Mul(dScheduleMultiplier)
 	}
 	// 添加 responses tools call 调用的配额
 	quotaCalculateDecimal = quotaCalculateDecimal.Add(dWebSearchQuota)
 	quotaCalculateDecimal = quotaCalculateDecimal.Add(dFileSearchQuota)
 
+	// 命中响应缓存或语义缓存时按缓存倍率计费
+	var responseCacheRatio float64
+	if relayInfo.ResponseCacheHit {
+		responseCacheRatio = operation_setting.GetResponseCacheSetting().CacheRatio
+		quotaCalculateDecimal = quotaCalculateDecimal.Mul(decimal.NewFromFloat(responseCacheRatio))
+		extraContent += fmt.Sprintf("命中响应缓存，缓存倍率 %.2f", responseCacheRatio)
+	} else if relayInfo.SemanticCacheHit {
+		responseCacheRatio = operation_setting.GetSemanticCacheSetting().CacheRatio
+		quotaCalculateDecimal = quotaCalculateDecimal.Mul(decimal.NewFromFloat(responseCacheRatio))
+		extraContent += fmt.Sprintf("命中语义缓存，缓存倍率 %.2f", responseCacheRatio)
+	}
+	// 语义缓存计算提问向量的费用不参与缓存倍率折扣
+	embeddingQuota := relayInfo.SemanticCacheEmbeddingQuota
+	if embeddingQuota > 0 {
+		quotaCalculateDecimal = quotaCalculateDecimal.Add(decimal.NewFromInt(int64(embeddingQuota)))
+		if extraContent != "" {
+			extraContent += "，"
+		}
+		extraContent += fmt.Sprintf("语义缓存向量费用 %s", common.LogQuota(embeddingQuota))
+	}
+
 	quota := int(quotaCalculateDecimal.Round(0).IntPart())
 	totalTokens := promptTokens + completionTokens
 
 	var logContent string
 	if !priceData.UsePrice {
 		logContent = fmt.Sprintf("模型倍率 %.2f，补全倍率 %.2f，分组倍率 %.2f", modelRatio, completionRatio, groupRatio)
+		logContent += priceData.TierLogContent()
 	} else {
 		logContent = fmt.Sprintf("模型价格 %.2f，分组倍率 %.2f", modelPrice, groupRatio)
 	}
+	logContent += priceData.ScheduleLogContent()
 
 	// record all the consume log even if quota is 0
 	if totalTokens == 0 {
 		// in this case, must be some error happened
 		// we cannot just return, because we may have to return the pre-consumed quota
-		quota = 0
+		quota = embeddingQuota
 		logContent += fmt.Sprintf("（可能是上游超时）")
 		common.LogError(ctx, fmt.Sprintf("total tokens is 0, cannot consume quota, userId %d, channelId %d, "+
 			"tokenId %d, model %s， pre-consumed quota %d", relayInfo.UserId, relayInfo.ChannelId, relayInfo.TokenId, modelName, preConsumedQuota))
 	} else {
 		model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, quota)
-		model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
+		if !relayInfo.ResponseCacheHit && !relayInfo.SemanticCacheHit {
+			model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
+		}
 	}
 
-	quotaDelta := quota - preConsumedQuota
-	if quotaDelta != 0 {
-		err := service.PostConsumeQuota(relayInfo, quotaDelta, preConsumedQuota, true)
-		if err != nil {
-			common.LogError(ctx, "error consuming token remain quota: "+err.Error())
-		}
+	// 订阅套餐优先承担费用，付费方只扣除超出套餐的部分
+	subscriptionQuota, err := service.PostConsumeQuotaWithSubscription(relayInfo, quota, preConsumedQuota, true)
+	if err != nil {
+		common.LogError(ctx, "error consuming token remain quota: "+err.Error())
+	}
+	if subscriptionQuota > 0 {
+		logContent += fmt.Sprintf("，套餐额度抵扣 %s", common.LogQuota(subscriptionQuota))
 	}
 
 	logModel := modelName
@@ -487,6 +625,9 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 		logContent += ", " + extraContent
 	}
 	other := service.GenerateTextOtherInfo(ctx, relayInfo, modelRatio, groupRatio, completionRatio, cacheTokens, cacheRatio, modelPrice)
+	service.AppendModelRatioTierInfo(other, priceData)
+	service.AppendPricingScheduleInfo(other, priceData)
+	service.AppendSubscriptionInfo(other, relayInfo, subscriptionQuota)
 	if imageTokens != 0 {
 		other["image"] = true
 		other["image_ratio"] = imageRatio
@@ -512,6 +653,16 @@ func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 			other["file_search_price"] = fileSearchPrice
 		}
 	}
+	if relayInfo.ResponseCacheHit {
+		other["response_cache_hit"] = true
+		other["response_cache_ratio"] = responseCacheRatio
+	} else if relayInfo.SemanticCacheHit {
+		other["semantic_cache_hit"] = true
+		other["response_cache_ratio"] = responseCacheRatio
+	}
+	if embeddingQuota > 0 {
+		other["semantic_cache_embedding_quota"] = embeddingQuota
+	}
 	model.RecordConsumeLog(ctx, relayInfo.UserId, relayInfo.ChannelId, promptTokens, completionTokens, logModel,
 		tokenName, quota, logContent, relayInfo.TokenId, userQuota, int(useTimeSeconds), relayInfo.IsStream, relayInfo.Group, other)
 }
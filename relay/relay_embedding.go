@@ -12,6 +12,7 @@ import (
 	relayconstant "one-api/relay/constant"
 	"one-api/relay/helper"
 	"one-api/service"
+	"one-api/setting/model_setting"
 )
 
 func getEmbeddingPromptToken(embeddingRequest dto.EmbeddingRequest) int {
@@ -53,6 +54,15 @@ func EmbeddingHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode)
 	}
 
 	embeddingRequest.Model = relayInfo.UpstreamModelName
+	// 向量结果不包含原文，脱敏后无需还原
+	if piiRedactor := service.NewPIIRedactor(relayInfo.ChannelId, relayInfo.Group); piiRedactor != nil {
+		embeddingRequest.Input = piiRedactor.RedactValue(embeddingRequest.Input)
+		if piiRedactor.HasRedactions() {
+			relayInfo.PIIRedactions = piiRedactor.Counts()
+			logPIIRedactions(c, relayInfo.PIIRedactions)
+		}
+	}
+	outputOptions := getEmbeddingOutputOptions(relayInfo, embeddingRequest)
 
 	promptToken := getEmbeddingPromptToken(*embeddingRequest)
 	relayInfo.PromptTokens = promptToken
@@ -72,36 +82,99 @@ func EmbeddingHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode)
 		}
 	}()
 
+	cacheKey := getResponseCacheKey(c, relayInfo)
+	var cacheWriter *responseCaptureWriter
+	if cacheKey != "" {
+		if usage, ok := replayResponseCache(c, relayInfo, cacheKey); ok {
+			postConsumeQuota(c, relayInfo, usage, preConsumedQuota, userQuota, priceData, "")
+			return nil
+		}
+		cacheWriter = startResponseCapture(c)
+		defer func() {
+			c.Writer = cacheWriter.ResponseWriter
+		}()
+	}
+
+	// 上游不支持的维度截断与编码格式在网关侧处理
+	var outputWriter *responseBufferWriter
+	if outputOptions != nil {
+		outputWriter = &responseBufferWriter{ResponseWriter: c.Writer}
+		c.Writer = outputWriter
+		defer func() {
+			c.Writer = outputWriter.ResponseWriter
+		}()
+	}
+
+	var usage *dto.Usage
+	var extraContent string
+	batched := false
+	// 按渠道最大批量拆分 input 数组 split large input arrays into batches
+	if inputs, ok := embeddingRequest.Input.([]any); ok && isEmbeddingInputList(inputs) && model_setting.GetEmbeddingSettings().BatchEnabled {
+		plan, err := getEmbeddingBatchPlan(c, relayInfo, len(inputs))
+		if err != nil {
+			common.LogError(c, fmt.Sprintf("get embedding batch plan failed: %s", err.Error()))
+		} else if plan != nil {
+			usage, extraContent, openaiErr = embeddingBatchHelper(c, relayInfo, *embeddingRequest, inputs, plan)
+			if openaiErr != nil {
+				return openaiErr
+			}
+			batched = true
+		}
+	}
+	if !batched {
+		usage, openaiErr = doEmbeddingRequest(c, relayInfo, *embeddingRequest)
+		if openaiErr != nil {
+			return openaiErr
+		}
+	}
+
+	if outputWriter != nil {
+		body, err := processEmbeddingOutput(outputWriter.body.Bytes(), outputOptions)
+		if err != nil {
+			return service.OpenAIErrorWrapper(err, "process_embedding_output_failed", http.StatusInternalServerError)
+		}
+		c.Writer = outputWriter.ResponseWriter
+		c.Writer.Header().Del("Content-Length")
+		c.Data(http.StatusOK, "application/json", body)
+	}
+	if cacheWriter != nil {
+		saveResponseCache(c, relayInfo, cacheKey, cacheWriter, usage)
+	}
+	postConsumeQuota(c, relayInfo, usage, preConsumedQuota, userQuota, priceData, extraContent)
+	return nil
+}
+
+func doEmbeddingRequest(c *gin.Context, relayInfo *relaycommon.RelayInfo, embeddingRequest dto.EmbeddingRequest) (*dto.Usage, *dto.OpenAIErrorWithStatusCode) {
 	adaptor := GetAdaptor(relayInfo.ApiType)
 	if adaptor == nil {
-		return service.OpenAIErrorWrapperLocal(fmt.Errorf("invalid api type: %d", relayInfo.ApiType), "invalid_api_type", http.StatusBadRequest)
+		return nil, service.OpenAIErrorWrapperLocal(fmt.Errorf("invalid api type: %d", relayInfo.ApiType), "invalid_api_type", http.StatusBadRequest)
 	}
 	adaptor.Init(relayInfo)
 
-	convertedRequest, err := adaptor.ConvertEmbeddingRequest(c, relayInfo, *embeddingRequest)
+	convertedRequest, err := adaptor.ConvertEmbeddingRequest(c, relayInfo, embeddingRequest)
 
 	if err != nil {
-		return service.OpenAIErrorWrapperLocal(err, "convert_request_failed", http.StatusInternalServerError)
+		return nil, service.OpenAIErrorWrapperLocal(err, "convert_request_failed", http.StatusInternalServerError)
 	}
 	jsonData, err := json.Marshal(convertedRequest)
 	if err != nil {
-		return service.OpenAIErrorWrapperLocal(err, "json_marshal_failed", http.StatusInternalServerError)
+		return nil, service.OpenAIErrorWrapperLocal(err, "json_marshal_failed", http.StatusInternalServerError)
 	}
 	requestBody := bytes.NewBuffer(jsonData)
 	statusCodeMappingStr := c.GetString("status_code_mapping")
 	resp, err := adaptor.DoRequest(c, relayInfo, requestBody)
 	if err != nil {
-		return service.OpenAIErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+		return nil, service.OpenAIErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
 	}
 
 	var httpResp *http.Response
 	if resp != nil {
 		httpResp = resp.(*http.Response)
 		if httpResp.StatusCode != http.StatusOK {
-			openaiErr = service.RelayErrorHandler(httpResp, false)
+			openaiErr := service.RelayErrorHandler(httpResp, false)
 			// reset status code 重置状态码
 			service.ResetStatusCode(openaiErr, statusCodeMappingStr)
-			return openaiErr
+			return nil, openaiErr
 		}
 	}
 
@@ -109,8 +182,7 @@ func EmbeddingHelper(c *gin.Context) (openaiErr *dto.OpenAIErrorWithStatusCode)
 	if openaiErr != nil {
 		// reset status code 重置状态码
 		service.ResetStatusCode(openaiErr, statusCodeMappingStr)
-		return openaiErr
+		return nil, openaiErr
 	}
-	postConsumeQuota(c, relayInfo, usage.(*dto.Usage), preConsumedQuota, userQuota, priceData, "")
-	return nil
+	return usage.(*dto.Usage), nil
 }
@@ -0,0 +1,380 @@
+package relay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"one-api/common"
+	"one-api/constant"
+	"one-api/dto"
+	"one-api/model"
+	relaycommon "one-api/relay/common"
+	relayconstant "one-api/relay/constant"
+	"one-api/relay/helper"
+	"one-api/service"
+	"one-api/setting/model_setting"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+// embeddingBatchItem 上游返回的单条 embedding，embedding 字段保持原样以兼容 float 与 base64 格式
+type embeddingBatchItem struct {
+	Object    string          `json:"object"`
+	Index     int             `json:"index"`
+	Embedding json.RawMessage `json:"embedding"`
+}
+
+type embeddingBatchResponse struct {
+	Object string               `json:"object"`
+	Data   []embeddingBatchItem `json:"data"`
+	Model  string               `json:"model"`
+	Usage  dto.Usage            `json:"usage"`
+}
+
+type embeddingChunk struct {
+	offset  int
+	input   []any
+	channel *model.Channel
+	result  *embeddingBatchResponse
+	err     *dto.OpenAIErrorWithStatusCode
+}
+
+// embeddingBatchPlan 描述一次分批请求：首轮分发使用的渠道、可用于重试的渠道以及批量大小
+type embeddingBatchPlan struct {
+	dispatch  []*model.Channel
+	pool      []*model.Channel
+	batchSize int
+}
+
+func getEmbeddingBatchSize(channel *model.Channel) int {
+	if size, ok := channel.GetSetting()[constant.ChannelSettingEmbeddingBatchSize].(float64); ok && size > 0 {
+		return int(size)
+	}
+	return model_setting.GetEmbeddingSettings().DefaultBatchSize
+}
+
+// isEmbeddingInputList input 数组的元素都是字符串或 token 数组时才是多条输入，
+// 单条 token 数组的元素是数字，不能拆分
+func isEmbeddingInputList(inputs []any) bool {
+	for _, input := range inputs {
+		switch input.(type) {
+		case string, []any:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// getEmbeddingBatchPlan 判断请求是否需要分批，不需要时返回 nil
+func getEmbeddingBatchPlan(c *gin.Context, info *relaycommon.RelayInfo, inputCount int) (*embeddingBatchPlan, error) {
+	primary, err := model.CacheGetChannel(info.ChannelId)
+	if err != nil {
+		return nil, err
+	}
+	plan := &embeddingBatchPlan{
+		dispatch: []*model.Channel{primary},
+		pool:     []*model.Channel{primary},
+	}
+	// 指定渠道时不分发也不切换渠道
+	if _, ok := c.Get("specific_channel_id"); !ok {
+		channels, err := model.CacheGetSatisfiedChannels(info.Group, info.OriginModelName)
+		if err == nil {
+			for _, channel := range channels {
+				if channel.Id == primary.Id {
+					continue
+				}
+				plan.pool = append(plan.pool, channel)
+				if model_setting.GetEmbeddingSettings().FanOutEnabled && channel.GetPriority() == primary.GetPriority() {
+					plan.dispatch = append(plan.dispatch, channel)
+				}
+			}
+		}
+	}
+	// 首轮分发的批次可能在任一渠道上执行，因此取最小的批量大小
+	for _, channel := range plan.dispatch {
+		size := getEmbeddingBatchSize(channel)
+		if size > 0 && (plan.batchSize == 0 || size < plan.batchSize) {
+			plan.batchSize = size
+		}
+	}
+	if plan.batchSize <= 0 || inputCount <= plan.batchSize {
+		return nil, nil
+	}
+	return plan, nil
+}
+
+// embeddingBatchHelper 将 input 数组按批量大小拆分后并发请求，按原顺序合并结果并汇总用量
+func embeddingBatchHelper(c *gin.Context, info *relaycommon.RelayInfo, request dto.EmbeddingRequest, inputs []any, plan *embeddingBatchPlan) (*dto.Usage, string, *dto.OpenAIErrorWithStatusCode) {
+	var chunks []*embeddingChunk
+	for offset := 0; offset < len(inputs); offset += plan.batchSize {
+		end := offset + plan.batchSize
+		if end > len(inputs) {
+			end = len(inputs)
+		}
+		chunks = append(chunks, &embeddingChunk{
+			offset:  offset,
+			input:   inputs[offset:end],
+			channel: plan.dispatch[len(chunks)%len(plan.dispatch)],
+		})
+	}
+
+	concurrency := model_setting.GetEmbeddingSettings().MaxConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, chunk := range chunks {
+		chunk := chunk
+		wg.Add(1)
+		sem <- struct{}{}
+		gopool.Go(func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			runEmbeddingChunk(c, info, request, chunk, plan.pool)
+		})
+	}
+	wg.Wait()
+
+	usage := &dto.Usage{}
+	data := make([]embeddingBatchItem, 0, len(inputs))
+	usedChannels := make([]string, 0, len(chunks))
+	responseModel := info.UpstreamModelName
+	for _, chunk := range chunks {
+		if chunk.err != nil {
+			return nil, "", chunk.err
+		}
+		for _, item := range chunk.result.Data {
+			item.Index += chunk.offset
+			data = append(data, item)
+		}
+		usage.PromptTokens += chunk.result.Usage.PromptTokens
+		usage.TotalTokens += chunk.result.Usage.TotalTokens
+		if chunk.result.Model != "" {
+			responseModel = chunk.result.Model
+		}
+		usedChannels = append(usedChannels, fmt.Sprintf("%d", chunk.channel.Id))
+	}
+	sort.SliceStable(data, func(i, j int) bool {
+		return data[i].Index < data[j].Index
+	})
+
+	c.JSON(http.StatusOK, embeddingBatchResponse{
+		Object: "list",
+		Data:   data,
+		Model:  responseModel,
+		Usage:  *usage,
+	})
+	extraContent := fmt.Sprintf("分批请求 %d 批，渠道 %s", len(chunks), strings.Join(usedChannels, ","))
+	return usage, extraContent, nil
+}
+
+// runEmbeddingChunk 执行单个批次，失败时在其他渠道上重试
+func runEmbeddingChunk(c *gin.Context, info *relaycommon.RelayInfo, request dto.EmbeddingRequest, chunk *embeddingChunk, pool []*model.Channel) {
+	tried := make(map[int]bool)
+	channel := chunk.channel
+	for i := 0; ; i++ {
+		tried[channel.Id] = true
+		chunk.channel = channel
+		chunk.result, chunk.err = doEmbeddingChunkRequest(c, info, channel, info.OriginModelName, request, chunk.input)
+		if chunk.err == nil {
+			return
+		}
+		common.LogError(c, fmt.Sprintf("embedding batch error (channel #%d, status code: %d): %s", channel.Id, chunk.err.StatusCode, chunk.err.Error.Message))
+		if service.ShouldDisableChannel(channel.Type, chunk.err) && channel.GetAutoBan() {
+			service.DisableChannel(channel.Id, channel.Name, chunk.err.Error.Message)
+		}
+		if chunk.err.LocalError || i >= model_setting.GetEmbeddingSettings().ChunkRetryTimes {
+			return
+		}
+		channel = pickEmbeddingRetryChannel(pool, tried, len(chunk.input))
+		if channel == nil {
+			return
+		}
+	}
+}
+
+func pickEmbeddingRetryChannel(pool []*model.Channel, tried map[int]bool, inputCount int) *model.Channel {
+	for _, channel := range pool {
+		if tried[channel.Id] {
+			continue
+		}
+		if size := getEmbeddingBatchSize(channel); size > 0 && size < inputCount {
+			continue
+		}
+		return channel
+	}
+	return nil
+}
+
+// newEmbeddingChunkInfo 复制原请求的 RelayInfo，并替换为批次使用的渠道
+func newEmbeddingChunkInfo(info *relaycommon.RelayInfo, channel *model.Channel, modelName string) (*relaycommon.RelayInfo, error) {
+	subInfo := *info
+	subInfo.RelayMode = relayconstant.RelayModeEmbeddings
+	subInfo.RequestURLPath = "/v1/embeddings"
+	subInfo.IsStream = false
+	subInfo.OriginModelName = modelName
+	subInfo.ChannelId = channel.Id
+	subInfo.ChannelType = channel.Type
+	subInfo.ChannelCreateTime = channel.CreatedTime
+	subInfo.ChannelSetting = channel.GetSetting()
+	subInfo.ParamOverride = channel.GetParamOverride()
+	subInfo.ApiType, _ = relayconstant.ChannelType2APIType(channel.Type)
+	subInfo.ApiKey = channel.Key
+	subInfo.BaseUrl = channel.GetBaseURL()
+	if subInfo.BaseUrl == "" {
+		subInfo.BaseUrl = common.ChannelBaseURLs[channel.Type]
+	}
+	subInfo.Organization = ""
+	if channel.OpenAIOrganization != nil {
+		subInfo.Organization = *channel.OpenAIOrganization
+	}
+	subInfo.ApiVersion = ""
+	switch channel.Type {
+	case common.ChannelTypeAzure, common.ChannelTypeVertexAi, common.ChannelTypeXunfei, common.ChannelTypeGemini,
+		common.ChannelCloudflare, common.ChannelTypeMokaAI:
+		subInfo.ApiVersion = channel.Other
+	}
+	subInfo.UpstreamModelName = modelName
+	subInfo.IsModelMapped = false
+	if err := helper.ApplyModelMapping(&subInfo, channel.GetModelMapping()); err != nil {
+		return nil, err
+	}
+	return &subInfo, nil
+}
+
+// doEmbeddingChunkRequest 在指定渠道上发送 embedding 请求，适配器输出的 OpenAI 格式响应写入独立的缓冲区
+func doEmbeddingChunkRequest(c *gin.Context, info *relaycommon.RelayInfo, channel *model.Channel, modelName string, request dto.EmbeddingRequest, input []any) (*embeddingBatchResponse, *dto.OpenAIErrorWithStatusCode) {
+	subInfo, err := newEmbeddingChunkInfo(info, channel, modelName)
+	if err != nil {
+		return nil, service.OpenAIErrorWrapperLocal(err, "model_mapped_error", http.StatusInternalServerError)
+	}
+	request.Input = input
+	request.Model = subInfo.UpstreamModelName
+	subInfo.PromptTokens = getEmbeddingPromptToken(request)
+
+	// 使用原请求上下文的副本，响应写入批次自己的缓冲区
+	cc := c.Copy()
+	w := newEmbeddingChunkWriter()
+	cc.Writer = w
+
+	adaptor := GetAdaptor(subInfo.ApiType)
+	if adaptor == nil {
+		return nil, service.OpenAIErrorWrapperLocal(fmt.Errorf("invalid api type: %d", subInfo.ApiType), "invalid_api_type", http.StatusBadRequest)
+	}
+	adaptor.Init(subInfo)
+
+	convertedRequest, err := adaptor.ConvertEmbeddingRequest(cc, subInfo, request)
+	if err != nil {
+		return nil, service.OpenAIErrorWrapperLocal(err, "convert_request_failed", http.StatusInternalServerError)
+	}
+	jsonData, err := json.Marshal(convertedRequest)
+	if err != nil {
+		return nil, service.OpenAIErrorWrapperLocal(err, "json_marshal_failed", http.StatusInternalServerError)
+	}
+	statusCodeMapping := channel.GetStatusCodeMapping()
+	resp, err := adaptor.DoRequest(cc, subInfo, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, service.OpenAIErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+
+	var httpResp *http.Response
+	if resp != nil {
+		httpResp = resp.(*http.Response)
+		if httpResp.StatusCode != http.StatusOK {
+			openaiErr := service.RelayErrorHandler(httpResp, false)
+			service.ResetStatusCode(openaiErr, statusCodeMapping)
+			return nil, openaiErr
+		}
+	}
+
+	usage, openaiErr := adaptor.DoResponse(cc, httpResp, subInfo)
+	if openaiErr != nil {
+		service.ResetStatusCode(openaiErr, statusCodeMapping)
+		return nil, openaiErr
+	}
+
+	var result embeddingBatchResponse
+	err = json.Unmarshal(w.body.Bytes(), &result)
+	if err != nil {
+		return nil, service.OpenAIErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError)
+	}
+	if len(result.Data) != len(input) {
+		return nil, service.OpenAIErrorWrapper(fmt.Errorf("expected %d embeddings, got %d", len(input), len(result.Data)), "bad_response_body", http.StatusInternalServerError)
+	}
+	if u, ok := usage.(*dto.Usage); ok && u != nil && u.TotalTokens > 0 {
+		result.Usage = *u
+	} else if result.Usage.TotalTokens == 0 {
+		result.Usage = dto.Usage{
+			PromptTokens: subInfo.PromptTokens,
+			TotalTokens:  subInfo.PromptTokens,
+		}
+	}
+	return &result, nil
+}
+
+// embeddingChunkWriter 保存单个批次的响应，不写给客户端
+type embeddingChunkWriter struct {
+	header http.Header
+	body   bytes.Buffer
+	status int
+}
+
+func newEmbeddingChunkWriter() *embeddingChunkWriter {
+	return &embeddingChunkWriter{header: make(http.Header), status: http.StatusOK}
+}
+
+func (w *embeddingChunkWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *embeddingChunkWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *embeddingChunkWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+func (w *embeddingChunkWriter) WriteHeader(code int) {
+	w.status = code
+}
+
+func (w *embeddingChunkWriter) WriteHeaderNow() {}
+
+func (w *embeddingChunkWriter) Status() int {
+	return w.status
+}
+
+func (w *embeddingChunkWriter) Size() int {
+	return w.body.Len()
+}
+
+func (w *embeddingChunkWriter) Written() bool {
+	return w.body.Len() > 0
+}
+
+func (w *embeddingChunkWriter) Flush() {}
+
+func (w *embeddingChunkWriter) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+func (w *embeddingChunkWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errors.New("embedding chunk writer does not support hijack")
+}
+
+func (w *embeddingChunkWriter) Pusher() http.Pusher {
+	return nil
+}
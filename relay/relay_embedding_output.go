@@ -0,0 +1,184 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"one-api/common"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	EmbeddingEncodingFloat         = "float"
+	EmbeddingEncodingBase64        = "base64"
+	EmbeddingEncodingBase64Float16 = "base64_float16"
+	EmbeddingEncodingBase64Int8    = "base64_int8"
+)
+
+// 上游原生支持 dimensions 参数的渠道类型
+var embeddingNativeDimensionsChannels = map[int]bool{
+	common.ChannelTypeOpenAI: true,
+	common.ChannelTypeAzure:  true,
+	common.ChannelTypeGemini: true,
+}
+
+// 上游原生支持 base64 输出的渠道类型
+var embeddingNativeBase64Channels = map[int]bool{
+	common.ChannelTypeOpenAI: true,
+	common.ChannelTypeAzure:  true,
+}
+
+// embeddingOutputOptions 需要在网关侧完成的 embedding 输出处理
+type embeddingOutputOptions struct {
+	dimensions     int
+	encodingFormat string
+}
+
+// getEmbeddingOutputOptions 判断是否需要在网关侧截断维度或编码输出；
+// 上游不支持的参数会从请求中移除，改为请求完整的 float 数组
+func getEmbeddingOutputOptions(info *relaycommon.RelayInfo, request *dto.EmbeddingRequest) *embeddingOutputOptions {
+	options := &embeddingOutputOptions{
+		dimensions:     request.Dimensions,
+		encodingFormat: request.EncodingFormat,
+	}
+	switch request.EncodingFormat {
+	case EmbeddingEncodingBase64Float16, EmbeddingEncodingBase64Int8:
+		request.EncodingFormat = ""
+	case EmbeddingEncodingBase64:
+		if !embeddingNativeBase64Channels[info.ChannelType] {
+			request.EncodingFormat = ""
+		}
+	default:
+		options.encodingFormat = EmbeddingEncodingFloat
+	}
+	if request.Dimensions > 0 && !embeddingNativeDimensionsChannels[info.ChannelType] {
+		request.Dimensions = 0
+	}
+	if options.dimensions <= 0 && options.encodingFormat == EmbeddingEncodingFloat {
+		return nil
+	}
+	return options
+}
+
+// responseBufferWriter 暂存适配器写出的响应，处理后再写给客户端
+type responseBufferWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseBufferWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *responseBufferWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+// processEmbeddingOutput 对 float 数组做 Matryoshka 截断与重新归一化，并按要求的格式编码；
+// 上游已返回 base64 字符串的条目保持不变
+func processEmbeddingOutput(body []byte, options *embeddingOutputOptions) ([]byte, error) {
+	var response embeddingBatchResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	for i, item := range response.Data {
+		trimmed := bytes.TrimSpace(item.Embedding)
+		if len(trimmed) == 0 || trimmed[0] != '[' {
+			continue
+		}
+		var vector []float64
+		if err := json.Unmarshal(trimmed, &vector); err != nil {
+			return nil, err
+		}
+		if options.dimensions > 0 && len(vector) > options.dimensions {
+			vector = normalizeEmbedding(vector[:options.dimensions])
+		}
+		encoded, err := encodeEmbedding(vector, options.encodingFormat)
+		if err != nil {
+			return nil, err
+		}
+		response.Data[i].Embedding = encoded
+	}
+	return json.Marshal(response)
+}
+
+func normalizeEmbedding(vector []float64) []float64 {
+	var norm float64
+	for _, v := range vector {
+		norm += v * v
+	}
+	norm = math.Sqrt(norm)
+	if norm == 0 {
+		return vector
+	}
+	normalized := make([]float64, len(vector))
+	for i, v := range vector {
+		normalized[i] = v / norm
+	}
+	return normalized
+}
+
+func encodeEmbedding(vector []float64, encodingFormat string) (json.RawMessage, error) {
+	var buf []byte
+	switch encodingFormat {
+	case EmbeddingEncodingBase64:
+		buf = make([]byte, 4*len(vector))
+		for i, v := range vector {
+			binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(float32(v)))
+		}
+	case EmbeddingEncodingBase64Float16:
+		buf = make([]byte, 2*len(vector))
+		for i, v := range vector {
+			binary.LittleEndian.PutUint16(buf[2*i:], float32ToFloat16(float32(v)))
+		}
+	case EmbeddingEncodingBase64Int8:
+		buf = make([]byte, len(vector))
+		for i, v := range vector {
+			buf[i] = byte(int8(math.Max(-127, math.Min(127, math.Round(v*127)))))
+		}
+	default:
+		return json.Marshal(vector)
+	}
+	return json.Marshal(base64.StdEncoding.EncodeToString(buf))
+}
+
+// float32ToFloat16 将 float32 转为 IEEE 754 半精度，按最近偶数舍入，超出范围时饱和为无穷大
+func float32ToFloat16(f float32) uint16 {
+	bits := math.Float32bits(f)
+	sign := uint16(bits>>16) & 0x8000
+	exp := int32(bits>>23&0xff) - 127 + 15
+	mantissa := bits & 0x7fffff
+
+	switch {
+	case bits&0x7fffffff == 0:
+		return sign
+	case exp >= 0x1f:
+		// 溢出、无穷大或 NaN
+		if bits&0x7f800000 == 0x7f800000 && mantissa != 0 {
+			return sign | 0x7e00
+		}
+		return sign | 0x7c00
+	case exp <= 0:
+		// 非规格化数
+		if exp < -10 {
+			return sign
+		}
+		mantissa |= 0x800000
+		shift := uint32(14 - exp)
+		half := uint16(mantissa >> shift)
+		if mantissa>>(shift-1)&1 == 1 && (mantissa&(1<<(shift-1)-1) != 0 || half&1 == 1) {
+			half++
+		}
+		return sign | half
+	}
+	half := sign | uint16(exp)<<10 | uint16(mantissa>>13)
+	if mantissa&0x1000 != 0 && (mantissa&0xfff != 0 || half&1 == 1) {
+		half++
+	}
+	return half
+}
@@ -0,0 +1,574 @@
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/relay/channel/gemini"
+	relaycommon "one-api/relay/common"
+	"one-api/service"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 透传请求时需要脱敏的顶层字段
+var piiPassThroughFields = []string{"messages", "prompt", "input", "instruction", "instructions"}
+
+// 流式响应中需要还原的文本字段
+var piiStreamTextFields = []string{"content", "reasoning_content", "reasoning"}
+
+// Claude 内容块的增量类型与需要还原的字段
+var piiClaudeDeltaFields = map[string]string{
+	"text_delta":       "text",
+	"thinking_delta":   "thinking",
+	"input_json_delta": "partial_json",
+}
+
+// Claude 请求中原样转发的内容块类型
+var piiClaudeSkipBlocks = map[string]bool{
+	"image":             true,
+	"redacted_thinking": true,
+}
+
+// redactTextRequest 在转换为上游格式前替换请求中的个人信息
+func redactTextRequest(redactor *service.PIIRedactor, textRequest *dto.GeneralOpenAIRequest) error {
+	for i := range textRequest.Messages {
+		message := &textRequest.Messages[i]
+		content, err := redactor.RedactJSON(message.Content)
+		if err != nil {
+			return err
+		}
+		message.Content = content
+		if len(message.ToolCalls) > 0 {
+			toolCalls, err := redactor.RedactJSON(message.ToolCalls)
+			if err != nil {
+				return err
+			}
+			message.ToolCalls = toolCalls
+		}
+	}
+	if textRequest.Prompt != nil {
+		textRequest.Prompt = redactor.RedactValue(textRequest.Prompt)
+	}
+	if textRequest.Input != nil {
+		textRequest.Input = redactor.RedactValue(textRequest.Input)
+	}
+	textRequest.Instruction = redactor.RedactText(textRequest.Instruction)
+	return nil
+}
+
+// redactRerankRequest 替换重排序请求的查询与文档中的个人信息
+func redactRerankRequest(redactor *service.PIIRedactor, rerankRequest *dto.RerankRequest) {
+	rerankRequest.Query = redactor.RedactText(rerankRequest.Query)
+	for i, document := range rerankRequest.Documents {
+		rerankRequest.Documents[i] = redactor.RedactValue(document)
+	}
+}
+
+// redactClaudeRequest 在转换为上游格式前替换 Claude 请求的系统提示与消息中的个人信息
+func redactClaudeRequest(redactor *service.PIIRedactor, claudeRequest *dto.ClaudeRequest) {
+	if claudeRequest.System != nil {
+		claudeRequest.System = redactClaudeContent(redactor, claudeRequest.System)
+	}
+	for i := range claudeRequest.Messages {
+		message := &claudeRequest.Messages[i]
+		message.Content = redactClaudeContent(redactor, message.Content)
+	}
+}
+
+// redactClaudeContent 替换 Claude 内容块中的个人信息，图片与二进制文档原样保留
+func redactClaudeContent(redactor *service.PIIRedactor, content any) any {
+	blocks, ok := content.([]any)
+	if !ok {
+		return redactor.RedactValue(content)
+	}
+	for _, item := range blocks {
+		block, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		if blockType, _ := block["type"].(string); piiClaudeSkipBlocks[blockType] {
+			continue
+		}
+		for key, value := range block {
+			switch key {
+			case "content":
+				block[key] = redactClaudeContent(redactor, value)
+			case "source":
+				redactClaudeSource(redactor, value)
+			case "type", "id", "name", "tool_use_id", "signature", "cache_control":
+			default:
+				block[key] = redactor.RedactValue(value)
+			}
+		}
+	}
+	return blocks
+}
+
+// redactClaudeSource 文档内容为纯文本或内容块时替换其中的个人信息
+func redactClaudeSource(redactor *service.PIIRedactor, value any) {
+	source, ok := value.(map[string]any)
+	if !ok {
+		return
+	}
+	switch source["type"] {
+	case "text":
+		if data, ok := source["data"].(string); ok {
+			source["data"] = redactor.RedactText(data)
+		}
+	case "content":
+		source["content"] = redactClaudeContent(redactor, source["content"])
+	}
+}
+
+// redactResponsesRequest 在转换为上游格式前替换 Responses 请求的输入与指令中的个人信息
+func redactResponsesRequest(redactor *service.PIIRedactor, responsesRequest *dto.OpenAIResponsesRequest) error {
+	input, err := redactor.RedactJSON(responsesRequest.Input)
+	if err != nil {
+		return err
+	}
+	instructions, err := redactor.RedactJSON(responsesRequest.Instructions)
+	if err != nil {
+		return err
+	}
+	responsesRequest.Input = input
+	responsesRequest.Instructions = instructions
+	return nil
+}
+
+// redactGeminiRequest 替换 Gemini 请求的系统指令与对话内容中的个人信息，内联数据与文件原样保留
+func redactGeminiRequest(redactor *service.PIIRedactor, geminiRequest *gemini.GeminiChatRequest) {
+	if geminiRequest.SystemInstructions != nil {
+		redactGeminiContent(redactor, geminiRequest.SystemInstructions)
+	}
+	for i := range geminiRequest.Contents {
+		redactGeminiContent(redactor, &geminiRequest.Contents[i])
+	}
+}
+
+func redactGeminiContent(redactor *service.PIIRedactor, content *gemini.GeminiChatContent) {
+	for i := range content.Parts {
+		part := &content.Parts[i]
+		part.Text = redactor.RedactText(part.Text)
+		if part.FunctionCall != nil {
+			part.FunctionCall.Arguments = redactor.RedactValue(part.FunctionCall.Arguments)
+		}
+		if part.FunctionResponse != nil {
+			part.FunctionResponse.Response.Content = redactor.RedactValue(part.FunctionResponse.Response.Content)
+		}
+		if part.ExecutableCode != nil {
+			part.ExecutableCode.Code = redactor.RedactText(part.ExecutableCode.Code)
+		}
+		if part.CodeExecutionResult != nil {
+			part.CodeExecutionResult.Output = redactor.RedactText(part.CodeExecutionResult.Output)
+		}
+	}
+}
+
+// redactPassThroughBody 透传请求时替换请求体中消息与输入字段的个人信息
+func redactPassThroughBody(redactor *service.PIIRedactor, body []byte) ([]byte, error) {
+	var request map[string]json.RawMessage
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, err
+	}
+	for _, field := range piiPassThroughFields {
+		raw, ok := request[field]
+		if !ok {
+			continue
+		}
+		redacted, err := redactor.RedactJSON(raw)
+		if err != nil {
+			return nil, err
+		}
+		request[field] = redacted
+	}
+	return json.Marshal(request)
+}
+
+// logPIIRedactions 记录各类个人信息的替换次数，不记录原值
+func logPIIRedactions(c *gin.Context, counts map[string]int) {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s=%d", name, counts[name]))
+	}
+	common.LogInfo(c, "pii redacted: "+strings.Join(parts, ", "))
+}
+
+// piiRestorer 将响应中的占位符还原为原值。非流式响应整体还原，
+// 流式响应按 SSE 事件还原，被拆分到多个事件中的占位符会保留到能够确定时再输出
+type piiRestorer struct {
+	out      gin.ResponseWriter
+	redactor *service.PIIRedactor
+	format   string
+	// pending 每个字段尚未输出的文本，键的格式由响应格式决定
+	pending   map[string]string
+	lastChunk map[string]any
+	// responsesDeltas Responses 每个字段最后一个增量事件，用于输出保留的文本
+	responsesDeltas map[string]map[string]any
+}
+
+// startPIIRestore format 为 RelayInfo.RelayFormat 或 sensitiveFormatResponses
+func startPIIRestore(c *gin.Context, redactor *service.PIIRedactor, format string) *sseRewriteWriter {
+	return startSSERewrite(c, &piiRestorer{
+		out:             c.Writer,
+		redactor:        redactor,
+		format:          format,
+		pending:         make(map[string]string),
+		responsesDeltas: make(map[string]map[string]any),
+	})
+}
+
+func (r *piiRestorer) rewriteBody(body []byte) []byte {
+	return r.redactor.RestoreJSON(body)
+}
+
+func (r *piiRestorer) finishStream() {
+	r.flushPending()
+}
+
+func (r *piiRestorer) rewriteEvent(event string, name string, payload string, ok bool) {
+	if !ok {
+		_, _ = r.out.WriteString(r.redactor.Restore(event))
+		return
+	}
+	if payload == "[DONE]" {
+		r.flushPending()
+		_, _ = r.out.WriteString(event)
+		return
+	}
+	chunk, err := decodeSSEChunk(payload)
+	if err != nil {
+		_, _ = r.out.WriteString(string(r.redactor.RestoreJSON([]byte(event))))
+		return
+	}
+	if !strings.Contains(payload, "[") && len(r.pending) == 0 {
+		r.lastChunk = chunk
+		_, _ = r.out.WriteString(event)
+		return
+	}
+	switch r.format {
+	case relaycommon.RelayFormatClaude:
+		r.writeClaudeEvent(name, chunk)
+	case relaycommon.RelayFormatGemini:
+		r.writeGeminiChunk(chunk)
+	case sensitiveFormatResponses:
+		r.writeResponsesEvent(name, chunk)
+	default:
+		r.restoreChunk(chunk)
+		r.lastChunk = chunk
+		r.writeData("", chunk)
+	}
+}
+
+// writeData 还原事件中其余字段的完整占位符后输出
+func (r *piiRestorer) writeData(name string, chunk map[string]any) {
+	r.redactor.RestoreValue(chunk)
+	writeSSEData(r.out, name, chunk)
+}
+
+// restoreText 还原一个字段的增量文本，结束时输出全部保留的文本
+func (r *piiRestorer) restoreText(key string, text string, finished bool) string {
+	text = r.pending[key] + text
+	delete(r.pending, key)
+	if finished {
+		return r.redactor.Restore(text)
+	}
+	text, hold := r.redactor.RestoreStream(text)
+	if hold != "" {
+		r.pending[key] = hold
+	}
+	return text
+}
+
+// takePending 取出一个字段保留的文本并还原
+func (r *piiRestorer) takePending(key string) string {
+	text := r.pending[key]
+	delete(r.pending, key)
+	return r.redactor.Restore(text)
+}
+
+func (r *piiRestorer) restoreChunk(chunk map[string]any) {
+	choices, _ := chunk["choices"].([]any)
+	for _, item := range choices {
+		choice, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		index := fmt.Sprint(choice["index"])
+		finished := choice["finish_reason"] != nil
+		// 文本补全接口的增量在 text 字段
+		if text, ok := choice["text"].(string); ok {
+			choice["text"] = r.restoreText(index+"/text", text, finished)
+		}
+		delta, ok := choice["delta"].(map[string]any)
+		if !ok {
+			if finished {
+				delta = make(map[string]any)
+				choice["delta"] = delta
+			} else {
+				continue
+			}
+		}
+		for _, field := range piiStreamTextFields {
+			text, ok := delta[field].(string)
+			if !ok && !(finished && r.pending[index+"/"+field] != "") {
+				continue
+			}
+			delta[field] = r.restoreText(index+"/"+field, text, finished)
+		}
+		toolCalls, _ := delta["tool_calls"].([]any)
+		for _, toolItem := range toolCalls {
+			toolCall, _ := toolItem.(map[string]any)
+			function, _ := toolCall["function"].(map[string]any)
+			if arguments, ok := function["arguments"].(string); ok {
+				function["arguments"] = r.restoreText(index+"/tool/"+fmt.Sprint(toolCall["index"]), arguments, finished)
+			}
+		}
+		if finished {
+			r.appendPendingToolCalls(index, delta)
+		}
+	}
+}
+
+// appendPendingToolCalls 选项结束时输出未出现在最后一个事件中的工具调用参数
+func (r *piiRestorer) appendPendingToolCalls(index string, delta map[string]any) {
+	prefix := index + "/tool/"
+	for key, text := range r.pending {
+		toolIndex, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+		toolCalls, _ := delta["tool_calls"].([]any)
+		delta["tool_calls"] = append(toolCalls, map[string]any{
+			"index":    json.Number(toolIndex),
+			"function": map[string]any{"arguments": r.redactor.Restore(text)},
+		})
+		delete(r.pending, key)
+	}
+}
+
+func (r *piiRestorer) writeClaudeEvent(name string, chunk map[string]any) {
+	eventType, _ := chunk["type"].(string)
+	if name == "" {
+		name = eventType
+	}
+	index := fmt.Sprint(chunk["index"])
+	switch eventType {
+	case "content_block_delta":
+		delta, _ := chunk["delta"].(map[string]any)
+		deltaType, _ := delta["type"].(string)
+		if field, ok := piiClaudeDeltaFields[deltaType]; ok {
+			text, _ := delta[field].(string)
+			// 文本全部被保留时不输出空的增量事件
+			if text = r.restoreText(index+"/"+field, text, false); text == "" {
+				return
+			}
+			delta[field] = text
+		}
+	case "content_block_stop":
+		r.flushClaudeBlock(index)
+	}
+	r.writeData(name, chunk)
+}
+
+// flushClaudeBlock 内容块结束前输出保留的文本
+func (r *piiRestorer) flushClaudeBlock(index string) {
+	for deltaType, field := range piiClaudeDeltaFields {
+		key := index + "/" + field
+		if r.pending[key] == "" {
+			continue
+		}
+		writeSSEData(r.out, "content_block_delta", map[string]any{
+			"type":  "content_block_delta",
+			"index": json.Number(index),
+			"delta": map[string]any{"type": deltaType, field: r.takePending(key)},
+		})
+	}
+}
+
+func (r *piiRestorer) writeGeminiChunk(chunk map[string]any) {
+	candidates, _ := chunk["candidates"].([]any)
+	for i, item := range candidates {
+		candidate, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		finishReason, _ := candidate["finishReason"].(string)
+		content, _ := candidate["content"].(map[string]any)
+		parts, _ := content["parts"].([]any)
+		for _, partItem := range parts {
+			part, ok := partItem.(map[string]any)
+			if !ok {
+				continue
+			}
+			if text, ok := part["text"].(string); ok {
+				part["text"] = r.restoreText(fmt.Sprintf("%d/%s", i, geminiPartField(part)), text, false)
+			}
+		}
+		if finishReason != "" && content != nil {
+			content["parts"] = r.appendGeminiPending(parts, i)
+		}
+	}
+	r.writeData("", chunk)
+}
+
+// appendGeminiPending 候选结束时将保留的文本追加到最后一个同类文本片段
+func (r *piiRestorer) appendGeminiPending(parts []any, index int) []any {
+	for _, field := range []string{"text", "thought"} {
+		key := fmt.Sprintf("%d/%s", index, field)
+		if r.pending[key] == "" {
+			continue
+		}
+		text := r.takePending(key)
+		appended := false
+		for j := len(parts) - 1; j >= 0; j-- {
+			part, ok := parts[j].(map[string]any)
+			if !ok {
+				continue
+			}
+			if existing, ok := part["text"].(string); ok && geminiPartField(part) == field {
+				part["text"] = existing + text
+				appended = true
+				break
+			}
+		}
+		if !appended {
+			part := map[string]any{"text": text}
+			if field == "thought" {
+				part["thought"] = true
+			}
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+func (r *piiRestorer) writeResponsesEvent(name string, chunk map[string]any) {
+	eventType, _ := chunk["type"].(string)
+	if name == "" {
+		name = eventType
+	}
+	// 增量事件按事件类型与字段保留文本，对应的完成事件携带完整文本，输出前先补齐保留的增量
+	if prefix, ok := strings.CutSuffix(eventType, ".delta"); ok {
+		if delta, ok := chunk["delta"].(string); ok {
+			key := prefix + "/" + responsesTextKey(chunk)
+			r.responsesDeltas[key] = chunk
+			if delta = r.restoreText(key, delta, false); delta == "" {
+				return
+			}
+			chunk["delta"] = delta
+		}
+	} else if prefix, ok := strings.CutSuffix(eventType, ".done"); ok {
+		r.flushResponsesDelta(prefix + "/" + responsesTextKey(chunk))
+	} else if eventType == "response.completed" || eventType == "response.incomplete" || eventType == "response.failed" {
+		r.flushPending()
+	}
+	r.writeData(name, chunk)
+}
+
+// flushResponsesDelta 以该字段最后一个增量事件为模板输出保留的文本
+func (r *piiRestorer) flushResponsesDelta(key string) {
+	template, ok := r.responsesDeltas[key]
+	delete(r.responsesDeltas, key)
+	if !ok || r.pending[key] == "" {
+		return
+	}
+	chunk := make(map[string]any, len(template))
+	for field, value := range template {
+		chunk[field] = value
+	}
+	chunk["delta"] = r.takePending(key)
+	eventType, _ := chunk["type"].(string)
+	writeSSEData(r.out, eventType, chunk)
+}
+
+// flushPending 上游没有正常结束字段时输出所有保留的文本
+func (r *piiRestorer) flushPending() {
+	if len(r.pending) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(r.pending))
+	for key := range r.pending {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	switch r.format {
+	case relaycommon.RelayFormatClaude:
+		for _, key := range keys {
+			index, _, _ := strings.Cut(key, "/")
+			r.flushClaudeBlock(index)
+		}
+	case relaycommon.RelayFormatGemini:
+		candidates := make([]any, 0, len(keys))
+		for _, key := range keys {
+			index, field, _ := strings.Cut(key, "/")
+			part := map[string]any{"text": r.takePending(key)}
+			if field == "thought" {
+				part["thought"] = true
+			}
+			candidates = append(candidates, map[string]any{
+				"index":   json.Number(index),
+				"content": map[string]any{"role": "model", "parts": []any{part}},
+			})
+		}
+		writeSSEData(r.out, "", map[string]any{"candidates": candidates})
+	case sensitiveFormatResponses:
+		for _, key := range keys {
+			r.flushResponsesDelta(key)
+		}
+	default:
+		r.flushOpenAIPending(keys)
+	}
+	r.pending = make(map[string]string)
+}
+
+// flushOpenAIPending 用最后一个事件的 id、模型等字段构造新的事件输出保留的文本
+func (r *piiRestorer) flushOpenAIPending(keys []string) {
+	if r.lastChunk == nil {
+		return
+	}
+	choices := make(map[string]map[string]any)
+	var indexes []string
+	for _, key := range keys {
+		index, field, _ := strings.Cut(key, "/")
+		choice, ok := choices[index]
+		if !ok {
+			choice = map[string]any{"index": json.Number(index), "delta": map[string]any{}}
+			choices[index] = choice
+			indexes = append(indexes, index)
+		}
+		text := r.redactor.Restore(r.pending[key])
+		delta := choice["delta"].(map[string]any)
+		if toolIndex, ok := strings.CutPrefix(field, "tool/"); ok {
+			toolCalls, _ := delta["tool_calls"].([]any)
+			delta["tool_calls"] = append(toolCalls, map[string]any{
+				"index":    json.Number(toolIndex),
+				"function": map[string]any{"arguments": text},
+			})
+		} else if field == "text" {
+			choice["text"] = text
+		} else {
+			delta[field] = text
+		}
+	}
+	chunk := make(map[string]any, len(r.lastChunk))
+	for key, value := range r.lastChunk {
+		if key != "choices" && key != "usage" {
+			chunk[key] = value
+		}
+	}
+	chunkChoices := make([]any, 0, len(indexes))
+	for _, index := range indexes {
+		chunkChoices = append(chunkChoices, choices[index])
+	}
+	chunk["choices"] = chunkChoices
+	writeSSEData(r.out, "", chunk)
+}
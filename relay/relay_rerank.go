@@ -7,6 +7,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"one-api/common"
+	"one-api/constant"
 	"one-api/dto"
 	relaycommon "one-api/relay/common"
 	"one-api/relay/helper"
@@ -67,6 +68,31 @@ func RerankHelper(c *gin.Context, relayMode int) (openaiErr *dto.OpenAIErrorWith
 		}
 	}()
 
+	// 返回的文档为脱敏后的文本，输出前还原
+	var piiWriter *sseRewriteWriter
+	if piiRedactor := service.NewPIIRedactor(relayInfo.ChannelId, relayInfo.Group); piiRedactor != nil {
+		redactRerankRequest(piiRedactor, rerankRequest)
+		if piiRedactor.HasRedactions() {
+			relayInfo.PIIRedactions = piiRedactor.Counts()
+			logPIIRedactions(c, relayInfo.PIIRedactions)
+			piiWriter = startPIIRestore(c, piiRedactor, relayInfo.RelayFormat)
+			defer func() {
+				piiWriter.Finish()
+				c.Writer = piiWriter.ResponseWriter
+			}()
+		}
+	}
+
+	// 使用 embedding 模型模拟 rerank，按 embedding 用量计费
+	if rerankModel := c.GetString(constant.ContextKeyRerankEmulationModel); rerankModel != "" {
+		usage, openaiErr := rerankEmulationHelper(c, relayInfo, *rerankRequest)
+		if openaiErr != nil {
+			return openaiErr
+		}
+		postConsumeQuota(c, relayInfo, usage, preConsumedQuota, userQuota, priceData, fmt.Sprintf("rerank 模拟，请求模型 %s", rerankModel))
+		return nil
+	}
+
 	adaptor := GetAdaptor(relayInfo.ApiType)
 	if adaptor == nil {
 		return service.OpenAIErrorWrapperLocal(fmt.Errorf("invalid api type: %d", relayInfo.ApiType), "invalid_api_type", http.StatusBadRequest)
@@ -0,0 +1,85 @@
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/model"
+	relaycommon "one-api/relay/common"
+	"one-api/service"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rerankDocumentText 提取 rerank 文档的文本，文档可以是字符串或 {"text": "..."} 对象
+func rerankDocumentText(document any) string {
+	switch v := document.(type) {
+	case string:
+		return v
+	case map[string]any:
+		if text, ok := v["text"].(string); ok {
+			return text
+		}
+	}
+	data, _ := json.Marshal(document)
+	return string(data)
+}
+
+// rerankEmulationHelper 使用 embedding 模型计算 query 与各文档的余弦相似度，模拟 rerank 响应
+func rerankEmulationHelper(c *gin.Context, info *relaycommon.RelayInfo, rerankRequest dto.RerankRequest) (*dto.Usage, *dto.OpenAIErrorWithStatusCode) {
+	channel, err := model.CacheGetChannel(info.ChannelId)
+	if err != nil {
+		return nil, service.OpenAIErrorWrapperLocal(err, "get_channel_failed", http.StatusInternalServerError)
+	}
+
+	input := make([]any, 0, len(rerankRequest.Documents)+1)
+	input = append(input, rerankRequest.Query)
+	for _, document := range rerankRequest.Documents {
+		input = append(input, rerankDocumentText(document))
+	}
+	embeddingResp, openaiErr := doEmbeddingChunkRequest(c, info, channel, info.OriginModelName, dto.EmbeddingRequest{}, input)
+	if openaiErr != nil {
+		return nil, openaiErr
+	}
+
+	vectors := make([][]float64, len(input))
+	for _, item := range embeddingResp.Data {
+		if item.Index < 0 || item.Index >= len(vectors) {
+			continue
+		}
+		if err := json.Unmarshal(item.Embedding, &vectors[item.Index]); err != nil {
+			return nil, service.OpenAIErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError)
+		}
+	}
+	if len(vectors[0]) == 0 {
+		return nil, service.OpenAIErrorWrapper(fmt.Errorf("query embedding is empty"), "bad_response_body", http.StatusInternalServerError)
+	}
+
+	results := make([]dto.RerankResponseResult, 0, len(rerankRequest.Documents))
+	for i, document := range rerankRequest.Documents {
+		result := dto.RerankResponseResult{
+			Index:          i,
+			RelevanceScore: common.CosineSimilarity(vectors[0], vectors[i+1]),
+		}
+		if info.ReturnDocuments {
+			result.Document = document
+		}
+		results = append(results, result)
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].RelevanceScore > results[j].RelevanceScore
+	})
+	if rerankRequest.TopN > 0 && rerankRequest.TopN < len(results) {
+		results = results[:rerankRequest.TopN]
+	}
+
+	usage := embeddingResp.Usage
+	c.JSON(http.StatusOK, dto.RerankResponse{
+		Results: results,
+		Usage:   usage,
+	})
+	return &usage, nil
+}
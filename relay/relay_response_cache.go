@@ -0,0 +1,124 @@
+package relay
+
+import (
+	"bytes"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	relaycommon "one-api/relay/common"
+	"one-api/relay/helper"
+	"one-api/service"
+	"one-api/setting/operation_setting"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// responseCacheHeader 请求头设置为 bypass/off 时跳过缓存，命中缓存时响应头为 hit
+const responseCacheHeader = "X-Response-Cache"
+
+// responseCaptureWriter 在写出响应的同时保存一份副本，超过大小限制后不再保存
+type responseCaptureWriter struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+func (w *responseCaptureWriter) capture(data []byte) {
+	if w.overflow {
+		return
+	}
+	if w.limit > 0 && w.body.Len()+len(data) > w.limit {
+		w.overflow = true
+		w.body.Reset()
+		return
+	}
+	w.body.Write(data)
+}
+
+func (w *responseCaptureWriter) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseCaptureWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// responseCacheBypassed 请求头要求跳过缓存
+func responseCacheBypassed(c *gin.Context) bool {
+	switch strings.ToLower(c.Request.Header.Get(responseCacheHeader)) {
+	case "bypass", "off", "false", "no":
+		return true
+	}
+	return false
+}
+
+// getResponseCacheKey 判断请求是否可以使用响应缓存，可以时返回缓存键
+func getResponseCacheKey(c *gin.Context, info *relaycommon.RelayInfo) string {
+	if !operation_setting.GetResponseCacheSetting().Enabled || responseCacheBypassed(c) {
+		return ""
+	}
+	body, err := common.GetRequestBody(c)
+	if err != nil {
+		return ""
+	}
+	key, err := service.GenerateResponseCacheKey(info.Group, info.OriginModelName, body)
+	if err != nil {
+		common.LogError(c, "generate response cache key failed: "+err.Error())
+		return ""
+	}
+	return key
+}
+
+// replayResponseCache 命中缓存时直接返回缓存的响应，不请求上游
+func replayResponseCache(c *gin.Context, info *relaycommon.RelayInfo, key string) (*dto.Usage, bool) {
+	entry, ok := service.GetResponseCacheStore().Get(key)
+	if !ok {
+		return nil, false
+	}
+	info.ResponseCacheHit = true
+	info.IsStream = entry.IsStream
+	info.SetFirstResponseTime()
+	c.Writer.Header().Set(responseCacheHeader, "hit")
+	if entry.IsStream {
+		helper.SetEventStreamHeaders(c)
+		c.Status(http.StatusOK)
+		_, _ = c.Writer.Write(entry.Body)
+		c.Writer.Flush()
+	} else {
+		c.Data(http.StatusOK, entry.ContentType, entry.Body)
+	}
+	usage := entry.Usage
+	return &usage, true
+}
+
+// startResponseCapture 替换 c.Writer 以便在请求成功后保存响应
+func startResponseCapture(c *gin.Context) *responseCaptureWriter {
+	writer := &responseCaptureWriter{
+		ResponseWriter: c.Writer,
+		limit:          operation_setting.GetResponseCacheSetting().MaxEntryBytes,
+	}
+	c.Writer = writer
+	return writer
+}
+
+func saveResponseCache(c *gin.Context, info *relaycommon.RelayInfo, key string, writer *responseCaptureWriter, usage *dto.Usage) {
+	if writer.overflow || writer.body.Len() == 0 || usage == nil || writer.Status() != http.StatusOK {
+		return
+	}
+	entry := &service.ResponseCacheEntry{
+		ContentType: writer.Header().Get("Content-Type"),
+		Body:        writer.body.Bytes(),
+		IsStream:    info.IsStream,
+		Usage:       *usage,
+		CreatedAt:   time.Now().Unix(),
+	}
+	ttl := time.Duration(operation_setting.GetResponseCacheSetting().TTLSeconds) * time.Second
+	if err := service.GetResponseCacheStore().Set(key, entry, ttl); err != nil {
+		common.LogError(c, "save response cache failed: "+err.Error())
+	}
+}
@@ -0,0 +1,271 @@
+package relay
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/model"
+	relaycommon "one-api/relay/common"
+	relayconstant "one-api/relay/constant"
+	"one-api/relay/helper"
+	"one-api/service"
+	"one-api/setting"
+	"one-api/setting/operation_setting"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type semanticCacheQuery struct {
+	scope       string
+	contextHash string
+	question    string
+	vector      []float64
+}
+
+// getSemanticCacheQuery 对最后一轮用户提问计算向量，其余上下文只做精确匹配，请求不适用语义缓存时返回 nil
+func getSemanticCacheQuery(c *gin.Context, info *relaycommon.RelayInfo, textRequest *dto.GeneralOpenAIRequest) *semanticCacheQuery {
+	if !operation_setting.GetSemanticCacheSetting().Enabled || responseCacheBypassed(c) {
+		return nil
+	}
+	if info.RelayMode != relayconstant.RelayModeChatCompletions || info.RelayFormat != relaycommon.RelayFormatOpenAI {
+		return nil
+	}
+	if len(textRequest.Tools) > 0 || textRequest.N > 1 {
+		return nil
+	}
+	questionIndex := -1
+	for i := len(textRequest.Messages) - 1; i >= 0; i-- {
+		if textRequest.Messages[i].Role == "user" {
+			questionIndex = i
+			break
+		}
+	}
+	if questionIndex < 0 {
+		return nil
+	}
+	question := textRequest.Messages[questionIndex].StringContent()
+	if strings.TrimSpace(question) == "" {
+		return nil
+	}
+	contextHash, err := getSemanticCacheContextHash(textRequest, questionIndex)
+	if err != nil {
+		return nil
+	}
+	vector, err := embedSemanticCacheQuestion(c, info, question)
+	if err != nil {
+		common.LogError(c, "semantic cache embedding failed: "+err.Error())
+		return nil
+	}
+	return &semanticCacheQuery{
+		scope:       service.GetSemanticCacheScope(info.TokenId, info.UserId),
+		contextHash: contextHash,
+		question:    question,
+		vector:      vector,
+	}
+}
+
+// getSemanticCacheContextHash 计算除最后一轮用户提问外的系统提示词、历史消息与请求参数的摘要，
+// 流式与非流式请求共用同一摘要
+func getSemanticCacheContextHash(textRequest *dto.GeneralOpenAIRequest, questionIndex int) (string, error) {
+	contextRequest := *textRequest
+	contextRequest.Messages = make([]dto.Message, 0, len(textRequest.Messages)-1)
+	contextRequest.Messages = append(contextRequest.Messages, textRequest.Messages[:questionIndex]...)
+	contextRequest.Messages = append(contextRequest.Messages, textRequest.Messages[questionIndex+1:]...)
+	contextRequest.Stream = false
+	contextRequest.StreamOptions = nil
+	data, err := json.Marshal(contextRequest)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+type semanticCacheVector struct {
+	question string
+	vector   []float64
+}
+
+// embedSemanticCacheQuestion 使用配置的 embedding 渠道计算问题向量，重试时复用已计算的向量
+func embedSemanticCacheQuestion(c *gin.Context, info *relaycommon.RelayInfo, question string) ([]float64, error) {
+	if value, ok := c.Get("semantic_cache_vector"); ok {
+		if cached := value.(*semanticCacheVector); cached.question == question {
+			return cached.vector, nil
+		}
+	}
+	cacheSetting := operation_setting.GetSemanticCacheSetting()
+	channel, err := model.CacheGetChannel(cacheSetting.EmbeddingChannelId)
+	if err != nil {
+		return nil, err
+	}
+	resp, openaiErr := doEmbeddingChunkRequest(c, info, channel, cacheSetting.EmbeddingModel, dto.EmbeddingRequest{}, []any{question})
+	if openaiErr != nil {
+		return nil, errors.New(openaiErr.Error.Message)
+	}
+	// 向量计算的费用在本次请求结算时一并扣除
+	embeddingQuota := getSemanticCacheEmbeddingQuota(info, cacheSetting.EmbeddingModel, resp.Usage)
+	info.SemanticCacheEmbeddingQuota += embeddingQuota
+	model.UpdateChannelUsedQuota(channel.Id, embeddingQuota)
+	if len(resp.Data) == 0 {
+		return nil, errors.New("embedding response is empty")
+	}
+	var vector []float64
+	if err := json.Unmarshal(resp.Data[0].Embedding, &vector); err != nil {
+		return nil, err
+	}
+	c.Set("semantic_cache_vector", &semanticCacheVector{question: question, vector: vector})
+	return vector, nil
+}
+
+// getSemanticCacheEmbeddingQuota 按向量模型的价格或倍率与用户分组倍率计算向量调用的费用
+func getSemanticCacheEmbeddingQuota(info *relaycommon.RelayInfo, modelName string, usage dto.Usage) int {
+	groupRatio := setting.GetGroupRatio(info.Group)
+	if modelPrice, usePrice := operation_setting.GetModelPrice(modelName, false); usePrice {
+		return int(modelPrice * common.QuotaPerUnit * groupRatio)
+	}
+	modelRatio, _ := operation_setting.GetModelRatio(modelName)
+	tokens := usage.PromptTokens
+	if tokens == 0 {
+		tokens = usage.TotalTokens
+	}
+	quota := int(float64(tokens) * modelRatio * groupRatio)
+	if quota == 0 && tokens > 0 && modelRatio > 0 && groupRatio > 0 {
+		quota = 1
+	}
+	return quota
+}
+
+// settleSemanticCacheEmbedding 请求失败未进入结算时，单独扣除已产生的向量调用费用
+func settleSemanticCacheEmbedding(c *gin.Context, info *relaycommon.RelayInfo) {
+	quota := info.SemanticCacheEmbeddingQuota
+	if quota <= 0 {
+		return
+	}
+	info.SemanticCacheEmbeddingQuota = 0
+	if err := service.PostConsumeQuota(info, quota, 0, false); err != nil {
+		common.LogError(c, "error consuming semantic cache embedding quota: "+err.Error())
+		return
+	}
+	model.UpdateUserUsedQuotaAndRequestCount(info.UserId, quota)
+	cacheSetting := operation_setting.GetSemanticCacheSetting()
+	other := map[string]interface{}{
+		"semantic_cache_embedding_quota": quota,
+	}
+	model.RecordConsumeLog(c, info.UserId, cacheSetting.EmbeddingChannelId, 0, 0, cacheSetting.EmbeddingModel,
+		c.GetString("token_name"), quota, "语义缓存向量费用", info.TokenId, 0, 0, false, info.Group, other)
+}
+
+// replaySemanticCache 命中语义缓存时按请求的流式或非流式格式返回缓存的回答
+func replaySemanticCache(c *gin.Context, info *relaycommon.RelayInfo, query *semanticCacheQuery) (*dto.Usage, bool) {
+	entry, score := service.SearchSemanticCache(query.scope, info.OriginModelName, query.contextHash, query.vector)
+	if entry == nil {
+		return nil, false
+	}
+	info.SemanticCacheHit = true
+	info.SetFirstResponseTime()
+	common.LogInfo(c, fmt.Sprintf("semantic cache hit: entry %s, similarity %.4f", entry.Id, score))
+
+	usage := dto.Usage{
+		PromptTokens:     info.PromptTokens,
+		CompletionTokens: entry.Usage.CompletionTokens,
+		TotalTokens:      info.PromptTokens + entry.Usage.CompletionTokens,
+	}
+	finishReason := entry.FinishReason
+	if finishReason == "" {
+		finishReason = "stop"
+	}
+	id := helper.GetResponseID(c)
+	createdAt := time.Now().Unix()
+	c.Writer.Header().Set(responseCacheHeader, "semantic-hit")
+	if info.IsStream {
+		helper.SetEventStreamHeaders(c)
+		delta := dto.ChatCompletionsStreamResponseChoiceDelta{Role: "assistant"}
+		delta.SetContentString(entry.Answer)
+		_ = helper.ObjectData(c, &dto.ChatCompletionsStreamResponse{
+			Id:      id,
+			Object:  "chat.completion.chunk",
+			Created: createdAt,
+			Model:   info.OriginModelName,
+			Choices: []dto.ChatCompletionsStreamResponseChoice{{Delta: delta}},
+		})
+		_ = helper.ObjectData(c, helper.GenerateStopResponse(id, createdAt, info.OriginModelName, finishReason))
+		if info.ShouldIncludeUsage {
+			_ = helper.ObjectData(c, helper.GenerateFinalUsageResponse(id, createdAt, info.OriginModelName, usage))
+		}
+		helper.Done(c)
+	} else {
+		message := dto.Message{Role: "assistant"}
+		message.SetStringContent(entry.Answer)
+		c.JSON(http.StatusOK, dto.OpenAITextResponse{
+			Id:      id,
+			Model:   info.OriginModelName,
+			Object:  "chat.completion",
+			Created: createdAt,
+			Choices: []dto.OpenAITextResponseChoice{
+				{
+					Index:        0,
+					Message:      message,
+					FinishReason: finishReason,
+				},
+			},
+			Usage: usage,
+		})
+	}
+	return &usage, true
+}
+
+// saveSemanticCache 从已写出的响应中提取回答并保存到语义缓存
+func saveSemanticCache(c *gin.Context, info *relaycommon.RelayInfo, query *semanticCacheQuery, writer *responseCaptureWriter, usage *dto.Usage) {
+	if writer.overflow || writer.body.Len() == 0 || usage == nil || writer.Status() != http.StatusOK {
+		return
+	}
+	var answer, finishReason string
+	if info.IsStream {
+		var builder strings.Builder
+		scanner := bufio.NewScanner(bytes.NewReader(writer.body.Bytes()))
+		scanner.Buffer(make([]byte, 64*1024), writer.body.Len()+1)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok || data == "[DONE]" {
+				continue
+			}
+			var chunk dto.ChatCompletionsStreamResponse
+			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+				continue
+			}
+			for _, choice := range chunk.Choices {
+				if len(choice.Delta.ToolCalls) > 0 {
+					return
+				}
+				builder.WriteString(choice.Delta.GetContentString())
+				if choice.FinishReason != nil {
+					finishReason = *choice.FinishReason
+				}
+			}
+		}
+		answer = builder.String()
+	} else {
+		var response dto.OpenAITextResponse
+		if err := json.Unmarshal(writer.body.Bytes(), &response); err != nil || len(response.Choices) == 0 {
+			return
+		}
+		if response.Choices[0].ToolCalls != nil {
+			return
+		}
+		answer = response.Choices[0].StringContent()
+		finishReason = response.Choices[0].FinishReason
+	}
+	if answer == "" {
+		return
+	}
+	service.AddSemanticCache(query.scope, info.OriginModelName, query.contextHash, query.question, query.vector, answer, finishReason, *usage)
+}
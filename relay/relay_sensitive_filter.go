@@ -0,0 +1,649 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"one-api/common"
+	"one-api/constant"
+	relaycommon "one-api/relay/common"
+	"one-api/service"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Responses 接口的响应格式，其余格式与 RelayInfo.RelayFormat 一致
+const sensitiveFormatResponses = "responses"
+
+// OpenAI 流式响应中需要过滤的文本字段
+var sensitiveOpenAIDeltaFields = []string{"content", "reasoning_content", "reasoning"}
+
+// Claude 内容块的增量类型与对应的文本字段
+var sensitiveClaudeDeltaFields = map[string]string{
+	"text_delta":     "text",
+	"thinking_delta": "thinking",
+}
+
+// sensitiveFilter 过滤模型输出中的敏感词。非流式响应整体过滤，
+// 流式响应按 SSE 事件过滤，命中敏感词后按各格式的方式结束输出
+type sensitiveFilter struct {
+	out       gin.ResponseWriter
+	c         *gin.Context
+	filter    *service.CompletionSensitiveFilter
+	format    string
+	lastChunk map[string]any
+	// stopReasonSent 停止输出后是否已经向客户端发送结束原因
+	stopReasonSent bool
+	// upstreamCancelled 停止输出后是否已经取消上游请求
+	upstreamCancelled bool
+}
+
+// startSensitiveFilter 未开启输出检查时返回 nil
+func startSensitiveFilter(c *gin.Context, format string) *sseRewriteWriter {
+	filter := service.NewCompletionSensitiveFilter()
+	if filter == nil {
+		return nil
+	}
+	return startSSERewrite(c, &sensitiveFilter{
+		out:    c.Writer,
+		c:      c,
+		filter: filter,
+		format: format,
+	})
+}
+
+func (f *sensitiveFilter) rewriteBody(body []byte) []byte {
+	body = f.filterBody(body)
+	f.logWords()
+	return body
+}
+
+func (f *sensitiveFilter) finishStream() {
+	f.flushPending()
+	if f.filter.Stopped() && !f.stopReasonSent {
+		f.writeStopReason()
+	}
+	f.logWords()
+}
+
+// logWords 记录命中的敏感词
+func (f *sensitiveFilter) logWords() {
+	if words := f.filter.Words(); len(words) > 0 {
+		common.LogWarn(f.c, fmt.Sprintf("completion sensitive words detected: %s", strings.Join(words, ", ")))
+	}
+}
+
+func (f *sensitiveFilter) rewriteEvent(event string, name string, payload string, ok bool) {
+	if !ok {
+		_, _ = f.out.WriteString(event)
+		return
+	}
+	if payload == "[DONE]" {
+		f.flushPending()
+		_, _ = f.out.WriteString(event)
+		return
+	}
+	chunk, err := decodeSSEChunk(payload)
+	if err != nil {
+		if !f.filter.Stopped() {
+			_, _ = f.out.WriteString(event)
+		}
+		return
+	}
+	switch f.format {
+	case relaycommon.RelayFormatClaude:
+		f.writeClaudeEvent(name, chunk)
+	case relaycommon.RelayFormatGemini:
+		f.writeGeminiChunk(chunk)
+	case sensitiveFormatResponses:
+		f.writeResponsesEvent(name, chunk)
+	default:
+		f.writeOpenAIChunk(chunk)
+	}
+	if f.filter.Stopped() {
+		f.cancelUpstream()
+	}
+}
+
+// cancelUpstream 停止输出后取消上游请求，不再为客户端收不到的内容继续生成
+func (f *sensitiveFilter) cancelUpstream() {
+	if f.upstreamCancelled {
+		return
+	}
+	f.upstreamCancelled = true
+	if cancel, ok := f.c.Get(constant.ContextKeyUpstreamCancel); ok {
+		cancel.(context.CancelFunc)()
+	}
+}
+
+func (f *sensitiveFilter) writeData(name string, chunk map[string]any) {
+	writeSSEData(f.out, name, chunk)
+}
+
+// filterDelta 过滤一个字段的增量文本，字段结束时一并输出保留的文本
+func (f *sensitiveFilter) filterDelta(key string, text string, finished bool) string {
+	text = f.filter.Filter(key, text)
+	if finished {
+		text += f.filter.Flush(key)
+	}
+	return text
+}
+
+func (f *sensitiveFilter) writeOpenAIChunk(chunk map[string]any) {
+	choices, _ := chunk["choices"].([]any)
+	if f.filter.Stopped() {
+		// 已停止输出，只保留携带用量的事件
+		if len(choices) == 0 {
+			f.writeData("", chunk)
+		}
+		return
+	}
+	f.lastChunk = chunk
+	for _, item := range choices {
+		choice, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		index := fmt.Sprint(choice["index"])
+		finished := choice["finish_reason"] != nil
+		// 文本补全接口的增量在 text 字段
+		if text, ok := choice["text"].(string); ok {
+			choice["text"] = f.filterDelta(index+"/text", text, finished)
+		}
+		delta, _ := choice["delta"].(map[string]any)
+		if delta == nil {
+			continue
+		}
+		for _, field := range sensitiveOpenAIDeltaFields {
+			text, ok := delta[field].(string)
+			if !ok && !finished {
+				continue
+			}
+			if text = f.filterDelta(index+"/"+field, text, finished); ok || text != "" {
+				delta[field] = text
+			}
+		}
+	}
+	if f.filter.Stopped() {
+		for _, item := range choices {
+			if choice, ok := item.(map[string]any); ok {
+				choice["finish_reason"] = "content_filter"
+			}
+		}
+		f.stopReasonSent = true
+	}
+	f.writeData("", chunk)
+}
+
+func (f *sensitiveFilter) writeClaudeEvent(name string, chunk map[string]any) {
+	eventType, _ := chunk["type"].(string)
+	if name == "" {
+		name = eventType
+	}
+	if f.filter.Stopped() {
+		switch eventType {
+		case "message_delta":
+			// 保留上游的用量，替换结束原因
+			if delta, ok := chunk["delta"].(map[string]any); ok {
+				delta["stop_reason"] = "refusal"
+				delta["stop_sequence"] = nil
+			}
+			f.stopReasonSent = true
+			f.writeData(name, chunk)
+		case "message_stop", "ping", "error":
+			f.writeData(name, chunk)
+		}
+		return
+	}
+	index := fmt.Sprint(chunk["index"])
+	switch eventType {
+	case "content_block_delta":
+		delta, _ := chunk["delta"].(map[string]any)
+		deltaType, _ := delta["type"].(string)
+		field, ok := sensitiveClaudeDeltaFields[deltaType]
+		if !ok {
+			f.writeData(name, chunk)
+			return
+		}
+		text, _ := delta[field].(string)
+		// 文本全部被保留时不输出空的增量事件
+		if text = f.filter.Filter(index+"/"+field, text); text != "" {
+			delta[field] = text
+			f.writeData(name, chunk)
+		}
+		if f.filter.Stopped() {
+			f.writeData("content_block_stop", map[string]any{"type": "content_block_stop", "index": chunk["index"]})
+		}
+	case "content_block_stop":
+		f.flushClaudeBlock(chunk["index"])
+		f.writeData(name, chunk)
+	default:
+		f.writeData(name, chunk)
+	}
+}
+
+// flushClaudeBlock 内容块结束前输出保留的文本
+func (f *sensitiveFilter) flushClaudeBlock(index any) {
+	for deltaType, field := range sensitiveClaudeDeltaFields {
+		text := f.filter.Flush(fmt.Sprint(index) + "/" + field)
+		if text == "" {
+			continue
+		}
+		f.writeData("content_block_delta", map[string]any{
+			"type":  "content_block_delta",
+			"index": index,
+			"delta": map[string]any{"type": deltaType, field: text},
+		})
+	}
+}
+
+func geminiPartField(part map[string]any) string {
+	if thought, _ := part["thought"].(bool); thought {
+		return "thought"
+	}
+	return "text"
+}
+
+func (f *sensitiveFilter) writeGeminiChunk(chunk map[string]any) {
+	if f.filter.Stopped() {
+		// 已停止输出，只保留用量
+		if _, ok := chunk["usageMetadata"]; ok {
+			delete(chunk, "candidates")
+			f.writeData("", chunk)
+		}
+		return
+	}
+	f.lastChunk = chunk
+	candidates, _ := chunk["candidates"].([]any)
+	for i, item := range candidates {
+		candidate, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		finishReason, _ := candidate["finishReason"].(string)
+		content, _ := candidate["content"].(map[string]any)
+		parts, _ := content["parts"].([]any)
+		for _, partItem := range parts {
+			part, ok := partItem.(map[string]any)
+			if !ok {
+				continue
+			}
+			if text, ok := part["text"].(string); ok {
+				part["text"] = f.filter.Filter(fmt.Sprintf("%d/%s", i, geminiPartField(part)), text)
+			}
+		}
+		if f.filter.Stopped() {
+			candidate["finishReason"] = "SAFETY"
+			f.stopReasonSent = true
+			break
+		}
+		if finishReason != "" && content != nil {
+			content["parts"] = appendGeminiPending(parts, f.filter, i)
+		}
+	}
+	f.writeData("", chunk)
+}
+
+// appendGeminiPending 候选结束时将保留的文本追加到最后一个同类文本片段
+func appendGeminiPending(parts []any, filter *service.CompletionSensitiveFilter, index int) []any {
+	for _, field := range []string{"text", "thought"} {
+		text := filter.Flush(fmt.Sprintf("%d/%s", index, field))
+		if text == "" {
+			continue
+		}
+		appended := false
+		for j := len(parts) - 1; j >= 0; j-- {
+			part, ok := parts[j].(map[string]any)
+			if !ok {
+				continue
+			}
+			if existing, ok := part["text"].(string); ok && geminiPartField(part) == field {
+				part["text"] = existing + text
+				appended = true
+				break
+			}
+		}
+		if !appended {
+			part := map[string]any{"text": text}
+			if field == "thought" {
+				part["thought"] = true
+			}
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// responsesTextKey Responses 流式事件的文本字段，按输出项与内容序号区分
+func responsesTextKey(chunk map[string]any) string {
+	if index, ok := chunk["summary_index"]; ok {
+		return fmt.Sprintf("%v/summary/%v", chunk["item_id"], index)
+	}
+	return fmt.Sprintf("%v/content/%v", chunk["item_id"], chunk["content_index"])
+}
+
+func (f *sensitiveFilter) writeResponsesEvent(name string, chunk map[string]any) {
+	eventType, _ := chunk["type"].(string)
+	if name == "" {
+		name = eventType
+	}
+	if f.filter.Stopped() {
+		switch eventType {
+		case "response.completed", "response.incomplete", "response.failed":
+			name = f.filterResponsesTerminal(chunk)
+			f.writeData(name, chunk)
+		case "error":
+			f.writeData(name, chunk)
+		}
+		return
+	}
+	switch eventType {
+	case "response.output_text.delta", "response.reasoning_summary_text.delta":
+		delta, _ := chunk["delta"].(string)
+		if delta = f.filter.Filter(responsesTextKey(chunk), delta); delta == "" {
+			return
+		}
+		chunk["delta"] = delta
+	case "response.output_text.done", "response.reasoning_summary_text.done":
+		if rest := f.filter.Flush(responsesTextKey(chunk)); rest != "" {
+			deltaChunk := make(map[string]any, len(chunk))
+			for key, value := range chunk {
+				deltaChunk[key] = value
+			}
+			delete(deltaChunk, "text")
+			deltaChunk["type"] = strings.TrimSuffix(eventType, ".done") + ".delta"
+			deltaChunk["delta"] = rest
+			f.writeData(deltaChunk["type"].(string), deltaChunk)
+		}
+		if text, ok := chunk["text"].(string); ok {
+			chunk["text"], _ = f.filter.FilterText(text)
+		}
+	case "response.content_part.done", "response.reasoning_summary_part.done":
+		if part, ok := chunk["part"].(map[string]any); ok {
+			f.filterResponsesText(part)
+		}
+	case "response.output_item.done":
+		if item, ok := chunk["item"].(map[string]any); ok {
+			f.filterResponsesItem(item)
+		}
+	case "response.completed", "response.incomplete", "response.failed":
+		name = f.filterResponsesTerminal(chunk)
+	}
+	f.writeData(name, chunk)
+}
+
+// filterResponsesTerminal 过滤响应结束事件中的完整输出，停止输出时改为 response.incomplete，返回事件类型
+func (f *sensitiveFilter) filterResponsesTerminal(chunk map[string]any) string {
+	eventType, _ := chunk["type"].(string)
+	response, ok := chunk["response"].(map[string]any)
+	if !ok {
+		return eventType
+	}
+	f.filterResponsesObject(response)
+	if f.filter.Stopped() && eventType == "response.completed" {
+		eventType = "response.incomplete"
+		chunk["type"] = eventType
+	}
+	if f.filter.Stopped() {
+		f.stopReasonSent = true
+	}
+	return eventType
+}
+
+// filterResponsesObject 过滤 Responses 响应对象的全部输出，停止输出时标记为未完成
+func (f *sensitiveFilter) filterResponsesObject(response map[string]any) bool {
+	hit := false
+	output, _ := response["output"].([]any)
+	for _, item := range output {
+		if item, ok := item.(map[string]any); ok && f.filterResponsesItem(item) {
+			hit = true
+		}
+	}
+	if f.filter.Stopped() {
+		response["status"] = "incomplete"
+		response["incomplete_details"] = map[string]any{"reason": "content_filter"}
+	}
+	return hit
+}
+
+func (f *sensitiveFilter) filterResponsesItem(item map[string]any) bool {
+	hit := false
+	for _, field := range []string{"content", "summary"} {
+		parts, _ := item[field].([]any)
+		for _, part := range parts {
+			if part, ok := part.(map[string]any); ok && f.filterResponsesText(part) {
+				hit = true
+			}
+		}
+	}
+	return hit
+}
+
+func (f *sensitiveFilter) filterResponsesText(part map[string]any) bool {
+	text, ok := part["text"].(string)
+	if !ok {
+		return false
+	}
+	filtered, hit := f.filter.FilterText(text)
+	part["text"] = filtered
+	return hit
+}
+
+// flushPending 上游没有正常结束字段时输出保留的文本
+func (f *sensitiveFilter) flushPending() {
+	pending := f.filter.FlushAll()
+	if len(pending) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(pending))
+	for key := range pending {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	switch f.format {
+	case relaycommon.RelayFormatClaude:
+		for _, key := range keys {
+			index, field, _ := strings.Cut(key, "/")
+			deltaType := "text_delta"
+			if field == "thinking" {
+				deltaType = "thinking_delta"
+			}
+			f.writeData("content_block_delta", map[string]any{
+				"type":  "content_block_delta",
+				"index": json.Number(index),
+				"delta": map[string]any{"type": deltaType, field: pending[key]},
+			})
+		}
+	case sensitiveFormatResponses:
+		for _, key := range keys {
+			itemId, rest, _ := strings.Cut(key, "/")
+			kind, index, _ := strings.Cut(rest, "/")
+			chunk := map[string]any{"item_id": itemId, "delta": pending[key]}
+			if kind == "summary" {
+				chunk["type"] = "response.reasoning_summary_text.delta"
+				chunk["summary_index"] = json.Number(index)
+			} else {
+				chunk["type"] = "response.output_text.delta"
+				chunk["content_index"] = json.Number(index)
+			}
+			f.writeData(chunk["type"].(string), chunk)
+		}
+	case relaycommon.RelayFormatGemini:
+		candidates := make([]any, 0, len(keys))
+		for _, key := range keys {
+			index, field, _ := strings.Cut(key, "/")
+			part := map[string]any{"text": pending[key]}
+			if field == "thought" {
+				part["thought"] = true
+			}
+			candidates = append(candidates, map[string]any{
+				"index":   json.Number(index),
+				"content": map[string]any{"role": "model", "parts": []any{part}},
+			})
+		}
+		f.writeData("", map[string]any{"candidates": candidates})
+	default:
+		if f.lastChunk == nil {
+			return
+		}
+		chunk := make(map[string]any, len(f.lastChunk))
+		for key, value := range f.lastChunk {
+			if key != "choices" && key != "usage" {
+				chunk[key] = value
+			}
+		}
+		choices := make([]any, 0, len(keys))
+		for _, key := range keys {
+			index, field, _ := strings.Cut(key, "/")
+			choice := map[string]any{"index": json.Number(index)}
+			if field == "text" {
+				choice["text"] = pending[key]
+			} else {
+				choice["delta"] = map[string]any{field: pending[key]}
+			}
+			choices = append(choices, choice)
+		}
+		chunk["choices"] = choices
+		f.writeData("", chunk)
+	}
+}
+
+// writeStopReason 停止输出后上游没有发送结束事件时补充结束原因
+func (f *sensitiveFilter) writeStopReason() {
+	f.stopReasonSent = true
+	switch f.format {
+	case relaycommon.RelayFormatClaude:
+		f.writeData("message_delta", map[string]any{
+			"type":  "message_delta",
+			"delta": map[string]any{"stop_reason": "refusal", "stop_sequence": nil},
+		})
+		f.writeData("message_stop", map[string]any{"type": "message_stop"})
+	}
+}
+
+// filterBody 过滤非流式响应，未命中敏感词时原样返回
+func (f *sensitiveFilter) filterBody(body []byte) []byte {
+	var response map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&response); err != nil {
+		return body
+	}
+	hit := false
+	switch f.format {
+	case relaycommon.RelayFormatClaude:
+		hit = f.filterClaudeBody(response)
+	case relaycommon.RelayFormatGemini:
+		hit = f.filterGeminiBody(response)
+	case sensitiveFormatResponses:
+		hit = f.filterResponsesObject(response)
+	default:
+		hit = f.filterOpenAIBody(response)
+	}
+	if !hit {
+		return body
+	}
+	data, err := json.Marshal(response)
+	if err != nil {
+		common.SysError("error marshalling sensitive filtered response: " + err.Error())
+		return body
+	}
+	return data
+}
+
+func (f *sensitiveFilter) filterOpenAIBody(response map[string]any) bool {
+	hit := false
+	choices, _ := response["choices"].([]any)
+	for _, item := range choices {
+		choice, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		choiceHit := false
+		if text, ok := choice["text"].(string); ok {
+			filtered, textHit := f.filter.FilterText(text)
+			choice["text"] = filtered
+			choiceHit = choiceHit || textHit
+		}
+		message, _ := choice["message"].(map[string]any)
+		for _, field := range sensitiveOpenAIDeltaFields {
+			text, ok := message[field].(string)
+			if !ok {
+				continue
+			}
+			filtered, textHit := f.filter.FilterText(text)
+			message[field] = filtered
+			choiceHit = choiceHit || textHit
+		}
+		if choiceHit && f.filter.Stopped() {
+			choice["finish_reason"] = "content_filter"
+		}
+		hit = hit || choiceHit
+	}
+	return hit
+}
+
+func (f *sensitiveFilter) filterClaudeBody(response map[string]any) bool {
+	hit := false
+	content, _ := response["content"].([]any)
+	for i, item := range content {
+		block, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		for _, field := range sensitiveClaudeDeltaFields {
+			text, ok := block[field].(string)
+			if !ok {
+				continue
+			}
+			filtered, textHit := f.filter.FilterText(text)
+			block[field] = filtered
+			hit = hit || textHit
+		}
+		if hit && f.filter.Stopped() {
+			// 停止输出时丢弃命中敏感词之后的内容块
+			response["content"] = content[:i+1]
+			response["stop_reason"] = "refusal"
+			response["stop_sequence"] = nil
+			break
+		}
+	}
+	return hit
+}
+
+func (f *sensitiveFilter) filterGeminiBody(response map[string]any) bool {
+	hit := false
+	candidates, _ := response["candidates"].([]any)
+	for _, item := range candidates {
+		candidate, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		content, _ := candidate["content"].(map[string]any)
+		parts, _ := content["parts"].([]any)
+		for j, partItem := range parts {
+			part, ok := partItem.(map[string]any)
+			if !ok {
+				continue
+			}
+			text, ok := part["text"].(string)
+			if !ok {
+				continue
+			}
+			filtered, textHit := f.filter.FilterText(text)
+			part["text"] = filtered
+			if textHit {
+				hit = true
+				if f.filter.Stopped() {
+					content["parts"] = parts[:j+1]
+					candidate["finishReason"] = "SAFETY"
+					break
+				}
+			}
+		}
+	}
+	return hit
+}
@@ -0,0 +1,148 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"one-api/common"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sseEventRewriter 改写响应内容：流式响应按 SSE 事件改写，非流式响应在结束时整体改写
+type sseEventRewriter interface {
+	// rewriteEvent 改写一个 SSE 事件，ok 为 false 表示事件没有数据行
+	rewriteEvent(event string, name string, data string, ok bool)
+	// finishStream 流式响应结束时输出保留的内容
+	finishStream()
+	// rewriteBody 改写完整的非流式响应
+	rewriteBody(body []byte) []byte
+}
+
+// sseRewriteWriter 缓存上游写入的响应，按完整的 SSE 事件交给 rewriter 改写后输出
+type sseRewriteWriter struct {
+	gin.ResponseWriter
+	rewriter sseEventRewriter
+	buffer   bytes.Buffer
+	finished bool
+}
+
+// startSSERewrite 替换 c.Writer，rewriter 应输出到替换前的 c.Writer
+func startSSERewrite(c *gin.Context, rewriter sseEventRewriter) *sseRewriteWriter {
+	writer := &sseRewriteWriter{
+		ResponseWriter: c.Writer,
+		rewriter:       rewriter,
+	}
+	c.Writer = writer
+	return writer
+}
+
+func (w *sseRewriteWriter) isStream() bool {
+	return strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream")
+}
+
+// WriteHeader 改写后长度会变化，去掉上游的 Content-Length
+func (w *sseRewriteWriter) WriteHeader(code int) {
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *sseRewriteWriter) WriteHeaderNow() {
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *sseRewriteWriter) Write(data []byte) (int, error) {
+	w.buffer.Write(data)
+	if w.isStream() {
+		w.writeEvents(false)
+	}
+	return len(data), nil
+}
+
+func (w *sseRewriteWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Finish 输出剩余内容，应在上游响应处理完成后调用，重复调用不会重复输出
+func (w *sseRewriteWriter) Finish() {
+	if w.finished {
+		return
+	}
+	w.finished = true
+	if w.isStream() {
+		w.writeEvents(true)
+		w.rewriter.finishStream()
+		w.ResponseWriter.Flush()
+		return
+	}
+	body := w.buffer.Bytes()
+	w.buffer.Reset()
+	if rewritten := w.rewriter.rewriteBody(body); len(rewritten) > 0 {
+		_, _ = w.ResponseWriter.Write(rewritten)
+	}
+}
+
+// writeEvents 输出缓冲区中完整的 SSE 事件，final 为 true 时同时输出不完整的剩余部分
+func (w *sseRewriteWriter) writeEvents(final bool) {
+	for {
+		data := w.buffer.Bytes()
+		idx := bytes.Index(data, []byte("\n\n"))
+		if idx < 0 {
+			if final && len(bytes.TrimSpace(data)) > 0 {
+				w.writeEvent(string(data))
+				w.buffer.Reset()
+			}
+			return
+		}
+		event := string(data[:idx+2])
+		w.buffer.Next(idx + 2)
+		if strings.TrimSpace(event) != "" {
+			w.writeEvent(event)
+		}
+	}
+}
+
+func (w *sseRewriteWriter) writeEvent(event string) {
+	name, data, ok := parseSSEEvent(event)
+	w.rewriter.rewriteEvent(event, name, data, ok)
+}
+
+// parseSSEEvent 解析 SSE 事件的类型与数据，没有数据行时返回 false
+func parseSSEEvent(event string) (string, string, bool) {
+	var name string
+	var data []string
+	for _, line := range strings.Split(strings.TrimLeft(event, "\r\n"), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if value, ok := strings.CutPrefix(line, "event:"); ok {
+			name = strings.TrimSpace(value)
+		} else if value, ok := strings.CutPrefix(line, "data:"); ok {
+			data = append(data, strings.TrimSpace(value))
+		}
+	}
+	return name, strings.Join(data, "\n"), len(data) > 0
+}
+
+// decodeSSEChunk 解析事件数据，保留数字原样以免改写后精度变化
+func decodeSSEChunk(data string) (map[string]any, error) {
+	var chunk map[string]any
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&chunk); err != nil {
+		return nil, err
+	}
+	return chunk, nil
+}
+
+// writeSSEData 输出一个改写后的事件，name 为空时不输出事件类型
+func writeSSEData(out gin.ResponseWriter, name string, chunk map[string]any) {
+	data, err := json.Marshal(chunk)
+	if err != nil {
+		common.SysError("error marshalling rewritten sse chunk: " + err.Error())
+		return
+	}
+	if name != "" {
+		_, _ = out.WriteString("event: " + name + "\n")
+	}
+	_, _ = out.WriteString("data: " + string(data) + "\n\n")
+}
@@ -25,6 +25,9 @@ Task 任务通过平台、Action 区分任务
 func RelayTaskSubmit(c *gin.Context, relayMode int) (taskErr *dto.TaskError) {
 	platform := constant.TaskPlatform(c.GetString("platform"))
 	relayInfo := relaycommon.GenTaskRelayInfo(c)
+	if err := service.CheckPIIRedactionUnsupported(relayInfo.ChannelId, relayInfo.Group); err != nil {
+		return service.TaskErrorWrapperLocal(err, "pii_redaction_unsupported", http.StatusBadRequest)
+	}
 
 	adaptor := GetTaskAdaptor(platform)
 	if adaptor == nil {
@@ -49,15 +52,30 @@ func RelayTaskSubmit(c *gin.Context, relayMode int) (taskErr *dto.TaskError) {
 	}
 
 	// 预扣
+	_, scheduleMultiplier := operation_setting.GetActivePricingSchedules(modelName, relayInfo.Group, relayInfo.StartTime)
 	groupRatio := setting.GetGroupRatio(relayInfo.Group)
-	ratio := modelPrice * groupRatio
-	userQuota, err := model.GetUserQuota(relayInfo.UserId, false)
+	ratio := modelPrice * groupRatio * scheduleMultiplier
+	userQuota, err := service.GetPayerQuota(relayInfo.RelayInfo)
 	if err != nil {
 		taskErr = service.TaskErrorWrapper(err, "get_user_quota_failed", http.StatusInternalServerError)
 		return
 	}
 	quota := int(ratio * common.QuotaPerUnit)
-	if userQuota-quota < 0 {
+	if err = service.CheckTokenMaxPrice(c, quota); err != nil {
+		taskErr = service.TaskErrorWrapperLocal(err, "token_max_price_exceeded", http.StatusForbidden)
+		return
+	}
+	relayInfo.OriginModelName = modelName
+	if err = service.CheckTokenBudget(relayInfo.RelayInfo, quota); err != nil {
+		taskErr = service.TaskErrorWrapperLocal(err, "token_budget_exceeded", http.StatusForbidden)
+		return
+	}
+	subscriptionQuota, err := service.GetSubscriptionRemainQuota(relayInfo.RelayInfo, quota)
+	if err != nil {
+		taskErr = service.TaskErrorWrapperLocal(err, "insufficient_subscription_quota", http.StatusForbidden)
+		return
+	}
+	if userQuota+subscriptionQuota-quota < 0 {
 		taskErr = service.TaskErrorWrapperLocal(errors.New("user quota is not enough"), "quota_not_enough", http.StatusForbidden)
 		return
 	}
@@ -81,6 +99,9 @@ func RelayTaskSubmit(c *gin.Context, relayMode int) (taskErr *dto.TaskError) {
 			if channel.Status != common.ChannelStatusEnabled {
 				return service.TaskErrorWrapperLocal(errors.New("该任务所属渠道已被禁用"), "task_channel_disable", http.StatusBadRequest)
 			}
+			if err = channel.KeyError(); err != nil {
+				return service.TaskErrorWrapperLocal(err, "channel_key_decrypt_failed", http.StatusInternalServerError)
+			}
 			c.Set("base_url", channel.GetBaseURL())
 			c.Set("channel_id", originTask.ChannelId)
 			c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", channel.Key))
@@ -109,20 +130,34 @@ func RelayTaskSubmit(c *gin.Context, relayMode int) (taskErr *dto.TaskError) {
 		return
 	}
 
+	var task *model.Task
 	defer func() {
 		// release quota
 		if relayInfo.ConsumeQuota && taskErr == nil {
 
-			err := service.PostConsumeQuota(relayInfo.RelayInfo, quota, 0, true)
+			coveredQuota, err := service.PostConsumeQuotaWithSubscription(relayInfo.RelayInfo, quota, 0, true)
 			if err != nil {
 				common.SysError("error consuming token remain quota: " + err.Error())
 			}
+			if notCharged := coveredQuota + relayInfo.SubscriptionWaivedQuota; notCharged > 0 && task != nil {
+				if err := task.UpdateSubscriptionQuota(notCharged); err != nil {
+					common.SysError("error update task subscription quota: " + err.Error())
+				}
+			}
 			if quota != 0 {
 				tokenName := c.GetString("token_name")
 				logContent := fmt.Sprintf("模型固定价格 %.2f，分组倍率 %.2f，操作 %s", modelPrice, groupRatio, relayInfo.Action)
 				other := make(map[string]interface{})
 				other["model_price"] = modelPrice
 				other["group_ratio"] = groupRatio
+				if scheduleMultiplier != 1 {
+					logContent += fmt.Sprintf("，定价时段倍率 %.2f", scheduleMultiplier)
+					other["pricing_schedule_multiplier"] = scheduleMultiplier
+				}
+				if coveredQuota > 0 {
+					logContent += fmt.Sprintf("，套餐额度抵扣 %s", common.LogQuota(coveredQuota))
+				}
+				service.AppendSubscriptionInfo(other, relayInfo.RelayInfo, coveredQuota)
 				model.RecordConsumeLog(c, relayInfo.UserId, relayInfo.ChannelId, 0, 0,
 					modelName, tokenName, quota, logContent, relayInfo.TokenId, userQuota, 0, false, relayInfo.Group, other)
 				model.UpdateUserUsedQuotaAndRequestCount(relayInfo.UserId, quota)
@@ -137,7 +172,7 @@ func RelayTaskSubmit(c *gin.Context, relayMode int) (taskErr *dto.TaskError) {
 	}
 	relayInfo.ConsumeQuota = true
 	// insert task
-	task := model.InitTask(constant.TaskPlatformSuno, relayInfo)
+	task = model.InitTask(constant.TaskPlatformSuno, relayInfo)
 	task.TaskID = taskID
 	task.Quota = quota
 	task.Data = taskData
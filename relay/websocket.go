@@ -17,6 +17,10 @@ import (
 func WssHelper(c *gin.Context, ws *websocket.Conn) (openaiErr *dto.OpenAIErrorWithStatusCode) {
 	relayInfo := relaycommon.GenRelayInfoWs(c, ws)
 
+	if err := service.CheckPIIRedactionUnsupported(relayInfo.ChannelId, relayInfo.Group); err != nil {
+		return service.OpenAIErrorWrapperLocal(err, "pii_redaction_unsupported", http.StatusBadRequest)
+	}
+
 	// get & validate textRequest 获取并验证文本请求
 	//realtimeEvent, err := getAndValidateWssRequest(c, ws)
 	//if err != nil {
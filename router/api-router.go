@@ -3,6 +3,7 @@ package router
 import (
 	"one-api/controller"
 	"one-api/middleware"
+	"one-api/model"
 
 	"github.com/gin-contrib/gzip"
 	"github.com/gin-gonic/gin"
@@ -17,7 +18,7 @@ func SetApiRouter(router *gin.Engine) {
 		apiRouter.POST("/setup", controller.PostSetup)
 		apiRouter.GET("/status", controller.GetStatus)
 		apiRouter.GET("/models", middleware.UserAuth(), controller.DashboardListModels)
-		apiRouter.GET("/status/test", middleware.AdminAuth(), controller.TestStatus)
+		apiRouter.GET("/status/test", middleware.PermissionAuth(model.PermissionSystemRead), controller.TestStatus)
 		apiRouter.GET("/notice", controller.GetNotice)
 		apiRouter.GET("/about", controller.GetAbout)
 		//apiRouter.GET("/midjourney", controller.GetMidjourney)
@@ -36,13 +37,17 @@ func SetApiRouter(router *gin.Engine) {
 		apiRouter.GET("/oauth/telegram/login", middleware.CriticalRateLimit(), controller.TelegramLogin)
 		apiRouter.GET("/oauth/telegram/bind", middleware.CriticalRateLimit(), controller.TelegramBind)
 
+		apiRouter.POST("/payment/notify/:provider", controller.PaymentNotify)
+
 		userRoute := apiRouter.Group("/user")
 		{
 			userRoute.POST("/register", middleware.CriticalRateLimit(), middleware.TurnstileCheck(), controller.Register)
 			userRoute.POST("/login", middleware.CriticalRateLimit(), middleware.TurnstileCheck(), controller.Login)
+			userRoute.POST("/login/2fa", middleware.CriticalRateLimit(), controller.LoginTwoFA)
 			//userRoute.POST("/tokenlog", middleware.CriticalRateLimit(), controller.TokenLog)
 			userRoute.GET("/logout", controller.Logout)
 			userRoute.GET("/epay/notify", controller.EpayNotify)
+			userRoute.GET("/payment/providers", controller.GetPaymentProviders)
 			userRoute.GET("/groups", controller.GetUserGroups)
 
 			selfRoute := userRoute.Group("/")
@@ -58,62 +63,168 @@ func SetApiRouter(router *gin.Engine) {
 				selfRoute.POST("/topup", controller.TopUp)
 				selfRoute.POST("/pay", controller.RequestEpay)
 				selfRoute.POST("/amount", controller.RequestAmount)
+				selfRoute.POST("/payment", controller.RequestPayment)
+				selfRoute.GET("/payment/:trade_no", controller.GetPaymentOrder)
 				selfRoute.POST("/aff_transfer", controller.TransferAffQuota)
 				selfRoute.PUT("/setting", controller.UpdateUserSetting)
+				selfRoute.GET("/2fa", controller.GetTwoFAStatus)
+				selfRoute.POST("/2fa/setup", controller.SetupTwoFA)
+				selfRoute.POST("/2fa/enable", middleware.CriticalRateLimit(), controller.EnableTwoFA)
+				selfRoute.POST("/2fa/disable", middleware.CriticalRateLimit(), controller.DisableTwoFA)
+				selfRoute.POST("/2fa/recovery_codes", middleware.CriticalRateLimit(), controller.RegenerateTwoFARecoveryCodes)
 			}
 
 			adminRoute := userRoute.Group("/")
-			adminRoute.Use(middleware.AdminAuth())
 			{
-				adminRoute.GET("/", controller.GetAllUsers)
-				adminRoute.GET("/search", controller.SearchUsers)
-				adminRoute.GET("/:id", controller.GetUser)
-				adminRoute.POST("/", controller.CreateUser)
-				adminRoute.POST("/manage", controller.ManageUser)
-				adminRoute.PUT("/", controller.UpdateUser)
-				adminRoute.DELETE("/:id", controller.DeleteUser)
+				adminRoute.GET("/", middleware.PermissionAuth(model.PermissionUserRead), controller.GetAllUsers)
+				adminRoute.GET("/search", middleware.PermissionAuth(model.PermissionUserRead), controller.SearchUsers)
+				adminRoute.GET("/:id", middleware.PermissionAuth(model.PermissionUserRead), controller.GetUser)
+				adminRoute.POST("/", middleware.PermissionAuth(model.PermissionUserWrite), controller.CreateUser)
+				adminRoute.POST("/manage", middleware.PermissionAuth(model.PermissionUserWrite), controller.ManageUser)
+				adminRoute.PUT("/", middleware.PermissionAuth(model.PermissionUserWrite), controller.UpdateUser)
+				adminRoute.PUT("/ip_limits", middleware.PermissionAuth(model.PermissionUserWrite), controller.UpdateUserIpLimits)
+				adminRoute.DELETE("/:id", middleware.PermissionAuth(model.PermissionUserWrite), controller.DeleteUser)
+				adminRoute.DELETE("/:id/2fa", middleware.PermissionAuth(model.PermissionUserWrite), controller.ResetUserTwoFA)
 			}
 		}
 		optionRoute := apiRouter.Group("/option")
-		optionRoute.Use(middleware.RootAuth())
 		{
-			optionRoute.GET("/", controller.GetOptions)
-			optionRoute.PUT("/", controller.UpdateOption)
-			optionRoute.POST("/rest_model_ratio", controller.ResetModelRatio)
+			optionRoute.GET("/", middleware.PermissionAuth(model.PermissionOptionRead), controller.GetOptions)
+			optionRoute.PUT("/", middleware.PermissionAuth(model.PermissionOptionWrite), controller.UpdateOption)
+			optionRoute.POST("/rest_model_ratio", middleware.PermissionAuth(model.PermissionOptionWrite), controller.ResetModelRatio)
 		}
 		channelRoute := apiRouter.Group("/channel")
-		channelRoute.Use(middleware.AdminAuth())
-		{
-			channelRoute.GET("/", controller.GetAllChannels)
-			channelRoute.GET("/search", controller.SearchChannels)
-			channelRoute.GET("/models", controller.ChannelListModels)
-			channelRoute.GET("/models_enabled", controller.EnabledListModels)
-			channelRoute.GET("/:id", controller.GetChannel)
-			channelRoute.GET("/test", controller.TestAllChannels)
-			channelRoute.GET("/test/:id", controller.TestChannel)
-			channelRoute.GET("/update_balance", controller.UpdateAllChannelsBalance)
-			channelRoute.GET("/update_balance/:id", controller.UpdateChannelBalance)
-			channelRoute.POST("/", controller.AddChannel)
-			channelRoute.PUT("/", controller.UpdateChannel)
-			channelRoute.DELETE("/disabled", controller.DeleteDisabledChannel)
-			channelRoute.POST("/tag/disabled", controller.DisableTagChannels)
-			channelRoute.POST("/tag/enabled", controller.EnableTagChannels)
-			channelRoute.PUT("/tag", controller.EditTagChannels)
-			channelRoute.DELETE("/:id", controller.DeleteChannel)
-			channelRoute.POST("/batch", controller.DeleteChannelBatch)
-			channelRoute.POST("/fix", controller.FixChannelsAbilities)
-			channelRoute.GET("/fetch_models/:id", controller.FetchUpstreamModels)
-			channelRoute.POST("/fetch_models", controller.FetchModels)
-			channelRoute.POST("/batch/tag", controller.BatchSetChannelTag)
+		{
+			channelRoute.GET("/", middleware.PermissionAuth(model.PermissionChannelRead), controller.GetAllChannels)
+			channelRoute.GET("/search", middleware.PermissionAuth(model.PermissionChannelRead), controller.SearchChannels)
+			channelRoute.GET("/models", middleware.PermissionAuth(model.PermissionChannelRead), controller.ChannelListModels)
+			channelRoute.GET("/models_enabled", middleware.PermissionAuth(model.PermissionChannelRead), controller.EnabledListModels)
+			channelRoute.GET("/:id", middleware.PermissionAuth(model.PermissionChannelRead), controller.GetChannel)
+			channelRoute.GET("/:id/key", middleware.PermissionAuth(model.PermissionChannelKey), controller.GetChannelKey)
+			channelRoute.GET("/test", middleware.PermissionAuth(model.PermissionChannelWrite), controller.TestAllChannels)
+			channelRoute.GET("/test/:id", middleware.PermissionAuth(model.PermissionChannelWrite), controller.TestChannel)
+			channelRoute.GET("/update_balance", middleware.PermissionAuth(model.PermissionChannelWrite), controller.UpdateAllChannelsBalance)
+			channelRoute.GET("/update_balance/:id", middleware.PermissionAuth(model.PermissionChannelWrite), controller.UpdateChannelBalance)
+			channelRoute.POST("/", middleware.PermissionAuth(model.PermissionChannelWrite), controller.AddChannel)
+			channelRoute.PUT("/", middleware.PermissionAuth(model.PermissionChannelWrite), controller.UpdateChannel)
+			channelRoute.DELETE("/disabled", middleware.PermissionAuth(model.PermissionChannelWrite), controller.DeleteDisabledChannel)
+			channelRoute.POST("/tag/disabled", middleware.PermissionAuth(model.PermissionChannelWrite), controller.DisableTagChannels)
+			channelRoute.POST("/tag/enabled", middleware.PermissionAuth(model.PermissionChannelWrite), controller.EnableTagChannels)
+			channelRoute.PUT("/tag", middleware.PermissionAuth(model.PermissionChannelWrite), controller.EditTagChannels)
+			channelRoute.DELETE("/:id", middleware.PermissionAuth(model.PermissionChannelWrite), controller.DeleteChannel)
+			channelRoute.POST("/batch", middleware.PermissionAuth(model.PermissionChannelWrite), controller.DeleteChannelBatch)
+			channelRoute.POST("/fix", middleware.PermissionAuth(model.PermissionChannelWrite), controller.FixChannelsAbilities)
+			channelRoute.GET("/fetch_models/:id", middleware.PermissionAuth(model.PermissionChannelWrite), controller.FetchUpstreamModels)
+			channelRoute.POST("/fetch_models", middleware.PermissionAuth(model.PermissionChannelWrite), controller.FetchModels)
+			channelRoute.POST("/batch/tag", middleware.PermissionAuth(model.PermissionChannelWrite), controller.BatchSetChannelTag)
 		}
 
 		// 分词器管理路由
 		tokenizerRoute := apiRouter.Group("/tokenizer")
-		tokenizerRoute.Use(middleware.AdminAuth())
 		{
-			tokenizerRoute.GET("/", controller.GetTokenizers)
-			tokenizerRoute.POST("/update", controller.UpdateTokenizers)
-			tokenizerRoute.GET("/verify", controller.VerifyTokenizers)
+			tokenizerRoute.GET("/", middleware.PermissionAuth(model.PermissionSystemRead), controller.GetTokenizers)
+			tokenizerRoute.POST("/update", middleware.PermissionAuth(model.PermissionSystemWrite), controller.UpdateTokenizers)
+			tokenizerRoute.GET("/verify", middleware.PermissionAuth(model.PermissionSystemRead), controller.VerifyTokenizers)
+		}
+
+		subscriptionRoute := apiRouter.Group("/subscription")
+		{
+			subscriptionSelfRoute := subscriptionRoute.Group("/")
+			subscriptionSelfRoute.Use(middleware.UserAuth())
+			{
+				subscriptionSelfRoute.GET("/plans", controller.GetPlans)
+				subscriptionSelfRoute.GET("/self", controller.GetSelfSubscription)
+				subscriptionSelfRoute.POST("/self", controller.Subscribe)
+				subscriptionSelfRoute.POST("/self/cancel", controller.CancelSelfSubscription)
+			}
+			subscriptionAdminRoute := subscriptionRoute.Group("/")
+			{
+				subscriptionAdminRoute.GET("/plan", middleware.PermissionAuth(model.PermissionBillingRead), controller.GetAllPlans)
+				subscriptionAdminRoute.POST("/plan", middleware.PermissionAuth(model.PermissionBillingWrite), controller.AddPlan)
+				subscriptionAdminRoute.PUT("/plan", middleware.PermissionAuth(model.PermissionBillingWrite), controller.UpdatePlan)
+				subscriptionAdminRoute.DELETE("/plan/:id", middleware.PermissionAuth(model.PermissionBillingWrite), controller.DeletePlan)
+				subscriptionAdminRoute.POST("/grant", middleware.PermissionAuth(model.PermissionBillingWrite), controller.GrantSubscription)
+			}
+		}
+
+		organizationRoute := apiRouter.Group("/organization")
+		{
+			organizationSelfRoute := organizationRoute.Group("/")
+			organizationSelfRoute.Use(middleware.UserAuth())
+			{
+				organizationSelfRoute.GET("/self", controller.GetSelfOrganizations)
+				organizationSelfRoute.POST("/", controller.CreateOrganization)
+				organizationSelfRoute.GET("/:id", controller.GetOrganization)
+				organizationSelfRoute.PUT("/:id", controller.UpdateOrganization)
+				organizationSelfRoute.DELETE("/:id", controller.DeleteOrganization)
+				organizationSelfRoute.GET("/:id/member", controller.GetOrganizationMembers)
+				organizationSelfRoute.POST("/:id/member", controller.AddOrganizationMember)
+				organizationSelfRoute.PUT("/:id/member", controller.UpdateOrganizationMember)
+				organizationSelfRoute.DELETE("/:id/member/:user_id", controller.RemoveOrganizationMember)
+				organizationSelfRoute.POST("/:id/transfer", controller.TransferQuotaToOrganization)
+				organizationSelfRoute.GET("/:id/token", controller.GetOrganizationTokens)
+				organizationSelfRoute.GET("/:id/log", controller.GetOrganizationLogs)
+				organizationSelfRoute.GET("/:id/data", controller.GetOrganizationQuotaDates)
+			}
+			organizationAdminRoute := organizationRoute.Group("/admin")
+			{
+				organizationAdminRoute.GET("/", middleware.PermissionAuth(model.PermissionUserRead), controller.GetAllOrganizations)
+				organizationAdminRoute.POST("/manage", middleware.PermissionAuth(model.PermissionUserWrite), controller.ManageOrganization)
+			}
+		}
+
+		statementRoute := apiRouter.Group("/statement")
+		{
+			statementSelfRoute := statementRoute.Group("/")
+			statementSelfRoute.Use(middleware.UserAuth())
+			{
+				statementSelfRoute.GET("/self", controller.GetSelfStatements)
+				statementSelfRoute.GET("/self/:id/export", controller.ExportSelfStatement)
+			}
+			statementAdminRoute := statementRoute.Group("/")
+			{
+				statementAdminRoute.GET("/", middleware.PermissionAuth(model.PermissionBillingRead), controller.GetAllStatements)
+				statementAdminRoute.GET("/:id/export", middleware.PermissionAuth(model.PermissionBillingRead), controller.ExportStatement)
+				statementAdminRoute.POST("/generate", middleware.PermissionAuth(model.PermissionBillingWrite), controller.GenerateStatements)
+			}
+		}
+
+		refundRoute := apiRouter.Group("/refund")
+		{
+			refundSelfRoute := refundRoute.Group("/")
+			refundSelfRoute.Use(middleware.UserAuth())
+			{
+				refundSelfRoute.GET("/self", controller.GetSelfRefunds)
+			}
+			refundAdminRoute := refundRoute.Group("/")
+			{
+				refundAdminRoute.GET("/", middleware.PermissionAuth(model.PermissionBillingRead), controller.GetAllRefunds)
+				refundAdminRoute.POST("/topup", middleware.PermissionAuth(model.PermissionBillingWrite), controller.RefundTopUp)
+				refundAdminRoute.POST("/consume", middleware.PermissionAuth(model.PermissionBillingWrite), controller.RefundConsume)
+			}
+		}
+
+		ledgerRoute := apiRouter.Group("/ledger")
+		{
+			ledgerSelfRoute := ledgerRoute.Group("/")
+			ledgerSelfRoute.Use(middleware.UserAuth())
+			{
+				ledgerSelfRoute.GET("/self", controller.GetSelfQuotaLedgers)
+			}
+			ledgerAdminRoute := ledgerRoute.Group("/")
+			{
+				ledgerAdminRoute.GET("/", middleware.PermissionAuth(model.PermissionBillingRead), controller.GetAllQuotaLedgers)
+				ledgerAdminRoute.GET("/accounts", middleware.PermissionAuth(model.PermissionBillingRead), controller.GetQuotaLedgerAccounts)
+				ledgerAdminRoute.GET("/reconcile", middleware.PermissionAuth(model.PermissionBillingRead), controller.GetQuotaLedgerReconcile)
+				ledgerAdminRoute.POST("/reconcile", middleware.PermissionAuth(model.PermissionBillingWrite), controller.ReconcileQuotaLedger)
+			}
+		}
+
+		semanticCacheRoute := apiRouter.Group("/semantic_cache")
+		{
+			semanticCacheRoute.GET("/", middleware.PermissionAuth(model.PermissionSystemRead), controller.GetSemanticCacheEntries)
+			semanticCacheRoute.DELETE("/", middleware.PermissionAuth(model.PermissionSystemWrite), controller.PurgeSemanticCache)
+			semanticCacheRoute.DELETE("/:id", middleware.PermissionAuth(model.PermissionSystemWrite), controller.DeleteSemanticCacheEntry)
 		}
 
 		tokenRoute := apiRouter.Group("/token")
@@ -126,27 +237,42 @@ func SetApiRouter(router *gin.Engine) {
 			tokenRoute.PUT("/", controller.UpdateToken)
 			tokenRoute.DELETE("/:id", controller.DeleteToken)
 		}
+		roleRoute := apiRouter.Group("/role")
+		roleRoute.Use(middleware.PermissionAuth(model.PermissionRoleManage))
+		{
+			roleRoute.GET("/", controller.GetRoles)
+			roleRoute.POST("/", controller.AddRole)
+			roleRoute.PUT("/", controller.UpdateRole)
+			roleRoute.DELETE("/:id", controller.DeleteRole)
+			roleRoute.PUT("/assign", controller.AssignRole)
+		}
+		auditLogRoute := apiRouter.Group("/audit_log")
+		{
+			auditLogRoute.GET("/", middleware.PermissionAuth(model.PermissionAuditRead), controller.GetAuditLogs)
+			auditLogRoute.GET("/export", middleware.PermissionAuth(model.PermissionAuditRead), controller.ExportAuditLogs)
+		}
 		redemptionRoute := apiRouter.Group("/redemption")
-		redemptionRoute.Use(middleware.AdminAuth())
 		{
-			redemptionRoute.GET("/", controller.GetAllRedemptions)
-			redemptionRoute.GET("/search", controller.SearchRedemptions)
-			redemptionRoute.GET("/:id", controller.GetRedemption)
-			redemptionRoute.POST("/", controller.AddRedemption)
-			redemptionRoute.PUT("/", controller.UpdateRedemption)
-			redemptionRoute.DELETE("/:id", controller.DeleteRedemption)
+			redemptionRoute.GET("/", middleware.PermissionAuth(model.PermissionRedemptionRead), controller.GetAllRedemptions)
+			redemptionRoute.GET("/search", middleware.PermissionAuth(model.PermissionRedemptionRead), controller.SearchRedemptions)
+			redemptionRoute.GET("/stats", middleware.PermissionAuth(model.PermissionRedemptionRead), controller.GetRedemptionStats)
+			redemptionRoute.GET("/export", middleware.PermissionAuth(model.PermissionRedemptionRead), controller.ExportRedemptions)
+			redemptionRoute.GET("/:id", middleware.PermissionAuth(model.PermissionRedemptionRead), controller.GetRedemption)
+			redemptionRoute.POST("/", middleware.PermissionAuth(model.PermissionRedemptionWrite), controller.AddRedemption)
+			redemptionRoute.PUT("/", middleware.PermissionAuth(model.PermissionRedemptionWrite), controller.UpdateRedemption)
+			redemptionRoute.DELETE("/:id", middleware.PermissionAuth(model.PermissionRedemptionWrite), controller.DeleteRedemption)
 		}
 		logRoute := apiRouter.Group("/log")
-		logRoute.GET("/", middleware.AdminAuth(), controller.GetAllLogs)
-		logRoute.DELETE("/", middleware.AdminAuth(), controller.DeleteHistoryLogs)
-		logRoute.GET("/stat", middleware.AdminAuth(), controller.GetLogsStat)
+		logRoute.GET("/", middleware.PermissionAuth(model.PermissionLogRead), controller.GetAllLogs)
+		logRoute.DELETE("/", middleware.PermissionAuth(model.PermissionLogWrite), controller.DeleteHistoryLogs)
+		logRoute.GET("/stat", middleware.PermissionAuth(model.PermissionLogRead), controller.GetLogsStat)
 		logRoute.GET("/self/stat", middleware.UserAuth(), controller.GetLogsSelfStat)
-		logRoute.GET("/search", middleware.AdminAuth(), controller.SearchAllLogs)
+		logRoute.GET("/search", middleware.PermissionAuth(model.PermissionLogRead), controller.SearchAllLogs)
 		logRoute.GET("/self", middleware.UserAuth(), controller.GetUserLogs)
 		logRoute.GET("/self/search", middleware.UserAuth(), controller.SearchUserLogs)
 
 		dataRoute := apiRouter.Group("/data")
-		dataRoute.GET("/", middleware.AdminAuth(), controller.GetAllQuotaDates)
+		dataRoute.GET("/", middleware.PermissionAuth(model.PermissionLogRead), controller.GetAllQuotaDates)
 		dataRoute.GET("/self", middleware.UserAuth(), controller.GetUserQuotaDates)
 
 		logRoute.Use(middleware.CORS())
@@ -155,18 +281,17 @@ func SetApiRouter(router *gin.Engine) {
 
 		}
 		groupRoute := apiRouter.Group("/group")
-		groupRoute.Use(middleware.AdminAuth())
 		{
-			groupRoute.GET("/", controller.GetGroups)
+			groupRoute.GET("/", middleware.PermissionAuth(model.PermissionUserRead, model.PermissionChannelRead, model.PermissionBillingRead, model.PermissionRedemptionRead), controller.GetGroups)
 		}
 		mjRoute := apiRouter.Group("/mj")
 		mjRoute.GET("/self", middleware.UserAuth(), controller.GetUserMidjourney)
-		mjRoute.GET("/", middleware.AdminAuth(), controller.GetAllMidjourney)
+		mjRoute.GET("/", middleware.PermissionAuth(model.PermissionLogRead), controller.GetAllMidjourney)
 
 		taskRoute := apiRouter.Group("/task")
 		{
 			taskRoute.GET("/self", middleware.UserAuth(), controller.GetUserTask)
-			taskRoute.GET("/", middleware.AdminAuth(), controller.GetAllTask)
+			taskRoute.GET("/", middleware.PermissionAuth(model.PermissionLogRead), controller.GetAllTask)
 		}
 	}
 }
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"one-api/common"
+	"one-api/model"
+	"one-api/setting/operation_setting"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const auditRedacted = "[REDACTED]"
+
+// 对象中需要脱敏的字段
+var auditSensitiveFields = map[string]bool{
+	"key":            true,
+	"password":       true,
+	"access_token":   true,
+	"secret":         true,
+	"webhook_secret": true,
+}
+
+// AuditChange 单个字段的变更
+type AuditChange struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// isSensitiveOption 判断配置项是否为密钥类配置，如 GitHubClientSecret、SMTPToken、EpayKey
+func isSensitiveOption(key string) bool {
+	if strings.HasSuffix(key, "Enabled") {
+		return false
+	}
+	lower := strings.ToLower(key)
+	for _, word := range []string{"secret", "password", "token", "key"} {
+		if strings.Contains(lower, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// auditFields 将对象转换为字段表，非对象的值作为 value 字段
+func auditFields(v any) map[string]any {
+	fields := make(map[string]any)
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) {
+		return fields
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		common.SysError("failed to marshal audit object: " + err.Error())
+		return fields
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		var value any
+		_ = json.Unmarshal(data, &value)
+		fields = map[string]any{"value": value}
+	}
+	return fields
+}
+
+// AuditDiff 比较操作前后的对象，返回变更字段。before 为 nil 表示新建，after 为 nil 表示删除，
+// 敏感字段只记录是否变化，不记录值
+func AuditDiff(before any, after any, sensitive bool) map[string]AuditChange {
+	beforeFields := auditFields(before)
+	afterFields := auditFields(after)
+	diff := make(map[string]AuditChange)
+	for field, beforeValue := range beforeFields {
+		afterValue := afterFields[field]
+		if !reflect.DeepEqual(beforeValue, afterValue) {
+			diff[field] = AuditChange{Before: beforeValue, After: afterValue}
+		}
+	}
+	for field, afterValue := range afterFields {
+		if _, ok := beforeFields[field]; !ok {
+			diff[field] = AuditChange{After: afterValue}
+		}
+	}
+	for field, change := range diff {
+		if sensitive || auditSensitiveFields[field] {
+			if change.Before != nil && change.Before != "" {
+				change.Before = auditRedacted
+			}
+			if change.After != nil && change.After != "" {
+				change.After = auditRedacted
+			}
+			diff[field] = change
+		}
+	}
+	return diff
+}
+
+// RecordAudit 记录一次管理操作，before 与 after 为操作前后的对象，更新时没有字段变化则不记录
+func RecordAudit(c *gin.Context, action string, targetType string, targetId any, before any, after any) {
+	sensitive := targetType == model.AuditTargetOption && isSensitiveOption(fmt.Sprint(targetId))
+	diff := AuditDiff(before, after, sensitive)
+	if before != nil && after != nil && len(diff) == 0 {
+		return
+	}
+	recordAuditLog(c, action, targetType, targetId, diff)
+}
+
+// RecordOptionAudit 记录配置项修改，JSON 对象格式的配置（如模型倍率）按键比较，密钥类配置不记录值
+func RecordOptionAudit(c *gin.Context, key string, before string, after string) {
+	if before == after {
+		return
+	}
+	var beforeMap, afterMap map[string]any
+	if !isSensitiveOption(key) && json.Unmarshal([]byte(before), &beforeMap) == nil && json.Unmarshal([]byte(after), &afterMap) == nil &&
+		beforeMap != nil && afterMap != nil {
+		RecordAudit(c, "option.update", model.AuditTargetOption, key, beforeMap, afterMap)
+		return
+	}
+	RecordAudit(c, "option.update", model.AuditTargetOption, key, before, after)
+}
+
+// RecordAuditAction 记录没有前后对象的管理操作，如批量删除、查看密钥，detail 作为 Diff 保存
+func RecordAuditAction(c *gin.Context, action string, targetType string, targetId any, detail any) {
+	recordAuditLog(c, action, targetType, targetId, detail)
+}
+
+func recordAuditLog(c *gin.Context, action string, targetType string, targetId any, diff any) {
+	diffBytes, err := json.Marshal(diff)
+	if err != nil {
+		common.SysError("failed to marshal audit diff: " + err.Error())
+		return
+	}
+	auditLog := &model.AuditLog{
+		CreatedAt:  common.GetTimestamp(),
+		UserId:     c.GetInt("id"),
+		Username:   c.GetString("username"),
+		Role:       c.GetInt("role"),
+		Ip:         c.ClientIP(),
+		Action:     action,
+		TargetType: targetType,
+		TargetId:   fmt.Sprint(targetId),
+		Diff:       string(diffBytes),
+	}
+	if err := auditLog.Insert(); err != nil {
+		common.SysError("failed to record audit log: " + err.Error())
+	}
+}
+
+// StartAuditLogCleanupTask 定期删除超过保留天数的审计日志
+func StartAuditLogCleanupTask(frequency int) {
+	for {
+		retentionDays := operation_setting.GetAuditLogSetting().RetentionDays
+		if retentionDays > 0 {
+			before := time.Now().AddDate(0, 0, -retentionDays).Unix()
+			count, err := model.DeleteAuditLogsBefore(before)
+			if err != nil {
+				common.SysError("failed to delete expired audit logs: " + err.Error())
+			} else if count > 0 {
+				common.SysLog(fmt.Sprintf("deleted %d expired audit logs", count))
+			}
+		}
+		time.Sleep(time.Duration(frequency) * time.Second)
+	}
+}
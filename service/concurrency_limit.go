@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"one-api/common"
+	"one-api/common/limiter"
+	"one-api/model"
+	"one-api/setting"
+	"time"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+var ErrConcurrencyLimitExceeded = errors.New("concurrency limit exceeded")
+
+const (
+	// 每个请求占用名额的租期，处理中的请求定期续期，节点异常退出后名额在租期结束时释放
+	concurrencyLease         = 2 * time.Minute
+	concurrencyRefreshPeriod = 30 * time.Second
+)
+
+var memoryConcurrencyLimiter = limiter.NewMemoryConcurrencyLimiter()
+
+func getConcurrencyLimiter() limiter.ConcurrencyLimiter {
+	if common.RedisEnabled {
+		return limiter.New(context.Background(), common.RDB)
+	}
+	return memoryConcurrencyLimiter
+}
+
+func userConcurrencyKey(userId int) string {
+	return fmt.Sprintf("concurrencyLimit:user:%d", userId)
+}
+
+func groupConcurrencyKey(group string) string {
+	return "concurrencyLimit:group:" + group
+}
+
+func tokenConcurrencyKey(tokenId int) string {
+	return fmt.Sprintf("concurrencyLimit:token:%d", tokenId)
+}
+
+// AcquireConcurrency 为请求占用用户、分组合计与令牌的并发名额，返回的 release 在请求结束时调用。
+// 启用并发限制后用户名额始终计数，用于在用户信息中展示当前并发数；限流服务异常时放行请求
+func AcquireConcurrency(c *gin.Context, group string) (release func(), err error) {
+	slots := make([]limiter.Slot, 0, 3)
+	if setting.ModelConcurrencyLimitEnabled {
+		slots = append(slots, limiter.Slot{
+			Key:   userConcurrencyKey(c.GetInt("id")),
+			Limit: int64(setting.GetUserConcurrencyLimit(group)),
+		})
+		if limit := setting.GetGroupConcurrencyLimit(group); limit > 0 {
+			slots = append(slots, limiter.Slot{
+				Key:   groupConcurrencyKey(group),
+				Limit: int64(limit),
+			})
+		}
+	}
+	if limit := c.GetInt("token_concurrency_limit"); limit > 0 {
+		slots = append(slots, limiter.Slot{
+			Key:   tokenConcurrencyKey(c.GetInt("token_id")),
+			Limit: int64(limit),
+		})
+	}
+	if len(slots) == 0 {
+		return func() {}, nil
+	}
+	id := c.GetString(common.RequestIdKey)
+	if id == "" {
+		id = common.GetUUID()
+	}
+	concurrencyLimiter := getConcurrencyLimiter()
+	allowed, index, current, err := concurrencyLimiter.Acquire(c.Request.Context(), slots, id, concurrencyLease)
+	if err != nil {
+		common.LogError(c, "concurrency limit failed: "+err.Error())
+		return func() {}, nil
+	}
+	if !allowed {
+		scope := "令牌"
+		switch slots[index].Key {
+		case userConcurrencyKey(c.GetInt("id")):
+			scope = "用户"
+		case groupConcurrencyKey(group):
+			scope = "分组 " + group + " "
+		}
+		return nil, fmt.Errorf("%w: %s同时处理中的请求数已达上限 %d（当前 %d），请等待已有请求结束后重试",
+			ErrConcurrencyLimitExceeded, scope, slots[index].Limit, current)
+	}
+
+	done := make(chan struct{})
+	gopool.Go(func() {
+		ticker := time.NewTicker(concurrencyRefreshPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := concurrencyLimiter.Refresh(context.Background(), slots, id, concurrencyLease); err != nil {
+					common.SysError("failed to refresh concurrency lease: " + err.Error())
+				}
+			}
+		}
+	})
+	return func() {
+		close(done)
+		if err := concurrencyLimiter.Release(context.Background(), slots, id); err != nil {
+			common.SysError("failed to release concurrency slot: " + err.Error())
+		}
+	}, nil
+}
+
+// GetUserConcurrency 返回用户当前处理中的请求数，未启用并发限制时返回 nil
+func GetUserConcurrency(userId int, group string) *model.UserConcurrency {
+	if !setting.ModelConcurrencyLimitEnabled {
+		return nil
+	}
+	current, err := getConcurrencyLimiter().Count(context.Background(), userConcurrencyKey(userId))
+	if err != nil {
+		common.SysError("failed to get user concurrency: " + err.Error())
+	}
+	return &model.UserConcurrency{
+		Current: current,
+		Limit:   setting.GetUserConcurrencyLimit(group),
+	}
+}
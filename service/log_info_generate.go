@@ -3,6 +3,7 @@ package service
 import (
 	"one-api/dto"
 	relaycommon "one-api/relay/common"
+	"one-api/relay/helper"
 
 	"github.com/gin-gonic/gin"
 )
@@ -24,6 +25,9 @@ func GenerateTextOtherInfo(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, m
 		other["is_model_mapped"] = true
 		other["upstream_model_name"] = relayInfo.UpstreamModelName
 	}
+	if len(relayInfo.PIIRedactions) > 0 {
+		other["pii_redactions"] = relayInfo.PIIRedactions
+	}
 	adminInfo := make(map[string]interface{})
 	adminInfo["use_channel"] = ctx.GetStringSlice("use_channel")
 	other["admin_info"] = adminInfo
@@ -62,3 +66,35 @@ func GenerateClaudeOtherInfo(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	info["cache_creation_ratio"] = cacheCreationRatio
 	return info
 }
+
+// AppendModelRatioTierInfo 记录结算时应用的阶梯倍率
+func AppendModelRatioTierInfo(other map[string]interface{}, priceData helper.PriceData) {
+	if priceData.TierIndex < 0 {
+		return
+	}
+	other["tiered_ratio"] = true
+	other["tier_index"] = priceData.TierIndex
+	other["tier_max_prompt_tokens"] = priceData.Tier.MaxPromptTokens
+}
+
+// AppendPricingScheduleInfo 记录请求开始时生效的定价时段倍率
+func AppendPricingScheduleInfo(other map[string]interface{}, priceData helper.PriceData) {
+	if len(priceData.ScheduleNames) == 0 {
+		return
+	}
+	other["pricing_schedule"] = priceData.ScheduleNames
+	other["pricing_schedule_multiplier"] = priceData.ScheduleMultiplier
+}
+
+// AppendSubscriptionInfo 记录订阅套餐承担的额度
+func AppendSubscriptionInfo(other map[string]interface{}, relayInfo *relaycommon.RelayInfo, subscriptionQuota int) {
+	if subscriptionQuota <= 0 && relayInfo.SubscriptionWaivedQuota <= 0 {
+		return
+	}
+	other["subscription_id"] = relayInfo.SubscriptionId
+	other["subscription_quota"] = subscriptionQuota
+	if relayInfo.SubscriptionWaivedQuota > 0 {
+		// 套餐不允许超额，超出部分未向用户收取，退款时不计入
+		other["subscription_waived_quota"] = relayInfo.SubscriptionWaivedQuota
+	}
+}
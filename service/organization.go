@@ -0,0 +1,51 @@
+package service
+
+import (
+	"one-api/model"
+	relaycommon "one-api/relay/common"
+)
+
+// GetPayerQuota 返回本次请求付费方的可用额度：组织令牌为成员可使用的组织额度池，自付令牌为令牌剩余额度，否则为用户余额
+func GetPayerQuota(relayInfo *relaycommon.RelayInfo) (int, error) {
+	if relayInfo.OrgId != 0 {
+		return model.GetOrganizationAvailableQuota(relayInfo.OrgId, relayInfo.UserId)
+	}
+	if relayInfo.TokenSelfFunded {
+		token, err := model.GetTokenByKey(relayInfo.TokenKey, false)
+		if err != nil {
+			return 0, err
+		}
+		return token.RemainQuota, nil
+	}
+	return model.GetUserQuota(relayInfo.UserId, false)
+}
+
+// ChangePayerQuota 预扣费时从付费方扣除额度，quota 为负数时表示退还；组织令牌的消耗同时计入成员用量，
+// 自付令牌只扣除令牌额度，个人余额的变动与消耗流水一起写入，开启批量更新时一并暂存
+func ChangePayerQuota(relayInfo *relaycommon.RelayInfo, quota int) error {
+	return changePayerQuota(relayInfo, quota, false)
+}
+
+// settlePayerQuota 结算时从付费方补扣或退还额度，组织额度池不足时允许扣成负数
+func settlePayerQuota(relayInfo *relaycommon.RelayInfo, quota int) error {
+	return changePayerQuota(relayInfo, quota, true)
+}
+
+func changePayerQuota(relayInfo *relaycommon.RelayInfo, quota int, settle bool) error {
+	if relayInfo.OrgId != 0 {
+		if settle {
+			return model.SettleOrganizationQuota(relayInfo.OrgId, relayInfo.UserId, quota)
+		}
+		return model.ChangeOrganizationQuota(relayInfo.OrgId, relayInfo.UserId, quota)
+	}
+	if relayInfo.TokenSelfFunded {
+		return nil
+	}
+	return model.ChangeUserQuota(relayInfo.UserId, -quota, model.LedgerEntry{
+		Type:           model.LedgerTypeConsume,
+		CounterAccount: model.LedgerAccountConsume,
+		RefType:        "request",
+		RefId:          relayInfo.RequestId,
+		Remark:         relayInfo.OriginModelName,
+	}, false)
+}
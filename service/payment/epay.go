@@ -0,0 +1,210 @@
+package payment
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"one-api/setting"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/Calcium-Ion/go-epay/epay"
+)
+
+// EpayProvider 易支付，支持微信与支付宝
+type EpayProvider struct{}
+
+type epayApiResponse struct {
+	Code    int    `json:"code"`
+	Msg     string `json:"msg"`
+	TradeNo string `json:"trade_no"`
+	Money   string `json:"money"`
+	Status  int    `json:"status"`
+}
+
+func (p *EpayProvider) Name() string {
+	return "epay"
+}
+
+func (p *EpayProvider) IsEnabled() bool {
+	return setting.PayAddress != "" && setting.EpayId != "" && setting.EpayKey != ""
+}
+
+func (p *EpayProvider) UnitPrice() float64 {
+	return setting.Price
+}
+
+func (p *EpayProvider) RoundMoney(money float64) float64 {
+	return math.Round(money*100) / 100
+}
+
+func (p *EpayProvider) client() (*epay.Client, error) {
+	return epay.NewClient(&epay.Config{
+		PartnerID: setting.EpayId,
+		Key:       setting.EpayKey,
+	}, setting.PayAddress)
+}
+
+func (p *EpayProvider) CreateOrder(order *Order) (*OrderResult, error) {
+	client, err := p.client()
+	if err != nil {
+		return nil, err
+	}
+	payType := "wxpay"
+	if order.PaymentMethod == "zfb" || order.PaymentMethod == "alipay" {
+		payType = "alipay"
+	}
+	notifyUrl, err := url.Parse(order.NotifyUrl)
+	if err != nil {
+		return nil, err
+	}
+	returnUrl, err := url.Parse(order.ReturnUrl)
+	if err != nil {
+		return nil, err
+	}
+	uri, params, err := client.Purchase(&epay.PurchaseArgs{
+		Type:           payType,
+		ServiceTradeNo: order.TradeNo,
+		Name:           order.Name,
+		Money:          strconv.FormatFloat(order.Money, 'f', 2, 64),
+		Device:         epay.PC,
+		NotifyUrl:      notifyUrl,
+		ReturnUrl:      returnUrl,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &OrderResult{Url: uri, Params: params}, nil
+}
+
+func (p *EpayProvider) VerifyCallback(req *http.Request) (*CallbackResult, error) {
+	client, err := p.client()
+	if err != nil {
+		return nil, err
+	}
+	query := req.URL.Query()
+	params := make(map[string]string, len(query))
+	for key := range query {
+		params[key] = query.Get(key)
+	}
+	verifyInfo, err := client.Verify(params)
+	if err != nil {
+		return nil, err
+	}
+	if !verifyInfo.VerifyStatus {
+		return nil, errors.New("epay callback signature verification failed")
+	}
+	money, _ := strconv.ParseFloat(verifyInfo.Money, 64)
+	result := &CallbackResult{
+		TradeNo:         verifyInfo.ServiceTradeNo,
+		ProviderOrderId: verifyInfo.TradeNo,
+		Status:          OrderStatusPending,
+		Money:           money,
+	}
+	if verifyInfo.TradeStatus == epay.StatusTradeSuccess {
+		result.Status = OrderStatusPaid
+	}
+	return result, nil
+}
+
+func (p *EpayProvider) apiUrl(act string) (string, error) {
+	u, err := url.Parse(setting.PayAddress)
+	if err != nil {
+		return "", err
+	}
+	u.Path = path.Join(u.Path, "/api.php")
+	u.RawQuery = url.Values{"act": {act}}.Encode()
+	return u.String(), nil
+}
+
+func (p *EpayProvider) doApi(req *http.Request) (*epayApiResponse, error) {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var apiResp epayApiResponse
+	if err = json.Unmarshal(body, &apiResp); err != nil {
+		return nil, fmt.Errorf("invalid epay response: %s", string(body))
+	}
+	return &apiResp, nil
+}
+
+func (p *EpayProvider) QueryOrder(tradeNo string, providerOrderId string) (*QueryResult, error) {
+	apiUrl, err := p.apiUrl("order")
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{
+		"pid":          {setting.EpayId},
+		"key":          {setting.EpayKey},
+		"out_trade_no": {tradeNo},
+	}
+	req, err := http.NewRequest(http.MethodGet, apiUrl+"&"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	apiResp, err := p.doApi(req)
+	if err != nil {
+		return nil, err
+	}
+	if apiResp.Code != 1 {
+		return nil, fmt.Errorf("epay query order failed: %s", apiResp.Msg)
+	}
+	money, _ := strconv.ParseFloat(apiResp.Money, 64)
+	result := &QueryResult{
+		ProviderOrderId: apiResp.TradeNo,
+		Status:          OrderStatusPending,
+		Money:           money,
+	}
+	if apiResp.Status == 1 {
+		result.Status = OrderStatusPaid
+	}
+	return result, nil
+}
+
+func (p *EpayProvider) Refund(refund *RefundRequest) error {
+	apiUrl, err := p.apiUrl("refund")
+	if err != nil {
+		return err
+	}
+	form := url.Values{
+		"pid":          {setting.EpayId},
+		"key":          {setting.EpayKey},
+		"out_trade_no": {refund.TradeNo},
+		"money":        {strconv.FormatFloat(refund.Money, 'f', 2, 64)},
+	}
+	if refund.ProviderOrderId != "" {
+		form.Set("trade_no", refund.ProviderOrderId)
+	}
+	req, err := http.NewRequest(http.MethodPost, apiUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	apiResp, err := p.doApi(req)
+	if err != nil {
+		return err
+	}
+	if apiResp.Code != 1 {
+		return fmt.Errorf("epay refund failed: %s", apiResp.Msg)
+	}
+	return nil
+}
+
+func (p *EpayProvider) WriteCallbackResponse(w http.ResponseWriter, success bool) {
+	if success {
+		_, _ = w.Write([]byte("success"))
+	} else {
+		_, _ = w.Write([]byte("fail"))
+	}
+}
@@ -0,0 +1,121 @@
+package payment
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	OrderStatusPending  = "pending"
+	OrderStatusPaid     = "paid"
+	OrderStatusExpired  = "expired"
+	OrderStatusRefunded = "refunded"
+)
+
+// Order 发起支付所需的订单信息，Money 为以支付渠道币种计价的金额
+type Order struct {
+	TradeNo       string
+	Name          string
+	Money         float64
+	PaymentMethod string
+	NotifyUrl     string
+	ReturnUrl     string
+}
+
+// OrderResult 发起支付的结果，前端跳转到 Url，表单提交类渠道同时提供 Params
+type OrderResult struct {
+	Url             string
+	Params          map[string]string
+	ProviderOrderId string
+}
+
+// CallbackResult 支付回调验签后的结果
+type CallbackResult struct {
+	TradeNo         string
+	ProviderOrderId string
+	Status          string
+	Money           float64
+}
+
+// QueryResult 向支付渠道查询的订单状态
+type QueryResult struct {
+	ProviderOrderId string
+	Status          string
+	Money           float64
+}
+
+// RefundRequest 退款请求，Money 为退款金额，等于订单金额时为全额退款
+type RefundRequest struct {
+	TradeNo         string
+	ProviderOrderId string
+	Money           float64
+	Reason          string
+}
+
+// PaymentProvider 支付渠道，负责下单、回调验签、订单查询与退款
+type PaymentProvider interface {
+	Name() string
+	IsEnabled() bool
+	// UnitPrice 充值 1 美元额度需要支付的金额
+	UnitPrice() float64
+	// RoundMoney 将金额按渠道币种的最小货币单位取整
+	RoundMoney(money float64) float64
+	CreateOrder(order *Order) (*OrderResult, error)
+	VerifyCallback(req *http.Request) (*CallbackResult, error)
+	QueryOrder(tradeNo string, providerOrderId string) (*QueryResult, error)
+	Refund(req *RefundRequest) error
+	// WriteCallbackResponse 按渠道要求的格式应答回调
+	WriteCallbackResponse(w http.ResponseWriter, success bool)
+}
+
+var ErrProviderNotFound = errors.New("payment provider not found")
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+var providers = make(map[string]PaymentProvider)
+var providersLock sync.RWMutex
+
+func Register(provider PaymentProvider) {
+	providersLock.Lock()
+	defer providersLock.Unlock()
+	providers[provider.Name()] = provider
+}
+
+// GetProvider 返回已启用的支付渠道
+func GetProvider(name string) (PaymentProvider, error) {
+	providersLock.RLock()
+	defer providersLock.RUnlock()
+	provider, ok := providers[name]
+	if !ok || !provider.IsEnabled() {
+		return nil, ErrProviderNotFound
+	}
+	return provider, nil
+}
+
+// GetEnabledProviderNames 返回所有已配置的支付渠道名称
+func GetEnabledProviderNames() []string {
+	providersLock.RLock()
+	defer providersLock.RUnlock()
+	names := make([]string, 0, len(providers))
+	for name, provider := range providers {
+		if provider.IsEnabled() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MoneyEqual 判断两个金额按渠道币种的最小货币单位取整后是否相等
+func MoneyEqual(provider PaymentProvider, a float64, b float64) bool {
+	return math.Abs(provider.RoundMoney(a)-provider.RoundMoney(b)) < 1e-9
+}
+
+func init() {
+	Register(&EpayProvider{})
+	Register(&StripeProvider{})
+}
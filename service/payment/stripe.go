@@ -0,0 +1,307 @@
+package payment
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"one-api/setting"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stripeSignatureTolerance Webhook 签名时间戳允许的最大偏差
+const stripeSignatureTolerance = 5 * time.Minute
+
+// StripeProvider 兼容 Stripe Checkout 的银行卡支付，API 地址可配置以便对接兼容服务或本地模拟服务
+type StripeProvider struct{}
+
+type stripeCheckoutSession struct {
+	Id                string            `json:"id"`
+	Url               string            `json:"url"`
+	Status            string            `json:"status"`
+	PaymentStatus     string            `json:"payment_status"`
+	PaymentIntent     string            `json:"payment_intent"`
+	AmountTotal       int64             `json:"amount_total"`
+	Currency          string            `json:"currency"`
+	ClientReferenceId string            `json:"client_reference_id"`
+	Metadata          map[string]string `json:"metadata"`
+}
+
+type stripeEvent struct {
+	Type string `json:"type"`
+	Data struct {
+		Object stripeCheckoutSession `json:"object"`
+	} `json:"data"`
+}
+
+type stripeErrorResponse struct {
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (p *StripeProvider) Name() string {
+	return "stripe"
+}
+
+func (p *StripeProvider) IsEnabled() bool {
+	return setting.StripeApiSecret != "" && setting.StripeWebhookSecret != ""
+}
+
+func (p *StripeProvider) UnitPrice() float64 {
+	return setting.StripeUnitPrice
+}
+
+func (p *StripeProvider) apiBase() string {
+	if setting.StripeApiBase == "" {
+		return "https://api.stripe.com"
+	}
+	return strings.TrimSuffix(setting.StripeApiBase, "/")
+}
+
+// stripeZeroDecimalCurrencies 没有小数位的币种，金额直接以元为最小单位
+var stripeZeroDecimalCurrencies = map[string]bool{
+	"bif": true, "clp": true, "djf": true, "gnf": true, "jpy": true, "kmf": true, "krw": true, "mga": true,
+	"pyg": true, "rwf": true, "ugx": true, "vnd": true, "vuv": true, "xaf": true, "xof": true, "xpf": true,
+}
+
+// stripeThreeDecimalCurrencies 三位小数的币种，Stripe 要求最后一位为 0
+var stripeThreeDecimalCurrencies = map[string]bool{
+	"bhd": true, "jod": true, "kwd": true, "omr": true, "tnd": true,
+}
+
+func (p *StripeProvider) currency() string {
+	if setting.StripeCurrency == "" {
+		return "usd"
+	}
+	return strings.ToLower(setting.StripeCurrency)
+}
+
+// sessionCurrency 返回会话的币种，会话未返回币种时使用当前配置
+func (p *StripeProvider) sessionCurrency(session *stripeCheckoutSession) string {
+	if session.Currency != "" {
+		return strings.ToLower(session.Currency)
+	}
+	return p.currency()
+}
+
+// toStripeAmount 将金额换算为币种的最小货币单位
+func toStripeAmount(money float64, currency string) int64 {
+	if stripeZeroDecimalCurrencies[currency] {
+		return int64(math.Round(money))
+	}
+	if stripeThreeDecimalCurrencies[currency] {
+		return int64(math.Round(money*100)) * 10
+	}
+	return int64(math.Round(money * 100))
+}
+
+func fromStripeAmount(amount int64, currency string) float64 {
+	if stripeZeroDecimalCurrencies[currency] {
+		return float64(amount)
+	}
+	if stripeThreeDecimalCurrencies[currency] {
+		return float64(amount) / 1000
+	}
+	return float64(amount) / 100
+}
+
+func (p *StripeProvider) RoundMoney(money float64) float64 {
+	currency := p.currency()
+	return fromStripeAmount(toStripeAmount(money, currency), currency)
+}
+
+func (p *StripeProvider) doRequest(method string, apiPath string, form url.Values, result any) error {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequest(method, p.apiBase()+apiPath, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+setting.StripeApiSecret)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var errResp stripeErrorResponse
+		if json.Unmarshal(respBody, &errResp) == nil && errResp.Error.Message != "" {
+			return fmt.Errorf("stripe api error: %s", errResp.Error.Message)
+		}
+		return fmt.Errorf("stripe api error: status code %d", resp.StatusCode)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(respBody, result)
+}
+
+func (p *StripeProvider) CreateOrder(order *Order) (*OrderResult, error) {
+	currency := p.currency()
+	form := url.Values{
+		"mode":                                {"payment"},
+		"success_url":                         {order.ReturnUrl},
+		"cancel_url":                          {order.ReturnUrl},
+		"client_reference_id":                 {order.TradeNo},
+		"metadata[trade_no]":                  {order.TradeNo},
+		"line_items[0][price_data][currency]": {currency},
+		"line_items[0][price_data][product_data][name]": {order.Name},
+		"line_items[0][price_data][unit_amount]":        {strconv.FormatInt(toStripeAmount(order.Money, currency), 10)},
+		"line_items[0][quantity]":                       {"1"},
+	}
+	var session stripeCheckoutSession
+	if err := p.doRequest(http.MethodPost, "/v1/checkout/sessions", form, &session); err != nil {
+		return nil, err
+	}
+	return &OrderResult{Url: session.Url, ProviderOrderId: session.Id}, nil
+}
+
+func stripeSessionStatus(session *stripeCheckoutSession) string {
+	if session.PaymentStatus == "paid" || session.PaymentStatus == "no_payment_required" {
+		return OrderStatusPaid
+	}
+	if session.Status == "expired" {
+		return OrderStatusExpired
+	}
+	return OrderStatusPending
+}
+
+// verifyStripeSignature 校验 Stripe-Signature 请求头，签名为 HMAC-SHA256(secret, "时间戳.请求体")
+func verifyStripeSignature(header string, payload []byte, secret string, now time.Time) error {
+	var timestamp string
+	signatures := make([]string, 0)
+	for _, part := range strings.Split(header, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == "" || len(signatures) == 0 {
+		return errors.New("invalid stripe signature header")
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid stripe signature timestamp")
+	}
+	if math.Abs(now.Sub(time.Unix(ts, 0)).Seconds()) > stripeSignatureTolerance.Seconds() {
+		return errors.New("stripe signature timestamp outside tolerance")
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(signature), []byte(expected)) {
+			return nil
+		}
+	}
+	return errors.New("stripe signature verification failed")
+}
+
+func (p *StripeProvider) VerifyCallback(req *http.Request) (*CallbackResult, error) {
+	payload, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	err = verifyStripeSignature(req.Header.Get("Stripe-Signature"), payload, setting.StripeWebhookSecret, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	var event stripeEvent
+	if err = json.Unmarshal(payload, &event); err != nil {
+		return nil, err
+	}
+	session := &event.Data.Object
+	tradeNo := session.ClientReferenceId
+	if tradeNo == "" {
+		tradeNo = session.Metadata["trade_no"]
+	}
+	result := &CallbackResult{
+		TradeNo:         tradeNo,
+		ProviderOrderId: session.Id,
+		Status:          OrderStatusPending,
+		Money:           fromStripeAmount(session.AmountTotal, p.sessionCurrency(session)),
+	}
+	switch event.Type {
+	case "checkout.session.completed", "checkout.session.async_payment_succeeded":
+		result.Status = stripeSessionStatus(session)
+	case "checkout.session.expired", "checkout.session.async_payment_failed":
+		result.Status = OrderStatusExpired
+	}
+	return result, nil
+}
+
+func (p *StripeProvider) getSession(providerOrderId string) (*stripeCheckoutSession, error) {
+	if providerOrderId == "" {
+		return nil, errors.New("stripe checkout session id is empty")
+	}
+	var session stripeCheckoutSession
+	err := p.doRequest(http.MethodGet, "/v1/checkout/sessions/"+url.PathEscape(providerOrderId), nil, &session)
+	return &session, err
+}
+
+func (p *StripeProvider) QueryOrder(tradeNo string, providerOrderId string) (*QueryResult, error) {
+	session, err := p.getSession(providerOrderId)
+	if err != nil {
+		return nil, err
+	}
+	return &QueryResult{
+		ProviderOrderId: session.Id,
+		Status:          stripeSessionStatus(session),
+		Money:           fromStripeAmount(session.AmountTotal, p.sessionCurrency(session)),
+	}, nil
+}
+
+func (p *StripeProvider) Refund(refund *RefundRequest) error {
+	session, err := p.getSession(refund.ProviderOrderId)
+	if err != nil {
+		return err
+	}
+	if session.PaymentIntent == "" {
+		return errors.New("stripe checkout session has no payment intent")
+	}
+	form := url.Values{
+		"payment_intent": {session.PaymentIntent},
+		"amount":         {strconv.FormatInt(toStripeAmount(refund.Money, p.sessionCurrency(session)), 10)},
+	}
+	if refund.Reason != "" {
+		form.Set("metadata[reason]", refund.Reason)
+	}
+	return p.doRequest(http.MethodPost, "/v1/refunds", form, nil)
+}
+
+func (p *StripeProvider) WriteCallbackResponse(w http.ResponseWriter, success bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if success {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"received":true}`))
+	} else {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"received":false}`))
+	}
+}
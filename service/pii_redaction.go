@@ -0,0 +1,358 @@
+package service
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"one-api/common"
+	"one-api/setting/operation_setting"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// 占位符格式为 [NAME_xxxxxxxx]，后缀为原值的 HMAC，同一个值在不同请求中得到相同的占位符
+var piiPlaceholderPattern = regexp.MustCompile(`\[[A-Z0-9_]+_[0-9a-f]{8,16}\]`)
+
+// ErrPIIRedactionUnsupported 启用脱敏策略时，不支持脱敏的接口直接拒绝请求，避免个人信息原样转发到上游
+var ErrPIIRedactionUnsupported = errors.New("当前分组或渠道已启用个人信息脱敏，该接口暂不支持脱敏")
+
+// 请求 JSON 中不做替换的字段
+var piiSkipFields = map[string]bool{
+	"type":              true,
+	"role":              true,
+	"name":              true,
+	"id":                true,
+	"tool_call_id":      true,
+	"tool_use_id":       true,
+	"call_id":           true,
+	"image_url":         true,
+	"input_audio":       true,
+	"file":              true,
+	"file_data":         true,
+	"video_url":         true,
+	"url":               true,
+	"signature":         true,
+	"encrypted_content": true,
+}
+
+var piiRegexCache sync.Map
+
+type piiDetector struct {
+	name      string
+	label     string
+	regex     *regexp.Regexp
+	validator string
+}
+
+type piiMatch struct {
+	start    int
+	end      int
+	detector *piiDetector
+}
+
+// PIIRedactor 替换一次请求中的个人信息，并记录占位符与原值的对应关系用于还原响应
+type PIIRedactor struct {
+	detectors    []*piiDetector
+	placeholders map[string]string
+	values       map[string]string
+	counts       map[string]int
+}
+
+func getPIIRegex(pattern string) (*regexp.Regexp, error) {
+	if re, ok := piiRegexCache.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	piiRegexCache.Store(pattern, re)
+	return re, nil
+}
+
+// NewPIIRedactor 按渠道与分组的脱敏策略创建替换器，未配置策略时返回 nil
+func NewPIIRedactor(channelId int, group string) *PIIRedactor {
+	configs := operation_setting.GetPIIRedactionSetting().GetPolicyDetectors(channelId, group)
+	if len(configs) == 0 {
+		return nil
+	}
+	detectors := make([]*piiDetector, 0, len(configs))
+	for _, config := range configs {
+		re, err := getPIIRegex(config.Pattern)
+		if err != nil {
+			common.SysError("invalid pii detector pattern " + config.Name + ": " + err.Error())
+			continue
+		}
+		detectors = append(detectors, &piiDetector{
+			name:      config.Name,
+			label:     strings.ToUpper(config.Name),
+			regex:     re,
+			validator: config.Validator,
+		})
+	}
+	if len(detectors) == 0 {
+		return nil
+	}
+	return &PIIRedactor{
+		detectors:    detectors,
+		placeholders: make(map[string]string),
+		values:       make(map[string]string),
+		counts:       make(map[string]int),
+	}
+}
+
+// CheckPIIRedactionUnsupported 不支持脱敏的接口在渠道或分组配置了脱敏策略时返回错误
+func CheckPIIRedactionUnsupported(channelId int, group string) error {
+	if len(operation_setting.GetPIIRedactionSetting().GetPolicyDetectors(channelId, group)) > 0 {
+		return ErrPIIRedactionUnsupported
+	}
+	return nil
+}
+
+func isDigitByte(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+// piiBoundaryValid 以数字开头或结尾的匹配不能紧邻其他数字，避免截取长数字串的一部分
+func piiBoundaryValid(text string, start int, end int) bool {
+	if start > 0 && isDigitByte(text[start]) && isDigitByte(text[start-1]) {
+		return false
+	}
+	if end < len(text) && isDigitByte(text[end-1]) && isDigitByte(text[end]) {
+		return false
+	}
+	return true
+}
+
+func piiDigits(value string) string {
+	var builder strings.Builder
+	for _, r := range value {
+		if unicode.IsDigit(r) || r == 'X' || r == 'x' {
+			builder.WriteRune(r)
+		}
+	}
+	return builder.String()
+}
+
+// validLuhn 银行卡号 Luhn 校验
+func validLuhn(value string) bool {
+	digits := piiDigits(value)
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		if !isDigitByte(digits[i]) {
+			return false
+		}
+		d := int(digits[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// validCNID 居民身份证号校验码校验
+func validCNID(value string) bool {
+	digits := strings.ToUpper(piiDigits(value))
+	if len(digits) != 18 {
+		return false
+	}
+	weights := []int{7, 9, 10, 5, 8, 4, 2, 1, 6, 3, 7, 9, 10, 5, 8, 4, 2}
+	sum := 0
+	for i, weight := range weights {
+		if !isDigitByte(digits[i]) {
+			return false
+		}
+		sum += int(digits[i]-'0') * weight
+	}
+	return "10X98765432"[sum%11] == digits[17]
+}
+
+func (d *piiDetector) validate(value string) bool {
+	switch d.validator {
+	case operation_setting.PIIValidatorLuhn:
+		return validLuhn(value)
+	case operation_setting.PIIValidatorCNID:
+		return validCNID(value)
+	}
+	return true
+}
+
+func (r *PIIRedactor) placeholder(d *piiDetector, value string) string {
+	h := hmac.New(sha256.New, []byte(common.CryptoSecret))
+	h.Write([]byte(d.name + ":" + value))
+	sum := hex.EncodeToString(h.Sum(nil))
+	placeholder := "[" + d.label + "_" + sum[:8] + "]"
+	if original, ok := r.placeholders[placeholder]; ok && original != value {
+		placeholder = "[" + d.label + "_" + sum[:16] + "]"
+	}
+	return placeholder
+}
+
+// RedactText 替换文本中的个人信息，多个规则的匹配重叠时保留先开始、较长的匹配
+func (r *PIIRedactor) RedactText(text string) string {
+	if text == "" {
+		return text
+	}
+	var matches []piiMatch
+	for _, d := range r.detectors {
+		for _, loc := range d.regex.FindAllStringIndex(text, -1) {
+			if loc[0] == loc[1] || !piiBoundaryValid(text, loc[0], loc[1]) || !d.validate(text[loc[0]:loc[1]]) {
+				continue
+			}
+			matches = append(matches, piiMatch{start: loc[0], end: loc[1], detector: d})
+		}
+	}
+	if len(matches) == 0 {
+		return text
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].start != matches[j].start {
+			return matches[i].start < matches[j].start
+		}
+		return matches[i].end > matches[j].end
+	})
+	var builder strings.Builder
+	last := 0
+	for _, m := range matches {
+		if m.start < last {
+			continue
+		}
+		value := text[m.start:m.end]
+		placeholder, ok := r.values[m.detector.name+":"+value]
+		if !ok {
+			placeholder = r.placeholder(m.detector, value)
+			r.values[m.detector.name+":"+value] = placeholder
+			r.placeholders[placeholder] = value
+		}
+		r.counts[m.detector.name]++
+		builder.WriteString(text[last:m.start])
+		builder.WriteString(placeholder)
+		last = m.end
+	}
+	builder.WriteString(text[last:])
+	return builder.String()
+}
+
+// RedactValue 替换 JSON 值中全部字符串里的个人信息，跳过类型、角色、图片等字段
+func (r *PIIRedactor) RedactValue(value any) any {
+	switch v := value.(type) {
+	case string:
+		return r.RedactText(v)
+	case []any:
+		for i := range v {
+			v[i] = r.RedactValue(v[i])
+		}
+		return v
+	case map[string]any:
+		for key, item := range v {
+			if piiSkipFields[key] {
+				continue
+			}
+			v[key] = r.RedactValue(item)
+		}
+		return v
+	}
+	return value
+}
+
+// RedactJSON 替换一段 JSON 中的个人信息，解析失败时返回原值
+func (r *PIIRedactor) RedactJSON(raw []byte) ([]byte, error) {
+	if len(raw) == 0 {
+		return raw, nil
+	}
+	var value any
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return raw, err
+	}
+	return json.Marshal(r.RedactValue(value))
+}
+
+// Counts 返回各检测规则替换的次数，不包含原值
+func (r *PIIRedactor) Counts() map[string]int {
+	return r.counts
+}
+
+// HasRedactions 是否替换过个人信息，没有替换时无需还原响应
+func (r *PIIRedactor) HasRedactions() bool {
+	return len(r.placeholders) > 0
+}
+
+// RestoreValue 将 JSON 值中全部字符串里的占位符还原为原值
+func (r *PIIRedactor) RestoreValue(value any) any {
+	switch v := value.(type) {
+	case string:
+		return r.Restore(v)
+	case []any:
+		for i := range v {
+			v[i] = r.RestoreValue(v[i])
+		}
+		return v
+	case map[string]any:
+		for key, item := range v {
+			v[key] = r.RestoreValue(item)
+		}
+		return v
+	}
+	return value
+}
+
+// Restore 将文本中的占位符还原为原值
+func (r *PIIRedactor) Restore(text string) string {
+	if !strings.Contains(text, "[") {
+		return text
+	}
+	return piiPlaceholderPattern.ReplaceAllStringFunc(text, func(placeholder string) string {
+		if original, ok := r.placeholders[placeholder]; ok {
+			return original
+		}
+		return placeholder
+	})
+}
+
+// RestoreJSON 将 JSON 文本中的占位符还原为转义后的原值
+func (r *PIIRedactor) RestoreJSON(raw []byte) []byte {
+	return piiPlaceholderPattern.ReplaceAllFunc(raw, func(placeholder []byte) []byte {
+		original, ok := r.placeholders[string(placeholder)]
+		if !ok {
+			return placeholder
+		}
+		escaped, err := json.Marshal(original)
+		if err != nil {
+			return placeholder
+		}
+		return escaped[1 : len(escaped)-1]
+	})
+}
+
+// RestoreStream 还原流式输出中的占位符，末尾可能是被截断的占位符时保留到下一段再处理，
+// 返回可以输出的文本与需要保留的文本
+func (r *PIIRedactor) RestoreStream(text string) (string, string) {
+	hold := ""
+	if idx := strings.LastIndex(text, "["); idx >= 0 && !strings.Contains(text[idx:], "]") {
+		for placeholder := range r.placeholders {
+			if strings.HasPrefix(placeholder, text[idx:]) {
+				hold = text[idx:]
+				text = text[:idx]
+				break
+			}
+		}
+	}
+	return r.Restore(text), hold
+}
@@ -33,6 +33,8 @@ type QuotaInfo struct {
 	ModelPrice    float64
 	ModelRatio    float64
 	GroupRatio    float64
+	// ScheduleMultiplier 定价时段倍率
+	ScheduleMultiplier float64
 }
 
 func calculateAudioQuota(info QuotaInfo) int {
@@ -40,8 +42,9 @@ func calculateAudioQuota(info QuotaInfo) int {
 		modelPrice := decimal.NewFromFloat(info.ModelPrice)
 		quotaPerUnit := decimal.NewFromFloat(common.QuotaPerUnit)
 		groupRatio := decimal.NewFromFloat(info.GroupRatio)
+		scheduleMultiplier := decimal.NewFromFloat(info.ScheduleMultiplier)
 
-		quota := modelPrice.Mul(quotaPerUnit).Mul(groupRatio)
+		quota := modelPrice.Mul(quotaPerUnit).Mul(groupRatio).Mul(scheduleMultiplier)
 		return int(quota.IntPart())
 	}
 
@@ -51,7 +54,7 @@ func calculateAudioQuota(info QuotaInfo) int {
 
 	groupRatio := decimal.NewFromFloat(info.GroupRatio)
 	modelRatio := decimal.NewFromFloat(info.ModelRatio)
-	ratio := groupRatio.Mul(modelRatio)
+	ratio := groupRatio.Mul(modelRatio).Mul(decimal.NewFromFloat(info.ScheduleMultiplier))
 
 	inputTextTokens := decimal.NewFromInt(int64(info.InputDetails.TextTokens))
 	outputTextTokens := decimal.NewFromInt(int64(info.OutputDetails.TextTokens))
@@ -78,7 +81,7 @@ func PreWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usag
 	if relayInfo.UsePrice {
 		return nil
 	}
-	userQuota, err := model.GetUserQuota(relayInfo.UserId, false)
+	userQuota, err := GetPayerQuota(relayInfo)
 	if err != nil {
 		return err
 	}
@@ -95,6 +98,7 @@ func PreWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usag
 	audioOutTokens := usage.OutputTokenDetails.AudioTokens
 	groupRatio := setting.GetGroupRatio(relayInfo.Group)
 	modelRatio, _ := operation_setting.GetModelRatio(modelName)
+	_, scheduleMultiplier := operation_setting.GetActivePricingSchedules(modelName, relayInfo.Group, relayInfo.StartTime)
 
 	quotaInfo := QuotaInfo{
 		InputDetails: TokenDetails{
@@ -105,15 +109,20 @@ func PreWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usag
 			TextTokens:  textOutTokens,
 			AudioTokens: audioOutTokens,
 		},
-		ModelName:  modelName,
-		UsePrice:   relayInfo.UsePrice,
-		ModelRatio: modelRatio,
-		GroupRatio: groupRatio,
+		ModelName:          modelName,
+		UsePrice:           relayInfo.UsePrice,
+		ModelRatio:         modelRatio,
+		GroupRatio:         groupRatio,
+		ScheduleMultiplier: scheduleMultiplier,
 	}
 
 	quota := calculateAudioQuota(quotaInfo)
 
-	if userQuota < quota {
+	subscriptionQuota, err := GetSubscriptionRemainQuota(relayInfo, quota)
+	if err != nil {
+		return err
+	}
+	if userQuota+subscriptionQuota < quota {
 		return fmt.Errorf("user quota is not enough, user quota: %s, need quota: %s", common.FormatQuota(userQuota), common.FormatQuota(quota))
 	}
 
@@ -121,7 +130,7 @@ func PreWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usag
 		return fmt.Errorf("token quota is not enough, token remain quota: %s, need quota: %s", common.FormatQuota(token.RemainQuota), common.FormatQuota(quota))
 	}
 
-	err = PostConsumeQuota(relayInfo, quota, 0, false)
+	_, err = PostConsumeQuotaWithSubscription(relayInfo, quota, 0, false)
 	if err != nil {
 		return err
 	}
@@ -133,6 +142,7 @@ func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, mod
 	usage *dto.RealtimeUsage, preConsumedQuota int, userQuota int, modelRatio float64, groupRatio float64,
 	modelPrice float64, usePrice bool, extraContent string) {
 
+	PostConsumeTPM(relayInfo, usage.InputTokens, usage.OutputTokens)
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	textInputTokens := usage.InputTokenDetails.TextTokens
 	textOutTokens := usage.OutputTokenDetails.TextTokens
@@ -144,6 +154,7 @@ func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, mod
 	completionRatio := decimal.NewFromFloat(operation_setting.GetCompletionRatio(modelName))
 	audioRatio := decimal.NewFromFloat(operation_setting.GetAudioRatio(relayInfo.OriginModelName))
 	audioCompletionRatio := decimal.NewFromFloat(operation_setting.GetAudioCompletionRatio(modelName))
+	schedules, scheduleMultiplier := operation_setting.GetActivePricingSchedules(relayInfo.OriginModelName, relayInfo.Group, relayInfo.StartTime)
 
 	quotaInfo := QuotaInfo{
 		InputDetails: TokenDetails{
@@ -154,10 +165,11 @@ func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, mod
 			TextTokens:  textOutTokens,
 			AudioTokens: audioOutTokens,
 		},
-		ModelName:  modelName,
-		UsePrice:   usePrice,
-		ModelRatio: modelRatio,
-		GroupRatio: groupRatio,
+		ModelName:          modelName,
+		UsePrice:           usePrice,
+		ModelRatio:         modelRatio,
+		GroupRatio:         groupRatio,
+		ScheduleMultiplier: scheduleMultiplier,
 	}
 
 	quota := calculateAudioQuota(quotaInfo)
@@ -170,6 +182,9 @@ func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, mod
 	} else {
 		logContent = fmt.Sprintf("模型价格 %.2f，分组倍率 %.2f", modelPrice, groupRatio)
 	}
+	if len(schedules) > 0 {
+		logContent += fmt.Sprintf("，定价时段倍率 %.2f", scheduleMultiplier)
+	}
 
 	// record all the consume log even if quota is 0
 	if totalTokens == 0 {
@@ -197,6 +212,8 @@ func PostWssConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, mod
 func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	usage *dto.Usage, preConsumedQuota int, userQuota int, priceData helper.PriceData, extraContent string) {
 
+	PostConsumeTPM(relayInfo, usage.PromptTokens+usage.PromptTokensDetails.CachedTokens+usage.PromptTokensDetails.CachedCreationTokens, usage.CompletionTokens)
+	priceData.ApplyModelRatioTier(relayInfo.OriginModelName, usage.PromptTokens+usage.PromptTokensDetails.CachedTokens+usage.PromptTokensDetails.CachedCreationTokens)
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	promptTokens := usage.PromptTokens
 	completionTokens := usage.CompletionTokens
@@ -220,9 +237,9 @@ func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 		calculateQuota += float64(cacheTokens) * cacheRatio
 		calculateQuota += float64(cacheCreationTokens) * cacheCreationRatio
 		calculateQuota += float64(completionTokens) * completionRatio
-		calculateQuota = calculateQuota * groupRatio * modelRatio
+		calculateQuota = calculateQuota * groupRatio * priceData.ScheduleMultiplier * modelRatio
 	} else {
-		calculateQuota = modelPrice * common.QuotaPerUnit * groupRatio
+		calculateQuota = modelPrice * common.QuotaPerUnit * groupRatio * priceData.ScheduleMultiplier
 	}
 
 	if modelRatio != 0 && calculateQuota <= 0 {
@@ -233,7 +250,7 @@ func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 
 	totalTokens := promptTokens + completionTokens
 
-	var logContent string
+	logContent := strings.TrimPrefix(priceData.TierLogContent()+priceData.ScheduleLogContent(), "，")
 	// record all the consume log even if quota is 0
 	if totalTokens == 0 {
 		// in this case, must be some error happened
@@ -247,16 +264,20 @@ func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 		model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
 	}
 
-	quotaDelta := quota - preConsumedQuota
-	if quotaDelta != 0 {
-		err := PostConsumeQuota(relayInfo, quotaDelta, preConsumedQuota, true)
-		if err != nil {
-			common.LogError(ctx, "error consuming token remain quota: "+err.Error())
-		}
+	// 订阅套餐优先承担费用，付费方只扣除超出套餐的部分
+	subscriptionQuota, err := PostConsumeQuotaWithSubscription(relayInfo, quota, preConsumedQuota, true)
+	if err != nil {
+		common.LogError(ctx, "error consuming token remain quota: "+err.Error())
+	}
+	if subscriptionQuota > 0 {
+		logContent += fmt.Sprintf("，套餐额度抵扣 %s", common.LogQuota(subscriptionQuota))
 	}
 
 	other := GenerateClaudeOtherInfo(ctx, relayInfo, modelRatio, groupRatio, completionRatio,
 		cacheTokens, cacheRatio, cacheCreationTokens, cacheCreationRatio, modelPrice)
+	AppendModelRatioTierInfo(other, priceData)
+	AppendPricingScheduleInfo(other, priceData)
+	AppendSubscriptionInfo(other, relayInfo, subscriptionQuota)
 	model.RecordConsumeLog(ctx, relayInfo.UserId, relayInfo.ChannelId, promptTokens, completionTokens, modelName,
 		tokenName, quota, logContent, relayInfo.TokenId, userQuota, int(useTimeSeconds), relayInfo.IsStream, relayInfo.Group, other)
 }
@@ -264,6 +285,7 @@ func PostClaudeConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	usage *dto.Usage, preConsumedQuota int, userQuota int, priceData helper.PriceData, extraContent string) {
 
+	PostConsumeTPM(relayInfo, usage.PromptTokens, usage.CompletionTokens)
 	useTimeSeconds := time.Now().Unix() - relayInfo.StartTime.Unix()
 	textInputTokens := usage.PromptTokensDetails.TextTokens
 	textOutTokens := usage.CompletionTokenDetails.TextTokens
@@ -290,10 +312,11 @@ func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 			TextTokens:  textOutTokens,
 			AudioTokens: audioOutTokens,
 		},
-		ModelName:  relayInfo.OriginModelName,
-		UsePrice:   usePrice,
-		ModelRatio: modelRatio,
-		GroupRatio: groupRatio,
+		ModelName:          relayInfo.OriginModelName,
+		UsePrice:           usePrice,
+		ModelRatio:         modelRatio,
+		GroupRatio:         groupRatio,
+		ScheduleMultiplier: priceData.ScheduleMultiplier,
 	}
 
 	quota := calculateAudioQuota(quotaInfo)
@@ -306,6 +329,7 @@ func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	} else {
 		logContent = fmt.Sprintf("模型价格 %.2f，分组倍率 %.2f", modelPrice, groupRatio)
 	}
+	logContent += priceData.ScheduleLogContent()
 
 	// record all the consume log even if quota is 0
 	if totalTokens == 0 {
@@ -320,12 +344,13 @@ func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 		model.UpdateChannelUsedQuota(relayInfo.ChannelId, quota)
 	}
 
-	quotaDelta := quota - preConsumedQuota
-	if quotaDelta != 0 {
-		err := PostConsumeQuota(relayInfo, quotaDelta, preConsumedQuota, true)
-		if err != nil {
-			common.LogError(ctx, "error consuming token remain quota: "+err.Error())
-		}
+	// 订阅套餐优先承担费用，付费方只扣除超出套餐的部分
+	subscriptionQuota, err := PostConsumeQuotaWithSubscription(relayInfo, quota, preConsumedQuota, true)
+	if err != nil {
+		common.LogError(ctx, "error consuming token remain quota: "+err.Error())
+	}
+	if subscriptionQuota > 0 {
+		logContent += fmt.Sprintf("，套餐额度抵扣 %s", common.LogQuota(subscriptionQuota))
 	}
 
 	logModel := relayInfo.OriginModelName
@@ -334,11 +359,15 @@ func PostAudioConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo,
 	}
 	other := GenerateAudioOtherInfo(ctx, relayInfo, usage, modelRatio, groupRatio,
 		completionRatio.InexactFloat64(), audioRatio.InexactFloat64(), audioCompletionRatio.InexactFloat64(), modelPrice)
+	AppendPricingScheduleInfo(other, priceData)
+	AppendSubscriptionInfo(other, relayInfo, subscriptionQuota)
 	model.RecordConsumeLog(ctx, relayInfo.UserId, relayInfo.ChannelId, usage.PromptTokens, usage.CompletionTokens, logModel,
 		tokenName, quota, logContent, relayInfo.TokenId, userQuota, int(useTimeSeconds), relayInfo.IsStream, relayInfo.Group, other)
 }
 
-func PreConsumeTokenQuota(relayInfo *relaycommon.RelayInfo, quota int) error {
+// PreConsumeTokenQuota 预扣令牌额度，estimatedQuota 为本次请求的预估费用，
+// 信任用户余额而无需预扣（quota 为 0）时仍按预估费用检查令牌周期预算
+func PreConsumeTokenQuota(relayInfo *relaycommon.RelayInfo, quota int, estimatedQuota int) error {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
@@ -355,33 +384,31 @@ func PreConsumeTokenQuota(relayInfo *relaycommon.RelayInfo, quota int) error {
 	if !relayInfo.TokenUnlimited && token.RemainQuota < quota {
 		return fmt.Errorf("token quota is not enough, token remain quota: %s, need quota: %s", common.FormatQuota(token.RemainQuota), common.FormatQuota(quota))
 	}
-	err = model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota)
+	err = checkTokenBudget(relayInfo, token, max(quota, estimatedQuota))
 	if err != nil {
 		return err
 	}
+	if quota > 0 {
+		err = model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota)
+		if err != nil {
+			return err
+		}
+		recordTokenBudgetUsage(relayInfo, token, quota)
+	}
 	return nil
 }
 
 func PostConsumeQuota(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQuota int, sendEmail bool) (err error) {
 
-	if quota > 0 {
-		err = model.DecreaseUserQuota(relayInfo.UserId, quota)
-	} else {
-		err = model.IncreaseUserQuota(relayInfo.UserId, -quota, false)
-	}
+	// 付费方扣除失败时令牌额度仍按实际消耗变动
+	payerErr := settlePayerQuota(relayInfo, quota)
+
+	err = changeTokenQuota(relayInfo, quota)
 	if err != nil {
 		return err
 	}
-
-	if !relayInfo.IsPlayground {
-		if quota > 0 {
-			err = model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota)
-		} else {
-			err = model.IncreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, -quota)
-		}
-		if err != nil {
-			return err
-		}
+	if payerErr != nil {
+		return payerErr
 	}
 
 	if sendEmail {
@@ -393,6 +420,24 @@ func PostConsumeQuota(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQu
 	return nil
 }
 
+// changeTokenQuota 按消耗调整令牌剩余额度并计入令牌周期预算，quota 为负数时表示退还
+func changeTokenQuota(relayInfo *relaycommon.RelayInfo, quota int) error {
+	if relayInfo.IsPlayground || quota == 0 {
+		return nil
+	}
+	var err error
+	if quota > 0 {
+		err = model.DecreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, quota)
+	} else {
+		err = model.IncreaseTokenQuota(relayInfo.TokenId, relayInfo.TokenKey, -quota)
+	}
+	if err != nil {
+		return err
+	}
+	recordTokenBudgetUsage(relayInfo, nil, quota)
+	return nil
+}
+
 func checkAndSendQuotaNotify(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQuota int) {
 	gopool.Go(func() {
 		userSetting := relayInfo.UserSetting
@@ -404,7 +449,7 @@ func checkAndSendQuotaNotify(relayInfo *relaycommon.RelayInfo, quota int, preCon
 		//noMoreQuota := userCache.Quota-(quota+preConsumedQuota) <= 0
 		quotaTooLow := false
 		consumeQuota := quota + preConsumedQuota
-		if relayInfo.UserQuota-consumeQuota < threshold {
+		if relayInfo.OrgId == 0 && !relayInfo.TokenSelfFunded && relayInfo.UserQuota-consumeQuota < threshold {
 			quotaTooLow = true
 		}
 		if quotaTooLow {
@@ -416,5 +461,6 @@ func checkAndSendQuotaNotify(relayInfo *relaycommon.RelayInfo, quota int, preCon
 				common.SysError(fmt.Sprintf("failed to send quota notify to user %d: %s", relayInfo.UserId, err.Error()))
 			}
 		}
+		checkTokenBudgetNotify(relayInfo, consumeQuota)
 	})
 }
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"fmt"
+	"one-api/common"
+	"one-api/model"
+	"sync"
+	"time"
+)
+
+// QuotaLedgerReconcileReport 最近一次流水核对的结果
+type QuotaLedgerReconcileReport struct {
+	CheckedAt  int64                        `json:"checked_at"`
+	Mismatches []*model.LedgerReconcileItem `json:"mismatches"`
+}
+
+var (
+	lastReconcileReport     *QuotaLedgerReconcileReport
+	lastReconcileReportLock sync.RWMutex
+)
+
+// ReconcileQuotaLedger 核对流水与用户余额并保存结果，不一致的用户会写入系统日志
+func ReconcileQuotaLedger() (*QuotaLedgerReconcileReport, error) {
+	mismatches, err := model.ReconcileQuotaLedger()
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range mismatches {
+		common.SysError(fmt.Sprintf("quota ledger mismatch: user %d quota %d ledger %d last balance %d diff %d",
+			item.UserId, item.Quota, item.LedgerBalance, item.LastBalanceAfter, item.Diff))
+	}
+	report := &QuotaLedgerReconcileReport{
+		CheckedAt:  common.GetTimestamp(),
+		Mismatches: mismatches,
+	}
+	lastReconcileReportLock.Lock()
+	lastReconcileReport = report
+	lastReconcileReportLock.Unlock()
+	return report, nil
+}
+
+func GetLastQuotaLedgerReconcileReport() *QuotaLedgerReconcileReport {
+	lastReconcileReportLock.RLock()
+	defer lastReconcileReportLock.RUnlock()
+	return lastReconcileReport
+}
+
+// StartQuotaLedgerReconcileTask 定期核对额度流水
+func StartQuotaLedgerReconcileTask(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		report, err := ReconcileQuotaLedger()
+		if err != nil {
+			common.SysError("failed to reconcile quota ledger: " + err.Error())
+			continue
+		}
+		common.SysLog(fmt.Sprintf("quota ledger reconciled, %d mismatches", len(report.Mismatches)))
+	}
+}
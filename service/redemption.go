@@ -0,0 +1,21 @@
+package service
+
+import (
+	"fmt"
+	"one-api/common"
+	"one-api/model"
+	"time"
+)
+
+// StartRedemptionTask 定期恢复兑换码限时分组升级到期用户的原分组
+func StartRedemptionTask(frequency int) {
+	for {
+		count, err := model.RestoreExpiredRedemptionGroups()
+		if err != nil {
+			common.SysError("failed to restore expired redemption groups: " + err.Error())
+		} else if count > 0 {
+			common.SysLog(fmt.Sprintf("restored %d expired redemption group upgrades", count))
+		}
+		time.Sleep(time.Duration(frequency) * time.Second)
+	}
+}
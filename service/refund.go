@@ -0,0 +1,22 @@
+package service
+
+import (
+	"fmt"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/model"
+)
+
+// NotifyUserRefund 通知用户退款结果
+func NotifyUserRefund(userId int, content string, values []interface{}) {
+	user, err := model.GetUserById(userId, false)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to get user %d for refund notify: %s", userId, err.Error()))
+		return
+	}
+	baseUser := user.ToBaseUser()
+	err = NotifyUser(baseUser.Id, baseUser.Email, baseUser.GetSetting(), dto.NewNotify(dto.NotifyTypeRefund, "退款通知", content, values))
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to send refund notify to user %d: %s", userId, err.Error()))
+	}
+}
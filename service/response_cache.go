@@ -0,0 +1,149 @@
+package service
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/setting/operation_setting"
+	"sync"
+	"time"
+)
+
+// ResponseCacheEntry 缓存的完整响应，流式响应保存原始 SSE 内容
+type ResponseCacheEntry struct {
+	ContentType string    `json:"content_type"`
+	Body        []byte    `json:"body"`
+	IsStream    bool      `json:"is_stream"`
+	Usage       dto.Usage `json:"usage"`
+	CreatedAt   int64     `json:"created_at"`
+}
+
+// ResponseCacheStore 响应缓存存储
+type ResponseCacheStore interface {
+	Get(key string) (*ResponseCacheEntry, bool)
+	Set(key string, entry *ResponseCacheEntry, ttl time.Duration) error
+}
+
+type redisResponseCacheStore struct{}
+
+func (s *redisResponseCacheStore) Get(key string) (*ResponseCacheEntry, bool) {
+	value, err := common.RedisGet(key)
+	if err != nil {
+		return nil, false
+	}
+	var entry ResponseCacheEntry
+	if err := json.Unmarshal([]byte(value), &entry); err != nil {
+		return nil, false
+	}
+	return &entry, true
+}
+
+func (s *redisResponseCacheStore) Set(key string, entry *ResponseCacheEntry, ttl time.Duration) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return common.RedisSet(key, string(data), ttl)
+}
+
+type memoryResponseCacheItem struct {
+	key      string
+	entry    *ResponseCacheEntry
+	expireAt time.Time
+}
+
+// memoryResponseCacheStore 带过期时间的进程内 LRU 缓存
+type memoryResponseCacheStore struct {
+	mutex sync.Mutex
+	items map[string]*list.Element
+	order *list.List
+}
+
+func newMemoryResponseCacheStore() *memoryResponseCacheStore {
+	return &memoryResponseCacheStore{
+		items: make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+func (s *memoryResponseCacheStore) Get(key string) (*ResponseCacheEntry, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	element, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	item := element.Value.(*memoryResponseCacheItem)
+	if time.Now().After(item.expireAt) {
+		s.order.Remove(element)
+		delete(s.items, key)
+		return nil, false
+	}
+	s.order.MoveToFront(element)
+	return item.entry, true
+}
+
+func (s *memoryResponseCacheStore) Set(key string, entry *ResponseCacheEntry, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if element, ok := s.items[key]; ok {
+		item := element.Value.(*memoryResponseCacheItem)
+		item.entry = entry
+		item.expireAt = time.Now().Add(ttl)
+		s.order.MoveToFront(element)
+		return nil
+	}
+	s.items[key] = s.order.PushFront(&memoryResponseCacheItem{
+		key:      key,
+		entry:    entry,
+		expireAt: time.Now().Add(ttl),
+	})
+	maxEntries := operation_setting.GetResponseCacheSetting().MaxEntries
+	for maxEntries > 0 && s.order.Len() > maxEntries {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.items, oldest.Value.(*memoryResponseCacheItem).key)
+	}
+	return nil
+}
+
+var redisResponseCache = &redisResponseCacheStore{}
+var memoryResponseCache = newMemoryResponseCacheStore()
+
+// GetResponseCacheStore 根据配置返回当前使用的缓存存储
+func GetResponseCacheStore() ResponseCacheStore {
+	switch operation_setting.GetResponseCacheSetting().Storage {
+	case "memory":
+		return memoryResponseCache
+	case "redis":
+		if common.RedisEnabled {
+			return redisResponseCache
+		}
+		return memoryResponseCache
+	default:
+		if common.RedisEnabled {
+			return redisResponseCache
+		}
+		return memoryResponseCache
+	}
+}
+
+// GenerateResponseCacheKey 根据分组、模型和请求体生成缓存键
+// 请求体会重新序列化为键有序的 JSON，忽略字段顺序与空白差异
+func GenerateResponseCacheKey(group string, modelName string, requestBody []byte) (string, error) {
+	var body map[string]any
+	if err := json.Unmarshal(requestBody, &body); err != nil {
+		return "", err
+	}
+	delete(body, "user")
+	normalized, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s\n%s\n%s", group, modelName, normalized)))
+	return "response_cache:" + hex.EncodeToString(hash[:]), nil
+}
@@ -0,0 +1,200 @@
+package service
+
+import (
+	"fmt"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/setting/operation_setting"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SemanticCacheEntry 一条语义缓存：问题向量与对应的回答
+type SemanticCacheEntry struct {
+	Id    string `json:"id"`
+	Scope string `json:"scope"`
+	Model string `json:"model"`
+	// ContextHash 系统提示词、历史消息与请求参数的摘要，只有完全一致才可能命中
+	ContextHash  string    `json:"context_hash"`
+	Question     string    `json:"question"`
+	Answer       string    `json:"answer"`
+	FinishReason string    `json:"finish_reason"`
+	Usage        dto.Usage `json:"usage"`
+	Vector       []float64 `json:"-"`
+	HitCount     int64     `json:"hit_count"`
+	CreatedAt    int64     `json:"created_at"`
+	ExpiredAt    int64     `json:"expired_at"`
+}
+
+// SemanticCacheStorage 语义缓存存储，向量检索在进程内完成，存储可替换
+type SemanticCacheStorage interface {
+	Add(entry *SemanticCacheEntry)
+	// List 返回范围内的所有条目，scope 为空时返回全部
+	List(scope string) []*SemanticCacheEntry
+	Delete(id string) bool
+	// Purge 删除范围内的所有条目，scope 为空时清空全部，返回删除数量
+	Purge(scope string) int
+}
+
+type memorySemanticCacheStorage struct {
+	mutex   sync.RWMutex
+	entries map[string][]*SemanticCacheEntry
+}
+
+func (s *memorySemanticCacheStorage) Add(entry *SemanticCacheEntry) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entries := append(s.entries[entry.Scope], entry)
+	maxEntries := operation_setting.GetSemanticCacheSetting().MaxEntries
+	if maxEntries > 0 && len(entries) > maxEntries {
+		entries = entries[len(entries)-maxEntries:]
+	}
+	s.entries[entry.Scope] = entries
+}
+
+func (s *memorySemanticCacheStorage) List(scope string) []*SemanticCacheEntry {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	now := time.Now().Unix()
+	var result []*SemanticCacheEntry
+	for entryScope, entries := range s.entries {
+		if scope != "" && entryScope != scope {
+			continue
+		}
+		for _, entry := range entries {
+			if entry.ExpiredAt > now {
+				result = append(result, entry)
+			}
+		}
+	}
+	return result
+}
+
+func (s *memorySemanticCacheStorage) Delete(id string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for scope, entries := range s.entries {
+		for i, entry := range entries {
+			if entry.Id == id {
+				s.entries[scope] = append(entries[:i:i], entries[i+1:]...)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *memorySemanticCacheStorage) Purge(scope string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	count := 0
+	for entryScope, entries := range s.entries {
+		if scope != "" && entryScope != scope {
+			continue
+		}
+		count += len(entries)
+		delete(s.entries, entryScope)
+	}
+	return count
+}
+
+// removeExpired 清理过期条目
+func (s *memorySemanticCacheStorage) removeExpired() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now().Unix()
+	for scope, entries := range s.entries {
+		kept := entries[:0]
+		for _, entry := range entries {
+			if entry.ExpiredAt > now {
+				kept = append(kept, entry)
+			}
+		}
+		if len(kept) == 0 {
+			delete(s.entries, scope)
+		} else {
+			s.entries[scope] = kept
+		}
+	}
+}
+
+var semanticCacheStorage SemanticCacheStorage = &memorySemanticCacheStorage{
+	entries: make(map[string][]*SemanticCacheEntry),
+}
+
+// SetSemanticCacheStorage 替换语义缓存存储
+func SetSemanticCacheStorage(storage SemanticCacheStorage) {
+	semanticCacheStorage = storage
+}
+
+func GetSemanticCacheStorage() SemanticCacheStorage {
+	return semanticCacheStorage
+}
+
+// StartSemanticCacheCleanup 定期清理内存存储中的过期条目
+func StartSemanticCacheCleanup(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		if storage, ok := semanticCacheStorage.(*memorySemanticCacheStorage); ok {
+			storage.removeExpired()
+		}
+	}
+}
+
+// GetSemanticCacheScope 根据配置返回令牌或用户级别的缓存范围
+func GetSemanticCacheScope(tokenId int, userId int) string {
+	if operation_setting.GetSemanticCacheSetting().Scope == "token" {
+		return fmt.Sprintf("token:%d", tokenId)
+	}
+	return fmt.Sprintf("user:%d", userId)
+}
+
+// SearchSemanticCache 在范围内查找上下文一致、与向量最相似且超过阈值的同模型条目
+func SearchSemanticCache(scope string, modelName string, contextHash string, vector []float64) (*SemanticCacheEntry, float64) {
+	threshold := operation_setting.GetSemanticCacheSetting().SimilarityThreshold
+	var best *SemanticCacheEntry
+	bestScore := 0.0
+	for _, entry := range semanticCacheStorage.List(scope) {
+		if entry.Model != modelName || entry.ContextHash != contextHash {
+			continue
+		}
+		score := common.CosineSimilarity(vector, entry.Vector)
+		if score >= threshold && score > bestScore {
+			best = entry
+			bestScore = score
+		}
+	}
+	if best != nil {
+		atomic.AddInt64(&best.HitCount, 1)
+	}
+	return best, bestScore
+}
+
+// AddSemanticCache 保存一条问答到语义缓存
+func AddSemanticCache(scope string, modelName string, contextHash string, question string, vector []float64, answer string, finishReason string, usage dto.Usage) {
+	now := time.Now()
+	semanticCacheStorage.Add(&SemanticCacheEntry{
+		Id:           common.GetUUID(),
+		Scope:        scope,
+		Model:        modelName,
+		ContextHash:  contextHash,
+		Question:     question,
+		Answer:       answer,
+		FinishReason: finishReason,
+		Usage:        usage,
+		Vector:       vector,
+		CreatedAt:    now.Unix(),
+		ExpiredAt:    now.Add(time.Duration(operation_setting.GetSemanticCacheSetting().TTLSeconds) * time.Second).Unix(),
+	})
+}
+
+// ListSemanticCache 按创建时间倒序列出缓存条目
+func ListSemanticCache(scope string) []*SemanticCacheEntry {
+	entries := semanticCacheStorage.List(scope)
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].CreatedAt > entries[j].CreatedAt
+	})
+	return entries
+}
@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"one-api/dto"
 	"one-api/setting"
+	"sort"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 func CheckSensitiveMessages(messages []dto.Message) ([]string, error) {
@@ -67,28 +70,169 @@ func SensitiveWordContains(text string) (bool, []string) {
 
 // SensitiveWordReplace 敏感词替换，返回是否包含敏感词和替换后的文本
 func SensitiveWordReplace(text string, returnImmediately bool) (bool, []string, string) {
-	if len(setting.SensitiveWords) == 0 {
+	hits, words := findSensitiveWords(text)
+	if len(hits) == 0 {
 		return false, nil, text
 	}
-	checkText := strings.ToLower(text)
-	m := InitAc(setting.SensitiveWords)
-	hits := m.MultiPatternSearch([]rune(checkText), returnImmediately)
+	if returnImmediately {
+		hits = hits[:1]
+		words = words[:1]
+	}
+	return true, words, maskSensitiveHits(text, hits)
+}
+
+// SensitiveWordMask 输出中替换敏感词使用的文本
+const SensitiveWordMask = "**###**"
+
+type sensitiveHit struct {
+	start int
+	end   int
+}
+
+// findSensitiveWords 查找文本中的敏感词，返回按位置排序并合并重叠部分后的字节区间与命中的敏感词
+func findSensitiveWords(text string) ([]sensitiveHit, []string) {
+	matcher := setting.GetSensitiveMatcher()
+	if matcher == nil || text == "" {
+		return nil, nil
+	}
+	// 逐字符转换小写，保证匹配位置与原文的字符一一对应
+	count := utf8.RuneCountInString(text)
+	lower := make([]rune, 0, count)
+	offsets := make([]int, 0, count+1)
+	for i, r := range text {
+		lower = append(lower, unicode.ToLower(r))
+		offsets = append(offsets, i)
+	}
+	offsets = append(offsets, len(text))
+	terms := matcher.Machine.MultiPatternSearch(lower, false)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	sort.SliceStable(terms, func(i, j int) bool {
+		return terms[i].Pos < terms[j].Pos
+	})
+	hits := make([]sensitiveHit, 0, len(terms))
+	words := make([]string, 0, len(terms))
+	for _, term := range terms {
+		words = append(words, string(term.Word))
+		start, end := offsets[term.Pos], offsets[term.Pos+len(term.Word)]
+		if n := len(hits); n > 0 && start < hits[n-1].end {
+			hits[n-1].end = max(hits[n-1].end, end)
+			continue
+		}
+		hits = append(hits, sensitiveHit{start: start, end: end})
+	}
+	return hits, RemoveDuplicate(words)
+}
+
+func maskSensitiveHits(text string, hits []sensitiveHit) string {
+	var builder strings.Builder
+	builder.Grow(len(text))
+	last := 0
+	for _, hit := range hits {
+		builder.WriteString(text[last:hit.start])
+		builder.WriteString(SensitiveWordMask)
+		last = hit.end
+	}
+	builder.WriteString(text[last:])
+	return builder.String()
+}
+
+// CompletionSensitiveFilter 过滤一次请求的模型输出。StopOnSensitiveEnabled 开启时在第一个敏感词前截断并停止输出，
+// 否则替换敏感词。流式输出按字段保留末尾不超过最长敏感词长度减一的文本，与下一段增量拼接后再检查
+type CompletionSensitiveFilter struct {
+	stop    bool
+	window  int
+	pending map[string]string
+	words   []string
+	stopped bool
+}
+
+// NewCompletionSensitiveFilter 未开启输出检查或敏感词为空时返回 nil
+func NewCompletionSensitiveFilter() *CompletionSensitiveFilter {
+	if !setting.ShouldCheckCompletionSensitive() {
+		return nil
+	}
+	matcher := setting.GetSensitiveMatcher()
+	if matcher == nil || matcher.Longest == 0 {
+		return nil
+	}
+	return &CompletionSensitiveFilter{
+		stop:    setting.StopOnSensitiveEnabled,
+		window:  matcher.Longest - 1,
+		pending: make(map[string]string),
+	}
+}
+
+func (f *CompletionSensitiveFilter) addWords(words []string) {
+	f.words = RemoveDuplicate(append(f.words, words...))
+}
+
+// Filter 过滤字段 key 的一段增量文本，返回可以输出的文本，停止输出后始终返回空字符串
+func (f *CompletionSensitiveFilter) Filter(key string, text string) string {
+	if f.stopped {
+		return ""
+	}
+	text = f.pending[key] + text
+	delete(f.pending, key)
+	hits, words := findSensitiveWords(text)
 	if len(hits) > 0 {
-		words := make([]string, 0, len(hits))
-		var builder strings.Builder
-		builder.Grow(len(text))
-		lastPos := 0
-
-		for _, hit := range hits {
-			pos := hit.Pos
-			word := string(hit.Word)
-			builder.WriteString(text[lastPos:pos])
-			builder.WriteString("**###**")
-			lastPos = pos + len(word)
-			words = append(words, word)
+		f.addWords(words)
+		if f.stop {
+			f.stopped = true
+			f.pending = make(map[string]string)
+			return text[:hits[0].start]
 		}
-		builder.WriteString(text[lastPos:])
-		return true, words, builder.String()
 	}
-	return false, nil, text
+	// 保留末尾可能是敏感词开头的部分，已命中的敏感词不会被保留
+	hold := len(text)
+	for i := 0; i < f.window && hold > 0; i++ {
+		_, size := utf8.DecodeLastRuneInString(text[:hold])
+		hold -= size
+	}
+	if len(hits) > 0 {
+		hold = max(hold, hits[len(hits)-1].end)
+	}
+	if hold < len(text) {
+		f.pending[key] = text[hold:]
+	}
+	return maskSensitiveHits(text[:hold], hits)
+}
+
+// Flush 字段结束时返回保留的文本
+func (f *CompletionSensitiveFilter) Flush(key string) string {
+	text := f.pending[key]
+	delete(f.pending, key)
+	return text
+}
+
+// FlushAll 返回全部字段保留的文本
+func (f *CompletionSensitiveFilter) FlushAll() map[string]string {
+	pending := f.pending
+	f.pending = make(map[string]string)
+	return pending
+}
+
+// FilterText 过滤一段完整的文本，返回过滤后的文本以及是否命中敏感词
+func (f *CompletionSensitiveFilter) FilterText(text string) (string, bool) {
+	hits, words := findSensitiveWords(text)
+	if len(hits) == 0 {
+		return text, false
+	}
+	f.addWords(words)
+	if f.stop {
+		f.stopped = true
+		return text[:hits[0].start], true
+	}
+	return maskSensitiveHits(text, hits), true
+}
+
+// Stopped 是否因命中敏感词停止输出
+func (f *CompletionSensitiveFilter) Stopped() bool {
+	return f.stopped
+}
+
+// Words 命中的敏感词
+func (f *CompletionSensitiveFilter) Words() []string {
+	return f.words
 }
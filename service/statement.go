@@ -0,0 +1,29 @@
+package service
+
+import (
+	"fmt"
+	"one-api/common"
+	"one-api/model"
+	"one-api/setting/operation_setting"
+	"time"
+)
+
+// StartStatementTask 定期检查上一个月的账单是否已生成，在月份结束后为所有有记录的用户生成账单
+func StartStatementTask(frequency int) {
+	lastPeriod := ""
+	for {
+		if operation_setting.GetBillingStatementSetting().Enabled {
+			period := model.GetLastClosedStatementPeriod(time.Now())
+			if period != lastPeriod {
+				count, err := model.GenerateStatementsForPeriod(period)
+				if err != nil {
+					common.SysError(fmt.Sprintf("failed to generate statements for period %s: %s", period, err.Error()))
+				} else {
+					lastPeriod = period
+					common.SysLog(fmt.Sprintf("generated %d statements for period %s", count, period))
+				}
+			}
+		}
+		time.Sleep(time.Duration(frequency) * time.Second)
+	}
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"fmt"
+	"one-api/common"
+	"one-api/model"
+	relaycommon "one-api/relay/common"
+	"one-api/setting"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// PlanPriceToQuota 按充值价格将套餐价格换算为余额额度
+func PlanPriceToQuota(price float64) int {
+	unitPrice := setting.Price
+	if unitPrice <= 0 {
+		unitPrice = 1
+	}
+	return int(decimal.NewFromFloat(price).Div(decimal.NewFromFloat(unitPrice)).
+		Mul(decimal.NewFromFloat(common.QuotaPerUnit)).Round(0).IntPart())
+}
+
+// StartSubscriptionTask 定期重置套餐额度并处理订阅续费与过期
+func StartSubscriptionTask(frequency int) {
+	for {
+		model.UpdateSubscriptions(PlanPriceToQuota)
+		time.Sleep(time.Duration(frequency) * time.Second)
+	}
+}
+
+// GetSubscriptionRemainQuota 返回请求模型可用的套餐额度，并记录订阅到 relayInfo；
+// 套餐不允许超额且剩余额度不足以支付 quota 时返回错误
+func GetSubscriptionRemainQuota(relayInfo *relaycommon.RelayInfo, quota int) (int, error) {
+	if relayInfo.OrgId != 0 || relayInfo.TokenSelfFunded {
+		// 组织令牌由组织额度池付费，自付令牌由令牌额度付费，均不使用个人订阅
+		return 0, nil
+	}
+	subscription, err := model.CacheGetActiveSubscription(relayInfo.UserId)
+	if err != nil || subscription == nil {
+		return 0, err
+	}
+	relayInfo.SubscriptionId = subscription.Id
+	remain := subscription.RemainQuotaForModel(relayInfo.OriginModelName)
+	if subscription.Plan != nil && subscription.Plan.OverageBehavior == model.PlanOverageBlock {
+		relayInfo.SubscriptionOverageBlock = true
+		if remain <= 0 || remain < quota {
+			return 0, fmt.Errorf("subscription plan %s remain quota %s is not enough, need quota: %s",
+				subscription.Plan.Name, common.FormatQuota(remain), common.FormatQuota(quota))
+		}
+	}
+	return remain, nil
+}
+
+// PostConsumeQuotaWithSubscription 结算请求费用：订阅套餐优先承担，付费方只扣除超出套餐的部分，令牌额度仍按实际消耗扣除；
+// 不允许超额的套餐只检查了预估费用，实际费用超出剩余套餐额度时不从余额扣除，超出部分记录到 SubscriptionWaivedQuota；
+// quota 为本次请求的实际费用，preConsumedQuota 为已从付费方和令牌预扣的额度，返回套餐承担的额度
+func PostConsumeQuotaWithSubscription(relayInfo *relaycommon.RelayInfo, quota int, preConsumedQuota int, sendEmail bool) (int, error) {
+	covered := 0
+	if relayInfo.SubscriptionId != 0 && quota > 0 {
+		var err error
+		covered, err = model.ConsumeSubscriptionQuota(relayInfo.UserId, relayInfo.OriginModelName, quota)
+		if err != nil {
+			common.SysError("error consuming subscription quota: " + err.Error())
+			covered = 0
+		}
+	}
+	payerQuota := quota - covered - preConsumedQuota
+	if relayInfo.SubscriptionOverageBlock && quota > covered {
+		relayInfo.SubscriptionWaivedQuota = quota - covered
+		payerQuota = -preConsumedQuota
+		common.SysLog(fmt.Sprintf("subscription %d does not allow overage, user %d waived quota %d",
+			relayInfo.SubscriptionId, relayInfo.UserId, relayInfo.SubscriptionWaivedQuota))
+	}
+	// 付费方扣除失败时令牌额度仍按实际消耗变动
+	payerErr := settlePayerQuota(relayInfo, payerQuota)
+	if err := changeTokenQuota(relayInfo, quota-preConsumedQuota); err != nil {
+		return covered, err
+	}
+	if payerErr != nil {
+		return covered, payerErr
+	}
+	if sendEmail && quota-covered != 0 {
+		checkAndSendQuotaNotify(relayInfo, payerQuota, preConsumedQuota)
+	}
+	return covered, nil
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"one-api/common"
+	"one-api/dto"
+	"one-api/model"
+	relaycommon "one-api/relay/common"
+	"one-api/setting/operation_setting"
+	"sort"
+	"time"
+)
+
+var ErrTokenBudgetExceeded = errors.New("token budget exceeded")
+
+// CheckTokenBudget 不经过预扣费的请求（异步任务提交）在请求上游前按本次费用检查令牌预算
+func CheckTokenBudget(relayInfo *relaycommon.RelayInfo, quota int) error {
+	if relayInfo.TokenBudgetPeriod == "" || relayInfo.IsPlayground {
+		return nil
+	}
+	token, err := model.GetTokenByKey(relayInfo.TokenKey, false)
+	if err != nil {
+		return err
+	}
+	return checkTokenBudget(relayInfo, token, quota)
+}
+
+// checkTokenBudget 判断本次请求是否会超出令牌当前周期的总预算或模型子预算，并记录预算周期到 relayInfo
+func checkTokenBudget(relayInfo *relaycommon.RelayInfo, token *model.Token, quota int) error {
+	if !token.IsBudgetEnabled() {
+		return nil
+	}
+	start, end := model.GetTokenBudgetWindow(token.BudgetPeriod, time.Now())
+	relayInfo.TokenBudgetWindow = start.Unix()
+	usages, err := model.GetTokenBudgetUsages(token.Id, relayInfo.TokenBudgetWindow)
+	if err != nil {
+		return err
+	}
+	resetAt := end.Format("2006-01-02 15:04:05 MST")
+	if token.BudgetQuota > 0 {
+		used := usages[""]
+		if used >= token.BudgetQuota || used+quota > token.BudgetQuota {
+			return fmt.Errorf("%w: %s budget used %s of %s, need %s, resets at %s", ErrTokenBudgetExceeded, token.BudgetPeriod,
+				common.FormatQuota(used), common.FormatQuota(token.BudgetQuota), common.FormatQuota(quota), resetAt)
+		}
+	}
+	limits := token.GetBudgetModelLimits()
+	if key := token.GetBudgetModelKey(relayInfo.OriginModelName); key != "" {
+		used := usages[key]
+		if used >= limits[key] || used+quota > limits[key] {
+			return fmt.Errorf("%w: %s budget for %s used %s of %s, need %s, resets at %s", ErrTokenBudgetExceeded, token.BudgetPeriod, key,
+				common.FormatQuota(used), common.FormatQuota(limits[key]), common.FormatQuota(quota), resetAt)
+		}
+	}
+	return nil
+}
+
+// recordTokenBudgetUsage 将额度变化计入令牌的预算周期，优先使用预扣费时的周期，保证同一请求的用量落在同一周期内
+func recordTokenBudgetUsage(relayInfo *relaycommon.RelayInfo, token *model.Token, quota int) {
+	if relayInfo.TokenBudgetPeriod == "" || quota == 0 {
+		return
+	}
+	if token == nil {
+		var err error
+		token, err = model.GetTokenByKey(relayInfo.TokenKey, false)
+		if err != nil {
+			common.SysError("error getting token for budget usage: " + err.Error())
+			return
+		}
+	}
+	if !token.IsBudgetEnabled() {
+		return
+	}
+	if relayInfo.TokenBudgetWindow == 0 {
+		start, _ := model.GetTokenBudgetWindow(token.BudgetPeriod, time.Now())
+		relayInfo.TokenBudgetWindow = start.Unix()
+	}
+	err := model.AddTokenBudgetUsage(token.Id, relayInfo.TokenBudgetWindow, token.GetBudgetModelKey(relayInfo.OriginModelName), quota)
+	if err != nil {
+		common.SysError("error recording token budget usage: " + err.Error())
+	}
+}
+
+// checkTokenBudgetNotify 本次请求使预算使用比例越过配置的阈值时通知用户
+func checkTokenBudgetNotify(relayInfo *relaycommon.RelayInfo, consumeQuota int) {
+	if relayInfo.TokenBudgetPeriod == "" || relayInfo.TokenBudgetWindow == 0 || consumeQuota <= 0 {
+		return
+	}
+	thresholds := operation_setting.GetTokenBudgetSetting().WarningThresholds
+	if len(thresholds) == 0 {
+		return
+	}
+	token, err := model.GetTokenByKey(relayInfo.TokenKey, false)
+	if err != nil || !token.IsBudgetEnabled() {
+		return
+	}
+	usages, err := model.GetTokenBudgetUsages(token.Id, relayInfo.TokenBudgetWindow)
+	if err != nil {
+		common.SysError("error getting token budget usages: " + err.Error())
+		return
+	}
+	limits := map[string]int{}
+	if token.BudgetQuota > 0 {
+		limits[""] = token.BudgetQuota
+	}
+	if key := token.GetBudgetModelKey(relayInfo.OriginModelName); key != "" {
+		limits[key] = token.GetBudgetModelLimits()[key]
+	}
+	keys := make([]string, 0, len(limits))
+	for key := range limits {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	_, end := model.GetTokenBudgetWindow(token.BudgetPeriod, time.Unix(relayInfo.TokenBudgetWindow, 0))
+	for _, key := range keys {
+		limit := limits[key]
+		if limit <= 0 {
+			continue
+		}
+		after := usages[key]
+		before := after - consumeQuota
+		crossed := 0
+		for _, threshold := range thresholds {
+			if before*100 < threshold*limit && after*100 >= threshold*limit && threshold > crossed {
+				crossed = threshold
+			}
+		}
+		if crossed == 0 {
+			continue
+		}
+		name := "总预算"
+		if key != "" {
+			name = fmt.Sprintf("模型 %s 预算", key)
+		}
+		prompt := "您的令牌预算即将用尽"
+		content := "{{value}}，令牌 {{value}} 的{{value}}已使用 {{value}}%（{{value}} / {{value}}），将于 {{value}} 重置。"
+		err = NotifyUser(relayInfo.UserId, relayInfo.UserEmail, relayInfo.UserSetting, dto.NewNotify(dto.NotifyTypeTokenBudget, prompt, content,
+			[]interface{}{prompt, token.Name, name, crossed, common.FormatQuota(after), common.FormatQuota(limit), end.Format("2006-01-02 15:04:05 MST")}))
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to send token budget notify to user %d: %s", relayInfo.UserId, err.Error()))
+		}
+	}
+}
+
+// StartTokenBudgetCleanupTask 定期删除超过保留天数的预算用量记录
+func StartTokenBudgetCleanupTask(frequency int) {
+	for {
+		retentionDays := operation_setting.GetTokenBudgetSetting().RetentionDays
+		if retentionDays > 0 {
+			before := time.Now().AddDate(0, 0, -retentionDays).Unix()
+			count, err := model.DeleteExpiredTokenBudgetUsages(before)
+			if err != nil {
+				common.SysError("failed to delete expired token budget usages: " + err.Error())
+			} else if count > 0 {
+				common.SysLog(fmt.Sprintf("deleted %d expired token budget usages", count))
+			}
+		}
+		time.Sleep(time.Duration(frequency) * time.Second)
+	}
+}
@@ -0,0 +1,30 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"one-api/common"
+	"one-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ErrTokenMaxPriceExceeded = errors.New("token max price exceeded")
+
+// CheckTokenMaxPrice 检查本次请求的预估费用是否超过令牌单次请求的最高费用，在预扣费之前调用
+func CheckTokenMaxPrice(c *gin.Context, quota int) error {
+	value, ok := c.Get("token_scopes")
+	if !ok {
+		return nil
+	}
+	scopes := value.(*model.TokenScopes)
+	if scopes.MaxPrice <= 0 {
+		return nil
+	}
+	maxQuota := int(scopes.MaxPrice * common.QuotaPerUnit)
+	if quota > maxQuota {
+		return fmt.Errorf("%w: 本次请求预估费用 %s 超过令牌单次请求最高费用 %s", ErrTokenMaxPriceExceeded,
+			common.FormatQuota(quota), common.FormatQuota(maxQuota))
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"one-api/common"
+	"one-api/common/limiter"
+	relaycommon "one-api/relay/common"
+	"one-api/setting"
+	"strconv"
+	"time"
+
+	"github.com/bytedance/gopkg/util/gopool"
+	"github.com/gin-gonic/gin"
+)
+
+var ErrTPMLimitExceeded = errors.New("tpm limit exceeded")
+
+var memoryTPMLimiter = limiter.NewMemoryTokenBucket()
+
+func getTPMLimiter() limiter.TokenBucketLimiter {
+	if common.RedisEnabled {
+		return limiter.New(context.Background(), common.RDB)
+	}
+	return memoryTPMLimiter
+}
+
+// tpmBucket 每分钟 limit 个 token 的令牌桶，容量为一分钟的额度
+func tpmBucket(key string, limit int) limiter.Bucket {
+	return limiter.Bucket{
+		Key:      "tpmLimit:" + key,
+		Capacity: int64(limit),
+		Rate:     float64(limit) / 60,
+	}
+}
+
+// getTPMBuckets 返回本次请求需要扣减的令牌桶：用户（按分组）、用户在该模型上、令牌自身
+func getTPMBuckets(relayInfo *relaycommon.RelayInfo) []limiter.Bucket {
+	buckets := make([]limiter.Bucket, 0, 3)
+	if setting.ModelTPMLimitEnabled {
+		if limit := setting.GetUserTPMLimit(relayInfo.Group); limit > 0 {
+			buckets = append(buckets, tpmBucket(fmt.Sprintf("user:%d", relayInfo.UserId), limit))
+		}
+		if limit := setting.GetModelTPMLimit(relayInfo.OriginModelName); limit > 0 {
+			buckets = append(buckets, tpmBucket(fmt.Sprintf("model:%d:%s", relayInfo.UserId, relayInfo.OriginModelName), limit))
+		}
+	}
+	if relayInfo.TokenTPMLimit > 0 {
+		buckets = append(buckets, tpmBucket(fmt.Sprintf("token:%d", relayInfo.TokenId), relayInfo.TokenTPMLimit))
+	}
+	return buckets
+}
+
+// tightestTPMState 返回剩余最少的桶，响应头按最严格的限制返回
+func tightestTPMState(states []limiter.BucketState) limiter.BucketState {
+	tightest := states[0]
+	for _, state := range states[1:] {
+		if state.Remaining < tightest.Remaining {
+			tightest = state
+		}
+	}
+	return tightest
+}
+
+func setTPMHeaders(c *gin.Context, state limiter.BucketState) {
+	remaining := state.Remaining
+	if remaining < 0 {
+		remaining = 0
+	}
+	c.Header("x-ratelimit-limit-tokens", strconv.FormatInt(state.Capacity, 10))
+	c.Header("x-ratelimit-remaining-tokens", strconv.FormatInt(remaining, 10))
+	c.Header("x-ratelimit-reset-tokens", state.Reset.Round(time.Millisecond).String())
+}
+
+// PreConsumeTPM 按提示 token 数扣减 TPM 令牌桶，任意一个桶余量不足时拒绝请求。
+// 限流服务异常时放行请求，只记录错误
+func PreConsumeTPM(c *gin.Context, relayInfo *relaycommon.RelayInfo) error {
+	buckets := getTPMBuckets(relayInfo)
+	if len(buckets) == 0 {
+		return nil
+	}
+	requested := int64(relayInfo.PromptTokens)
+	allowed, states, err := getTPMLimiter().Consume(c.Request.Context(), buckets, requested, false)
+	if err != nil {
+		common.LogError(c, "tpm limit failed: "+err.Error())
+		return nil
+	}
+	if allowed {
+		setTPMHeaders(c, tightestTPMState(states))
+		relayInfo.TPMBuckets = buckets
+		relayInfo.TPMCharged = requested
+		return nil
+	}
+	for _, bucket := range buckets {
+		if requested > bucket.Capacity {
+			return fmt.Errorf("%w: 本次请求的提示 token 数 %d 超过每分钟 token 数限制 %d", ErrTPMLimitExceeded, requested, bucket.Capacity)
+		}
+	}
+	// 按需要等待最久的桶返回限制信息
+	var state limiter.BucketState
+	var wait time.Duration
+	for _, s := range states {
+		if s.Remaining >= requested {
+			continue
+		}
+		w := time.Duration(float64(requested-s.Remaining) / (float64(s.Capacity) / 60) * float64(time.Second))
+		if w >= wait {
+			state, wait = s, w
+		}
+	}
+	setTPMHeaders(c, state)
+	c.Header("retry-after", strconv.Itoa(int(wait.Seconds())+1))
+	return fmt.Errorf("%w: 已达到每分钟 token 数限制 %d，剩余 %d，请 %s 后重试", ErrTPMLimitExceeded,
+		state.Capacity, max(state.Remaining, 0), wait.Round(time.Second).String())
+}
+
+// PostConsumeTPM 按实际用量结算 TPM，补扣完成 token 与提示 token 的差额，余量可以扣为负数。
+// 结算后已扣减数清零，实时会话多次结算时每次按该次用量扣减
+func PostConsumeTPM(relayInfo *relaycommon.RelayInfo, promptTokens int, completionTokens int) {
+	if len(relayInfo.TPMBuckets) == 0 {
+		return
+	}
+	delta := int64(promptTokens+completionTokens) - relayInfo.TPMCharged
+	relayInfo.TPMCharged = 0
+	consumeTPMAsync(relayInfo.TPMBuckets, delta)
+}
+
+// ReturnTPM 请求失败时退还预扣的提示 token
+func ReturnTPM(relayInfo *relaycommon.RelayInfo) {
+	if len(relayInfo.TPMBuckets) == 0 {
+		return
+	}
+	charged := relayInfo.TPMCharged
+	relayInfo.TPMCharged = 0
+	consumeTPMAsync(relayInfo.TPMBuckets, -charged)
+}
+
+func consumeTPMAsync(buckets []limiter.Bucket, delta int64) {
+	if delta == 0 {
+		return
+	}
+	gopool.Go(func() {
+		_, _, err := getTPMLimiter().Consume(context.Background(), buckets, delta, true)
+		if err != nil {
+			common.SysError("failed to settle tpm limit: " + err.Error())
+		}
+	})
+}
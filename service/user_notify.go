@@ -57,9 +57,10 @@ func NotifyUser(userId int, userEmail string, userSetting map[string]interface{}
 		}
 
 		// 获取 webhook secret
-		var webhookSecret string
-		if secret, ok := userSetting[constant.UserSettingWebhookSecret]; ok {
-			webhookSecret, _ = secret.(string)
+		webhookSecret, err := model.GetUserWebhookSecret(userSetting)
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to decrypt webhook secret of user %d: %s", userId, err.Error()))
+			return nil
 		}
 
 		return SendWebhookNotify(webhookURLStr, webhookSecret, data)
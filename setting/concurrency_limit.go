@@ -0,0 +1,92 @@
+package setting
+
+import (
+	"encoding/json"
+	"fmt"
+	"one-api/common"
+	"sync"
+)
+
+// 同时处理中的请求数限制，流式请求与实时会话在连接关闭前一直占用名额，0 表示不限制
+var ModelConcurrencyLimitEnabled = false
+
+// ModelConcurrencyLimitUser 每个用户同时处理中的请求数
+var ModelConcurrencyLimitUser = 0
+
+// ModelConcurrencyLimitGroup 按分组覆盖每个用户的并发数
+var ModelConcurrencyLimitGroup = map[string]int{}
+
+// ModelConcurrencyLimitGroupTotal 每个分组内所有用户合计同时处理中的请求数，未配置的分组不限制
+var ModelConcurrencyLimitGroupTotal = map[string]int{}
+var ModelConcurrencyLimitMutex sync.RWMutex
+
+func ModelConcurrencyLimitGroup2JSONString() string {
+	ModelConcurrencyLimitMutex.RLock()
+	defer ModelConcurrencyLimitMutex.RUnlock()
+
+	jsonBytes, err := json.Marshal(ModelConcurrencyLimitGroup)
+	if err != nil {
+		common.SysError("error marshalling concurrency limit group: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateModelConcurrencyLimitGroupByJSONString(jsonStr string) error {
+	ModelConcurrencyLimitMutex.Lock()
+	defer ModelConcurrencyLimitMutex.Unlock()
+
+	ModelConcurrencyLimitGroup = make(map[string]int)
+	return json.Unmarshal([]byte(jsonStr), &ModelConcurrencyLimitGroup)
+}
+
+func ModelConcurrencyLimitGroupTotal2JSONString() string {
+	ModelConcurrencyLimitMutex.RLock()
+	defer ModelConcurrencyLimitMutex.RUnlock()
+
+	jsonBytes, err := json.Marshal(ModelConcurrencyLimitGroupTotal)
+	if err != nil {
+		common.SysError("error marshalling concurrency limit group total: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateModelConcurrencyLimitGroupTotalByJSONString(jsonStr string) error {
+	ModelConcurrencyLimitMutex.Lock()
+	defer ModelConcurrencyLimitMutex.Unlock()
+
+	ModelConcurrencyLimitGroupTotal = make(map[string]int)
+	return json.Unmarshal([]byte(jsonStr), &ModelConcurrencyLimitGroupTotal)
+}
+
+// GetGroupConcurrencyLimit 返回分组内所有用户合计的并发数，0 表示不限制
+func GetGroupConcurrencyLimit(group string) int {
+	ModelConcurrencyLimitMutex.RLock()
+	defer ModelConcurrencyLimitMutex.RUnlock()
+
+	return ModelConcurrencyLimitGroupTotal[group]
+}
+
+// GetUserConcurrencyLimit 返回分组内每个用户的并发数，分组未单独配置时使用默认值
+func GetUserConcurrencyLimit(group string) int {
+	ModelConcurrencyLimitMutex.RLock()
+	defer ModelConcurrencyLimitMutex.RUnlock()
+
+	if limit, ok := ModelConcurrencyLimitGroup[group]; ok {
+		return limit
+	}
+	return ModelConcurrencyLimitUser
+}
+
+func CheckModelConcurrencyLimitGroup(jsonStr string) error {
+	limits := make(map[string]int)
+	err := json.Unmarshal([]byte(jsonStr), &limits)
+	if err != nil {
+		return err
+	}
+	for group, limit := range limits {
+		if limit < 0 {
+			return fmt.Errorf("group %s has negative concurrency limit: %d", group, limit)
+		}
+	}
+	return nil
+}
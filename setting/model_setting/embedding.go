@@ -0,0 +1,41 @@
+package model_setting
+
+import (
+	"one-api/setting/config"
+)
+
+// EmbeddingSettings 定义 Embedding 请求分批与分发的配置
+type EmbeddingSettings struct {
+	// BatchEnabled 是否按渠道最大批量拆分 input 数组
+	BatchEnabled bool `json:"batch_enabled"`
+	// DefaultBatchSize 渠道未配置 embedding_batch_size 时使用的批量大小，0 表示不限制
+	DefaultBatchSize int `json:"default_batch_size"`
+	// MaxConcurrency 单个请求同时发往上游的最大批次数
+	MaxConcurrency int `json:"max_concurrency"`
+	// FanOutEnabled 是否将批次分发到同模型同优先级的多个渠道
+	FanOutEnabled bool `json:"fan_out_enabled"`
+	// ChunkRetryTimes 单个批次失败后在其他渠道上的重试次数
+	ChunkRetryTimes int `json:"chunk_retry_times"`
+}
+
+// 默认配置
+var defaultEmbeddingSettings = EmbeddingSettings{
+	BatchEnabled:     false,
+	DefaultBatchSize: 0,
+	MaxConcurrency:   4,
+	FanOutEnabled:    false,
+	ChunkRetryTimes:  2,
+}
+
+// 全局实例
+var embeddingSettings = defaultEmbeddingSettings
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("embedding", &embeddingSettings)
+}
+
+// GetEmbeddingSettings 获取 Embedding 配置
+func GetEmbeddingSettings() *EmbeddingSettings {
+	return &embeddingSettings
+}
@@ -0,0 +1,46 @@
+package model_setting
+
+import (
+	"one-api/setting/config"
+)
+
+// RerankSettings 定义 Rerank 模型的配置
+type RerankSettings struct {
+	// EmulationEnabled 无可用 rerank 渠道时是否使用 embedding 模型计算余弦相似度模拟 rerank
+	EmulationEnabled bool `json:"emulation_enabled"`
+	// EmulationModelMapping rerank 模型到 embedding 模型的映射，default 为未单独配置时使用的模型
+	EmulationModelMapping map[string]string `json:"emulation_model_mapping"`
+}
+
+// 默认配置
+var defaultRerankSettings = RerankSettings{
+	EmulationEnabled:      false,
+	EmulationModelMapping: map[string]string{},
+}
+
+// 全局实例
+var rerankSettings = defaultRerankSettings
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("rerank", &rerankSettings)
+}
+
+// GetRerankSettings 获取Rerank配置
+func GetRerankSettings() *RerankSettings {
+	return &rerankSettings
+}
+
+// GetRerankEmulationModel 获取用于模拟指定 rerank 模型的 embedding 模型
+func GetRerankEmulationModel(model string) (string, bool) {
+	if !rerankSettings.EmulationEnabled {
+		return "", false
+	}
+	if embeddingModel, ok := rerankSettings.EmulationModelMapping[model]; ok && embeddingModel != "" {
+		return embeddingModel, true
+	}
+	if embeddingModel, ok := rerankSettings.EmulationModelMapping["default"]; ok && embeddingModel != "" {
+		return embeddingModel, true
+	}
+	return "", false
+}
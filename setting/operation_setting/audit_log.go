@@ -0,0 +1,23 @@
+package operation_setting
+
+import "one-api/setting/config"
+
+// AuditLogSetting 管理操作审计日志配置
+type AuditLogSetting struct {
+	// RetentionDays 审计日志的保留天数，0 表示永久保留
+	RetentionDays int `json:"retention_days"`
+}
+
+// 默认配置
+var auditLogSetting = AuditLogSetting{
+	RetentionDays: 180,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("audit_log", &auditLogSetting)
+}
+
+func GetAuditLogSetting() *AuditLogSetting {
+	return &auditLogSetting
+}
@@ -0,0 +1,26 @@
+package operation_setting
+
+import "one-api/setting/config"
+
+// BillingStatementSetting 月度账单配置
+type BillingStatementSetting struct {
+	// Enabled 是否在每月结束后自动生成上一个月的账单
+	Enabled bool `json:"enabled"`
+	// Timezone 计算账单月份边界使用的 IANA 时区，为空时使用服务器本地时区
+	Timezone string `json:"timezone"`
+}
+
+// 默认配置
+var billingStatementSetting = BillingStatementSetting{
+	Enabled:  true,
+	Timezone: "",
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("billing_statement", &billingStatementSetting)
+}
+
+func GetBillingStatementSetting() *BillingStatementSetting {
+	return &billingStatementSetting
+}
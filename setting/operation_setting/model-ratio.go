@@ -288,6 +288,11 @@ func InitRatioSettings() {
 	imageRatioMap = defaultImageRatio
 	imageRatioMapMutex.Unlock()
 
+	// initialize modelTieredRatioMap
+	modelTieredRatioMapMutex.Lock()
+	modelTieredRatioMap = defaultModelTieredRatio
+	modelTieredRatioMapMutex.Unlock()
+
 }
 
 func GetModelPriceMap() map[string]float64 {
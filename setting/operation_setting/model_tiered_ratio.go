@@ -0,0 +1,98 @@
+package operation_setting
+
+import (
+	"encoding/json"
+	"fmt"
+	"one-api/common"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ModelRatioTier 按提示 token 数划分的阶梯倍率，未设置的补全、缓存倍率沿用模型的固定配置
+type ModelRatioTier struct {
+	// MaxPromptTokens 本阶梯适用的最大提示 token 数（含），0 表示不设上限
+	MaxPromptTokens    int      `json:"max_prompt_tokens"`
+	ModelRatio         float64  `json:"model_ratio"`
+	CompletionRatio    *float64 `json:"completion_ratio,omitempty"`
+	CacheRatio         *float64 `json:"cache_ratio,omitempty"`
+	CacheCreationRatio *float64 `json:"cache_creation_ratio,omitempty"`
+}
+
+var defaultModelTieredRatio = map[string][]ModelRatioTier{}
+
+var modelTieredRatioMap map[string][]ModelRatioTier
+var modelTieredRatioMapMutex sync.RWMutex
+
+func ModelTieredRatio2JSONString() string {
+	modelTieredRatioMapMutex.RLock()
+	defer modelTieredRatioMapMutex.RUnlock()
+	jsonBytes, err := json.Marshal(modelTieredRatioMap)
+	if err != nil {
+		common.SysError("error marshalling model tiered ratio: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+// UpdateModelTieredRatioByJSONString 解析并校验阶梯倍率，阶梯按上限升序排列，不设上限的阶梯只能有一个且位于最后
+func UpdateModelTieredRatioByJSONString(jsonStr string) error {
+	newMap := make(map[string][]ModelRatioTier)
+	if err := json.Unmarshal([]byte(jsonStr), &newMap); err != nil {
+		return err
+	}
+	for name, tiers := range newMap {
+		sort.SliceStable(tiers, func(i, j int) bool {
+			if tiers[i].MaxPromptTokens == 0 {
+				return false
+			}
+			if tiers[j].MaxPromptTokens == 0 {
+				return true
+			}
+			return tiers[i].MaxPromptTokens < tiers[j].MaxPromptTokens
+		})
+		for i, tier := range tiers {
+			if tier.MaxPromptTokens < 0 || tier.ModelRatio < 0 {
+				return fmt.Errorf("model %s tier %d has negative value", name, i)
+			}
+			if i > 0 && tier.MaxPromptTokens != 0 && tier.MaxPromptTokens == tiers[i-1].MaxPromptTokens {
+				return fmt.Errorf("model %s has duplicate tier max_prompt_tokens %d", name, tier.MaxPromptTokens)
+			}
+			if tier.MaxPromptTokens == 0 && i != len(tiers)-1 {
+				return fmt.Errorf("model %s has more than one unbounded tier", name)
+			}
+		}
+		newMap[name] = tiers
+	}
+	modelTieredRatioMapMutex.Lock()
+	defer modelTieredRatioMapMutex.Unlock()
+	modelTieredRatioMap = newMap
+	return nil
+}
+
+// GetModelTieredRatios 返回模型的阶梯倍率配置
+func GetModelTieredRatios(name string) ([]ModelRatioTier, bool) {
+	modelTieredRatioMapMutex.RLock()
+	defer modelTieredRatioMapMutex.RUnlock()
+	if strings.HasPrefix(name, "gpt-4-gizmo") {
+		name = "gpt-4-gizmo-*"
+	}
+	tiers, ok := modelTieredRatioMap[name]
+	if !ok || len(tiers) == 0 {
+		return nil, false
+	}
+	return tiers, true
+}
+
+// GetModelRatioTier 按提示 token 数选择阶梯，超过所有上限时使用最后一个阶梯
+func GetModelRatioTier(name string, promptTokens int) (ModelRatioTier, int, bool) {
+	tiers, ok := GetModelTieredRatios(name)
+	if !ok {
+		return ModelRatioTier{}, -1, false
+	}
+	for i, tier := range tiers {
+		if tier.MaxPromptTokens == 0 || promptTokens <= tier.MaxPromptTokens {
+			return tier, i, true
+		}
+	}
+	return tiers[len(tiers)-1], len(tiers) - 1, true
+}
@@ -0,0 +1,120 @@
+package operation_setting
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"one-api/setting/config"
+	"regexp"
+	"strconv"
+)
+
+// 检测结果的校验方式
+const (
+	PIIValidatorNone = ""
+	// PIIValidatorLuhn 银行卡号 Luhn 校验
+	PIIValidatorLuhn = "luhn"
+	// PIIValidatorCNID 中国居民身份证号 ISO 7064 MOD 11-2 校验
+	PIIValidatorCNID = "cn_id"
+)
+
+// PIIDetector 个人信息检测规则，正则匹配后再经过校验才会被替换
+type PIIDetector struct {
+	// Name 检测规则名称，只能包含小写字母、数字与下划线，占位符为 [NAME_xxxxxxxx]
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	Pattern string `json:"pattern"`
+	// Validator 校验方式：luhn、cn_id，为空表示不校验
+	Validator string `json:"validator"`
+}
+
+// PIIRedactionSetting 请求发往上游前替换个人信息，并在响应中还原
+type PIIRedactionSetting struct {
+	Enabled   bool          `json:"enabled"`
+	Detectors []PIIDetector `json:"detectors"`
+	// GroupPolicies 分组使用的检测规则名称
+	GroupPolicies map[string][]string `json:"group_policies"`
+	// ChannelPolicies 渠道使用的检测规则名称，键为渠道 id，优先于分组策略，空列表表示该渠道不脱敏
+	ChannelPolicies map[string][]string `json:"channel_policies"`
+}
+
+// 默认配置
+var piiRedactionSetting = PIIRedactionSetting{
+	Enabled: false,
+	Detectors: []PIIDetector{
+		{Name: "email", Enabled: true, Pattern: `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`},
+		{Name: "phone", Enabled: true, Pattern: `(?:\+86[- ]?)?1[3-9]\d{9}`},
+		{Name: "id_number", Enabled: true, Pattern: `[1-9]\d{5}(?:18|19|20)\d{2}(?:0[1-9]|1[0-2])(?:0[1-9]|[12]\d|3[01])\d{3}[\dXx]`, Validator: PIIValidatorCNID},
+		{Name: "card", Enabled: true, Pattern: `\d(?:[ -]?\d){12,18}`, Validator: PIIValidatorLuhn},
+	},
+	GroupPolicies:   map[string][]string{},
+	ChannelPolicies: map[string][]string{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("pii_redaction", &piiRedactionSetting)
+}
+
+func GetPIIRedactionSetting() *PIIRedactionSetting {
+	return &piiRedactionSetting
+}
+
+var piiDetectorNamePattern = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// CheckPIIDetectors 校验检测规则配置
+func CheckPIIDetectors(value string) error {
+	var detectors []PIIDetector
+	if err := json.Unmarshal([]byte(value), &detectors); err != nil {
+		return err
+	}
+	names := make(map[string]bool)
+	for _, detector := range detectors {
+		if !piiDetectorNamePattern.MatchString(detector.Name) {
+			return fmt.Errorf("检测规则名称 %q 只能包含小写字母、数字与下划线", detector.Name)
+		}
+		if names[detector.Name] {
+			return fmt.Errorf("检测规则名称 %s 重复", detector.Name)
+		}
+		names[detector.Name] = true
+		if detector.Pattern == "" {
+			return fmt.Errorf("检测规则 %s 的正则表达式不能为空", detector.Name)
+		}
+		if _, err := regexp.Compile(detector.Pattern); err != nil {
+			return fmt.Errorf("检测规则 %s 的正则表达式无效：%s", detector.Name, err.Error())
+		}
+		switch detector.Validator {
+		case PIIValidatorNone, PIIValidatorLuhn, PIIValidatorCNID:
+		default:
+			return errors.New("不支持的校验方式：" + detector.Validator)
+		}
+	}
+	return nil
+}
+
+// GetPolicyDetectors 返回渠道与分组适用的检测规则，渠道策略优先，未配置策略时返回 nil
+func (s *PIIRedactionSetting) GetPolicyDetectors(channelId int, group string) []PIIDetector {
+	if !s.Enabled {
+		return nil
+	}
+	names, ok := s.ChannelPolicies[strconv.Itoa(channelId)]
+	if !ok {
+		names, ok = s.GroupPolicies[group]
+	}
+	if !ok || len(names) == 0 {
+		return nil
+	}
+	detectors := make([]PIIDetector, 0, len(names))
+	for _, detector := range s.Detectors {
+		if !detector.Enabled {
+			continue
+		}
+		for _, name := range names {
+			if name == detector.Name {
+				detectors = append(detectors, detector)
+				break
+			}
+		}
+	}
+	return detectors
+}
@@ -0,0 +1,166 @@
+package operation_setting
+
+import (
+	"one-api/common"
+	"one-api/setting/config"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PricingSchedule 定价时段：在指定的日期范围与每日时间窗口内，对匹配的模型和分组按倍率计费
+type PricingSchedule struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	// Timezone IANA 时区名，为空时使用服务器本地时区
+	Timezone string `json:"timezone"`
+	// StartDate、EndDate 生效日期范围（含），格式 2006-01-02，为空表示不限
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	// Weekdays 生效的星期，0 为星期日，为空表示每天
+	Weekdays []int `json:"weekdays"`
+	// StartTime、EndTime 每日时间窗口，格式 15:04，结束时间早于开始时间表示跨越午夜，均为空表示全天
+	StartTime string `json:"start_time"`
+	EndTime   string `json:"end_time"`
+	// Models 适用的模型，支持以 * 结尾的前缀匹配，为空表示全部模型
+	Models []string `json:"models"`
+	// Groups 适用的分组，为空表示全部分组
+	Groups     []string `json:"groups"`
+	Multiplier float64  `json:"multiplier"`
+}
+
+// PricingScheduleSetting 定价时段配置，多个时段同时生效时倍率相乘
+type PricingScheduleSetting struct {
+	Enabled   bool              `json:"enabled"`
+	Schedules []PricingSchedule `json:"schedules"`
+}
+
+// 默认配置
+var pricingScheduleSetting = PricingScheduleSetting{
+	Enabled:   false,
+	Schedules: []PricingSchedule{},
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("pricing_schedule", &pricingScheduleSetting)
+}
+
+func GetPricingScheduleSetting() *PricingScheduleSetting {
+	return &pricingScheduleSetting
+}
+
+func matchPricingScheduleName(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if pattern == name {
+			return true
+		}
+	}
+	return false
+}
+
+// pricingScheduleLocations 缓存已加载的时区，避免每次计费都解析时区数据库，加载失败时缓存 nil
+var pricingScheduleLocations sync.Map
+
+func loadPricingScheduleLocation(name string) *time.Location {
+	if value, ok := pricingScheduleLocations.Load(name); ok {
+		return value.(*time.Location)
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		common.SysError("invalid pricing schedule timezone: " + name)
+		location = nil
+	}
+	pricingScheduleLocations.Store(name, location)
+	return location
+}
+
+// IsActive 判断时段在给定时间是否生效
+func (s *PricingSchedule) IsActive(now time.Time) bool {
+	if !s.Enabled || s.Multiplier < 0 {
+		return false
+	}
+	location := time.Local
+	if s.Timezone != "" {
+		location = loadPricingScheduleLocation(s.Timezone)
+		if location == nil {
+			return false
+		}
+	}
+	now = now.In(location)
+	date := now.Format("2006-01-02")
+	if s.StartDate != "" && date < s.StartDate {
+		return false
+	}
+	if s.EndDate != "" && date > s.EndDate {
+		return false
+	}
+	if len(s.Weekdays) > 0 {
+		matched := false
+		for _, weekday := range s.Weekdays {
+			if time.Weekday(weekday) == now.Weekday() {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if s.StartTime == "" && s.EndTime == "" {
+		return true
+	}
+	clock := now.Format("15:04")
+	start, end := s.StartTime, s.EndTime
+	if start == "" {
+		start = "00:00"
+	}
+	if end == "" {
+		end = "24:00"
+	}
+	if start <= end {
+		return clock >= start && clock < end
+	}
+	return clock >= start || clock < end
+}
+
+// GetActivePricingSchedules 返回在给定时间对模型和分组生效的时段及其倍率之积
+func GetActivePricingSchedules(modelName string, group string, now time.Time) ([]PricingSchedule, float64) {
+	multiplier := 1.0
+	if !pricingScheduleSetting.Enabled {
+		return nil, multiplier
+	}
+	var active []PricingSchedule
+	for _, schedule := range pricingScheduleSetting.Schedules {
+		if !matchPricingScheduleName(schedule.Models, modelName) || !matchPricingScheduleName(schedule.Groups, group) {
+			continue
+		}
+		if !schedule.IsActive(now) {
+			continue
+		}
+		active = append(active, schedule)
+		multiplier *= schedule.Multiplier
+	}
+	return active, multiplier
+}
+
+// GetCurrentPricingSchedules 返回在给定时间生效的所有时段，用于在定价接口中展示
+func GetCurrentPricingSchedules(now time.Time) []PricingSchedule {
+	active := make([]PricingSchedule, 0)
+	if !pricingScheduleSetting.Enabled {
+		return active
+	}
+	for _, schedule := range pricingScheduleSetting.Schedules {
+		if schedule.IsActive(now) {
+			active = append(active, schedule)
+		}
+	}
+	return active
+}
@@ -0,0 +1,36 @@
+package operation_setting
+
+import "one-api/setting/config"
+
+// ResponseCacheSetting 精确匹配响应缓存配置
+type ResponseCacheSetting struct {
+	Enabled bool `json:"enabled"`
+	// Storage 缓存存储方式：auto（有 Redis 时使用 Redis）、redis、memory
+	Storage string `json:"storage"`
+	// CacheRatio 命中缓存时按原始费用的该倍率计费
+	CacheRatio float64 `json:"cache_ratio"`
+	TTLSeconds int     `json:"ttl_seconds"`
+	// MaxEntries 内存 LRU 缓存的最大条目数
+	MaxEntries int `json:"max_entries"`
+	// MaxEntryBytes 单条响应的最大缓存字节数，超过则不缓存
+	MaxEntryBytes int `json:"max_entry_bytes"`
+}
+
+// 默认配置
+var responseCacheSetting = ResponseCacheSetting{
+	Enabled:       false,
+	Storage:       "auto",
+	CacheRatio:    0.1,
+	TTLSeconds:    3600,
+	MaxEntries:    1000,
+	MaxEntryBytes: 1 << 20,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("response_cache", &responseCacheSetting)
+}
+
+func GetResponseCacheSetting() *ResponseCacheSetting {
+	return &responseCacheSetting
+}
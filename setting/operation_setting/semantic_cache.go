@@ -0,0 +1,42 @@
+package operation_setting
+
+import "one-api/setting/config"
+
+// SemanticCacheSetting 对话补全语义缓存配置
+type SemanticCacheSetting struct {
+	Enabled bool `json:"enabled"`
+	// EmbeddingChannelId 用于计算问题向量的渠道
+	EmbeddingChannelId int `json:"embedding_channel_id"`
+	// EmbeddingModel 用于计算问题向量的模型
+	EmbeddingModel string `json:"embedding_model"`
+	// SimilarityThreshold 余弦相似度不低于该值时视为命中
+	SimilarityThreshold float64 `json:"similarity_threshold"`
+	// Scope 缓存隔离范围：token（按令牌）或 user（按用户）
+	Scope string `json:"scope"`
+	// CacheRatio 命中缓存时按原始费用的该倍率计费
+	CacheRatio float64 `json:"cache_ratio"`
+	TTLSeconds int     `json:"ttl_seconds"`
+	// MaxEntries 每个范围内保留的最大条目数
+	MaxEntries int `json:"max_entries"`
+}
+
+// 默认配置
+var semanticCacheSetting = SemanticCacheSetting{
+	Enabled:             false,
+	EmbeddingChannelId:  0,
+	EmbeddingModel:      "text-embedding-3-small",
+	SimilarityThreshold: 0.95,
+	Scope:               "user",
+	CacheRatio:          0.1,
+	TTLSeconds:          86400,
+	MaxEntries:          1000,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("semantic_cache", &semanticCacheSetting)
+}
+
+func GetSemanticCacheSetting() *SemanticCacheSetting {
+	return &semanticCacheSetting
+}
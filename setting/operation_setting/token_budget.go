@@ -0,0 +1,29 @@
+package operation_setting
+
+import "one-api/setting/config"
+
+// TokenBudgetSetting 令牌周期预算配置
+type TokenBudgetSetting struct {
+	// Timezone 计算预算周期边界使用的 IANA 时区，为空时使用服务器本地时区
+	Timezone string `json:"timezone"`
+	// WarningThresholds 预算使用比例达到这些百分比时通知用户
+	WarningThresholds []int `json:"warning_thresholds"`
+	// RetentionDays 预算用量记录的保留天数
+	RetentionDays int `json:"retention_days"`
+}
+
+// 默认配置
+var tokenBudgetSetting = TokenBudgetSetting{
+	Timezone:          "",
+	WarningThresholds: []int{80, 100},
+	RetentionDays:     62,
+}
+
+func init() {
+	// 注册到全局配置管理器
+	config.GlobalConfig.Register("token_budget", &tokenBudgetSetting)
+}
+
+func GetTokenBudgetSetting() *TokenBudgetSetting {
+	return &tokenBudgetSetting
+}
@@ -6,3 +6,10 @@ var EpayId = ""
 var EpayKey = ""
 var Price = 7.3
 var MinTopUp = 1
+
+// Stripe Checkout 兼容支付，StripeUnitPrice 为充值 1 美元额度需要支付的金额（以 StripeCurrency 计价）
+var StripeApiBase = "https://api.stripe.com"
+var StripeApiSecret = ""
+var StripeWebhookSecret = ""
+var StripeCurrency = "usd"
+var StripeUnitPrice = 1.0
@@ -1,11 +1,18 @@
 package setting
 
-import "strings"
+import (
+	"one-api/common"
+	"strings"
+	"sync/atomic"
+
+	goahocorasick "github.com/anknown/ahocorasick"
+)
 
 var CheckSensitiveEnabled = true
 var CheckSensitiveOnPromptEnabled = true
 
-//var CheckSensitiveOnCompletionEnabled = true
+// CheckSensitiveOnCompletionEnabled 是否检查模型输出，流式输出按字段保留末尾部分文本，避免敏感词被拆分到多个事件中而漏检
+var CheckSensitiveOnCompletionEnabled = false
 
 // StopOnSensitiveEnabled 如果检测到敏感词，是否立刻停止生成，否则替换敏感词
 var StopOnSensitiveEnabled = true
@@ -19,6 +26,47 @@ var SensitiveWords = []string{
 	"test_sensitive",
 }
 
+// SensitiveMatcher 由敏感词列表构建的匹配器
+type SensitiveMatcher struct {
+	Machine *goahocorasick.Machine
+	// Longest 最长敏感词的字符数
+	Longest int
+}
+
+// sensitiveMatcher 敏感词更新时重新构建，读取时无需加锁，敏感词为空时为 nil
+var sensitiveMatcher atomic.Pointer[SensitiveMatcher]
+
+func init() {
+	buildSensitiveMatcher()
+}
+
+// GetSensitiveMatcher 返回当前敏感词列表的匹配器，敏感词为空时返回 nil
+func GetSensitiveMatcher() *SensitiveMatcher {
+	return sensitiveMatcher.Load()
+}
+
+// buildSensitiveMatcher 按小写构建匹配器，匹配时同样转换为小写
+func buildSensitiveMatcher() {
+	if len(SensitiveWords) == 0 {
+		sensitiveMatcher.Store(nil)
+		return
+	}
+	dict := make([][]rune, 0, len(SensitiveWords))
+	longest := 0
+	for _, word := range SensitiveWords {
+		runes := []rune(strings.ToLower(strings.TrimSpace(word)))
+		dict = append(dict, runes)
+		longest = max(longest, len(runes))
+	}
+	machine := new(goahocorasick.Machine)
+	if err := machine.Build(dict); err != nil {
+		common.SysError("error building sensitive words matcher: " + err.Error())
+		sensitiveMatcher.Store(nil)
+		return
+	}
+	sensitiveMatcher.Store(&SensitiveMatcher{Machine: machine, Longest: longest})
+}
+
 func SensitiveWordsToString() string {
 	return strings.Join(SensitiveWords, "\n")
 }
@@ -32,12 +80,13 @@ func SensitiveWordsFromString(s string) {
 			SensitiveWords = append(SensitiveWords, w)
 		}
 	}
+	buildSensitiveMatcher()
 }
 
 func ShouldCheckPromptSensitive() bool {
 	return CheckSensitiveEnabled && CheckSensitiveOnPromptEnabled
 }
 
-//func ShouldCheckCompletionSensitive() bool {
-//	return CheckSensitiveEnabled && CheckSensitiveOnCompletionEnabled
-//}
+func ShouldCheckCompletionSensitive() bool {
+	return CheckSensitiveEnabled && CheckSensitiveOnCompletionEnabled
+}
@@ -0,0 +1,92 @@
+package setting
+
+import (
+	"encoding/json"
+	"fmt"
+	"one-api/common"
+	"sync"
+)
+
+// 每分钟 token 数 (TPM) 限制，与请求数限流同时生效，0 表示不限制
+var ModelTPMLimitEnabled = false
+
+// ModelTPMLimitUser 每个用户每分钟可使用的 token 数
+var ModelTPMLimitUser = 0
+
+// ModelTPMLimitGroup 按分组覆盖每个用户的 TPM
+var ModelTPMLimitGroup = map[string]int{}
+
+// ModelTPMLimitModel 每个用户在单个模型上的 TPM
+var ModelTPMLimitModel = map[string]int{}
+var ModelTPMLimitMutex sync.RWMutex
+
+func ModelTPMLimitGroup2JSONString() string {
+	ModelTPMLimitMutex.RLock()
+	defer ModelTPMLimitMutex.RUnlock()
+
+	jsonBytes, err := json.Marshal(ModelTPMLimitGroup)
+	if err != nil {
+		common.SysError("error marshalling tpm limit group: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func ModelTPMLimitModel2JSONString() string {
+	ModelTPMLimitMutex.RLock()
+	defer ModelTPMLimitMutex.RUnlock()
+
+	jsonBytes, err := json.Marshal(ModelTPMLimitModel)
+	if err != nil {
+		common.SysError("error marshalling tpm limit model: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateModelTPMLimitGroupByJSONString(jsonStr string) error {
+	ModelTPMLimitMutex.Lock()
+	defer ModelTPMLimitMutex.Unlock()
+
+	ModelTPMLimitGroup = make(map[string]int)
+	return json.Unmarshal([]byte(jsonStr), &ModelTPMLimitGroup)
+}
+
+func UpdateModelTPMLimitModelByJSONString(jsonStr string) error {
+	ModelTPMLimitMutex.Lock()
+	defer ModelTPMLimitMutex.Unlock()
+
+	ModelTPMLimitModel = make(map[string]int)
+	return json.Unmarshal([]byte(jsonStr), &ModelTPMLimitModel)
+}
+
+// GetUserTPMLimit 返回分组内每个用户的 TPM，分组未单独配置时使用默认值
+func GetUserTPMLimit(group string) int {
+	ModelTPMLimitMutex.RLock()
+	defer ModelTPMLimitMutex.RUnlock()
+
+	if limit, ok := ModelTPMLimitGroup[group]; ok {
+		return limit
+	}
+	return ModelTPMLimitUser
+}
+
+func GetModelTPMLimit(modelName string) int {
+	ModelTPMLimitMutex.RLock()
+	defer ModelTPMLimitMutex.RUnlock()
+
+	return ModelTPMLimitModel[modelName]
+}
+
+// CheckModelTPMLimitMap 校验分组或模型 TPM 配置
+func CheckModelTPMLimitMap(jsonStr string) error {
+	limits := make(map[string]int)
+	err := json.Unmarshal([]byte(jsonStr), &limits)
+	if err != nil {
+		return err
+	}
+	for name, limit := range limits {
+		if limit < 0 {
+			return fmt.Errorf("%s has negative tpm limit: %d", name, limit)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"one-api/service/payment"
+	"one-api/setting"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newMockStripeServer 模拟 Stripe Checkout 接口，记录创建的会话与退款请求
+func newMockStripeServer(t *testing.T, refunds *[]string) *httptest.Server {
+	sessions := make(map[string]map[string]any)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer sk_test", r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/checkout/sessions":
+			assert.NoError(t, r.ParseForm())
+			id := fmt.Sprintf("cs_test_%d", len(sessions)+1)
+			sessions[id] = map[string]any{
+				"id":                  id,
+				"url":                 "https://checkout.example/" + id,
+				"status":              "complete",
+				"payment_status":      "paid",
+				"payment_intent":      "pi_" + id,
+				"amount_total":        1250,
+				"client_reference_id": r.Form.Get("client_reference_id"),
+			}
+			assert.Equal(t, "1250", r.Form.Get("line_items[0][price_data][unit_amount]"))
+			_ = json.NewEncoder(w).Encode(sessions[id])
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v1/checkout/sessions/"):
+			session, ok := sessions[strings.TrimPrefix(r.URL.Path, "/v1/checkout/sessions/")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"error":{"message":"No such checkout session"}}`))
+				return
+			}
+			_ = json.NewEncoder(w).Encode(session)
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/refunds":
+			assert.NoError(t, r.ParseForm())
+			*refunds = append(*refunds, r.Form.Get("payment_intent")+":"+r.Form.Get("amount"))
+			_, _ = w.Write([]byte(`{"id":"re_test","status":"succeeded"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func signStripePayload(payload []byte, secret string, timestamp int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprintf("%d.", timestamp)))
+	mac.Write(payload)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+func setupStripeSetting(apiBase string) {
+	setting.StripeApiBase = apiBase
+	setting.StripeApiSecret = "sk_test"
+	setting.StripeWebhookSecret = "whsec_test"
+	setting.StripeCurrency = "usd"
+	setting.StripeUnitPrice = 1
+}
+
+func TestStripeOrderLifecycle(t *testing.T) {
+	var refunds []string
+	server := newMockStripeServer(t, &refunds)
+	defer server.Close()
+	setupStripeSetting(server.URL)
+
+	provider, err := payment.GetProvider("stripe")
+	assert.NoError(t, err)
+
+	result, err := provider.CreateOrder(&payment.Order{
+		TradeNo:   "USR1NOtest",
+		Name:      "TUC10",
+		Money:     12.5,
+		ReturnUrl: "http://localhost/log",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "cs_test_1", result.ProviderOrderId)
+	assert.Equal(t, "https://checkout.example/cs_test_1", result.Url)
+
+	query, err := provider.QueryOrder("USR1NOtest", result.ProviderOrderId)
+	assert.NoError(t, err)
+	assert.Equal(t, payment.OrderStatusPaid, query.Status)
+	assert.Equal(t, 12.5, query.Money)
+
+	_, err = provider.QueryOrder("USR1NOtest", "cs_missing")
+	assert.Error(t, err)
+
+	err = provider.Refund(&payment.RefundRequest{
+		TradeNo:         "USR1NOtest",
+		ProviderOrderId: result.ProviderOrderId,
+		Money:           5,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pi_cs_test_1:500"}, refunds)
+}
+
+func TestStripeWebhookSignature(t *testing.T) {
+	setupStripeSetting("http://127.0.0.1:0")
+	provider, err := payment.GetProvider("stripe")
+	assert.NoError(t, err)
+
+	payload := []byte(`{"type":"checkout.session.completed","data":{"object":{"id":"cs_test_1","payment_status":"paid","amount_total":1000,"client_reference_id":"USR1NOtest"}}}`)
+	newRequest := func(signature string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/api/payment/notify/stripe", strings.NewReader(string(payload)))
+		req.Header.Set("Stripe-Signature", signature)
+		return req
+	}
+
+	callback, err := provider.VerifyCallback(newRequest(signStripePayload(payload, "whsec_test", time.Now().Unix())))
+	assert.NoError(t, err)
+	assert.Equal(t, "USR1NOtest", callback.TradeNo)
+	assert.Equal(t, "cs_test_1", callback.ProviderOrderId)
+	assert.Equal(t, payment.OrderStatusPaid, callback.Status)
+	assert.Equal(t, 10.0, callback.Money)
+
+	_, err = provider.VerifyCallback(newRequest(signStripePayload(payload, "whsec_wrong", time.Now().Unix())))
+	assert.Error(t, err)
+
+	_, err = provider.VerifyCallback(newRequest(signStripePayload(payload, "whsec_test", time.Now().Add(-time.Hour).Unix())))
+	assert.Error(t, err)
+
+	recorder := httptest.NewRecorder()
+	provider.WriteCallbackResponse(recorder, false)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
+
+func TestStripeZeroDecimalCurrency(t *testing.T) {
+	setupStripeSetting("http://127.0.0.1:0")
+	setting.StripeCurrency = "jpy"
+	defer func() { setting.StripeCurrency = "usd" }()
+	provider, err := payment.GetProvider("stripe")
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1500.0, provider.RoundMoney(1499.6))
+	assert.True(t, payment.MoneyEqual(provider, 1500, 1499.6))
+	assert.False(t, payment.MoneyEqual(provider, 15, 1500))
+
+	payload := []byte(`{"type":"checkout.session.completed","data":{"object":{"id":"cs_test_1","payment_status":"paid","amount_total":1500,"currency":"jpy","client_reference_id":"USR1NOtest"}}}`)
+	req := httptest.NewRequest(http.MethodPost, "/api/payment/notify/stripe", strings.NewReader(string(payload)))
+	req.Header.Set("Stripe-Signature", signStripePayload(payload, "whsec_test", time.Now().Unix()))
+	callback, err := provider.VerifyCallback(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 1500.0, callback.Money)
+}
@@ -0,0 +1,104 @@
+package test
+
+import (
+	"one-api/service"
+	"one-api/setting/operation_setting"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// usePIIPolicy 为测试分组启用指定的检测规则，测试结束后恢复原配置
+func usePIIPolicy(t *testing.T, group string, names ...string) {
+	setting := operation_setting.GetPIIRedactionSetting()
+	origin := *setting
+	t.Cleanup(func() {
+		*setting = origin
+	})
+	setting.Enabled = true
+	setting.GroupPolicies = map[string][]string{group: names}
+	setting.ChannelPolicies = map[string][]string{}
+}
+
+func TestPIIRedactionLuhnValidator(t *testing.T) {
+	usePIIPolicy(t, "pii_test", "card")
+	redactor := service.NewPIIRedactor(0, "pii_test")
+	assert.NotNil(t, redactor)
+
+	// 通过 Luhn 校验的卡号被替换，带空格或连字符的写法同样识别
+	for _, card := range []string{"4111111111111111", "4111 1111 1111 1111", "6222-0212-3456-7890-128"} {
+		redacted := redactor.RedactText("卡号 " + card)
+		assert.NotContains(t, redacted, card)
+		assert.Contains(t, redacted, "[CARD_")
+		assert.Equal(t, "卡号 "+card, redactor.Restore(redacted))
+	}
+
+	// 校验失败的数字串保持原样
+	for _, number := range []string{"4111111111111112", "1234567890123"} {
+		assert.Equal(t, "订单 "+number, redactor.RedactText("订单 "+number))
+	}
+	assert.Equal(t, 3, redactor.Counts()["card"])
+}
+
+func TestPIIRedactionCNIDValidator(t *testing.T) {
+	usePIIPolicy(t, "pii_test", "id_number")
+	redactor := service.NewPIIRedactor(0, "pii_test")
+	assert.NotNil(t, redactor)
+
+	// 校验位为 X 与数字的身份证号都能识别，小写 x 同样有效
+	for _, id := range []string{"11010519491231002X", "11010519491231002x", "440524188001010014"} {
+		redacted := redactor.RedactText("身份证 " + id)
+		assert.NotContains(t, redacted, id)
+		assert.Contains(t, redacted, "[ID_NUMBER_")
+	}
+
+	// 校验位错误的号码保持原样
+	for _, id := range []string{"110105194912310021", "440524188001010015"} {
+		assert.Equal(t, "身份证 "+id, redactor.RedactText("身份证 "+id))
+	}
+}
+
+func TestPIIRestoreStreamSplitPlaceholder(t *testing.T) {
+	usePIIPolicy(t, "pii_test", "email")
+	redactor := service.NewPIIRedactor(0, "pii_test")
+	assert.NotNil(t, redactor)
+
+	redacted := redactor.RedactText("alice@example.com")
+	assert.True(t, strings.HasPrefix(redacted, "[EMAIL_"))
+
+	// 占位符被拆分到多段输出时，前一段保留不完整的占位符，拼接下一段后还原
+	for split := 1; split < len(redacted); split++ {
+		visible, hold := redactor.RestoreStream("邮箱是 " + redacted[:split])
+		assert.Equal(t, "邮箱是 ", visible)
+		assert.Equal(t, redacted[:split], hold)
+
+		visible, hold = redactor.RestoreStream(hold + redacted[split:] + "。")
+		assert.Equal(t, "alice@example.com。", visible)
+		assert.Empty(t, hold)
+	}
+
+	// 不是占位符前缀的方括号不保留
+	visible, hold := redactor.RestoreStream("见 [1")
+	assert.Equal(t, "见 [1", visible)
+	assert.Empty(t, hold)
+}
+
+func TestPIIRestoreValueNested(t *testing.T) {
+	usePIIPolicy(t, "pii_test", "email")
+	redactor := service.NewPIIRedactor(0, "pii_test")
+	assert.NotNil(t, redactor)
+
+	placeholder := redactor.RedactText("alice@example.com")
+	// 嵌套对象与数组中的占位符全部还原，其他类型的值保持不变
+	value := map[string]any{
+		"text":  "发给 " + placeholder,
+		"parts": []any{map[string]any{"args": map[string]any{"to": placeholder}}, 3},
+		"done":  true,
+	}
+	redactor.RestoreValue(value)
+	assert.Equal(t, "发给 alice@example.com", value["text"])
+	assert.Equal(t, "alice@example.com", value["parts"].([]any)[0].(map[string]any)["args"].(map[string]any)["to"])
+	assert.Equal(t, 3, value["parts"].([]any)[1])
+	assert.Equal(t, true, value["done"])
+}
@@ -0,0 +1,99 @@
+package test
+
+import (
+	"one-api/service"
+	"one-api/setting"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// useCompletionSensitive 开启输出检查并设置敏感词，测试结束后恢复原配置
+func useCompletionSensitive(t *testing.T, stop bool, words ...string) {
+	origin := setting.SensitiveWordsToString()
+	checkEnabled := setting.CheckSensitiveEnabled
+	completionEnabled := setting.CheckSensitiveOnCompletionEnabled
+	stopEnabled := setting.StopOnSensitiveEnabled
+	t.Cleanup(func() {
+		setting.SensitiveWordsFromString(origin)
+		setting.CheckSensitiveEnabled = checkEnabled
+		setting.CheckSensitiveOnCompletionEnabled = completionEnabled
+		setting.StopOnSensitiveEnabled = stopEnabled
+	})
+	setting.CheckSensitiveEnabled = true
+	setting.CheckSensitiveOnCompletionEnabled = true
+	setting.StopOnSensitiveEnabled = stop
+	setting.SensitiveWordsFromString(strings.Join(words, "\n"))
+}
+
+func TestCompletionSensitiveFilterSplitWord(t *testing.T) {
+	useCompletionSensitive(t, false, "违禁词", "Secret")
+	text := "前文违禁词中间SECRET结尾"
+
+	// 敏感词在任意位置被拆分到两段增量中都能识别，按原文大小写无关地替换
+	runes := []rune(text)
+	for split := 1; split < len(runes); split++ {
+		filter := service.NewCompletionSensitiveFilter()
+		assert.NotNil(t, filter)
+		output := filter.Filter("0/content", string(runes[:split]))
+		output += filter.Filter("0/content", string(runes[split:]))
+		output += filter.Flush("0/content")
+		assert.Equal(t, "前文"+service.SensitiveWordMask+"中间"+service.SensitiveWordMask+"结尾", output)
+		assert.ElementsMatch(t, []string{"违禁词", "secret"}, filter.Words())
+		assert.False(t, filter.Stopped())
+	}
+}
+
+func TestCompletionSensitiveFilterStopOnSplitWord(t *testing.T) {
+	useCompletionSensitive(t, true, "违禁词")
+	filter := service.NewCompletionSensitiveFilter()
+	assert.NotNil(t, filter)
+
+	output := filter.Filter("0/content", "这是违")
+	assert.False(t, filter.Stopped())
+	output += filter.Filter("0/content", "禁词，后面的内容")
+	assert.True(t, filter.Stopped())
+	assert.Equal(t, "这是", output)
+
+	// 停止后不再输出任何内容，保留的文本也被丢弃
+	assert.Empty(t, filter.Filter("0/content", "继续输出"))
+	assert.Empty(t, filter.FlushAll())
+	assert.Equal(t, []string{"违禁词"}, filter.Words())
+}
+
+func TestCompletionSensitiveFilterFlushAtStreamEnd(t *testing.T) {
+	useCompletionSensitive(t, false, "违禁词")
+	filter := service.NewCompletionSensitiveFilter()
+	assert.NotNil(t, filter)
+
+	// 末尾可能是敏感词开头的文本被保留，流结束时按字段全部输出
+	content := filter.Filter("0/content", "回答：违禁")
+	reasoning := filter.Filter("0/reasoning_content", "思考违")
+	assert.NotContains(t, content, "违")
+	assert.NotContains(t, reasoning, "违")
+
+	pending := filter.FlushAll()
+	assert.Equal(t, "回答：违禁", content+pending["0/content"])
+	assert.Equal(t, "思考违", reasoning+pending["0/reasoning_content"])
+	assert.Empty(t, filter.FlushAll())
+	assert.Empty(t, filter.Words())
+}
+
+func TestSensitiveMatcherRebuiltOnUpdate(t *testing.T) {
+	useCompletionSensitive(t, false, "旧词")
+	matcher := setting.GetSensitiveMatcher()
+	assert.NotNil(t, matcher)
+	assert.Equal(t, 2, matcher.Longest)
+
+	// 更新敏感词后立即使用新的匹配器
+	setting.SensitiveWordsFromString("新的敏感词")
+	assert.NotSame(t, matcher, setting.GetSensitiveMatcher())
+	assert.Equal(t, 5, setting.GetSensitiveMatcher().Longest)
+	replaced, _ := service.NewCompletionSensitiveFilter().FilterText("旧词与新的敏感词")
+	assert.Equal(t, "旧词与"+service.SensitiveWordMask, replaced)
+
+	setting.SensitiveWordsFromString("")
+	assert.Nil(t, setting.GetSensitiveMatcher())
+	assert.Nil(t, service.NewCompletionSensitiveFilter())
+}